@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/anwardh/meliProject/internal/prices"
+	"github.com/anwardh/meliProject/internal/products"
+	"github.com/anwardh/meliProject/internal/stock"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// commandUsage é exibido quando o subcomando não é reconhecido
+const commandUsage = `uso:
+  server                          inicia a API
+  server backups list             lista os backups do arquivo de produtos
+  server backups diff <nome>      compara um backup com o catálogo atual
+  server backups restore <nome>   restaura um backup (o catálogo atual vira um backup antes)
+  server reencrypt                regrava o catálogo com a chave de criptografia ativa
+  server verify                   confere se o arquivo de produtos pode ser lido e se confere com o checksum
+  server repair                   recupera os registros legíveis de um arquivo danificado (o original vai para a quarentena)`
+
+/*
+runCommand executa os subcomandos administrativos, com a mesma configuração (.env) do servidor.
+Ex.: go run ./cmd/server backups list
+*/
+func runCommand(out io.Writer, args []string, db store.Store, service products.Service, stockService stock.Service, priceService prices.Service) error {
+	if len(args) == 1 && args[0] == "reencrypt" {
+		es, ok := db.(*store.EncryptedStore)
+		if !ok {
+			return errors.New("a criptografia não está configurada (STORE_ENCRYPTION_KEYS)")
+		}
+		if err := es.Reencrypt(); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "O catálogo foi regravado com a chave ativa")
+		return nil
+	}
+
+	if len(args) == 1 && (args[0] == "verify" || args[0] == "repair") {
+		fs, ok := db.(*store.FileStore)
+		if es, isEncrypted := db.(*store.EncryptedStore); isEncrypted {
+			fs, ok = es.Inner().(*store.FileStore)
+		}
+		if !ok {
+			return errors.New("a verificação só existe na store de arquivo (STORE_DSN=file://...)")
+		}
+
+		verify := fs.Verify
+		if args[0] == "repair" {
+			verify = fs.Repair
+		}
+		report, err := verify()
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+		// No verify, um arquivo danificado termina com erro (útil em scripts e no cron)
+		if !report.OK && args[0] == "verify" {
+			return fmt.Errorf("o arquivo %s está danificado: %s", report.File, report.Problem)
+		}
+		return nil
+	}
+
+	if len(args) < 2 || args[0] != "backups" {
+		return errors.New(commandUsage)
+	}
+
+	fs, ok := db.(store.BackupStore)
+	if !ok {
+		return errors.New("os backups só existem na store de arquivo (STORE_DSN=file://...)")
+	}
+
+	switch {
+	case args[1] == "list" && len(args) == 2:
+		backups, err := fs.ListBackups()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NOME\tHORÁRIO\tBYTES")
+		for _, b := range backups {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", b.Name, b.Time.Local().Format("2006-01-02 15:04:05"), b.Size)
+		}
+		return w.Flush()
+
+	case args[1] == "diff" && len(args) == 3:
+		var old []products.Product
+		if err := fs.ReadBackup(args[2], &old); err != nil {
+			return err
+		}
+		// O backup guarda também os produtos da lixeira: comparamos com todos, e o produto que foi para a lixeira
+		// aparece como alterado (deleted_at), e não como removido
+		current, err := service.GetAll(products.Filter{Deleted: products.IncludeDeleted})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(products.Compare(old, current))
+
+	case args[1] == "restore" && len(args) == 3:
+		if err := fs.Restore(args[2]); err != nil {
+			return err
+		}
+		// Como no endpoint de restore: o livro de estoque e o histórico de preços registram a diferença
+		reason := fmt.Sprintf("restauração do backup %s", args[2])
+		if _, err := stockService.Sync(reason); err != nil {
+			return err
+		}
+		if _, err := priceService.Sync(reason); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "O backup %s foi restaurado\n", args[2])
+		return nil
+	}
+	return errors.New(commandUsage)
+}
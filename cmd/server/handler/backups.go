@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/anwardh/meliProject/internal/prices"
+	"github.com/anwardh/meliProject/internal/products"
+	"github.com/anwardh/meliProject/internal/stock"
+	"github.com/anwardh/meliProject/pkg/store"
+	"github.com/anwardh/meliProject/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+// Estrutura Backup - endpoints administrativos dos backups do arquivo de produtos
+type Backup struct {
+	store   store.BackupStore
+	service products.Service
+	stock   stock.Service
+	prices  prices.Service
+}
+
+// Função que recebe a store com backups, o Service, o livro de estoque e o histórico de preços e retorna o controller dos backups
+func NewBackup(bs store.BackupStore, s products.Service, st stock.Service, pr prices.Service) *Backup {
+	return &Backup{
+		store:   bs,
+		service: s,
+		stock:   st,
+		prices:  pr,
+	}
+}
+
+// ListBackups godoc
+// @Summary List backups
+// @Tags Admin
+// @Description list the backups of the products file, newest first
+// @Produce  json
+// @Param token header string true "token"
+// @Success 200 {object} web.Response
+// @Router /admin/backups [get]
+func (c *Backup) List() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		backups, err := c.store.ListBackups()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, backups, ""))
+	}
+}
+
+// DiffBackup godoc
+// @Summary Diff backup
+// @Tags Admin
+// @Description compare a backup with the current catalog
+// @Produce  json
+// @Param token header string true "token"
+// @Param name path string true "Backup name"
+// @Success 200 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /admin/backups/{name}/diff [get]
+func (c *Backup) Diff() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var old []products.Product
+		if err := c.store.ReadBackup(ctx.Param("name"), &old); err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+
+		// O backup guarda também os produtos da lixeira: comparamos com todos, e o produto que foi para a lixeira
+		// aparece como alterado (deleted_at), e não como removido
+		current, err := c.service.GetAll(products.Filter{Deleted: products.IncludeDeleted})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		// Comparamos do backup para o catálogo atual: "added" são os produtos criados depois do backup
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, products.Compare(old, current), ""))
+	}
+}
+
+// RestoreBackup godoc
+// @Summary Restore backup
+// @Tags Admin
+// @Description replace the current catalog with a backup (the current catalog is backed up first)
+// @Produce  json
+// @Param token header string true "token"
+// @Param name path string true "Backup name"
+// @Success 200 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /admin/backups/{name}/restore [post]
+func (c *Backup) Restore() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("name")
+		if err := c.store.Restore(name); err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+		// O catálogo foi trocado por inteiro: o índice da busca precisa ser remontado
+		if err := c.service.Reindex(); err != nil {
+			ctx.Error(err)
+		}
+		// O estoque e os preços voltaram aos do backup de propósito: o livro e o histórico de preços registram a diferença
+		reason := fmt.Sprintf("restauração do backup %s", name)
+		if _, err := c.stock.Sync(reason); err != nil {
+			ctx.Error(err)
+		}
+		if _, err := c.prices.Sync(reason); err != nil {
+			ctx.Error(err)
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, fmt.Sprintf("O backup %s foi restaurado", name), ""))
+	}
+}
+
+func (c *Backup) respondWithError(ctx *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	if errors.Is(err, store.ErrBackupNotFound) {
+		code = http.StatusNotFound
+	}
+	ctx.JSON(code, web.NewResponse(code, nil, err.Error()))
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/anwardh/meliProject/internal/categories"
+	"github.com/anwardh/meliProject/pkg/store"
+	"github.com/anwardh/meliProject/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+// Declaração da Estrutura categoryRequest; parent_id nulo (ou ausente) cria uma categoria raiz
+type categoryRequest struct {
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+}
+
+// Estrutura Category - controller das categorias
+type Category struct {
+	service categories.Service
+}
+
+// Função que recebe o Service das categorias e retorna o controller instanciado
+func NewCategory(s categories.Service) *Category {
+	return &Category{
+		service: s,
+	}
+}
+
+// ListCategories godoc
+// @Summary List categories
+// @Tags Categories
+// @Description list all categories (flat); see /categories/tree for the hierarchy
+// @Produce  json
+// @Param token header string true "token"
+// @Success 200 {object} web.Response
+// @Router /categories [get]
+func (c *Category) GetAll() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		cs, err := c.service.GetAll()
+		if err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, cs, ""))
+	}
+}
+
+// CategoryTree godoc
+// @Summary Category tree
+// @Tags Categories
+// @Description the category hierarchy, from the root categories down
+// @Produce  json
+// @Param token header string true "token"
+// @Success 200 {object} web.Response
+// @Router /categories/tree [get]
+func (c *Category) Tree() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		nodes, err := c.service.Tree()
+		if err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, nodes, ""))
+	}
+}
+
+// GetCategory godoc
+// @Summary Get category
+// @Tags Categories
+// @Description a category with its breadcrumbs (the path from the root) and its direct subcategories
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "Category ID"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /categories/{id} [get]
+func (c *Category) Get() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := categoryID(ctx)
+		if !ok {
+			return
+		}
+		d, err := c.service.Get(id)
+		if err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, d, ""))
+	}
+}
+
+// StoreCategory godoc
+// @Summary Store category
+// @Tags Categories
+// @Description create a category; without parent_id it is a root category
+// @Accept  json
+// @Produce  json
+// @Param token header string true "token"
+// @Param category body categoryRequest true "Category to store"
+// @Success 201 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 409 {object} web.Response
+// @Router /categories [post]
+func (c *Category) Store() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		req, ok := bindCategory(ctx)
+		if !ok {
+			return
+		}
+		cat, err := c.service.Store(req.Name, req.ParentID)
+		if err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusCreated, web.NewResponse(http.StatusCreated, cat, ""))
+	}
+}
+
+// UpdateCategory godoc
+// @Summary Update category
+// @Tags Categories
+// @Description rename a category or move it under another parent (parent_id null moves it to the root)
+// @Accept  json
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "Category ID"
+// @Param category body categoryRequest true "Category"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Failure 409 {object} web.Response
+// @Router /categories/{id} [put]
+func (c *Category) Update() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := categoryID(ctx)
+		if !ok {
+			return
+		}
+		req, ok := bindCategory(ctx)
+		if !ok {
+			return
+		}
+		cat, err := c.service.Update(id, req.Name, req.ParentID)
+		if err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, cat, ""))
+	}
+}
+
+// DeleteCategory godoc
+// @Summary Delete category
+// @Tags Categories
+// @Description delete a category; categories with subcategories or products cannot be deleted
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "Category ID"
+// @Success 200 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Failure 409 {object} web.Response
+// @Router /categories/{id} [delete]
+func (c *Category) Delete() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := categoryID(ctx)
+		if !ok {
+			return
+		}
+		if err := c.service.Delete(id); err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, fmt.Sprintf("A categoria %d foi removida", id), ""))
+	}
+}
+
+// categoryID lê o ID da URL; se for inválido, já responde o erro
+func categoryID(ctx *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+		return 0, false
+	}
+	return id, true
+}
+
+// bindCategory lê e valida o corpo da requisição; se for inválido, já responde o erro
+func bindCategory(ctx *gin.Context) (categoryRequest, bool) {
+	var req categoryRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+		return req, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "o nome da categoria é obrigatório"))
+		return req, false
+	}
+	return req, true
+}
+
+func (c *Category) respondWithError(ctx *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, categories.ErrInvalidParent):
+		code = http.StatusBadRequest
+	case errors.Is(err, categories.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, categories.ErrDuplicate), errors.Is(err, categories.ErrInUse):
+		code = http.StatusConflict
+	case errors.Is(err, store.ErrCorrupted):
+		code = http.StatusServiceUnavailable
+	}
+	ctx.JSON(code, web.NewResponse(code, nil, err.Error()))
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anwardh/meliProject/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+// Limites da quantidade de registros por página dos históricos (livro de estoque, preços)
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 1000
+)
+
+// productID lê o ID do produto da URL; se for inválido, já responde o erro
+func productID(ctx *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+		return 0, false
+	}
+	return id, true
+}
+
+// parseRange lê o período (from e to, em RFC3339) da query string; os erros vão para errs
+func parseRange(ctx *gin.Context, errs map[string]string) (from, to time.Time) {
+	instant := func(name string) time.Time {
+		v := strings.TrimSpace(ctx.Query(name))
+		if v == "" {
+			return time.Time{}
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs[name] = "use o formato RFC3339 (ex.: 2023-06-01T10:00:00Z)"
+		}
+		return t
+	}
+	from, to = instant("from"), instant("to")
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		errs["to"] = "deve ser posterior a from"
+	}
+	return from, to
+}
+
+// parseOffsetPage lê limit e offset da query string (sem limit, a página tem defaultHistoryLimit registros)
+func parseOffsetPage(ctx *gin.Context, errs map[string]string) (limit, offset int) {
+	number := func(name string, min, max, def int) int {
+		v, ok := ctx.GetQuery(name)
+		if !ok {
+			return def
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < min || n > max {
+			errs[name] = fmt.Sprintf("deve ser um número inteiro entre %d e %d", min, max)
+		}
+		return n
+	}
+	return number("limit", 1, maxHistoryLimit, defaultHistoryLimit), number("offset", 0, math.MaxInt32, 0)
+}
+
+// offsetPagination monta os metadados de uma página por offset com n registros; os links repetem a query string
+func offsetPagination(ctx *gin.Context, total, limit, offset, n int) web.Pagination {
+	p := web.Pagination{
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+		Links:  web.Links{Self: ctx.Request.URL.RequestURI()},
+	}
+	link := func(offset int) string {
+		u := *ctx.Request.URL
+		q := u.Query()
+		q.Set("offset", strconv.Itoa(offset))
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+	if offset+n < total {
+		p.Links.Next = link(offset + n)
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		p.Links.Prev = link(prev)
+	}
+	return p
+}
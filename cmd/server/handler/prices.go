@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/anwardh/meliProject/internal/prices"
+	"github.com/anwardh/meliProject/pkg/store"
+	"github.com/anwardh/meliProject/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+// Estrutura Price - controller do histórico de preços
+type Price struct {
+	service prices.Service
+}
+
+// Função que recebe o Service do histórico de preços e retorna o controller instanciado
+func NewPrice(s prices.Service) *Price {
+	return &Price{
+		service: s,
+	}
+}
+
+// ListPrices godoc
+// @Summary Price history
+// @Tags Prices
+// @Description the price history of a product, newest first: old and new price, who changed it and when; it stays available after the product is deleted
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "Product ID"
+// @Param from query string false "Alterações a partir deste instante (RFC3339)"
+// @Param to query string false "Alterações até este instante (RFC3339)"
+// @Param limit query int false "Tamanho da página (1 a 1000, padrão 50)"
+// @Param offset query int false "Quantidade de alterações a pular"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Router /products/{id}/prices [get]
+func (c *Price) History() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := productID(ctx)
+		if !ok {
+			return
+		}
+		c.respondWithPage(ctx, prices.Filter{ProductID: id}, map[string]string{})
+	}
+}
+
+// PriceChangesReport godoc
+// @Summary Price changes report
+// @Tags Prices
+// @Description all price changes in a period, newest first (e.g. to check a promotion or answer a customer dispute)
+// @Produce  json
+// @Param token header string true "token"
+// @Param from query string false "Alterações a partir deste instante (RFC3339)"
+// @Param to query string false "Alterações até este instante (RFC3339)"
+// @Param product_id query int false "Só as alterações deste produto"
+// @Param limit query int false "Tamanho da página (1 a 1000, padrão 50)"
+// @Param offset query int false "Quantidade de alterações a pular"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Router /reports/price-changes [get]
+func (c *Price) Report() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var f prices.Filter
+		errs := map[string]string{}
+		if v := strings.TrimSpace(ctx.Query("product_id")); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id <= 0 {
+				errs["product_id"] = "deve ser um número inteiro positivo"
+			}
+			f.ProductID = id
+		}
+		c.respondWithPage(ctx, f, errs)
+	}
+}
+
+// respondWithPage completa o filtro com o período da query string e responde a página pedida do histórico;
+// os erros dos demais parâmetros chegam em errs, para a resposta listar todos juntos
+func (c *Price) respondWithPage(ctx *gin.Context, f prices.Filter, errs map[string]string) {
+	f.From, f.To = parseRange(ctx, errs)
+	limit, offset := parseOffsetPage(ctx, errs)
+	if len(errs) > 0 {
+		ctx.JSON(http.StatusBadRequest, web.NewValidationResponse(errs))
+		return
+	}
+
+	cs, total, err := c.service.History(f, limit, offset)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, store.ErrCorrupted) {
+			code = http.StatusServiceUnavailable
+		}
+		ctx.JSON(code, web.NewResponse(code, nil, err.Error()))
+		return
+	}
+	ctx.JSON(http.StatusOK, web.NewPagedResponse(cs, offsetPagination(ctx, total, limit, offset, len(cs))))
+}
@@ -1,21 +1,55 @@
 package handler
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/anwardh/meliProject/internal/categories"
 	"github.com/anwardh/meliProject/internal/products"
+	"github.com/anwardh/meliProject/internal/stock"
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
 	"github.com/anwardh/meliProject/pkg/web"
 	"github.com/gin-gonic/gin"
 )
 
 // Declaração da Estrutura Request e seus campos rotulados
+// O preço vai no formato do money.Money ({"amount": "12.50", "currency": "BRL"}); um número (ex.: 12.5),
+// como antes, é lido na moeda do catálogo. Prices são os preços fixados em outras moedas (opcional).
+// SKU e GTIN são os códigos do produto (opcionais e únicos no catálogo)
 type request struct {
-	Name     string  `json:"name"`
-	Category string  `json:"category"`
-	Count    int     `json:"count"`
-	Price    float64 `json:"price"`
+	Name       string          `json:"name"`
+	SKU        string          `json:"sku"`
+	GTIN       string          `json:"gtin"`
+	CategoryID int             `json:"category_id"`
+	Count      int             `json:"count"`
+	Price      json.RawMessage `json:"price"`
+	Prices     []money.Money   `json:"prices"`
+}
+
+// price lê o preço da requisição; o número sem moeda é lido na moeda do catálogo (veja money.FromLegacy)
+func (r request) price(currency string) (money.Money, error) {
+	if len(r.Price) == 0 {
+		return money.Money{}, nil
+	}
+	return money.FromLegacy(r.Price, currency)
+}
+
+// actor é quem fez a requisição, informado no header "user" (registrado no histórico de preços)
+func actor(ctx *gin.Context) string {
+	if user := strings.TrimSpace(ctx.GetHeader("user")); user != "" {
+		return user
+	}
+	return "desconhecido"
 }
 
 // Estrutura Product
@@ -39,7 +73,20 @@ passar a tarefa ao Service e devolver a resposta correspondente ao cliente */
 // @Accept  json
 // @Produce  json
 // @Param token header string true "token"
+// @Param as_of query string false "Estado do catálogo neste instante (RFC3339)"
+// @Param category_id query int false "Categoria (inclui as subcategorias)"
+// @Param category query string false "Nome da categoria, em qualquer nível da árvore (inclui as subcategorias)"
+// @Param min_price query string false "Preço mínimo, na moeda do catálogo (ex.: 10.50)"
+// @Param max_price query string false "Preço máximo, na moeda do catálogo (ex.: 99.90)"
+// @Param in_stock query bool false "true: só produtos com estoque; false: só sem estoque"
+// @Param q query string false "Texto procurado no nome"
+// @Param sort query string false "Ordenação: campos (id, name, category_id, count, price) separados por vírgula; \"-\" para decrescente (ex.: price,-name)"
+// @Param limit query int false "Produtos por página (1 a 1000)"
+// @Param offset query int false "Produtos a pular (não use junto com cursor)"
+// @Param cursor query string false "Cursor da próxima página (pagination.next_cursor da resposta anterior)"
 // @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 503 {object} web.Response
 // @Router /products [get]
 func (c *Product) GetAll() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -49,15 +96,553 @@ func (c *Product) GetAll() gin.HandlerFunc {
 		// 	return
 		// }
 
-		p, err := c.service.GetAll()
+		f, errs := parseFilter(ctx, c.service.Currency())
+		pr, paged := parsePage(ctx, errs)
+		if len(errs) > 0 {
+			ctx.JSON(http.StatusBadRequest, web.NewValidationResponse(errs))
+			return
+		}
+
+		// Com o as_of, devolvemos o catálogo como estava naquele instante
+		if asOf := ctx.Query("as_of"); asOf != "" {
+			t, err := time.Parse(time.RFC3339, asOf)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "as_of inválido: use o formato RFC3339 (ex.: 2023-06-01T10:00:00Z)"))
+				return
+			}
+
+			p, err := c.service.GetAllAsOf(t, f)
+			if errors.Is(err, products.ErrAsOfNotSupported) || errors.Is(err, store.ErrHistoryCompacted) {
+				ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+				return
+			}
+			if err != nil {
+				listError(ctx, err)
+				return
+			}
+			if paged {
+				ctx.JSON(http.StatusOK, pagedResponse(ctx, pr, products.Paginate(p, pr)))
+				return
+			}
+			ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, p, ""))
+			return
+		}
+
+		// Com ordenação ou paginação, a store precisa ver todos os produtos do filtro antes de devolver a página
+		if paged {
+			page, err := c.service.List(f, pr)
+			if err != nil {
+				listError(ctx, err)
+				return
+			}
+			ctx.JSON(http.StatusOK, pagedResponse(ctx, pr, page))
+			return
+		}
+
+		// Os produtos são enviados conforme são lidos, sem montar o catálogo inteiro em memória
+		s := newProductStream(ctx)
+		err := c.service.Each(f, s.write)
+		if s.count > 0 {
+			s.close(err)
+			return
+		}
+		if err != nil {
+			listError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, []products.Product{}, ""))
+	}
+}
+
+// listError responde o erro de uma listagem (ou busca) antes que qualquer produto tenha sido enviado
+func listError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, categories.ErrNotFound):
+		ctx.JSON(http.StatusBadRequest, web.NewValidationResponse(map[string]string{"category_id": err.Error()}))
+	case errors.Is(err, store.ErrCorrupted):
+		ctx.JSON(http.StatusServiceUnavailable, web.NewResponse(http.StatusServiceUnavailable, nil, err.Error()))
+	default:
+		ctx.JSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, "não há produtos armazenados"))
+	}
+}
+
+// parseFilter lê os filtros da listagem na query string; cada parâmetro inválido gera o seu próprio erro.
+// currency é a moeda do catálogo, a dos limites de preço
+func parseFilter(ctx *gin.Context, currency string) (products.Filter, map[string]string) {
+	f := products.Filter{
+		Query: strings.TrimSpace(ctx.Query("q")),
+	}
+	errs := map[string]string{}
+
+	if v, ok := ctx.GetQuery("category_id"); ok {
+		id, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || id <= 0 {
+			errs["category_id"] = "deve ser o ID de uma categoria"
+		}
+		f.CategoryID = id
+	}
+	// A categoria também pode vir pelo nome, como antes das categorias terem ID
+	if v, ok := ctx.GetQuery("category"); ok {
+		f.Category = strings.TrimSpace(v)
+		if f.Category == "" {
+			errs["category"] = "informe o nome da categoria"
+		}
+	}
+
+	// Os limites de preço estão na moeda do catálogo, como o preço dos produtos
+	price := func(name string) *money.Money {
+		v, ok := ctx.GetQuery(name)
+		if !ok {
+			return nil
+		}
+		m, err := money.Parse(v, currency)
+		if err != nil {
+			errs[name] = fmt.Sprintf("deve ser um valor em %s (ex.: 10.50)", currency)
+			return nil
+		}
+		if m.Amount < 0 {
+			errs[name] = "não pode ser negativo"
+			return nil
+		}
+		return &m
+	}
+	f.MinPrice = price("min_price")
+	f.MaxPrice = price("max_price")
+	if f.MinPrice != nil && f.MaxPrice != nil && f.MinPrice.Amount > f.MaxPrice.Amount {
+		errs["max_price"] = "deve ser maior ou igual a min_price"
+	}
+
+	if v, ok := ctx.GetQuery("in_stock"); ok {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			errs["in_stock"] = "use true ou false"
+		} else {
+			f.InStock = &b
+		}
+	}
+	return f, errs
+}
+
+/*
+parsePage lê a ordenação e a paginação na query string, acrescentando em errs os parâmetros inválidos.
+paged informa se algum desses parâmetros veio: sem eles, a listagem continua devolvendo todos os produtos.
+Com offset ou cursor, mas sem limit, a página tem products.DefaultPageLimit produtos
+*/
+func parsePage(ctx *gin.Context, errs map[string]string) (pr products.PageRequest, paged bool) {
+	if v, ok := ctx.GetQuery("sort"); ok {
+		paged = true
+		keys, err := products.ParseSort(v)
+		if err != nil {
+			errs["sort"] = err.Error()
+		}
+		pr.Sort = keys
+	}
+
+	number := func(name string, min, max int) (int, bool) {
+		v, ok := ctx.GetQuery(name)
+		if !ok {
+			return 0, false
+		}
+		paged = true
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < min || n > max {
+			errs[name] = fmt.Sprintf("deve ser um número inteiro entre %d e %d", min, max)
+			return 0, false
+		}
+		return n, true
+	}
+	limit, hasLimit := number("limit", 1, products.MaxPageLimit)
+	offset, hasOffset := number("offset", 0, math.MaxInt32)
+	pr.Limit, pr.Offset = limit, offset
+
+	if v, ok := ctx.GetQuery("cursor"); ok {
+		paged = true
+		if _, hasOffset := ctx.GetQuery("offset"); hasOffset {
+			errs["cursor"] = "use cursor ou offset, não os dois"
+		} else if _, badSort := errs["sort"]; !badSort {
+			after, err := products.DecodeCursor(strings.TrimSpace(v), pr.Sort)
+			if err != nil {
+				errs["cursor"] = err.Error()
+			}
+			pr.After = after
+		}
+	}
+
+	if !hasLimit && (hasOffset || pr.After != nil) {
+		pr.Limit = products.DefaultPageLimit
+	}
+	return pr, paged
+}
+
+/*
+pagedResponse monta a resposta da página com os metadados da paginação. Os links repetem a query string
+da requisição (filtros e ordenação) trocando só a posição: quem paginou por offset segue por offset,
+e quem paginou por cursor (ou só pediu um limit) segue pelo cursor, que não se perde com inserções e remoções
+*/
+func pagedResponse(ctx *gin.Context, pr products.PageRequest, page products.Page) web.Response {
+	p := web.Pagination{
+		Total:  page.Total,
+		Limit:  pr.Limit,
+		Offset: pr.Offset,
+		Links:  web.Links{Self: ctx.Request.URL.RequestURI()},
+	}
+
+	link := func(set func(q url.Values)) string {
+		u := *ctx.Request.URL
+		q := u.Query()
+		q.Del("offset")
+		q.Del("cursor")
+		set(q)
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+	_, byOffset := ctx.GetQuery("offset")
+
+	if page.Next != nil {
+		p.NextCursor = page.Next.Encode()
+		if byOffset {
+			next := pr.Offset + len(page.Products)
+			p.Links.Next = link(func(q url.Values) { q.Set("offset", strconv.Itoa(next)) })
+		} else {
+			p.Links.Next = link(func(q url.Values) { q.Set("cursor", p.NextCursor) })
+		}
+	}
+	if byOffset && pr.Offset > 0 {
+		prev := pr.Offset - pr.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		p.Links.Prev = link(func(q url.Values) { q.Set("offset", strconv.Itoa(prev)) })
+	}
+	return web.NewPagedResponse(page.Products, p)
+}
+
+// SearchProducts godoc
+// @Summary Search products
+// @Tags Products
+// @Description search products by name and category, ignoring accents and plurals ("cafe" finds "Café"), most relevant first; the last words may be incomplete
+// @Produce  json
+// @Param token header string true "token"
+// @Param q query string true "Texto procurado"
+// @Param limit query int false "Quantidade máxima de resultados (1 a 100, padrão 20)"
+// @Param category_id query int false "Categoria (inclui as subcategorias)"
+// @Param category query string false "Nome da categoria, em qualquer nível da árvore (inclui as subcategorias)"
+// @Param min_price query string false "Preço mínimo, na moeda do catálogo (ex.: 10.50)"
+// @Param max_price query string false "Preço máximo, na moeda do catálogo (ex.: 99.90)"
+// @Param in_stock query bool false "true: só produtos com estoque; false: só sem estoque"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 503 {object} web.Response
+// @Router /products/search [get]
+func (c *Product) Search() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		f, errs := parseFilter(ctx, c.service.Currency())
+		// Aqui o q é a própria busca, e não o filtro por trecho do nome
+		f.Query = ""
+		q := strings.TrimSpace(ctx.Query("q"))
+		if q == "" {
+			errs["q"] = "informe o texto procurado"
+		}
+
+		limit := products.DefaultSearchLimit
+		if v, ok := ctx.GetQuery("limit"); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil || n < 1 || n > products.MaxSearchLimit {
+				errs["limit"] = fmt.Sprintf("deve ser um número inteiro entre 1 e %d", products.MaxSearchLimit)
+			}
+			limit = n
+		}
+		if len(errs) > 0 {
+			ctx.JSON(http.StatusBadRequest, web.NewValidationResponse(errs))
+			return
+		}
+
+		results, err := c.service.Search(q, f, limit)
+		if err != nil {
+			listError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, results, ""))
+	}
+}
+
+// GetProduct godoc
+// @Summary Get product
+// @Tags Products
+// @Description get a single product by id; the response carries an ETag (use If-None-Match to get 304 when unchanged)
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "Product ID"
+// @Param If-None-Match header string false "ETag da última resposta"
+// @Success 200 {object} web.Response
+// @Success 304 "Not Modified"
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /products/{id} [get]
+func (c *Product) Get() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
 		if err != nil {
-			ctx.JSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, "não há produtos armazenados"))
+			ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
 			return
 		}
+
+		p, err := c.service.GetByID(int(id))
+		if errors.Is(err, products.ErrNotFound) {
+			ctx.JSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, err.Error()))
+			return
+		}
+		if errors.Is(err, store.ErrCorrupted) {
+			ctx.JSON(http.StatusServiceUnavailable, web.NewResponse(http.StatusServiceUnavailable, nil, err.Error()))
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+
+		body, err := json.Marshal(web.NewResponse(http.StatusOK, p, ""))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+			return
+		}
+		// O ETag é o hash da resposta: muda sempre que qualquer campo do produto muda
+		etag := etagOf(body)
+		ctx.Header("ETag", etag)
+		if matchesETag(ctx.GetHeader("If-None-Match"), etag) {
+			ctx.Status(http.StatusNotModified)
+			return
+		}
+		ctx.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
+
+/*
+codeError responde os erros do SKU e do GTIN com o campo recusado em errors: 400 para o código inválido
+e 409 para o código de outro produto. Devolve false (sem responder nada) para os demais erros
+*/
+func codeError(ctx *gin.Context, err error) bool {
+	var ce *products.CodeError
+	if !errors.As(err, &ce) {
+		return false
+	}
+	if errors.Is(err, products.ErrDuplicateCode) {
+		r := web.NewResponse(http.StatusConflict, nil, err.Error())
+		r.Errors = map[string]string{ce.Field: err.Error()}
+		ctx.JSON(http.StatusConflict, r)
+		return true
+	}
+	ctx.JSON(http.StatusBadRequest, web.NewValidationResponse(map[string]string{ce.Field: err.Error()}))
+	return true
+}
+
+// GetProductBySKU godoc
+// @Summary Get product by SKU
+// @Tags Products
+// @Description get a single product by its SKU (case-insensitive)
+// @Produce  json
+// @Param token header string true "token"
+// @Param sku path string true "SKU"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /products/by-sku/{sku} [get]
+func (c *Product) GetBySKU() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		p, err := c.service.GetBySKU(ctx.Param("sku"))
+		c.respondWithCodeLookup(ctx, p, err)
+	}
+}
+
+// GetProductByGTIN godoc
+// @Summary Get product by GTIN
+// @Tags Products
+// @Description get a single product by its barcode (EAN-8, UPC-A, EAN-13 or GTIN-14); the same item is found in any of the formats (e.g. the UPC-A finds the product stored with the EAN-13)
+// @Produce  json
+// @Param token header string true "token"
+// @Param gtin path string true "GTIN"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /products/by-gtin/{gtin} [get]
+func (c *Product) GetByGTIN() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		p, err := c.service.GetByGTIN(ctx.Param("gtin"))
+		c.respondWithCodeLookup(ctx, p, err)
+	}
+}
+
+// respondWithCodeLookup responde a busca de um produto pelo código
+func (c *Product) respondWithCodeLookup(ctx *gin.Context, p products.Product, err error) {
+	if codeError(ctx, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, products.ErrNotFound):
+		ctx.JSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, err.Error()))
+	case errors.Is(err, store.ErrCorrupted):
+		ctx.JSON(http.StatusServiceUnavailable, web.NewResponse(http.StatusServiceUnavailable, nil, err.Error()))
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+	default:
 		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, p, ""))
 	}
 }
 
+// QuoteProduct godoc
+// @Summary Product price in a currency
+// @Tags Products
+// @Description the price of a product in the requested currency: the price fixed in that currency if the product has one, otherwise the catalog price converted by the exchange-rate table (converted=true), rounded by the table's rule
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "Product ID"
+// @Param currency query string true "Código ISO 4217 da moeda (ex.: USD)"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /products/{id}/quote [get]
+func (c *Product) Quote() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := productID(ctx)
+		if !ok {
+			return
+		}
+		currency := strings.ToUpper(strings.TrimSpace(ctx.Query("currency")))
+		if currency == "" {
+			ctx.JSON(http.StatusBadRequest, web.NewValidationResponse(map[string]string{"currency": "informe o código da moeda (ex.: USD)"}))
+			return
+		}
+
+		p, err := c.service.GetByID(id)
+		if err != nil {
+			moneyError(ctx, err)
+			return
+		}
+		q, err := c.service.Quote(p, currency)
+		if err != nil {
+			moneyError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, q, ""))
+	}
+}
+
+// InventoryValueReport godoc
+// @Summary Inventory value
+// @Tags Products
+// @Description the value of the stock (count × price of every product) in the requested currency, summed exactly in minor units; only the conversion of each unit price is rounded
+// @Produce  json
+// @Param token header string true "token"
+// @Param currency query string false "Código ISO 4217 da moeda (padrão: a moeda do catálogo)"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 503 {object} web.Response
+// @Router /reports/inventory-value [get]
+func (c *Product) InventoryValue() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		currency := strings.ToUpper(strings.TrimSpace(ctx.Query("currency")))
+		if currency == "" {
+			currency = c.service.Currency()
+		}
+		v, err := c.service.InventoryValue(currency)
+		if err != nil {
+			moneyError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, v, ""))
+	}
+}
+
+// moneyError responde os erros das consultas de preço em outras moedas
+func moneyError(ctx *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, money.ErrUnknownCurrency) || errors.Is(err, money.ErrNoRate):
+		code = http.StatusBadRequest
+	case errors.Is(err, products.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, store.ErrCorrupted):
+		code = http.StatusServiceUnavailable
+	}
+	ctx.JSON(code, web.NewResponse(code, nil, err.Error()))
+}
+
+// etagOf calcula um ETag forte a partir do corpo da resposta
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchesETag confere o If-None-Match, que pode trazer vários ETags separados por vírgula, ou "*"
+func matchesETag(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// streamFlushEvery é a quantidade de produtos enviados entre um flush e outro da resposta
+const streamFlushEvery = 500
+
+/*
+productStream escreve a resposta de uma listagem aos poucos, no mesmo formato do web.Response:
+
+	{"code":"200","data":[{...},{...}]}
+
+O status e o começo do corpo só são escritos com o primeiro produto: até lá, um erro ainda pode virar
+uma resposta de erro normal. Um erro no meio da listagem não pode mais mudar o status, então fechamos a lista
+e informamos o erro no campo "error" do próprio corpo.
+*/
+type productStream struct {
+	ctx   *gin.Context
+	count int
+}
+
+func newProductStream(ctx *gin.Context) *productStream {
+	return &productStream{ctx: ctx}
+}
+
+func (s *productStream) write(p products.Product) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	w := s.ctx.Writer
+	if s.count == 0 {
+		s.ctx.Header("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.WriteString(`{"code":"200","data":[`)
+	} else {
+		_, err = w.WriteString(",")
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+
+	s.count++
+	if s.count%streamFlushEvery == 0 {
+		w.Flush()
+	}
+	return nil
+}
+
+func (s *productStream) close(err error) {
+	if err == nil {
+		s.ctx.Writer.WriteString("]}")
+		return
+	}
+	msg, _ := json.Marshal(err.Error())
+	s.ctx.Writer.WriteString(`],"error":` + string(msg) + `}`)
+	s.ctx.Error(err)
+}
+
 // Método Store
 // StoreProducts godoc
 // @Summary Store products
@@ -66,8 +651,11 @@ func (c *Product) GetAll() gin.HandlerFunc {
 // @Accept  json
 // @Produce  json
 // @Param token header string true "token"
+// @Param user header string false "Quem faz a alteração (registrado no histórico de preços)"
 // @Param product body request true "Product to store"
 // @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 409 {object} web.Response
 // @Router /products [post]
 func (c *Product) Store() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -91,8 +679,8 @@ func (c *Product) Store() gin.HandlerFunc {
 			return
 		}
 
-		if req.Category == "" {
-			ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "o nome do tipo é obrigatório"))
+		if req.CategoryID == 0 {
+			ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "a categoria é obrigatória"))
 			return
 		}
 
@@ -101,12 +689,30 @@ func (c *Product) Store() gin.HandlerFunc {
 			return
 		}
 
-		if req.Price == 0 {
+		if req.Count < 0 {
+			ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "a quantidade não pode ser negativa"))
+			return
+		}
+
+		price, err := req.price(c.service.Currency())
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+		if price.IsZero() {
 			ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "o preço do produto é obrigatório"))
 			return
 		}
 
-		p, err := c.service.Store(req.Name, req.Category, req.Count, req.Price)
+		p, err := c.service.Store(req.Name, req.SKU, req.GTIN, req.CategoryID, req.Count, price, req.Prices, actor(ctx))
+		if codeError(ctx, err) {
+			return
+		}
+		// A categoria precisa existir: o produto não é gravado apontando para uma categoria inexistente
+		if errors.Is(err, categories.ErrNotFound) || errors.Is(err, products.ErrInvalidPrice) || errors.Is(err, stock.ErrInvalidMovement) {
+			ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
 		if err != nil {
 			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
@@ -150,9 +756,9 @@ func (c *Product) Update() gin.HandlerFunc {
 			return
 		}
 
-		// Validação do Tipo do Produto
-		if req.Category == "" {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "O tipo do produto é obrigatório"})
+		// Validação da Categoria do Produto
+		if req.CategoryID == 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "A categoria do produto é obrigatória"})
 			return
 		}
 
@@ -161,16 +767,32 @@ func (c *Product) Update() gin.HandlerFunc {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "A quantidade é obrigatória"})
 			return
 		}
+		if req.Count < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "A quantidade não pode ser negativa"})
+			return
+		}
 
 		// Validação do Preço do Produto
-		if req.Price == 0 {
+		price, err := req.price(c.service.Currency())
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if price.IsZero() {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "O preço é obrigatório"})
 			return
 		}
 
 		// Quando estiver 'OK', será chamado o método Update, do Service
 
-		p, err := c.service.Update(int(id), req.Name, req.Category, req.Count, req.Price)
+		p, err := c.service.Update(int(id), req.Name, req.SKU, req.GTIN, req.CategoryID, req.Count, price, req.Prices, actor(ctx))
+		if codeError(ctx, err) {
+			return
+		}
+		if errors.Is(err, categories.ErrNotFound) || errors.Is(err, products.ErrInvalidPrice) || errors.Is(err, stock.ErrInvalidMovement) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return // Retorno do erro do Service
@@ -214,6 +836,18 @@ func (c *Product) UpdateName() gin.HandlerFunc {
 	}
 }
 
+// DeleteProduct godoc
+// @Summary Delete product
+// @Tags Products
+// @Description move the product to the trash; it can be restored until the trash retention expires
+// @Produce  json
+// @Param token header string true "token"
+// @Param user header string false "Quem remove o produto (registrado na lixeira)"
+// @Param id path int true "Product ID"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Router /products/{id} [delete]
 func (c *Product) Delete() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// token := ctx.GetHeader("token")
@@ -228,12 +862,77 @@ func (c *Product) Delete() gin.HandlerFunc {
 			return
 		}
 
-		err = c.service.Delete(int(id))
+		err = c.service.Delete(int(id), actor(ctx))
 		if err != nil {
 			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
 
-		ctx.JSON(http.StatusOK, gin.H{"data": fmt.Sprintf("O produto %d foi removido", id)})
+		ctx.JSON(http.StatusOK, gin.H{"data": fmt.Sprintf("O produto %d foi movido para a lixeira", id)})
+	}
+}
+
+// ListTrash godoc
+// @Summary List trash
+// @Tags Products
+// @Description get the deleted products, with who deleted them and when, until they are purged
+// @Produce  json
+// @Param token header string true "token"
+// @Param sort query string false "Ordenação: campos (id, name, category_id, count, price) separados por vírgula; \"-\" para decrescente (ex.: price,-name)"
+// @Param limit query int false "Produtos por página (1 a 1000)"
+// @Param offset query int false "Produtos a pular (não use junto com cursor)"
+// @Param cursor query string false "Cursor da próxima página (pagination.next_cursor da resposta anterior)"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Router /products/trash [get]
+func (c *Product) Trash() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		errs := map[string]string{}
+		pr, _ := parsePage(ctx, errs)
+		if len(errs) > 0 {
+			ctx.JSON(http.StatusBadRequest, web.NewValidationResponse(errs))
+			return
+		}
+
+		page, err := c.service.Trash(pr)
+		if err != nil {
+			listError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, pagedResponse(ctx, pr, page))
+	}
+}
+
+// RestoreProduct godoc
+// @Summary Restore product
+// @Tags Products
+// @Description take a deleted product out of the trash, with the stock it had
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "Product ID"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Failure 409 {object} web.Response
+// @Router /products/{id}/restore [post]
+func (c *Product) Restore() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, "ID inválido"))
+			return
+		}
+
+		p, err := c.service.Restore(int(id))
+		switch {
+		case errors.Is(err, products.ErrNotFound):
+			ctx.JSON(http.StatusNotFound, web.NewResponse(http.StatusNotFound, nil, err.Error()))
+		case errors.Is(err, products.ErrNotDeleted):
+			ctx.JSON(http.StatusConflict, web.NewResponse(http.StatusConflict, nil, err.Error()))
+		case err != nil:
+			ctx.JSON(http.StatusInternalServerError, web.NewResponse(http.StatusInternalServerError, nil, err.Error()))
+		default:
+			ctx.JSON(http.StatusOK, web.NewResponse(http.StatusOK, p, ""))
+		}
 	}
 }
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/anwardh/meliProject/internal/categories"
+	"github.com/anwardh/meliProject/internal/prices"
+	"github.com/anwardh/meliProject/internal/products"
+	"github.com/anwardh/meliProject/internal/stock"
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
+	"github.com/gin-gonic/gin"
+)
+
+// newServices monta os Services de produtos e de categorias sobre stores em memória
+func newServices() (products.Service, categories.Service) {
+	r := products.NewRepository(store.NewMemoryStore(nil))
+	c := categories.NewService(categories.NewRepository(store.NewMemoryStore(nil)), r)
+	st := stock.NewService(stock.NewRepository(store.NewMemoryStore(nil)), r)
+	pr := prices.NewService(prices.NewRepository(store.NewMemoryStore(nil), "BRL"), r)
+	return products.NewService(r, c, st, pr, "BRL", nil), c
+}
+
+func mustStore(t *testing.T, s products.Service, name string, categoryID int) products.Product {
+	t.Helper()
+	price, err := money.Parse("5.50", "BRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.Store(name, "", "", categoryID, 3, price, nil, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// newRouter monta as rotas de produtos sobre stores em memória, com um produto cadastrado
+func newRouter(t *testing.T) (*gin.Engine, products.Product) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	service, c := newServices()
+	category, err := c.Store("Padaria", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := mustStore(t, service, "Bolo", category.ID)
+
+	h := NewProduct(service)
+	router := gin.New()
+	router.GET("/products", h.GetAll())
+	router.GET("/products/:id", h.Get())
+	router.PATCH("/products/:id", h.UpdateName())
+	return router, p
+}
+
+func serve(router *gin.Engine, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// O Get devolve o ETag; com o If-None-Match do mesmo ETag, a resposta é 304 sem corpo, até o produto mudar
+func TestGetProductETag(t *testing.T) {
+	router, p := newRouter(t)
+	path := "/products/" + strconv.Itoa(p.ID)
+
+	first := serve(router, http.MethodGet, path, "", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Body.Len() == 0 {
+		t.Fatalf("GET = %d, ETag %q, corpo %q", first.Code, etag, first.Body)
+	}
+
+	for _, ifNoneMatch := range []string{etag, `"outro", ` + etag, "W/" + etag, "*"} {
+		w := serve(router, http.MethodGet, path, "", http.Header{"If-None-Match": {ifNoneMatch}})
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("GET com If-None-Match %s = %d, corpo %q; esperado 304 sem corpo", ifNoneMatch, w.Code, w.Body)
+		}
+		if got := w.Header().Get("ETag"); got != etag {
+			t.Fatalf("o 304 veio com o ETag %q, esperado %q", got, etag)
+		}
+	}
+	if w := serve(router, http.MethodGet, path, "", http.Header{"If-None-Match": {`"outro"`}}); w.Code != http.StatusOK {
+		t.Fatalf("GET com outro ETag = %d, esperado 200", w.Code)
+	}
+
+	if w := serve(router, http.MethodPatch, path, `{"name": "Bolo de fubá"}`, nil); w.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d: %s", w.Code, w.Body)
+	}
+	w := serve(router, http.MethodGet, path, "", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Bolo de fubá") {
+		t.Fatalf("GET depois da alteração = %d, corpo %q; esperado 200 com o produto novo", w.Code, w.Body)
+	}
+	if got := w.Header().Get("ETag"); got == "" || got == etag {
+		t.Fatalf("depois da alteração, ETag = %q, esperado um diferente de %q", got, etag)
+	}
+}
+
+func TestGetProductNotFound(t *testing.T) {
+	router, _ := newRouter(t)
+	if w := serve(router, http.MethodGet, "/products/99", "", nil); w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" {
+		t.Fatalf("GET do produto inexistente = %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+	if w := serve(router, http.MethodGet, "/products/abc", "", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("GET com ID inválido = %d, esperado 400", w.Code)
+	}
+}
+
+// O ?category=<nome> lista os produtos de todas as categorias com o nome, em qualquer nível, e das subcategorias delas
+func TestGetAllByCategoryName(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service, c := newServices()
+	category := func(name string, parentID *int) categories.Category {
+		category, err := c.Store(name, parentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return category
+	}
+	bebidas := category("Bebidas", nil)
+	refrigerantes := category("Refrigerantes", &bebidas.ID)
+	mercado := category("Mercado", nil)
+	outras := category("Bebidas", &mercado.ID)
+	padaria := category("Padaria", nil)
+	mustStore(t, service, "Água", bebidas.ID)
+	mustStore(t, service, "Coca", refrigerantes.ID)
+	mustStore(t, service, "Suco", outras.ID)
+	mustStore(t, service, "Bolo", padaria.ID)
+
+	router := gin.New()
+	router.GET("/products", NewProduct(service).GetAll())
+
+	w := serve(router, http.MethodGet, "/products?category=bebida&sort=name", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET = %d: %s", w.Code, w.Body)
+	}
+	for _, name := range []string{"Água", "Coca", "Suco"} {
+		if !strings.Contains(w.Body.String(), `"`+name+`"`) {
+			t.Fatalf("a listagem de Bebidas não tem %s: %s", name, w.Body)
+		}
+	}
+	if strings.Contains(w.Body.String(), `"Bolo"`) {
+		t.Fatalf("a listagem de Bebidas tem o Bolo: %s", w.Body)
+	}
+
+	// Um nome sem categoria não lista nada; o nome em branco é recusado
+	if w := serve(router, http.MethodGet, "/products?category=Doces", "", nil); w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"name"`) {
+		t.Fatalf("GET de uma categoria inexistente = %d: %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodGet, "/products?category=%20", "", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("GET com a categoria em branco = %d, esperado 400", w.Code)
+	}
+}
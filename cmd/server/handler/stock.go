@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/anwardh/meliProject/internal/products"
+	"github.com/anwardh/meliProject/internal/stock"
+	"github.com/anwardh/meliProject/pkg/store"
+	"github.com/anwardh/meliProject/pkg/web"
+	"github.com/gin-gonic/gin"
+)
+
+// Declaração da Estrutura movementRequest; quantity é positiva, exceto nos ajustes, em que o sinal diz o sentido
+type movementRequest struct {
+	Type      string `json:"type"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+	Reference string `json:"reference"`
+}
+
+// Estrutura Stock - controller do livro de estoque
+type Stock struct {
+	service stock.Service
+}
+
+// Função que recebe o Service do livro de estoque e retorna o controller instanciado
+func NewStock(s stock.Service) *Stock {
+	return &Stock{
+		service: s,
+	}
+}
+
+// StoreMovement godoc
+// @Summary Store stock movement
+// @Tags Stock
+// @Description record a stock movement (inbound, outbound, return or adjustment) and update the product count; outbound movements never drive the stock negative
+// @Accept  json
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "Product ID"
+// @Param movement body movementRequest true "Movement to record"
+// @Success 201 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Failure 404 {object} web.Response
+// @Failure 409 {object} web.Response
+// @Router /products/{id}/stock/movements [post]
+func (c *Stock) Store() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := productID(ctx)
+		if !ok {
+			return
+		}
+		var req movementRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, web.NewResponse(http.StatusBadRequest, nil, err.Error()))
+			return
+		}
+		m, err := c.service.Record(id, strings.TrimSpace(req.Type), req.Quantity, strings.TrimSpace(req.Reason), strings.TrimSpace(req.Reference))
+		if err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusCreated, web.NewResponse(http.StatusCreated, m, ""))
+	}
+}
+
+// ListMovements godoc
+// @Summary List stock movements
+// @Tags Stock
+// @Description the stock movement history of a product, newest first; it stays available after the product is deleted
+// @Produce  json
+// @Param token header string true "token"
+// @Param id path int true "Product ID"
+// @Param type query string false "Tipo: inbound, outbound, return ou adjustment"
+// @Param from query string false "Lançamentos a partir deste instante (RFC3339)"
+// @Param to query string false "Lançamentos até este instante (RFC3339)"
+// @Param limit query int false "Tamanho da página (1 a 1000, padrão 50)"
+// @Param offset query int false "Quantidade de lançamentos a pular"
+// @Success 200 {object} web.Response
+// @Failure 400 {object} web.Response
+// @Router /products/{id}/stock/movements [get]
+func (c *Stock) List() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := productID(ctx)
+		if !ok {
+			return
+		}
+		f, limit, offset, errs := parseMovementQuery(ctx)
+		if len(errs) > 0 {
+			ctx.JSON(http.StatusBadRequest, web.NewValidationResponse(errs))
+			return
+		}
+		f.ProductID = id
+
+		ms, total, err := c.service.History(f, limit, offset)
+		if err != nil {
+			c.respondWithError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, web.NewPagedResponse(ms, offsetPagination(ctx, total, limit, offset, len(ms))))
+	}
+}
+
+// parseMovementQuery lê os filtros e a página do histórico; cada parâmetro inválido gera o seu próprio erro
+func parseMovementQuery(ctx *gin.Context) (f stock.Filter, limit, offset int, errs map[string]string) {
+	errs = map[string]string{}
+
+	if v := strings.TrimSpace(ctx.Query("type")); v != "" {
+		switch v {
+		case stock.Inbound, stock.Outbound, stock.Return, stock.Adjustment:
+			f.Type = v
+		default:
+			errs["type"] = fmt.Sprintf("use %s, %s, %s ou %s", stock.Inbound, stock.Outbound, stock.Return, stock.Adjustment)
+		}
+	}
+	f.From, f.To = parseRange(ctx, errs)
+	limit, offset = parseOffsetPage(ctx, errs)
+	return f, limit, offset, errs
+}
+
+func (c *Stock) respondWithError(ctx *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, stock.ErrInvalidMovement):
+		code = http.StatusBadRequest
+	case errors.Is(err, products.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, stock.ErrInsufficientStock):
+		code = http.StatusConflict
+	case errors.Is(err, store.ErrCorrupted):
+		code = http.StatusServiceUnavailable
+	}
+	ctx.JSON(code, web.NewResponse(code, nil, err.Error()))
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/anwardh/meliProject/cmd/server/handler"
 	"github.com/anwardh/meliProject/docs"
+	"github.com/anwardh/meliProject/internal/categories"
+	"github.com/anwardh/meliProject/internal/prices"
 	"github.com/anwardh/meliProject/internal/products"
+	"github.com/anwardh/meliProject/internal/stock"
+	"github.com/anwardh/meliProject/pkg/money"
 	"github.com/anwardh/meliProject/pkg/store"
 	"github.com/anwardh/meliProject/pkg/web"
 	"github.com/gin-gonic/gin"
@@ -60,6 +70,227 @@ func TokenAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// legacyDSN monta o DSN a partir da configuração antiga (STORE_TYPE, STORE_FILE e BACKUP_*),
+// usada quando STORE_DSN não está configurada
+func legacyDSN() string {
+	// Sem nada configurado, usamos o products.json
+	storeType := os.Getenv("STORE_TYPE")
+	if storeType == "" {
+		storeType = store.FileType
+	}
+	// Na store em memória o arquivo é opcional, então só assumimos o padrão quando a variável não existe
+	storeFile, ok := os.LookupEnv("STORE_FILE")
+	if !ok || (storeFile == "" && storeType == store.FileType) {
+		storeFile = "products.json"
+	}
+
+	scheme, opts := storeType, url.Values{}
+	switch storeType {
+	case store.FileType:
+		scheme = store.FileScheme
+		for env, key := range map[string]string{
+			"BACKUP_DIR":       "backup_dir",
+			"BACKUP_MAX_COUNT": "backup_max_count",
+			"BACKUP_MAX_AGE":   "backup_max_age",
+		} {
+			if v := os.Getenv(env); v != "" {
+				opts.Set(key, v)
+			}
+		}
+	case store.MemoryType:
+		scheme = store.MemoryScheme
+		if storeFile != "" {
+			opts.Set("seed", storeFile)
+		}
+		storeFile = ""
+	}
+
+	dsn := scheme + "://" + storeFile
+	if len(opts) > 0 {
+		dsn += "?" + opts.Encode()
+	}
+	return dsn
+}
+
+/*
+openStore abre a store do DSN e a prepara para atender requisições: conclui escritas interrompidas,
+atualiza o schema do arquivo, acompanha edições externas e liga a criptografia, se configurada.
+Devolve também a FileStore por baixo da store, quando ela é de arquivo (ou nil)
+*/
+func openStore(dsn string) (store.Store, *store.FileStore) {
+	db, err := store.Open(dsn)
+	if err != nil {
+		log.Fatal("Não foi possivel criar a store: ", err)
+	}
+
+	// Antes de atender requisições, concluímos ou descartamos escritas interrompidas por uma queda anterior
+	fs, isFile := db.(*store.FileStore)
+	if isFile {
+		if err := fs.Recover(); err != nil {
+			log.Fatal("não foi possível recuperar o arquivo da store: ", err)
+		}
+	}
+
+	// Com chaves configuradas, os dados são criptografados antes de chegar à store
+	if keys := os.Getenv("STORE_ENCRYPTION_KEYS"); keys != "" {
+		switch db.(type) {
+		case *store.FileStore, *store.MemoryStore:
+		default:
+			log.Fatal("a criptografia só está disponível nas stores file e mem")
+		}
+		// O conteúdo criptografado é um objeto, e os formatos de linha só guardam listas
+		if isFile && (fs.Codec.Name() == store.FormatCSV || fs.Codec.Name() == store.FormatNDJSON) {
+			log.Fatal("a criptografia só está disponível nos formatos json e yaml")
+		}
+		kr, err := store.ParseKeyring(keys, os.Getenv("STORE_ENCRYPTION_KEY_ID"))
+		if err != nil {
+			log.Fatal("configuração de criptografia inválida: ", err)
+		}
+		db = store.NewEncryptedStore(db, kr)
+	}
+	return db, fs
+}
+
+/*
+useMigrations configura as migrations do schema na store dos produtos: no arquivo (mesmo criptografado)
+e na massa de dados da store em memória. As stores SQLite e journal não guardam envelope
+*/
+func useMigrations(db store.Store, m store.Migrations) {
+	if es, ok := db.(*store.EncryptedStore); ok {
+		db = es.Inner()
+	}
+	switch s := db.(type) {
+	case *store.FileStore:
+		s.Migrations = m
+	case *store.MemoryStore:
+		s.Migrations = m
+	}
+}
+
+/*
+upgradeStore migra o arquivo da store para o schema atual e passa a vigiá-lo. Roda depois de montados os Services:
+a migration das categorias dos produtos antigos precisa do Service das categorias (veja useMigrations)
+*/
+func upgradeStore(fs *store.FileStore) {
+	if fs == nil {
+		return
+	}
+	// Arquivos em schemas antigos são migrados; um schema mais novo que o desta versão impede a subida
+	// Um arquivo corrompido não impede a subida: as gravações ficam recusadas até o repair (ou um restore)
+	if err := fs.UpgradeSchema(); errors.Is(err, store.ErrCorrupted) {
+		log.Printf("evento=catalogo_corrompido arquivo=%s erro=%q acao=\"rode: server verify / server repair\"", fs.FileName, err)
+	} else if err != nil {
+		log.Fatal("não foi possível abrir o arquivo da store: ", err)
+	}
+	// Recarrega o catálogo quando o arquivo é editado por fora (ex.: à mão, com o servidor rodando)
+	if fs.WatchInterval > 0 {
+		go fs.Watch(context.Background(), fs.WatchInterval)
+	}
+}
+
+/*
+sideDSN é a store de um domínio que acompanha os produtos (as categorias, o livro de estoque) quando os produtos
+não estão no SQLite (lá, cada domínio é uma tabela do mesmo banco). Sem a variável env configurada, ela fica
+ao lado dos produtos, com as mesmas opções: com name "categories", file://dados/products.json vira
+file://dados/categories.json, journal://dados vira journal://dados/categories e a store em memória começa vazia
+*/
+func sideDSN(dsn, name, env string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	scheme, rest, _ := strings.Cut(dsn, "://")
+	path, query, _ := strings.Cut(rest, "?")
+	switch scheme {
+	case store.FileScheme:
+		path = filepath.Join(filepath.Dir(path), name+filepath.Ext(path))
+	case store.JournalScheme:
+		path = filepath.Join(path, name)
+	case store.MemoryScheme:
+		return store.MemoryScheme + "://"
+	default:
+		log.Fatalf("configure a store de %s (%s) para o esquema %q", name, env, scheme)
+	}
+	if query != "" {
+		path += "?" + query
+	}
+	return scheme + "://" + path
+}
+
+// defaultCurrency é a moeda do catálogo quando CATALOG_CURRENCY não é configurada
+const defaultCurrency = "BRL"
+
+/*
+loadMoney lê a moeda do catálogo (CATALOG_CURRENCY, padrão BRL), em que ficam os preços dos produtos
+e em que são lidos os preços antigos, gravados só como número, e a tabela de câmbio (EXCHANGE_RATES_FILE).
+Sem tabela, a conversão fica desligada: só os preços fixados em cada moeda estão disponíveis
+*/
+func loadMoney() (string, *money.Rates) {
+	currency := defaultCurrency
+	if v := strings.ToUpper(strings.TrimSpace(os.Getenv("CATALOG_CURRENCY"))); v != "" {
+		if _, err := money.Exponent(v); err != nil {
+			log.Fatal("CATALOG_CURRENCY inválida: ", err)
+		}
+		currency = v
+	}
+
+	path := os.Getenv("EXCHANGE_RATES_FILE")
+	if path == "" {
+		return currency, nil
+	}
+	rates, err := money.LoadRates(path)
+	if err != nil {
+		log.Fatal("não foi possível carregar a tabela de câmbio: ", err)
+	}
+	log.Printf("evento=cambio_carregado base=%s moedas=%s arredondamento=%s", rates.Base, strings.Join(rates.Currencies(), ","), rates.Rounding)
+	return currency, rates
+}
+
+// Prazos padrão da lixeira: quanto tempo um produto removido pode ser restaurado e de quanto em quanto tempo a limpeza roda
+const (
+	defaultTrashRetention     = 30 * 24 * time.Hour
+	defaultTrashPurgeInterval = time.Hour
+)
+
+// loadTrash lê o prazo da lixeira (TRASH_RETENTION, "0" desliga a limpeza) e o intervalo da limpeza (TRASH_PURGE_INTERVAL)
+func loadTrash() (retention, interval time.Duration) {
+	retention, interval = defaultTrashRetention, defaultTrashPurgeInterval
+	if v := strings.TrimSpace(os.Getenv("TRASH_RETENTION")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("TRASH_RETENTION=%q não é uma duração (ex.: 720h, 0 desliga a limpeza)", v)
+		}
+		retention = d
+	}
+	if v := strings.TrimSpace(os.Getenv("TRASH_PURGE_INTERVAL")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("TRASH_PURGE_INTERVAL=%q não é uma duração positiva (ex.: 1h)", v)
+		}
+		interval = d
+	}
+	return retention, interval
+}
+
+// purgeTrash remove de vez, na subida e depois a cada intervalo, os produtos que estão na lixeira há mais que retention
+func purgeTrash(ctx context.Context, s products.Service, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.Purge(retention); err != nil {
+			log.Printf("evento=lixeira_erro erro=%q", err)
+		} else if n > 0 {
+			log.Printf("evento=lixeira_expurgada produtos=%d", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 /*
 Instanciamos cada camada do domínio Products e usaremos os métodos do controlador para cada endpoint.
 */
@@ -90,13 +321,122 @@ func main() {
 
 	// log.Println("User: ", usuario)
 	// log.Println("Password: ", password)
-	store := store.Factory("arquivo", "products.json")
-	if store == nil {
-		log.Fatal("Não foi possivel criar a store")
+	// A store vem do DSN configurado (ex.: STORE_DSN=file://products.json, veja o .env_example)
+	// A moeda do catálogo vem antes da store: os preços antigos dos produtos são lidos nela
+	currency, rates := loadMoney()
+
+	dsn := os.Getenv("STORE_DSN")
+	if dsn == "" {
+		dsn = legacyDSN()
+	}
+
+	db, fs := openStore(dsn)
+	isFile := fs != nil
+
+	// A store SQLite guarda os produtos, as categorias, o livro de estoque e o histórico de preços em tabelas,
+	// então usa os repositórios SQL; as demais guardam um documento, e os outros domínios ficam em stores à parte (veja sideDSN)
+	var repo products.Repository
+	var categoryRepo categories.Repository
+	var stockRepo stock.Repository
+	var priceRepo prices.Repository
+	// sideFiles são os arquivos das stores dos outros domínios, migrados junto com o dos produtos (veja upgradeStore)
+	var sideFiles []*store.FileStore
+	if sq, ok := db.(*store.SQLiteStore); ok {
+		// As categorias primeiro: a tabela de produtos aponta para a de categorias
+		categoryRepo, err = categories.NewSQLRepository(sq.DB())
+		if err != nil {
+			log.Fatal("não foi possível preparar o banco: ", err)
+		}
+		repo, err = products.NewSQLRepository(sq.DB())
+		if err != nil {
+			log.Fatal("não foi possível preparar o banco: ", err)
+		}
+		stockRepo, err = stock.NewSQLRepository(sq.DB())
+		if err != nil {
+			log.Fatal("não foi possível preparar o banco: ", err)
+		}
+		priceRepo, err = prices.NewSQLRepository(sq.DB(), currency)
+		if err != nil {
+			log.Fatal("não foi possível preparar o banco: ", err)
+		}
+	} else {
+		repo = products.NewRepository(db)
+		categoryDB, categoryFile := openStore(sideDSN(dsn, "categories", "CATEGORIES_STORE_DSN"))
+		categoryRepo = categories.NewRepository(categoryDB)
+		stockDB, stockFile := openStore(sideDSN(dsn, "movements", "MOVEMENTS_STORE_DSN"))
+		stockRepo = stock.NewRepository(stockDB)
+		priceDB, priceFile := openStore(sideDSN(dsn, "prices", "PRICES_STORE_DSN"))
+		priceRepo = prices.NewRepository(priceDB, currency)
+		sideFiles = []*store.FileStore{categoryFile, stockFile, priceFile}
+	}
+	categoryService := categories.NewService(categoryRepo, repo)
+	stockService := stock.NewService(stockRepo, repo)
+	priceService := prices.NewService(priceRepo, repo)
+	service := products.NewService(repo, categoryService, stockService, priceService, currency, rates)
+	// As migrations do arquivo dos produtos resolvem as categorias em texto pelo Service das categorias:
+	// entram depois dele e antes da primeira leitura da store
+	useMigrations(db, products.Migrations(categoryService, currency))
+
+	// As categorias primeiro: a migration dos produtos antigos pode criar categorias
+	for _, f := range sideFiles {
+		upgradeStore(f)
 	}
-	repo := products.NewRepository(store)
-	service := products.NewService(repo)
+	upgradeStore(fs)
+
+	// Produtos gravados antes das categorias guardam o nome dela em texto: convertemos antes de atender requisições
+	// (os arquivos com envelope já foram convertidos pela migration do schema, no upgradeStore)
+	if n, err := service.MigrateCategories(); errors.Is(err, store.ErrCorrupted) {
+		log.Printf("evento=categorias_nao_migradas erro=%q", err)
+	} else if err != nil {
+		log.Fatal("não foi possível converter as categorias dos produtos: ", err)
+	} else if n > 0 {
+		log.Printf("evento=categorias_migradas produtos=%d", n)
+	}
+
+	// Preços gravados como float, antes do money.Money, passam para unidades mínimas da moeda do catálogo
+	// (como as categorias, os arquivos com envelope já foram convertidos no upgradeStore)
+	if n, err := service.MigratePrices(); errors.Is(err, store.ErrCorrupted) {
+		log.Printf("evento=precos_nao_migrados erro=%q", err)
+	} else if err != nil {
+		log.Fatal("não foi possível converter os preços dos produtos: ", err)
+	} else if n > 0 {
+		log.Printf("evento=precos_migrados produtos=%d moeda=%s", n, currency)
+	}
+
+	// O livro de estoque manda no Count dos produtos: acertamos as divergências antes de atender requisições
+	if n, err := stockService.Reconcile(); errors.Is(err, store.ErrCorrupted) {
+		log.Printf("evento=estoque_nao_conciliado erro=%q", err)
+	} else if err != nil {
+		log.Fatal("não foi possível conciliar o estoque com o livro: ", err)
+	} else if n > 0 {
+		log.Printf("evento=estoque_conciliado produtos=%d", n)
+	}
+
+	// Preços gravados sem passar pelo Service (antes do histórico, ou numa queda no meio da gravação) entram no histórico
+	if n, err := priceService.Sync("alteração fora da API"); errors.Is(err, store.ErrCorrupted) {
+		log.Printf("evento=precos_nao_conciliados erro=%q", err)
+	} else if err != nil {
+		log.Fatal("não foi possível conciliar o histórico de preços: ", err)
+	} else if n > 0 {
+		log.Printf("evento=precos_conciliados alteracoes=%d", n)
+	}
+
+	// Com argumentos, executamos o subcomando administrativo em vez de subir a API
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Stdout, os.Args[1:], db, service, stockService, priceService); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// A limpeza da lixeira só roda com a API no ar: os subcomandos terminam antes
+	if retention, interval := loadTrash(); retention > 0 {
+		go purgeTrash(context.Background(), service, retention, interval)
+	}
+
 	p := handler.NewProduct(service)
+	st := handler.NewStock(stockService)
+	pc := handler.NewPrice(priceService)
 
 	r := gin.Default()
 	pr := r.Group("/products")
@@ -105,9 +445,53 @@ func main() {
 
 		pr.POST("/", p.Store())
 		pr.GET("/", p.GetAll())
+		pr.GET("/search", p.Search())
+		pr.GET("/by-sku/:sku", p.GetBySKU())
+		pr.GET("/by-gtin/:gtin", p.GetByGTIN())
+		pr.GET("/trash", p.Trash())
+		pr.GET("/:id", p.Get())
 		pr.PUT("/:id", p.Update())
 		pr.PATCH("/:id", p.UpdateName())
 		pr.DELETE("/:id", p.Delete())
+		pr.POST("/:id/restore", p.Restore())
+		pr.POST("/:id/stock/movements", st.Store())
+		pr.GET("/:id/stock/movements", st.List())
+		pr.GET("/:id/prices", pc.History())
+		pr.GET("/:id/quote", p.Quote())
+	}
+
+	rp := r.Group("/reports")
+	{
+		rp.Use(TokenAuthMiddleware())
+
+		rp.GET("/price-changes", pc.Report())
+		rp.GET("/inventory-value", p.InventoryValue())
+	}
+
+	cat := handler.NewCategory(categoryService)
+	cr := r.Group("/categories")
+	{
+		cr.Use(TokenAuthMiddleware())
+
+		cr.GET("/", cat.GetAll())
+		cr.GET("/tree", cat.Tree())
+		cr.GET("/:id", cat.Get())
+		cr.POST("/", cat.Store())
+		cr.PUT("/:id", cat.Update())
+		cr.DELETE("/:id", cat.Delete())
+	}
+
+	// Os backups só existem na store de arquivo (com ou sem criptografia)
+	if bs, ok := db.(store.BackupStore); ok && isFile {
+		b := handler.NewBackup(bs, service, stockService, priceService)
+		ad := r.Group("/admin/backups")
+		{
+			ad.Use(TokenAuthMiddleware())
+
+			ad.GET("/", b.List())
+			ad.GET("/:name/diff", b.Diff())
+			ad.POST("/:name/restore", b.Restore())
+		}
 	}
 
 	docs.SwaggerInfo.Host = os.Getenv("HOST")
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// A configuração antiga (STORE_TYPE, STORE_FILE e BACKUP_*) continua valendo sem o STORE_DSN
+func TestLegacyDSN(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"nada configurado", map[string]string{}, "file://products.json"},
+		{"arquivo", map[string]string{"STORE_TYPE": store.FileType, "STORE_FILE": "dados/catalogo.json"}, "file://dados/catalogo.json"},
+		{"arquivo com STORE_FILE vazia", map[string]string{"STORE_TYPE": store.FileType, "STORE_FILE": ""}, "file://products.json"},
+		{"backups", map[string]string{"BACKUP_DIR": "bkp", "BACKUP_MAX_COUNT": "5", "BACKUP_MAX_AGE": "24h"},
+			"file://products.json?backup_dir=bkp&backup_max_age=24h&backup_max_count=5"},
+		{"memória sem STORE_FILE", map[string]string{"STORE_TYPE": store.MemoryType}, "mem://?seed=products.json"},
+		{"memória com STORE_FILE vazia", map[string]string{"STORE_TYPE": store.MemoryType, "STORE_FILE": ""}, "mem://"},
+		{"memória com massa de dados", map[string]string{"STORE_TYPE": store.MemoryType, "STORE_FILE": "seed.json"}, "mem://?seed=seed.json"},
+		{"backups não valem na memória", map[string]string{"STORE_TYPE": store.MemoryType, "STORE_FILE": "", "BACKUP_DIR": "bkp"}, "mem://"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, key := range []string{"STORE_TYPE", "STORE_FILE", "BACKUP_DIR", "BACKUP_MAX_COUNT", "BACKUP_MAX_AGE"} {
+				// STORE_FILE vazia e ausente são diferentes: as variáveis fora do caso são apagadas
+				if v, ok := c.env[key]; ok {
+					t.Setenv(key, v)
+				} else {
+					unsetenv(t, key)
+				}
+			}
+			if got := legacyDSN(); got != c.want {
+				t.Fatalf("legacyDSN() = %q, esperado %q", got, c.want)
+			}
+		})
+	}
+}
+
+// O DSN montado a partir da configuração antiga abre a store; um STORE_TYPE desconhecido é recusado pelo Open
+func TestLegacyDSNOpens(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("STORE_TYPE", store.FileType)
+	t.Setenv("STORE_FILE", filepath.Join(dir, "products.json"))
+	t.Setenv("BACKUP_MAX_COUNT", "7")
+	s, err := store.Open(legacyDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs, ok := s.(*store.FileStore); !ok || fs.Backups.MaxCount != 7 {
+		t.Fatalf("Open(legacyDSN()) = %#v, esperado a FileStore com 7 backups", s)
+	}
+
+	t.Setenv("STORE_TYPE", "banco")
+	if _, err := store.Open(legacyDSN()); err == nil {
+		t.Fatal("Open aceitou um STORE_TYPE desconhecido")
+	}
+}
+
+// unsetenv apaga a variável durante o teste; o t.Setenv antes dele devolve o valor original no fim
+func unsetenv(t *testing.T, key string) {
+	t.Helper()
+	t.Setenv(key, "")
+	os.Unsetenv(key)
+}
@@ -0,0 +1,10 @@
+-- Tabela de categorias, com os mesmos campos de categories.Category
+-- parent_id nulo indica uma categoria raiz
+CREATE TABLE categories (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    name      TEXT    NOT NULL,
+    parent_id INTEGER REFERENCES categories (id)
+);
+
+-- Índice para montar a árvore (as subcategorias de cada categoria)
+CREATE INDEX idx_categories_parent_id ON categories (parent_id);
@@ -0,0 +1,140 @@
+package categories
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// Category é um nó da árvore de categorias; ParentID nulo indica uma categoria raiz
+type Category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+}
+
+// ErrNotFound é devolvido (embrulhado, ex.: "categoria 5 não encontrada") quando a categoria pedida não existe
+var ErrNotFound = errors.New("não encontrada")
+
+func notFoundError(id int) error {
+	return fmt.Errorf("categoria %d %w", id, ErrNotFound)
+}
+
+// Repository guarda as categorias; as regras da árvore (pai existente, sem ciclos, nomes únicos) ficam no Service
+type Repository interface {
+	GetAll() ([]Category, error)
+	GetByID(id int) (Category, error)
+	// Store atribui o próximo ID à categoria, na mesma transação em que grava
+	Store(name string, parentID *int) (Category, error)
+	Update(id int, name string, parentID *int) (Category, error)
+	Delete(id int) error
+}
+
+// repository guarda a lista de categorias num documento da store, como a repository de produtos
+type repository struct {
+	db store.Store
+}
+
+// Função que retorna o repositório de categorias sobre a store informada
+func NewRepository(db store.Store) Repository {
+	return &repository{
+		db: db,
+	}
+}
+
+// Enquanto nenhuma categoria foi criada o arquivo não existe: a lista está vazia
+func (r *repository) GetAll() ([]Category, error) {
+	cs := []Category{}
+	if err := r.db.Read(&cs); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func (r *repository) GetByID(id int) (Category, error) {
+	cs, err := r.GetAll()
+	if err != nil {
+		return Category{}, err
+	}
+	for _, c := range cs {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return Category{}, notFoundError(id)
+}
+
+func (r *repository) Store(name string, parentID *int) (Category, error) {
+	var c Category
+	err := r.db.Update(func(tx store.Tx) error {
+		cs := []Category{}
+		if err := tx.Read(&cs); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		c = Category{ID: nextID(tx, cs), Name: name, ParentID: parentID}
+		if seq, ok := tx.(store.Sequence); ok {
+			seq.SetLastID(c.ID)
+		}
+		return tx.Write(append(cs, c))
+	})
+	if err != nil {
+		return Category{}, err
+	}
+	return c, nil
+}
+
+// nextID devolve o ID da próxima categoria: o seguinte ao último atribuído, que a store guarda junto dos dados
+// (veja store.Sequence). Sem o contador, o ID de uma categoria removida voltaria para a próxima criada,
+// e os produtos e backups que ainda apontam para a antiga passariam para a nova
+func nextID(tx store.Tx, cs []Category) int {
+	last := 0
+	for _, c := range cs {
+		if c.ID > last {
+			last = c.ID
+		}
+	}
+	if seq, ok := tx.(store.Sequence); ok && seq.LastID() > last {
+		last = seq.LastID()
+	}
+	return last + 1
+}
+
+func (r *repository) Update(id int, name string, parentID *int) (Category, error) {
+	var c Category
+	err := r.db.Update(func(tx store.Tx) error {
+		cs := []Category{}
+		if err := tx.Read(&cs); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for i := range cs {
+			if cs[i].ID == id {
+				cs[i].Name, cs[i].ParentID = name, parentID
+				c = cs[i]
+				return tx.Write(cs)
+			}
+		}
+		return notFoundError(id)
+	})
+	if err != nil {
+		return Category{}, err
+	}
+	return c, nil
+}
+
+func (r *repository) Delete(id int) error {
+	return r.db.Update(func(tx store.Tx) error {
+		cs := []Category{}
+		if err := tx.Read(&cs); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for i := range cs {
+			if cs[i].ID == id {
+				return tx.Write(append(cs[:i], cs[i+1:]...))
+			}
+		}
+		return notFoundError(id)
+	})
+}
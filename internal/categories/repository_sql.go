@@ -0,0 +1,116 @@
+package categories
+
+import (
+	"database/sql"
+	"embed"
+	"io/fs"
+
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// As migrations das categorias ficam junto do pacote, como as dos produtos
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// sqlRepository é a implementação do Repository sobre um banco SQL (SQLite)
+type sqlRepository struct {
+	db *sql.DB
+}
+
+// Função que aplica as migrations pendentes e retorna o repositório SQL
+func NewSQLRepository(db *sql.DB) (Repository, error) {
+	dir, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Migrate(db, dir); err != nil {
+		return nil, err
+	}
+	return &sqlRepository{db: db}, nil
+}
+
+func (r *sqlRepository) GetAll() ([]Category, error) {
+	rows, err := r.db.Query(`SELECT id, name, parent_id FROM categories ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cs := []Category{}
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
+
+func (r *sqlRepository) GetByID(id int) (Category, error) {
+	c, err := scanCategory(r.db.QueryRow(`SELECT id, name, parent_id FROM categories WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return Category{}, notFoundError(id)
+	}
+	return c, err
+}
+
+// scanner é o que *sql.Row e *sql.Rows têm em comum
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCategory(s scanner) (Category, error) {
+	var c Category
+	var parent sql.NullInt64
+	if err := s.Scan(&c.ID, &c.Name, &parent); err != nil {
+		return Category{}, err
+	}
+	if parent.Valid {
+		id := int(parent.Int64)
+		c.ParentID = &id
+	}
+	return c, nil
+}
+
+func (r *sqlRepository) Store(name string, parentID *int) (Category, error) {
+	res, err := r.db.Exec(`INSERT INTO categories (name, parent_id) VALUES (?, ?)`, name, parentID)
+	if err != nil {
+		return Category{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Category{}, err
+	}
+	return Category{ID: int(id), Name: name, ParentID: parentID}, nil
+}
+
+func (r *sqlRepository) Update(id int, name string, parentID *int) (Category, error) {
+	res, err := r.db.Exec(`UPDATE categories SET name = ?, parent_id = ? WHERE id = ?`, name, parentID, id)
+	if err := notFound(res, err, id); err != nil {
+		return Category{}, err
+	}
+	return Category{ID: id, Name: name, ParentID: parentID}, nil
+}
+
+// A chave estrangeira dos produtos e das subcategorias impede a remoção de uma categoria em uso
+func (r *sqlRepository) Delete(id int) error {
+	res, err := r.db.Exec(`DELETE FROM categories WHERE id = ?`, id)
+	return notFound(res, err, id)
+}
+
+// notFound converte "nenhuma linha alterada" no erro de categoria inexistente
+func notFound(res sql.Result, err error, id int) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFoundError(id)
+	}
+	return nil
+}
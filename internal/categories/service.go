@@ -0,0 +1,367 @@
+package categories
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anwardh/meliProject/pkg/search"
+)
+
+// ErrInUse é devolvido ao remover uma categoria que ainda tem subcategorias ou produtos
+var ErrInUse = errors.New("a categoria não está vazia")
+
+// ErrDuplicate é devolvido quando já existe uma categoria com o mesmo nome no mesmo nível da árvore
+var ErrDuplicate = errors.New("já existe uma categoria com esse nome no mesmo nível")
+
+// ErrInvalidParent é devolvido quando a categoria pai não existe, ou é a própria categoria ou uma subcategoria dela
+var ErrInvalidParent = errors.New("categoria pai inválida")
+
+// Products é o que as categorias precisam saber dos produtos (implementado pela repository de produtos)
+type Products interface {
+	// CountByCategory devolve quantos produtos estão diretamente na categoria
+	CountByCategory(categoryID int) (int, error)
+}
+
+// Node é uma categoria com as subcategorias, para montar a árvore
+type Node struct {
+	Category
+	Children []Node `json:"children"`
+}
+
+// Detail é uma categoria com o caminho desde a raiz (breadcrumbs, incluindo a própria) e as subcategorias diretas
+type Detail struct {
+	Category
+	Breadcrumbs []Category `json:"breadcrumbs"`
+	Children    []Category `json:"children"`
+}
+
+// Criação da Interface
+type Service interface {
+	GetAll() ([]Category, error)
+	// Get devolve a categoria com os breadcrumbs e as subcategorias
+	Get(id int) (Detail, error)
+	// Tree devolve a árvore inteira, a partir das categorias raiz
+	Tree() ([]Node, error)
+	// Subtree devolve o ID da categoria e os de todas as subcategorias, em qualquer nível
+	Subtree(id int) ([]int, error)
+	// SubtreeNamed faz o mesmo que o Subtree para todas as categorias com o nome, em qualquer nível da árvore
+	// (sem diferenciar acentos, maiúsculas e plural); nenhuma com o nome devolve uma lista vazia
+	SubtreeNamed(name string) ([]int, error)
+	// Paths devolve o caminho de cada categoria pelos nomes (ex.: "Bebidas > Refrigerantes")
+	Paths() (map[int]string, error)
+	Store(name string, parentID *int) (Category, error)
+	Update(id int, name string, parentID *int) (Category, error)
+	// Delete só remove categorias sem subcategorias e sem produtos
+	Delete(id int) error
+	// Use executa fn garantindo que a categoria existe e não é removida enquanto fn roda
+	// (ex.: gravar um produto que aponta para ela)
+	Use(id int, fn func() error) error
+	// Resolve devolve a categoria raiz com o nome (sem diferenciar acentos, maiúsculas e plural), criando-a se preciso
+	Resolve(name string) (Category, error)
+	// OnChange registra uma função chamada depois de cada renomeação ou mudança de lugar de uma categoria
+	OnChange(fn func())
+}
+
+/*
+service guarda as regras da árvore. O mutex faz a conferência e a gravação acontecerem juntas:
+sem ele, um produto poderia ser gravado numa categoria enquanto ela é removida,
+ou duas categorias com o mesmo nome poderiam ser criadas ao mesmo tempo
+*/
+type service struct {
+	repository Repository
+	products   Products
+
+	mu        sync.RWMutex
+	listeners []func()
+}
+
+func NewService(r Repository, p Products) Service {
+	return &service{
+		repository: r,
+		products:   p,
+	}
+}
+
+func (s *service) GetAll() ([]Category, error) {
+	return s.repository.GetAll()
+}
+
+func (s *service) Get(id int) (Detail, error) {
+	t, err := s.load()
+	if err != nil {
+		return Detail{}, err
+	}
+	c, ok := t.byID[id]
+	if !ok {
+		return Detail{}, notFoundError(id)
+	}
+	children := t.children[id]
+	if children == nil {
+		children = []Category{}
+	}
+	return Detail{Category: c, Breadcrumbs: t.breadcrumbs(id), Children: children}, nil
+}
+
+func (s *service) Tree() ([]Node, error) {
+	t, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return t.nodes(0, map[int]bool{}), nil
+}
+
+func (s *service) Subtree(id int) ([]int, error) {
+	t, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.byID[id]; !ok {
+		return nil, notFoundError(id)
+	}
+	return t.subtree(id), nil
+}
+
+func (s *service) SubtreeNamed(name string) ([]int, error) {
+	t, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	key := search.Key(name)
+	ids := []int{}
+	seen := map[int]bool{}
+	for _, c := range t.byID {
+		if search.Key(c.Name) != key || seen[c.ID] {
+			continue
+		}
+		// Uma categoria com o nome pode estar dentro de outra com o mesmo nome: os IDs não se repetem
+		for _, id := range t.subtree(c.ID) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (s *service) Paths() (map[int]string, error) {
+	t, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[int]string, len(t.byID))
+	for id := range t.byID {
+		var names []string
+		for _, c := range t.breadcrumbs(id) {
+			names = append(names, c.Name)
+		}
+		paths[id] = strings.Join(names, " > ")
+	}
+	return paths, nil
+}
+
+func (s *service) Store(name string, parentID *int) (Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.load()
+	if err != nil {
+		return Category{}, err
+	}
+	if err := t.checkPlace(0, name, parentID); err != nil {
+		return Category{}, err
+	}
+	return s.repository.Store(name, parentID)
+}
+
+func (s *service) Update(id int, name string, parentID *int) (Category, error) {
+	c, err := s.update(id, name, parentID)
+	if err != nil {
+		return Category{}, err
+	}
+	// Os produtos mostram (e buscam) o caminho da categoria, que pode ter mudado
+	for _, fn := range s.listeners {
+		fn()
+	}
+	return c, nil
+}
+
+func (s *service) update(id int, name string, parentID *int) (Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.load()
+	if err != nil {
+		return Category{}, err
+	}
+	if _, ok := t.byID[id]; !ok {
+		return Category{}, notFoundError(id)
+	}
+	if err := t.checkPlace(id, name, parentID); err != nil {
+		return Category{}, err
+	}
+	return s.repository.Update(id, name, parentID)
+}
+
+func (s *service) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := t.byID[id]; !ok {
+		return notFoundError(id)
+	}
+	if n := len(t.children[id]); n > 0 {
+		return fmt.Errorf("%w: a categoria %d tem %d subcategoria(s)", ErrInUse, id, n)
+	}
+	n, err := s.products.CountByCategory(id)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%w: a categoria %d tem %d produto(s)", ErrInUse, id, n)
+	}
+	return s.repository.Delete(id)
+}
+
+func (s *service) Use(id int, fn func() error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.repository.GetByID(id); err != nil {
+		return err
+	}
+	return fn()
+}
+
+func (s *service) Resolve(name string) (Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.load()
+	if err != nil {
+		return Category{}, err
+	}
+	key := search.Key(name)
+	for _, c := range t.children[0] {
+		if search.Key(c.Name) == key {
+			return c, nil
+		}
+	}
+	return s.repository.Store(strings.TrimSpace(name), nil)
+}
+
+// OnChange deve ser chamado na montagem da aplicação, antes das requisições
+func (s *service) OnChange(fn func()) {
+	s.listeners = append(s.listeners, fn)
+}
+
+// tree é a árvore de categorias montada a partir da lista; o ID 0 representa a raiz
+type tree struct {
+	byID     map[int]Category
+	children map[int][]Category
+}
+
+func (s *service) load() (*tree, error) {
+	cs, err := s.repository.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	t := &tree{byID: make(map[int]Category, len(cs)), children: map[int][]Category{}}
+	for _, c := range cs {
+		t.byID[c.ID] = c
+	}
+	for _, c := range cs {
+		parent := 0
+		if c.ParentID != nil {
+			parent = *c.ParentID
+		}
+		t.children[parent] = append(t.children[parent], c)
+	}
+	for _, cs := range t.children {
+		sort.Slice(cs, func(i, j int) bool {
+			if a, b := strings.ToLower(cs[i].Name), strings.ToLower(cs[j].Name); a != b {
+				return a < b
+			}
+			return cs[i].ID < cs[j].ID
+		})
+	}
+	return t, nil
+}
+
+// breadcrumbs devolve o caminho da raiz até a categoria (inclusive); seen protege de um ciclo gravado por fora
+func (t *tree) breadcrumbs(id int) []Category {
+	var path []Category
+	seen := map[int]bool{}
+	for c, ok := t.byID[id]; ok && !seen[c.ID]; {
+		seen[c.ID] = true
+		path = append([]Category{c}, path...)
+		if c.ParentID == nil {
+			break
+		}
+		c, ok = t.byID[*c.ParentID]
+	}
+	return path
+}
+
+func (t *tree) nodes(parent int, seen map[int]bool) []Node {
+	nodes := []Node{}
+	for _, c := range t.children[parent] {
+		if seen[c.ID] {
+			continue
+		}
+		seen[c.ID] = true
+		nodes = append(nodes, Node{Category: c, Children: t.nodes(c.ID, seen)})
+	}
+	return nodes
+}
+
+func (t *tree) subtree(id int) []int {
+	ids := []int{id}
+	seen := map[int]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, c := range t.children[ids[i]] {
+			if !seen[c.ID] {
+				seen[c.ID] = true
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+	return ids
+}
+
+/*
+checkPlace confere se a categoria id (0 para uma nova) pode ter esse nome e ficar sob parentID:
+o pai precisa existir, não pode ser ela mesma nem uma subcategoria dela, e não pode haver outra categoria
+no mesmo nível com o mesmo nome (sem diferenciar acentos, maiúsculas e plural: "Bebida" e "bebidas" são iguais)
+*/
+func (t *tree) checkPlace(id int, name string, parentID *int) error {
+	parent := 0
+	if parentID != nil {
+		parent = *parentID
+		if _, ok := t.byID[parent]; !ok {
+			return fmt.Errorf("%w: a categoria %d não existe", ErrInvalidParent, parent)
+		}
+		if id != 0 {
+			for _, sub := range t.subtree(id) {
+				if sub == parent {
+					return fmt.Errorf("%w: a categoria não pode ficar dentro dela mesma nem de uma subcategoria dela", ErrInvalidParent)
+				}
+			}
+		}
+	}
+
+	key := search.Key(name)
+	for _, sibling := range t.children[parent] {
+		if sibling.ID != id && search.Key(sibling.Name) == key {
+			return fmt.Errorf("%w (%q, categoria %d)", ErrDuplicate, sibling.Name, sibling.ID)
+		}
+	}
+	return nil
+}
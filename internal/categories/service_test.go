@@ -0,0 +1,190 @@
+package categories
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// products conta os produtos de cada categoria, no lugar da repository de produtos
+type products map[int]int
+
+func (p products) CountByCategory(categoryID int) (int, error) {
+	return p[categoryID], nil
+}
+
+func newService(t *testing.T, p products) Service {
+	t.Helper()
+	return NewService(NewRepository(store.NewMemoryStore(nil)), p)
+}
+
+func mustStore(t *testing.T, s Service, name string, parentID *int) Category {
+	t.Helper()
+	c, err := s.Store(name, parentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// names devolve os nomes da árvore, com as subcategorias entre parênteses
+func names(nodes []Node) string {
+	out := ""
+	for i, n := range nodes {
+		if i > 0 {
+			out += " "
+		}
+		out += n.Name
+		if len(n.Children) > 0 {
+			out += "(" + names(n.Children) + ")"
+		}
+	}
+	return out
+}
+
+func TestServiceTree(t *testing.T) {
+	s := newService(t, products{})
+	bebidas := mustStore(t, s, "Bebidas", nil)
+	mustStore(t, s, "Padaria", nil)
+	refrigerantes := mustStore(t, s, "Refrigerantes", &bebidas.ID)
+	mustStore(t, s, "Sucos", &bebidas.ID)
+	cola := mustStore(t, s, "Cola", &refrigerantes.ID)
+
+	tree, err := s.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(tree), "Bebidas(Refrigerantes(Cola) Sucos) Padaria"; got != want {
+		t.Fatalf("Tree = %s, esperado %s", got, want)
+	}
+
+	d, err := s.Get(cola.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path []string
+	for _, c := range d.Breadcrumbs {
+		path = append(path, c.Name)
+	}
+	if fmt.Sprint(path) != "[Bebidas Refrigerantes Cola]" || len(d.Children) != 0 {
+		t.Fatalf("Get = %+v, esperado os breadcrumbs desde Bebidas e nenhuma subcategoria", d)
+	}
+
+	paths, err := s.Paths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths[cola.ID]; got != "Bebidas > Refrigerantes > Cola" {
+		t.Fatalf("Paths[Cola] = %q", got)
+	}
+
+	// O mesmo nome no mesmo nível é recusado, sem diferenciar acentos, maiúsculas e plural; em outro nível pode
+	if _, err := s.Store("bebida", nil); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("Store com o nome repetido = %v, esperado ErrDuplicate", err)
+	}
+	mustStore(t, s, "Bebidas", &refrigerantes.ID)
+	missing := 99
+	if _, err := s.Store("Doces", &missing); !errors.Is(err, ErrInvalidParent) {
+		t.Fatalf("Store com o pai inexistente = %v, esperado ErrInvalidParent", err)
+	}
+}
+
+// A categoria não pode ir para dentro dela mesma nem de uma subcategoria dela
+func TestServiceUpdateRejectsCycles(t *testing.T) {
+	s := newService(t, products{})
+	bebidas := mustStore(t, s, "Bebidas", nil)
+	refrigerantes := mustStore(t, s, "Refrigerantes", &bebidas.ID)
+	cola := mustStore(t, s, "Cola", &refrigerantes.ID)
+
+	for _, parent := range []int{bebidas.ID, refrigerantes.ID, cola.ID} {
+		parent := parent
+		if _, err := s.Update(bebidas.ID, "Bebidas", &parent); !errors.Is(err, ErrInvalidParent) {
+			t.Fatalf("Update de Bebidas para dentro da categoria %d = %v, esperado ErrInvalidParent", parent, err)
+		}
+	}
+	if c, err := s.GetAll(); err != nil || len(c) != 3 || c[0].ParentID != nil {
+		t.Fatalf("depois das recusas, GetAll = %+v, %v; esperado Bebidas ainda na raiz", c, err)
+	}
+
+	// Mudar de lugar para fora da própria subárvore pode
+	if _, err := s.Update(cola.ID, "Cola", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServiceDeleteBlockedWhileInUse(t *testing.T) {
+	p := products{}
+	s := newService(t, p)
+	bebidas := mustStore(t, s, "Bebidas", nil)
+	refrigerantes := mustStore(t, s, "Refrigerantes", &bebidas.ID)
+	p[refrigerantes.ID] = 2
+
+	if err := s.Delete(bebidas.ID); !errors.Is(err, ErrInUse) {
+		t.Fatalf("Delete da categoria com subcategoria = %v, esperado ErrInUse", err)
+	}
+	if err := s.Delete(refrigerantes.ID); !errors.Is(err, ErrInUse) {
+		t.Fatalf("Delete da categoria com produtos = %v, esperado ErrInUse", err)
+	}
+
+	delete(p, refrigerantes.ID)
+	if err := s.Delete(refrigerantes.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(bebidas.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(bebidas.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Delete da categoria removida = %v, esperado ErrNotFound", err)
+	}
+}
+
+// O ?category=<nome> da listagem de produtos: todas as categorias com o nome, em qualquer nível, com as subcategorias
+func TestServiceSubtreeNamed(t *testing.T) {
+	s := newService(t, products{})
+	bebidas := mustStore(t, s, "Bebidas", nil)
+	refrigerantes := mustStore(t, s, "Refrigerantes", &bebidas.ID)
+	cola := mustStore(t, s, "Cola", &refrigerantes.ID)
+	mercado := mustStore(t, s, "Mercado", nil)
+	outras := mustStore(t, s, "Bebidas", &mercado.ID)
+	mustStore(t, s, "Padaria", nil)
+
+	for _, name := range []string{"Bebidas", "bebida", "BEBÍDAS"} {
+		ids, err := s.SubtreeNamed(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Ints(ids)
+		if want := []int{bebidas.ID, refrigerantes.ID, cola.ID, outras.ID}; fmt.Sprint(ids) != fmt.Sprint(want) {
+			t.Fatalf("SubtreeNamed(%q) = %v, esperado %v", name, ids, want)
+		}
+	}
+	if ids, err := s.SubtreeNamed("Refrigerante"); err != nil || fmt.Sprint(ids) != fmt.Sprint([]int{refrigerantes.ID, cola.ID}) {
+		t.Fatalf("SubtreeNamed(Refrigerante) = %v, %v", ids, err)
+	}
+	if ids, err := s.SubtreeNamed("Doces"); err != nil || ids == nil || len(ids) != 0 {
+		t.Fatalf("SubtreeNamed de um nome sem categoria = %#v, %v; esperado uma lista vazia", ids, err)
+	}
+}
+
+// O ID de uma categoria removida não volta para a próxima criada
+func TestRepositoryDoesNotReuseIDs(t *testing.T) {
+	r := NewRepository(store.NewMemoryStore(nil))
+	for _, name := range []string{"Bebidas", "Padaria"} {
+		if _, err := r.Store(name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.Store("Doces", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != 3 {
+		t.Fatalf("Store depois de remover a última categoria = ID %d, esperado 3", c.ID)
+	}
+}
@@ -0,0 +1,15 @@
+-- Histórico de preços, com os mesmos campos de prices.Change
+-- Sem chave estrangeira para products: o histórico continua depois que o produto é removido
+CREATE TABLE price_changes (
+    id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+    product_id INTEGER   NOT NULL,
+    old_price  REAL,
+    new_price  REAL      NOT NULL,
+    changed_by TEXT      NOT NULL,
+    reason     TEXT      NOT NULL DEFAULT '',
+    changed_at TIMESTAMP NOT NULL
+);
+
+-- Índices para o histórico de cada produto e para o relatório por período
+CREATE INDEX idx_price_changes_product_id ON price_changes (product_id, id);
+CREATE INDEX idx_price_changes_changed_at ON price_changes (changed_at);
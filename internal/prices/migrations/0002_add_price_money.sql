@@ -0,0 +1,6 @@
+-- Os preços passam a ser guardados em unidades mínimas da moeda, com o código ISO 4217 da moeda (veja money.Money).
+-- As alterações gravadas antes disso ficam como estão, com currency vazia: old_price e new_price são lidos
+-- na moeda do catálogo (veja scanChange); as novas gravam os valores em old_amount e new_amount e deixam new_price zerado
+ALTER TABLE price_changes ADD COLUMN currency TEXT NOT NULL DEFAULT '';
+ALTER TABLE price_changes ADD COLUMN old_amount INTEGER;
+ALTER TABLE price_changes ADD COLUMN new_amount INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,177 @@
+package prices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// Change é uma alteração de preço: de quanto, para quanto, quem alterou e quando.
+// OldPrice é nulo no primeiro preço do produto (o do cadastro). Os preços são os da moeda do catálogo;
+// as alterações gravadas como número, antes do money.Money, são lidas nela (veja o NewRepository)
+type Change struct {
+	ID        int          `json:"id"`
+	ProductID int          `json:"product_id"`
+	OldPrice  *money.Money `json:"old_price"`
+	NewPrice  money.Money  `json:"new_price"`
+	ChangedBy string       `json:"changed_by"`
+	Reason    string       `json:"reason,omitempty"`
+	ChangedAt time.Time    `json:"changed_at"`
+}
+
+// Filter são os critérios da consulta ao histórico; campos vazios não filtram nada
+type Filter struct {
+	ProductID int
+	// From e To limitam o horário da alteração, com os extremos incluídos
+	From time.Time
+	To   time.Time
+}
+
+// Match diz se a alteração atende a todos os critérios do filtro
+func (f Filter) Match(c Change) bool {
+	if f.ProductID != 0 && c.ProductID != f.ProductID {
+		return false
+	}
+	if !f.From.IsZero() && c.ChangedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && c.ChangedAt.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// Repository guarda o histórico de preços, que só recebe alterações novas (nada é alterado nem removido)
+type Repository interface {
+	// Append atribui os próximos IDs às alterações, na mesma transação em que grava (ou grava todas, ou nenhuma)
+	Append(cs ...Change) ([]Change, error)
+	// List devolve as alterações que atendem ao filtro, da mais antiga para a mais nova
+	List(f Filter) ([]Change, error)
+	// Latest devolve o último preço registrado de cada produto que tem histórico
+	Latest() (map[int]money.Money, error)
+}
+
+// repository guarda o histórico num documento da store, como o livro de estoque
+type repository struct {
+	db       store.Store
+	currency string
+}
+
+// Função que retorna o repositório do histórico de preços sobre a store informada;
+// currency é a moeda do catálogo, em que são lidas as alterações gravadas só com o número
+func NewRepository(db store.Store, currency string) Repository {
+	return &repository{
+		db:       db,
+		currency: currency,
+	}
+}
+
+// legacyChange é a alteração com os preços como foram gravados, talvez de antes do money.Money (só o número)
+type legacyChange struct {
+	Change
+	OldPrice json.RawMessage `json:"old_price"`
+	NewPrice json.RawMessage `json:"new_price"`
+}
+
+// decodeChange lê a alteração gravada; os preços antigos, só números, são lidos na moeda do catálogo
+func decodeChange(item json.RawMessage, currency string) (Change, error) {
+	var l legacyChange
+	if err := json.Unmarshal(item, &l); err != nil {
+		return Change{}, err
+	}
+	c := l.Change
+	price, err := money.FromLegacy(l.NewPrice, currency)
+	if err != nil {
+		return Change{}, fmt.Errorf("alteração de preço %d: %w", c.ID, err)
+	}
+	c.NewPrice = price
+	if len(l.OldPrice) > 0 && string(l.OldPrice) != "null" {
+		old, err := money.FromLegacy(l.OldPrice, currency)
+		if err != nil {
+			return Change{}, fmt.Errorf("alteração de preço %d: %w", c.ID, err)
+		}
+		c.OldPrice = &old
+	}
+	return c, nil
+}
+
+func (r *repository) Append(cs ...Change) ([]Change, error) {
+	appended := make([]Change, len(cs))
+	err := r.db.Update(func(tx store.Tx) error {
+		// As alterações gravadas voltam como estão: as antigas continuam com os preços só em número
+		history := []json.RawMessage{}
+		if err := tx.Read(&history); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		id := 0
+		if len(history) > 0 {
+			var last struct {
+				ID int `json:"id"`
+			}
+			if err := json.Unmarshal(history[len(history)-1], &last); err != nil {
+				return err
+			}
+			id = last.ID
+		}
+		for i, c := range cs {
+			id++
+			c.ID = id
+			appended[i] = c
+			raw, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			history = append(history, raw)
+		}
+		return tx.Write(history)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return appended, nil
+}
+
+func (r *repository) List(f Filter) ([]Change, error) {
+	cs := []Change{}
+	err := r.each(func(c Change) {
+		if f.Match(c) {
+			cs = append(cs, c)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func (r *repository) Latest() (map[int]money.Money, error) {
+	latest := map[int]money.Money{}
+	err := r.each(func(c Change) {
+		latest[c.ProductID] = c.NewPrice
+	})
+	if err != nil {
+		return nil, err
+	}
+	return latest, nil
+}
+
+// each percorre o histórico em ordem, uma alteração por vez (veja store.Each); sem arquivo, o histórico está vazio
+func (r *repository) each(fn func(c Change)) error {
+	err := store.Each(r.db, func(item json.RawMessage) error {
+		c, err := decodeChange(item, r.currency)
+		if err != nil {
+			return err
+		}
+		fn(c)
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
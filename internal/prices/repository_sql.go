@@ -0,0 +1,165 @@
+package prices
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// As migrations do histórico de preços ficam junto do pacote, como as dos produtos
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// changeColumns são as colunas de Change, na ordem do scanChange
+const changeColumns = `id, product_id, old_price, new_price, currency, old_amount, new_amount, changed_by, reason, changed_at`
+
+// scanner é o que scanChange precisa de *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanChange lê as colunas de changeColumns; as alterações antigas, sem moeda, são lidas em currency (a moeda do catálogo)
+func scanChange(row scanner, currency string) (Change, error) {
+	var c Change
+	var oldPrice sql.NullFloat64
+	var newPrice float64
+	var saved string
+	var oldAmount sql.NullInt64
+	var newAmount int64
+	if err := row.Scan(&c.ID, &c.ProductID, &oldPrice, &newPrice, &saved, &oldAmount, &newAmount,
+		&c.ChangedBy, &c.Reason, &c.ChangedAt); err != nil {
+		return Change{}, err
+	}
+
+	if saved != "" {
+		c.NewPrice = money.Money{Amount: newAmount, Currency: saved}
+		if oldAmount.Valid {
+			c.OldPrice = &money.Money{Amount: oldAmount.Int64, Currency: saved}
+		}
+		return c, nil
+	}
+	price, err := money.FromFloat(newPrice, currency)
+	if err != nil {
+		return Change{}, fmt.Errorf("alteração de preço %d: %w", c.ID, err)
+	}
+	c.NewPrice = price
+	if oldPrice.Valid {
+		old, err := money.FromFloat(oldPrice.Float64, currency)
+		if err != nil {
+			return Change{}, fmt.Errorf("alteração de preço %d: %w", c.ID, err)
+		}
+		c.OldPrice = &old
+	}
+	return c, nil
+}
+
+// sqlRepository é a implementação do Repository sobre um banco SQL (SQLite)
+type sqlRepository struct {
+	db       *sql.DB
+	currency string
+}
+
+// Função que aplica as migrations pendentes e retorna o repositório SQL; currency é a moeda do catálogo (veja NewRepository)
+func NewSQLRepository(db *sql.DB, currency string) (Repository, error) {
+	dir, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Migrate(db, dir); err != nil {
+		return nil, err
+	}
+	return &sqlRepository{db: db, currency: currency}, nil
+}
+
+// As alterações são inseridas numa transação do banco: ou todas são gravadas, ou nenhuma.
+// O preço anterior é de uma alteração da mesma moeda (a do catálogo), e vai só com o valor
+func (r *sqlRepository) Append(cs ...Change) ([]Change, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	appended := make([]Change, len(cs))
+	for i, c := range cs {
+		var old interface{}
+		if c.OldPrice != nil {
+			old = c.OldPrice.Amount
+		}
+		res, err := tx.Exec(`INSERT INTO price_changes (product_id, new_price, currency, old_amount, new_amount, changed_by, reason, changed_at)
+			VALUES (?, 0, ?, ?, ?, ?, ?, ?)`, c.ProductID, c.NewPrice.Currency, old, c.NewPrice.Amount, c.ChangedBy, c.Reason, c.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		c.ID = int(id)
+		appended[i] = c
+	}
+	return appended, tx.Commit()
+}
+
+// O filtro vira a cláusula WHERE; os horários são comparados em UTC, o fuso em que o Service grava as alterações
+func (r *sqlRepository) List(f Filter) ([]Change, error) {
+	var conds []string
+	var args []interface{}
+	if f.ProductID != 0 {
+		conds = append(conds, "product_id = ?")
+		args = append(args, f.ProductID)
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "changed_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "changed_at <= ?")
+		args = append(args, f.To.UTC())
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	rows, err := r.db.Query(`SELECT `+changeColumns+` FROM price_changes`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cs := []Change{}
+	for rows.Next() {
+		c, err := scanChange(rows, r.currency)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
+
+func (r *sqlRepository) Latest() (map[int]money.Money, error) {
+	rows, err := r.db.Query(`SELECT ` + changeColumns + ` FROM price_changes
+		WHERE id IN (SELECT MAX(id) FROM price_changes GROUP BY product_id)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := map[int]money.Money{}
+	for rows.Next() {
+		c, err := scanChange(rows, r.currency)
+		if err != nil {
+			return nil, err
+		}
+		latest[c.ProductID] = c.NewPrice
+	}
+	return latest, rows.Err()
+}
@@ -0,0 +1,114 @@
+package prices
+
+import (
+	"sort"
+	"time"
+
+	"github.com/anwardh/meliProject/pkg/money"
+)
+
+// System é quem assina as alterações que o próprio servidor registra (ex.: o preço inicial dos produtos antigos)
+const System = "sistema"
+
+// Motivo da alteração que abre o histórico de um produto que já tinha preço antes dele
+const openingReason = "preço inicial"
+
+// Products é o que o histórico precisa dos produtos: o preço atual de cada um
+type Products interface {
+	Prices() (map[int]money.Money, error)
+}
+
+// Criação da Interface
+type Service interface {
+	// Record registra uma alteração de preço; old é nil no primeiro preço do produto
+	Record(productID int, old *money.Money, price money.Money, by, reason string) (Change, error)
+	// History devolve uma página do histórico, da alteração mais nova para a mais antiga, e o total encontrado
+	History(f Filter, limit, offset int) ([]Change, int, error)
+	// Sync acerta o histórico pelo preço atual dos produtos (veja o método)
+	Sync(reason string) (int, error)
+}
+
+// service registra as alterações de preço feitas pelo products.Service, que as chama depois de gravar o produto
+type service struct {
+	repository Repository
+	products   Products
+	// now é o relógio das alterações, sempre em UTC: assim o horário gravado é comparável em qualquer store
+	now func() time.Time
+}
+
+func NewService(r Repository, p Products) Service {
+	return &service{
+		repository: r,
+		products:   p,
+		now:        func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Criação do Método Record
+func (s *service) Record(productID int, old *money.Money, price money.Money, by, reason string) (Change, error) {
+	c := Change{ProductID: productID, OldPrice: old, NewPrice: price, ChangedBy: by, Reason: reason, ChangedAt: s.now()}
+	cs, err := s.repository.Append(c)
+	if err != nil {
+		return Change{}, err
+	}
+	return cs[0], nil
+}
+
+// Criação do Método History
+func (s *service) History(f Filter, limit, offset int) ([]Change, int, error) {
+	cs, err := s.repository.List(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(cs)
+	for i, j := 0, len(cs)-1; i < j; i, j = i+1, j-1 {
+		cs[i], cs[j] = cs[j], cs[i]
+	}
+	if offset > len(cs) {
+		offset = len(cs)
+	}
+	cs = cs[offset:]
+	if limit > 0 && limit < len(cs) {
+		cs = cs[:limit]
+	}
+	return cs, total, nil
+}
+
+/*
+Sync registra, em nome do System, os preços que o histórico ainda não explica: os produtos sem histórico
+(gravados antes dele, ou por fora do Service) ganham o preço inicial, e os que têm um preço diferente do último
+registrado (ex.: uma queda entre a gravação do produto e a do histórico, ou a restauração de um backup)
+ganham uma alteração com o motivo informado. As alterações são gravadas numa única vez.
+Devolve quantas alterações foram registradas
+*/
+func (s *service) Sync(reason string) (int, error) {
+	current, err := s.products.Prices()
+	if err != nil {
+		return 0, err
+	}
+	latest, err := s.repository.Latest()
+	if err != nil {
+		return 0, err
+	}
+
+	var cs []Change
+	now := s.now()
+	for id, price := range current {
+		last, found := latest[id]
+		switch {
+		case !found:
+			cs = append(cs, Change{ProductID: id, NewPrice: price, ChangedBy: System, Reason: openingReason, ChangedAt: now})
+		case last != price:
+			old := last
+			cs = append(cs, Change{ProductID: id, OldPrice: &old, NewPrice: price, ChangedBy: System, Reason: reason, ChangedAt: now})
+		}
+	}
+	if len(cs) == 0 {
+		return 0, nil
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].ProductID < cs[j].ProductID })
+	if _, err := s.repository.Append(cs...); err != nil {
+		return 0, err
+	}
+	return len(cs), nil
+}
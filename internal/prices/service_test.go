@@ -0,0 +1,273 @@
+package prices
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// backends são as repositories que precisam registrar e consultar o histórico do mesmo jeito
+var backends = []struct {
+	name string
+	open func(t *testing.T) Repository
+}{
+	{"memoria", func(t *testing.T) Repository {
+		return NewRepository(store.NewMemoryStore(nil), "BRL")
+	}},
+	{"sqlite", func(t *testing.T) Repository {
+		sq, err := store.OpenSQLite(filepath.Join(t.TempDir(), "catalog.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sq.DB().Close() })
+		r, err := NewSQLRepository(sq.DB(), "BRL")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}},
+}
+
+// products são os preços atuais do catálogo, no lugar da repository de produtos
+type products map[int]money.Money
+
+func (p products) Prices() (map[int]money.Money, error) {
+	return p, nil
+}
+
+// day é o horário das alterações nos testes: o relógio do Service avança um dia por alteração
+var day = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newService(r Repository, p products) *service {
+	s := NewService(r, p).(*service)
+	next := day
+	s.now = func() time.Time {
+		now := next
+		next = next.Add(24 * time.Hour)
+		return now
+	}
+	return s
+}
+
+func brl(t *testing.T, s string) money.Money {
+	t.Helper()
+	m, err := money.Parse(s, "BRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// describe resume a alteração para a comparação nos testes
+func describe(c Change) string {
+	old := "-"
+	if c.OldPrice != nil {
+		old = c.OldPrice.String()
+	}
+	return fmt.Sprintf("%d:%s>%s:%s", c.ProductID, old, c.NewPrice, c.ChangedBy)
+}
+
+func describeAll(cs []Change) []string {
+	out := []string{}
+	for _, c := range cs {
+		out = append(out, describe(c))
+	}
+	return out
+}
+
+// O histórico do produto vem da alteração mais nova para a mais antiga, com o total para a paginação
+func TestServiceRecordsHistory(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newService(b.open(t), products{})
+			first, err := s.Record(1, nil, brl(t, "10.00"), "ana", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.ID == 0 || !first.ChangedAt.Equal(day) || first.OldPrice != nil {
+				t.Fatalf("Record = %+v, esperado um ID, o horário do relógio e nenhum preço anterior", first)
+			}
+			old := brl(t, "10.00")
+			if _, err := s.Record(2, nil, brl(t, "3.50"), "rui", ""); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Record(1, &old, brl(t, "12.00"), "ana", "reajuste"); err != nil {
+				t.Fatal(err)
+			}
+			old = brl(t, "12.00")
+			if _, err := s.Record(1, &old, brl(t, "9.90"), "rui", "promoção"); err != nil {
+				t.Fatal(err)
+			}
+
+			cs, total, err := s.History(Filter{ProductID: 1}, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "[1:12.00 BRL>9.90 BRL:rui 1:10.00 BRL>12.00 BRL:ana 1:->10.00 BRL:ana]"
+			if got := fmt.Sprint(describeAll(cs)); got != want || total != 3 {
+				t.Fatalf("History do produto 1 = %s (total %d), esperado %s (total 3)", got, total, want)
+			}
+			if cs[0].Reason != "promoção" {
+				t.Fatalf("History perdeu o motivo: %+v", cs[0])
+			}
+
+			// A página não muda o total; um offset além do fim devolve uma página vazia
+			cs, total, err = s.History(Filter{ProductID: 1}, 1, 1)
+			if err != nil || total != 3 || len(cs) != 1 || describe(cs[0]) != "1:10.00 BRL>12.00 BRL:ana" {
+				t.Fatalf("History(limit 1, offset 1) = %v (total %d), %v", describeAll(cs), total, err)
+			}
+			cs, total, err = s.History(Filter{ProductID: 1}, 10, 5)
+			if err != nil || total != 3 || len(cs) != 0 {
+				t.Fatalf("History(offset 5) = %v (total %d), %v; esperado uma página vazia", describeAll(cs), total, err)
+			}
+			if cs, total, err := s.History(Filter{ProductID: 99}, 0, 0); err != nil || total != 0 || len(cs) != 0 {
+				t.Fatalf("History de um produto sem alterações = %v (total %d), %v", describeAll(cs), total, err)
+			}
+		})
+	}
+}
+
+// O relatório do período junta todos os produtos, com os extremos incluídos
+func TestServiceHistoryReport(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			s := newService(b.open(t), products{})
+			// Uma alteração por dia, de 1 a 5 de março, alternando entre os produtos 1 e 2
+			for i := 0; i < 5; i++ {
+				if _, err := s.Record(1+i%2, nil, brl(t, fmt.Sprintf("%d.00", i+1)), "ana", ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cases := []struct {
+				f     Filter
+				total int
+			}{
+				{Filter{}, 5},
+				{Filter{From: day.AddDate(0, 0, 1), To: day.AddDate(0, 0, 3)}, 3},
+				{Filter{From: day.AddDate(0, 0, 3)}, 2},
+				{Filter{To: day}, 1},
+				{Filter{From: day.AddDate(0, 0, 1), To: day.AddDate(0, 0, 3), ProductID: 2}, 2},
+				{Filter{From: day.AddDate(0, 0, 10)}, 0},
+			}
+			for _, c := range cases {
+				cs, total, err := s.History(c.f, 2, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if total != c.total || len(cs) != minInt(2, c.total) {
+					t.Errorf("History(%+v) = %d alterações (total %d), esperado o total %d", c.f, len(cs), total, c.total)
+				}
+				for _, ch := range cs {
+					if !c.f.Match(ch) {
+						t.Errorf("History(%+v) devolveu uma alteração fora do filtro: %+v", c.f, ch)
+					}
+				}
+			}
+		})
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Sync abre o histórico dos produtos sem ele e registra os preços que mudaram por fora, uma vez só
+func TestServiceSync(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			p := products{1: brl(t, "10.00"), 2: brl(t, "5.00"), 3: brl(t, "7.25")}
+			s := newService(b.open(t), p)
+			if _, err := s.Record(1, nil, brl(t, "10.00"), "ana", ""); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Record(2, nil, brl(t, "4.00"), "ana", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := s.Sync("restauração do backup")
+			if err != nil || n != 2 {
+				t.Fatalf("Sync = %d, %v; esperado 2 alterações", n, err)
+			}
+			cs, _, err := s.History(Filter{}, 2, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Gravadas juntas, em ordem de produto: a mais nova é a do produto 3
+			want := "[3:->7.25 BRL:sistema 2:4.00 BRL>5.00 BRL:sistema]"
+			if got := fmt.Sprint(describeAll(cs)); got != want {
+				t.Fatalf("Sync registrou %s, esperado %s", got, want)
+			}
+			if cs[0].Reason != openingReason || cs[1].Reason != "restauração do backup" {
+				t.Fatalf("motivos do Sync = %q e %q", cs[0].Reason, cs[1].Reason)
+			}
+			if !cs[0].ChangedAt.Equal(cs[1].ChangedAt) {
+				t.Fatalf("as alterações do Sync têm horários diferentes: %v e %v", cs[0].ChangedAt, cs[1].ChangedAt)
+			}
+
+			if n, err := s.Sync("restauração do backup"); err != nil || n != 0 {
+				t.Fatalf("o segundo Sync = %d, %v; esperado nenhuma alteração", n, err)
+			}
+			if _, total, err := s.History(Filter{}, 0, 0); err != nil || total != 4 {
+				t.Fatalf("History depois do Sync = total %d, %v; esperado 4", total, err)
+			}
+		})
+	}
+}
+
+// As alterações gravadas antes do money.Money, só com o número, são lidas na moeda da repository
+func TestRepositoryReadsLegacyChanges(t *testing.T) {
+	legacy := `[
+		{"id": 1, "product_id": 1, "old_price": null, "new_price": 10.5, "changed_by": "ana", "changed_at": "2024-03-01T12:00:00Z"},
+		{"id": 2, "product_id": 1, "old_price": 10.5, "new_price": 12.125, "changed_by": "ana", "changed_at": "2024-03-02T12:00:00Z"}
+	]`
+	for _, currency := range []string{"BRL", "USD"} {
+		r := NewRepository(store.NewMemoryStore([]byte(legacy)), currency)
+		cs, err := r.List(Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("[1:->10.50 %[1]s:ana 1:10.50 %[1]s>12.12 %[1]s:ana]", currency)
+		if got := fmt.Sprint(describeAll(cs)); got != want {
+			t.Fatalf("List em %s = %s, esperado %s", currency, got, want)
+		}
+
+		// O histórico continua dos IDs antigos, sem reescrever as alterações gravadas
+		appended, err := r.Append(Change{ProductID: 1, NewPrice: money.Money{Amount: 1300, Currency: currency}, ChangedBy: "rui", ChangedAt: day})
+		if err != nil || appended[0].ID != 3 {
+			t.Fatalf("Append = %+v, %v; esperado o ID 3", appended, err)
+		}
+		latest, err := r.Latest()
+		if err != nil || latest[1] != (money.Money{Amount: 1300, Currency: currency}) {
+			t.Fatalf("Latest = %v, %v", latest, err)
+		}
+	}
+
+	sq, err := store.OpenSQLite(filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sq.DB().Close()
+	r, err := NewSQLRepository(sq.DB(), "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sq.DB().Exec(`INSERT INTO price_changes (product_id, old_price, new_price, changed_by, changed_at)
+		VALUES (1, 10.5, 12.125, 'ana', ?)`, day); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := r.List(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(describeAll(cs)), "[1:10.50 USD>12.12 USD:ana]"; got != want {
+		t.Fatalf("List no SQLite = %s, esperado %s", got, want)
+	}
+}
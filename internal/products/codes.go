@@ -0,0 +1,107 @@
+package products
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anwardh/meliProject/pkg/gtin"
+)
+
+// ErrInvalidCode é devolvido (num CodeError) quando o SKU ou o GTIN não está no formato aceito
+var ErrInvalidCode = errors.New("código inválido")
+
+// ErrDuplicateCode é devolvido (num CodeError) quando o SKU ou o GTIN já pertence a outro produto
+var ErrDuplicateCode = errors.New("código já usado por outro produto")
+
+// Os campos dos códigos, como aparecem no JSON e no CodeError
+const (
+	FieldSKU  = "sku"
+	FieldGTIN = "gtin"
+)
+
+// maxSKULength é o tamanho máximo do SKU
+const maxSKULength = 64
+
+/*
+CodeError diz qual código foi recusado e por quê (Err é ErrInvalidCode ou ErrDuplicateCode);
+no código duplicado, ProductID é o produto que já o usa, e InTrash diz se ele está na lixeira
+(os produtos da lixeira guardam os códigos até serem removidos de vez, para poderem ser restaurados)
+*/
+type CodeError struct {
+	Field     string
+	Code      string
+	ProductID int
+	InTrash   bool
+	Err       error
+	reason    string
+}
+
+func (e *CodeError) Error() string {
+	if errors.Is(e.Err, ErrDuplicateCode) && e.InTrash {
+		return fmt.Sprintf("o %s %s já é do produto %d, que está na lixeira", strings.ToUpper(e.Field), e.Code, e.ProductID)
+	}
+	if errors.Is(e.Err, ErrDuplicateCode) {
+		return fmt.Sprintf("o %s %s já é do produto %d", strings.ToUpper(e.Field), e.Code, e.ProductID)
+	}
+	return fmt.Sprintf("%s inválido: %s", strings.ToUpper(e.Field), e.reason)
+}
+
+func (e *CodeError) Unwrap() error {
+	return e.Err
+}
+
+/*
+NormalizeSKU deixa o SKU no formato em que é gravado e comparado: sem espaços nas pontas e em maiúsculas
+("abc-1" e "ABC-1" são o mesmo SKU). Aceita letras sem acento, números, "-", "_" e ".", até 64 caracteres;
+vazio é um produto sem SKU
+*/
+func NormalizeSKU(sku string) (string, error) {
+	sku = strings.ToUpper(strings.TrimSpace(sku))
+	if len(sku) > maxSKULength {
+		return "", &CodeError{Field: FieldSKU, Code: sku, Err: ErrInvalidCode,
+			reason: fmt.Sprintf("deve ter até %d caracteres, não %d", maxSKULength, len(sku))}
+	}
+	for _, r := range sku {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' && r != '.' {
+			return "", &CodeError{Field: FieldSKU, Code: sku, Err: ErrInvalidCode,
+				reason: fmt.Sprintf("%q tem o caractere %q; use letras sem acento, números, \"-\", \"_\" ou \".\"", sku, r)}
+		}
+	}
+	return sku, nil
+}
+
+// NormalizeGTIN confere o GTIN (veja gtin.Validate) e o devolve sem os espaços das pontas; vazio é um produto sem GTIN
+func NormalizeGTIN(code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return "", nil
+	}
+	if _, err := gtin.Validate(code); err != nil {
+		return "", &CodeError{Field: FieldGTIN, Code: code, Err: ErrInvalidCode,
+			reason: strings.TrimPrefix(err.Error(), gtin.ErrInvalid.Error()+": ")}
+	}
+	return code, nil
+}
+
+/*
+checkCodes procura, entre os produtos informados, outro produto (de ID diferente de id) que já use o SKU
+ou o GTIN, inclusive entre os produtos da lixeira; os GTINs são comparados na forma de 14 dígitos,
+então o UPC-A e o EAN-13 do mesmo item colidem.
+É a regra de unicidade das duas repositories: a de arquivo passa o catálogo inteiro, a SQL só os candidatos
+*/
+func checkCodes(ps []Product, id int, sku, code string) error {
+	key := gtin.Key(code)
+	for _, p := range ps {
+		if p.ID == id {
+			continue
+		}
+		if sku != "" && p.SKU == sku {
+			return &CodeError{Field: FieldSKU, Code: sku, ProductID: p.ID, InTrash: p.DeletedAt != nil, Err: ErrDuplicateCode}
+		}
+		if key != "" && gtin.Key(p.GTIN) == key {
+			return &CodeError{Field: FieldGTIN, Code: code, ProductID: p.ID, InTrash: p.DeletedAt != nil, Err: ErrDuplicateCode}
+		}
+	}
+	return nil
+}
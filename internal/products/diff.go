@@ -0,0 +1,50 @@
+package products
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Change guarda um produto que existe nas duas versões, mas com campos diferentes
+type Change struct {
+	Before Product `json:"before"`
+	After  Product `json:"after"`
+}
+
+// Diff é o resultado da comparação entre duas versões do catálogo
+type Diff struct {
+	Added   []Product `json:"added"`
+	Removed []Product `json:"removed"`
+	Changed []Change  `json:"changed"`
+}
+
+// Compare compara duas versões do catálogo pelo ID dos produtos.
+// "Added" são os produtos que só existem em after, "Removed" os que só existem em before.
+// Os produtos são comparados com reflect.DeepEqual: com a lista de preços, o Product não é comparável com !=
+func Compare(before, after []Product) Diff {
+	d := Diff{Added: []Product{}, Removed: []Product{}, Changed: []Change{}}
+
+	old := make(map[int]Product, len(before))
+	for _, p := range before {
+		old[p.ID] = p
+	}
+	seen := make(map[int]bool, len(after))
+	for _, p := range after {
+		seen[p.ID] = true
+		prev, ok := old[p.ID]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, p)
+		case !reflect.DeepEqual(prev, p):
+			d.Changed = append(d.Changed, Change{Before: prev, After: p})
+		}
+	}
+	for _, p := range before {
+		if !seen[p.ID] {
+			d.Removed = append(d.Removed, p)
+		}
+	}
+
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].ID < d.Removed[j].ID })
+	return d
+}
@@ -0,0 +1,144 @@
+package products
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/search"
+)
+
+// DeletedMode escolhe entre os produtos do catálogo e os da lixeira (veja Service.Delete)
+type DeletedMode int
+
+const (
+	// ExcludeDeleted deixa de fora os produtos da lixeira; é o padrão de todas as listagens e buscas
+	ExcludeDeleted DeletedMode = iota
+	// OnlyDeleted escolhe só os produtos da lixeira
+	OnlyDeleted
+	// IncludeDeleted escolhe todos (ex.: para conciliar o estoque, que os produtos da lixeira continuam tendo)
+	IncludeDeleted
+)
+
+// Filter são os critérios de busca da listagem de produtos; campos vazios (ou nil) não filtram nada,
+// exceto Deleted: o Filter vazio deixa de fora os produtos da lixeira.
+// A repository de arquivo aplica o filtro produto a produto (Match); a SQL o transforma em WHERE
+type Filter struct {
+	// Deleted escolhe entre os produtos do catálogo (o padrão), os da lixeira ou todos
+	Deleted DeletedMode
+	// CategoryID escolhe os produtos da categoria e das subcategorias dela, em qualquer nível
+	CategoryID int
+	// Category escolhe pelo nome (veja categories.Service.SubtreeNamed) as categorias, e as subcategorias delas;
+	// um nome que nenhuma categoria tem não deixa passar nenhum produto
+	Category string
+	// categoryIDs são as categorias escolhidas por CategoryID e Category, com as subcategorias, preenchidas pelo Service;
+	// nil, vale só a própria CategoryID
+	categoryIDs []int
+	// MinPrice e MaxPrice limitam o preço, com os extremos incluídos; ficam na moeda do catálogo, como o Price
+	MinPrice *money.Money
+	MaxPrice *money.Money
+	// InStock escolhe só os produtos com estoque (true) ou só os sem estoque (false)
+	InStock *bool
+	// Query procura o texto em qualquer parte do nome, sem diferenciar maiúsculas, minúsculas nem acentos (veja search.Fold)
+	Query string
+}
+
+// Match diz se o produto atende a todos os critérios do filtro
+func (f Filter) Match(p Product) bool {
+	if f.Deleted == ExcludeDeleted && p.DeletedAt != nil || f.Deleted == OnlyDeleted && p.DeletedAt == nil {
+		return false
+	}
+	if f.byCategory() && !containsID(f.categories(), p.CategoryID) {
+		return false
+	}
+	if f.MinPrice != nil && p.Price.Amount < f.MinPrice.Amount {
+		return false
+	}
+	if f.MaxPrice != nil && p.Price.Amount > f.MaxPrice.Amount {
+		return false
+	}
+	if f.InStock != nil && (p.Count > 0) != *f.InStock {
+		return false
+	}
+	if f.Query != "" && !strings.Contains(search.Fold(p.Name), search.Fold(f.Query)) {
+		return false
+	}
+	return true
+}
+
+// matchesAll diz se o filtro deixa passar todos os produtos (o Filter não é comparável com ==, por causa da lista de categorias)
+func (f Filter) matchesAll() bool {
+	return f.Deleted == IncludeDeleted && !f.byCategory() && f.MinPrice == nil && f.MaxPrice == nil && f.InStock == nil && f.Query == ""
+}
+
+func (f Filter) byCategory() bool {
+	return f.CategoryID != 0 || f.Category != ""
+}
+
+func (f Filter) categories() []int {
+	if f.categoryIDs == nil && f.Category == "" {
+		return []int{f.CategoryID}
+	}
+	return f.categoryIDs
+}
+
+func containsID(ids []int, id int) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// where monta a cláusula WHERE equivalente ao Match, para a repository SQL
+func (f Filter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	switch f.Deleted {
+	case ExcludeDeleted:
+		conds = append(conds, "deleted_at IS NULL")
+	case OnlyDeleted:
+		conds = append(conds, "deleted_at IS NOT NULL")
+	}
+	if f.byCategory() {
+		ids := f.categories()
+		if len(ids) == 0 {
+			// Nenhuma categoria com o nome pedido: o IN () vazio não é aceito pelo SQLite
+			conds = append(conds, "0 = 1")
+		} else {
+			conds = append(conds, "category_id IN (?"+strings.Repeat(", ?", len(ids)-1)+")")
+		}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	if f.MinPrice != nil {
+		conds = append(conds, "price_amount >= ?")
+		args = append(args, f.MinPrice.Amount)
+	}
+	if f.MaxPrice != nil {
+		conds = append(conds, "price_amount <= ?")
+		args = append(args, f.MaxPrice.Amount)
+	}
+	if f.InStock != nil {
+		if *f.InStock {
+			conds = append(conds, "count > 0")
+		} else {
+			conds = append(conds, "count <= 0")
+		}
+	}
+	if f.Query != "" {
+		// % e _ no texto procurado são literais, não curingas do LIKE. A busca é na coluna name_fold,
+		// e não no LIKE sobre o name, que só ignora a caixa das letras ASCII
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(search.Fold(f.Query))
+		conds = append(conds, `name_fold LIKE ? ESCAPE '\'`)
+		args = append(args, fmt.Sprintf("%%%s%%", escaped))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,12 @@
+-- Tabela de produtos, com os mesmos campos de products.Product
+CREATE TABLE products (
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    name     TEXT    NOT NULL,
+    category TEXT    NOT NULL,
+    count    INTEGER NOT NULL,
+    price    REAL    NOT NULL
+);
+
+-- Índices para as buscas por categoria e por nome
+CREATE INDEX idx_products_category ON products (category);
+CREATE INDEX idx_products_name ON products (name);
@@ -0,0 +1,7 @@
+-- Os produtos passam a apontar para a tabela de categorias (criada pela migration do pacote categories)
+-- A coluna category, com o nome da categoria em texto, fica vazia depois da conversão dos produtos antigos
+-- (products.MigrateCategories); ela continua na tabela só para essa conversão
+ALTER TABLE products ADD COLUMN category_id INTEGER REFERENCES categories (id);
+
+DROP INDEX idx_products_category;
+CREATE INDEX idx_products_category_id ON products (category_id);
@@ -0,0 +1,9 @@
+-- O preço passa a ser guardado em unidades mínimas da moeda (ex.: centavos), com o código ISO 4217 da moeda,
+-- em vez do REAL, que acumulava erros de arredondamento nas somas. prices é a lista, em JSON, dos preços
+-- fixados em outras moedas. A coluna price fica com os preços antigos até a conversão (products.MigratePrices),
+-- que preenche price_amount e price_currency; os produtos convertidos têm price_currency preenchida
+ALTER TABLE products ADD COLUMN price_amount INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN price_currency TEXT NOT NULL DEFAULT '';
+ALTER TABLE products ADD COLUMN prices TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_products_price_amount ON products (price_amount);
@@ -0,0 +1,8 @@
+-- Códigos do produto: o SKU (gravado em maiúsculas) e o GTIN (EAN-8, UPC-A, EAN-13 ou GTIN-14, como foi informado).
+-- Vazios quando o produto não tem o código. Os índices únicos só valem para os códigos preenchidos;
+-- o do GTIN é sobre a forma de 14 dígitos (veja gtin.Key), para que o UPC-A e o EAN-13 do mesmo item colidam
+ALTER TABLE products ADD COLUMN sku TEXT NOT NULL DEFAULT '';
+ALTER TABLE products ADD COLUMN gtin TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX idx_products_sku ON products (sku) WHERE sku <> '';
+CREATE UNIQUE INDEX idx_products_gtin ON products (substr('00000000000000' || gtin, -14)) WHERE gtin <> '';
@@ -0,0 +1,6 @@
+-- Lixeira: a remoção só marca o produto, com o horário e quem removeu; o produto some do catálogo
+-- e é removido de vez pela limpeza da lixeira (products.Service.Purge) depois do prazo configurado
+ALTER TABLE products ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE products ADD COLUMN deleted_by TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_products_deleted_at ON products (deleted_at);
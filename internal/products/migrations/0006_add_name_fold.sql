@@ -0,0 +1,7 @@
+-- Nome sem acentos e em minúsculas (veja search.Fold), usado na busca pelo texto (q) e na ordenação pelo nome.
+-- O LIKE e o COLLATE NOCASE do SQLite só ignoram a caixa das letras ASCII; com a coluna, o banco compara
+-- os nomes do mesmo jeito que a repository de arquivo. Os produtos que já existiam são preenchidos
+-- pela aplicação na inicialização (veja NewSQLRepository)
+ALTER TABLE products ADD COLUMN name_fold TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_products_name_fold ON products (name_fold);
@@ -0,0 +1,263 @@
+package products
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anwardh/meliProject/pkg/search"
+)
+
+// Limites da paginação
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 1000
+)
+
+// ErrInvalidCursor é devolvido quando o cursor não foi gerado por esta API ou foi gerado com outra ordenação
+var ErrInvalidCursor = errors.New("cursor inválido")
+
+// sortFields são os campos aceitos no sort, com a coluna correspondente na repository SQL.
+// O nome é ordenado sem acentos e em minúsculas (veja search.Fold), nas duas repositories
+var sortFields = map[string]string{
+	"id":          "id",
+	"name":        "name_fold",
+	"category_id": "COALESCE(category_id, 0)",
+	"count":       "count",
+	"price":       "price_amount",
+}
+
+// SortKey é um critério de ordenação; Desc inverte a ordem
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort lê a ordenação no formato "price,-name": campos separados por vírgula, "-" para ordem decrescente
+func ParseSort(spec string) ([]SortKey, error) {
+	var keys []SortKey
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k := SortKey{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if _, ok := sortFields[k.Field]; !ok {
+			return nil, fmt.Errorf("campo %q desconhecido (use id, name, category_id, count ou price)", k.Field)
+		}
+		if seen[k.Field] {
+			return nil, fmt.Errorf("campo %q repetido", k.Field)
+		}
+		seen[k.Field] = true
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// sortSpec é o inverso de ParseSort
+func sortSpec(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k.Field
+		if k.Desc {
+			parts[i] = "-" + k.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+/*
+PageRequest descreve a página pedida. Há dois jeitos de paginar:
+  - Offset: pula os primeiros Offset produtos;
+  - After: continua logo depois do último produto da página anterior (o cursor).
+
+O cursor guarda os valores de ordenação do último produto, e não a posição dele: produtos inseridos
+ou removidos durante a navegação não fazem a próxima página repetir nem pular produtos.
+Limit zero devolve todos os produtos (a partir do Offset ou do cursor).
+*/
+type PageRequest struct {
+	Sort   []SortKey
+	Limit  int
+	Offset int
+	After  *Cursor
+}
+
+// Page é uma página da listagem; Next é nil na última página
+type Page struct {
+	Products []Product
+	Total    int
+	Next     *Cursor
+}
+
+// Cursor marca o último produto entregue, pelos valores dos campos de ordenação (e o ID, que desempata)
+type Cursor struct {
+	Sort string  `json:"s"`
+	Last Product `json:"p"`
+}
+
+// newCursor guarda do produto só os campos que participam da ordenação
+func newCursor(p Product, keys []SortKey) *Cursor {
+	last := Product{ID: p.ID}
+	for _, k := range keys {
+		switch k.Field {
+		case "name":
+			last.Name = p.Name
+		case "category_id":
+			last.CategoryID = p.CategoryID
+		case "count":
+			last.Count = p.Count
+		case "price":
+			last.Price = p.Price
+		}
+	}
+	return &Cursor{Sort: sortSpec(keys), Last: last}
+}
+
+// Encode devolve o cursor no formato opaco usado na API
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor lê o cursor recebido na API; ele só vale com a mesma ordenação com que foi gerado
+func DecodeCursor(s string, keys []SortKey) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != sortSpec(keys) {
+		return nil, fmt.Errorf("%w: ele foi gerado com sort=%q", ErrInvalidCursor, c.Sort)
+	}
+	return &c, nil
+}
+
+// compareProducts compara dois produtos pelos critérios de ordenação, desempatando pelo ID
+func compareProducts(a, b Product, keys []SortKey) int {
+	for _, k := range keys {
+		var c int
+		switch k.Field {
+		case "id":
+			c = compareInt(a.ID, b.ID)
+		case "name":
+			c = strings.Compare(search.Fold(a.Name), search.Fold(b.Name))
+		case "category_id":
+			c = compareInt(a.CategoryID, b.CategoryID)
+		case "count":
+			c = compareInt(a.Count, b.Count)
+		case "price":
+			c = compareAmount(a.Price.Amount, b.Price.Amount)
+		}
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInt(a.ID, b.ID)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareAmount(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Paginate ordena a lista e recorta a página pedida; usado quando a store não ordena nem pagina sozinha
+func Paginate(ps []Product, pr PageRequest) Page {
+	sort.SliceStable(ps, func(i, j int) bool { return compareProducts(ps[i], ps[j], pr.Sort) < 0 })
+
+	start := pr.Offset
+	if pr.After != nil {
+		start = sort.Search(len(ps), func(i int) bool { return compareProducts(ps[i], pr.After.Last, pr.Sort) > 0 })
+	}
+	if start > len(ps) {
+		start = len(ps)
+	}
+	end := len(ps)
+	if pr.Limit > 0 && start+pr.Limit < end {
+		end = start + pr.Limit
+	}
+
+	page := Page{Products: ps[start:end], Total: len(ps)}
+	if end < len(ps) && end > start {
+		page.Next = newCursor(ps[end-1], pr.Sort)
+	}
+	return page
+}
+
+// orderBy monta o ORDER BY equivalente ao compareProducts, para a repository SQL
+func (pr PageRequest) orderBy() string {
+	var cols []string
+	for _, k := range pr.Sort {
+		col := sortFields[k.Field]
+		if k.Desc {
+			col += " DESC"
+		}
+		cols = append(cols, col)
+	}
+	return " ORDER BY " + strings.Join(append(cols, "id"), ", ")
+}
+
+// afterWhere monta a condição "vem depois do cursor" para a repository SQL:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ... OR (k1 = v1 AND ... AND id > vid), com "<" nos campos decrescentes
+func (pr PageRequest) afterWhere() (string, []interface{}) {
+	if pr.After == nil {
+		return "", nil
+	}
+	last := pr.After.Last
+	value := func(field string) interface{} {
+		switch field {
+		case "name":
+			return search.Fold(last.Name)
+		case "category_id":
+			return last.CategoryID
+		case "count":
+			return last.Count
+		case "price":
+			return last.Price.Amount
+		}
+		return last.ID
+	}
+
+	keys := append(append([]SortKey(nil), pr.Sort...), SortKey{Field: "id"})
+	var ors []string
+	var args []interface{}
+	for i, k := range keys {
+		var ands []string
+		for _, prev := range keys[:i] {
+			ands = append(ands, sortFields[prev.Field]+" = ?")
+			args = append(args, value(prev.Field))
+		}
+		op := " > ?"
+		if k.Desc {
+			op = " < ?"
+		}
+		ands = append(ands, sortFields[k.Field]+op)
+		args = append(args, value(k.Field))
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
@@ -1,41 +1,107 @@
 package products
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/anwardh/meliProject/pkg/gtin"
+	"github.com/anwardh/meliProject/pkg/money"
 	"github.com/anwardh/meliProject/pkg/store"
 )
 
 // Adicionando a Estrutura Product e seus campos rotulados
 type Product struct {
-	ID       int     `json:"id"`
-	Name     string  `json:"name"`
-	Category string  `json:"category"`
-	Count    int     `json:"count"`
-	Price    float64 `json:"price"`
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// SKU e GTIN são os códigos do produto (opcionais e únicos no catálogo, veja NormalizeSKU e NormalizeGTIN)
+	SKU        string `json:"sku,omitempty"`
+	GTIN       string `json:"gtin,omitempty"`
+	CategoryID int    `json:"category_id"`
+	Count      int    `json:"count"`
+	// Price é o preço na moeda do catálogo (veja NewService)
+	Price money.Money `json:"price"`
+	// Prices são os preços fixados em outras moedas, um por moeda e em ordem de moeda;
+	// nas demais, o preço sai da tabela de câmbio (veja Service.Quote)
+	Prices []money.Money `json:"prices,omitempty"`
+	// DeletedAt e DeletedBy dizem quando e por quem o produto foi para a lixeira; nil é um produto do catálogo
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
 }
 
-/*
-Criação da variável para guardar os produtos
+// ErrNotFound é devolvido (embrulhado, ex.: "produto 5 não encontrado") quando o produto pedido não existe
+var ErrNotFound = errors.New("não encontrado")
 
-	Corresponde a nossa Camada de Persistência de Dados
-*/
-var ps []Product
-var lastID int
+// errStop interrompe uma iteração com Each assim que o produto procurado aparece
+var errStop = errors.New("iteração interrompida")
+
+// ErrNotDeleted é devolvido (embrulhado, com o ID) ao restaurar um produto que não está na lixeira
+var ErrNotDeleted = errors.New("não está na lixeira")
+
+// ErrAsOfNotSupported é devolvido quando a store configurada não guarda o histórico das alterações
+var ErrAsOfNotSupported = errors.New("a store configurada não guarda histórico; a consulta com as_of não está disponível")
 
 // Criação da Iterface e Declaração dos Métodos
 type Repository interface {
-	GetAll() ([]Product, error)
-	Store(id int, name, category string, count int, price float64) (Product, error)
+	// GetAll devolve os produtos que atendem ao filtro (Filter{} devolve todos)
+	GetAll(f Filter) ([]Product, error)
+	// GetByID devolve um único produto do catálogo, ou um erro com ErrNotFound (também para os produtos da lixeira)
+	GetByID(id int) (Product, error)
+	// GetBySKU e GetByGTIN devolvem o produto com o código, ou um erro com ErrNotFound;
+	// o GTIN é procurado em qualquer formato (o UPC-A acha o produto gravado com o EAN-13 do mesmo item)
+	GetBySKU(sku string) (Product, error)
+	GetByGTIN(code string) (Product, error)
+	// Each entrega os produtos que atendem ao filtro um por vez, sem montar a lista inteira em memória;
+	// se fn devolver um erro, a leitura para
+	Each(f Filter, fn func(p Product) error) error
+	// List devolve uma página dos produtos que atendem ao filtro, na ordem pedida, e o total de produtos encontrados
+	List(f Filter, pr PageRequest) (Page, error)
+	// GetAllAsOf devolve os produtos como estavam no instante informado
+	GetAllAsOf(t time.Time, f Filter) ([]Product, error)
+	// Store atribui o próximo ID ao produto, na mesma transação em que grava, para não haver IDs duplicados;
+	// Store e Update recusam o SKU ou o GTIN de outro produto (um CodeError com ErrDuplicateCode)
+	Store(name, sku, gtin string, categoryID int, count int, price money.Money, prices []money.Money) (Product, error)
+	// LastID devolve o último ID atribuído, inclusive o de produtos que já saíram do catálogo pelo Purge:
+	// os IDs nunca são reaproveitados
 	LastID() (int, error)
 	// Declaração do Método Update - que cuidará de atualizar um dado
-	Update(id int, name, productType string, count int, price float64) (Product, error)
+	Update(id int, name, sku, gtin string, categoryID int, count int, price money.Money, prices []money.Money) (Product, error)
 
 	// Declaração do Método UpdateName
 	UpdateName(id int, name string) (Product, error)
 
-	// Declaração do Método Delete
-	Delete(id int) error
+	// Trash leva o produto para a lixeira, com o horário e quem removeu; um produto que já está nela não é encontrado
+	Trash(id int, at time.Time, by string) (Product, error)
+	// Restore devolve o produto da lixeira ao catálogo (erro com ErrNotDeleted se ele não estiver na lixeira)
+	Restore(id int) (Product, error)
+	// Remove apaga o produto de vez, sem passar pela lixeira: só desfaz um Store que não pôde ser concluído
+	// (veja service.Store); o ID dele não volta a ser atribuído
+	Remove(id int) error
+	// Purge remove de vez, numa única gravação, os produtos que foram para a lixeira antes de before, e devolve os IDs deles.
+	// Os IDs removidos não voltam a ser atribuídos pelo Store
+	Purge(before time.Time) ([]int, error)
+
+	// Stock, SetStock e Stocks leem e gravam só o estoque; quem movimenta o estoque é o livro (veja stock.Service).
+	// Stock não encontra os produtos da lixeira, que não recebem movimentações; SetStock e Stocks os incluem,
+	// para o livro continuar conciliado com o estoque que eles levam de volta ao catálogo se forem restaurados
+	Stock(id int) (int, error)
+	SetStock(id, count int) error
+	// SetStocks grava o estoque de vários produtos numa única transação (ou todos, ou nenhum)
+	SetStocks(counts map[int]int) error
+	Stocks() (map[int]int, error)
+	// Prices devolve o preço atual de cada produto, inclusive os da lixeira (veja prices.Service.Sync)
+	Prices() (map[int]money.Money, error)
+
+	// CountByCategory devolve quantos produtos estão diretamente na categoria, inclusive os da lixeira
+	// (eles voltam para ela se forem restaurados)
+	CountByCategory(categoryID int) (int, error)
+	// MigrateCategories troca a categoria em texto dos produtos antigos pelo ID da categoria (veja MigrateCategories)
+	MigrateCategories(resolve func(names map[string]int) (map[string]int, error)) (int, error)
+	// MigratePrices grava no formato do Money os preços antigos, gravados como número, lidos em currency (veja MigratePrices)
+	MigratePrices(currency string) (int, error)
 }
 
 type repository struct {
@@ -52,7 +118,7 @@ func NewRepository(db store.Store) Repository {
 
 // Métodos que serão utilizados sobre a estrutura repository
 // quando for instanciada
-func (r *repository) GetAll() ([]Product, error) {
+func (r *repository) GetAll(f Filter) ([]Product, error) {
 	var ps []Product
 	// estamos preenchendo a variavel "produtos" com a função read
 	err := r.db.Read(&ps)
@@ -62,55 +128,173 @@ func (r *repository) GetAll() ([]Product, error) {
 		// retornamos o erro
 		return nil, err
 	}
-	// Senão, retornamos os produtos lidos
-	return ps, nil
+	// Senão, retornamos os produtos lidos que passam pelo filtro
+	return filterProducts(ps, f), nil
+}
+
+// filterProducts mantém só os produtos que atendem ao filtro
+func filterProducts(ps []Product, f Filter) []Product {
+	if f.matchesAll() {
+		return ps
+	}
+	filtered := []Product{}
+	for _, p := range ps {
+		if f.Match(p) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// GetByID percorre os produtos até achar o ID (veja find)
+func (r *repository) GetByID(id int) (Product, error) {
+	return r.find(func(p Product) bool { return p.ID == id }, notFoundError(id))
+}
+
+// GetBySKU percorre os produtos até achar o SKU, como o GetByID; o SKU vazio não acha ninguém (como na SQL)
+func (r *repository) GetBySKU(sku string) (Product, error) {
+	return r.find(func(p Product) bool { return p.SKU != "" && p.SKU == sku }, codeNotFoundError(FieldSKU, sku))
+}
+
+// GetByGTIN compara os GTINs na forma de 14 dígitos (veja gtin.Key)
+func (r *repository) GetByGTIN(code string) (Product, error) {
+	key := gtin.Key(code)
+	return r.find(func(p Product) bool { return p.GTIN != "" && gtin.Key(p.GTIN) == key }, codeNotFoundError(FieldGTIN, code))
 }
 
+// find percorre os produtos até o primeiro que atende a match, sem montar a lista inteira; notFound é o erro se nenhum atender
+func (r *repository) find(match func(p Product) bool, notFound error) (Product, error) {
+	var found Product
+	err := r.Each(Filter{}, func(p Product) error {
+		if !match(p) {
+			return nil
+		}
+		found = p
+		return errStop
+	})
+	if err == errStop {
+		return found, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Product{}, err
+	}
+	return Product{}, notFound
+}
+
+// Each decodifica um produto por vez, conforme a store os entrega (veja store.Each)
+func (r *repository) Each(f Filter, fn func(p Product) error) error {
+	return store.Each(r.db, func(item json.RawMessage) error {
+		var p Product
+		if err := json.Unmarshal(item, &p); err != nil {
+			return err
+		}
+		if !f.Match(p) {
+			return nil
+		}
+		return fn(p)
+	})
+}
+
+// O arquivo não tem índices: juntamos os produtos do filtro e ordenamos em memória (veja Paginate)
+func (r *repository) List(f Filter, pr PageRequest) (Page, error) {
+	ps := []Product{}
+	err := r.Each(f, func(p Product) error {
+		ps = append(ps, p)
+		return nil
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	return Paginate(ps, pr), nil
+}
+
+// Só as stores que guardam o histórico (como o journal) conseguem responder
+func (r *repository) GetAllAsOf(t time.Time, f Filter) ([]Product, error) {
+	tt, ok := r.db.(store.TimeTraveler)
+	if !ok {
+		return nil, ErrAsOfNotSupported
+	}
+
+	ps := []Product{}
+	if err := tt.ReadAt(t, &ps); err != nil {
+		return nil, err
+	}
+	return filterProducts(ps, f), nil
+}
+
+// O contador da store (veja store.Sequence) só é lido dentro de uma transação; ela não grava nada
 func (r *repository) LastID() (int, error) {
-	var ps []Product
-	if err := r.db.Read(&ps); err != nil {
-		// Caso ocorrra um erro na leitura do arquivo,retorna o lastId como 0
+	var id int
+	err := r.db.Update(func(tx store.Tx) error {
+		var ps []Product
+		if err := tx.Read(&ps); err != nil {
+			return err
+		}
+		id = nextID(tx, ps) - 1
+		return nil
+	})
+	if err != nil {
 		return 0, err
 	}
+	return id, nil
+}
+
+// nextID devolve o ID do próximo produto: o seguinte ao último atribuído, que a store guarda junto dos dados
+// (veja store.Sequence). Sem o contador, um produto removido de vez pelo Purge liberaria o ID dele
+// para o próximo Store, e o histórico (livro de estoque, preços, backups) do produto antigo passaria para o novo
+func nextID(tx store.Tx, ps []Product) int {
+	last := lastID(ps)
+	if seq, ok := tx.(store.Sequence); ok && seq.LastID() > last {
+		last = seq.LastID()
+	}
+	return last + 1
+}
 
-	// Caso ele leia o arquivo, mas o arquivo não tenha produtos, retorna o lastId como 0
+// lastID devolve o ID do último produto da lista
+func lastID(ps []Product) int {
+	// Caso a lista não tenha produtos, retorna o lastId como 0
 	if len(ps) == 0 {
-		return 0, nil
+		return 0
 	}
 
 	// Aqui obtemos o ultimo produto inserido
 	ultimoProduto := ps[len(ps)-1]
 	// Aqui retornamos o id do ultimo produto
-	return ultimoProduto.ID, nil
+	return ultimoProduto.ID
 }
 
 /* Store é o método que salvará as informações do produto,
 atribuirá o último ID à variável e retornará a entidade Product */
-
-// Aqui está a implementação antiga da repository
-// func (r *repository) Store(id int, name, category string, count int, price float64) (Product, error) {
-// 	p := Product{id, name, category, count, price}
-// 	ps = append(ps, p)
-// 	lastID = p.ID
-// 	return p, nil
-// }
-
-// Aqui está a nova implementação da repository
-
 // para gravar num arquivo, precisamos ler o arquivo para pegar os produtos
 // que já estavam nele, e adicionar mais um
+// Tudo acontece dentro de uma transação: duas requisições simultâneas não podem ler o mesmo último ID
+// nem sobrescrever o produto uma da outra
+func (r *repository) Store(name, sku, code string, categoryID int, count int, price money.Money, prices []money.Money) (Product, error) {
+	var p Product
+	err := r.db.Update(func(tx store.Tx) error {
+		produtos := []Product{}
+
+		// estamos preenchendo a variavel "produtos" com a função read
+		// Só partimos de uma lista vazia quando ainda não há nada gravado: qualquer outro erro de leitura
+		// (ex.: arquivo corrompido) interrompe a gravação, senão o catálogo seria trocado só pelo produto novo
+		if err := tx.Read(&produtos); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		// Os códigos não podem ser de nenhum produto já gravado
+		if err := checkCodes(produtos, 0, sku, code); err != nil {
+			return err
+		}
 
-func (r *repository) Store(id int, name, productType string, count int, price float64) (Product, error) {
-	produtos := []Product{}
-
-	// estamos preenchendo a variavel "produtos" com a função read
-	r.db.Read(&produtos)
-
-	// Criamos um novo produto com as informações que a pessoa passou na função
-	p := Product{id, name, productType, count, price}
-	// Agora a variavel produtos tem os produtos que estavam no JSON, mais o produto criado
-	produtos = append(produtos, p)
-	if err := r.db.Write(produtos); err != nil {
+		// Criamos um novo produto com as informações que a pessoa passou na função, com o ID seguinte ao último atribuído
+		p = Product{ID: nextID(tx, produtos), Name: name, SKU: sku, GTIN: code, CategoryID: categoryID, Count: count, Price: price, Prices: prices}
+		if seq, ok := tx.(store.Sequence); ok {
+			seq.SetLastID(p.ID)
+		}
+		// Agora a variavel produtos tem os produtos que estavam no JSON, mais o produto criado
+		produtos = append(produtos, p)
+		return tx.Write(produtos)
+	})
+	if err != nil {
 		return Product{}, err
 	}
 	return p, nil
@@ -123,72 +307,367 @@ func (r *repository) Store(id int, name, productType string, count int, price fl
 encontrado por meio do Id que indicaros na busca (url).
 	Com este Id encontrado, todos os elementos dos seus campos serão atualizados, caso contrário, não achando esse Id,
 será nos enviada uma mensagem de - Produto não encontrado
+	Assim como o Store, lemos os produtos do arquivo, alteramos o produto e gravamos a lista inteira de volta
 */
-func (repository) Update(id int, name, productType string, count int, price float64) (Product, error) {
-	p := Product{Name: name, Category: productType, Count: count, Price: price} // Instância de "p" para Update
-	updated := false                                                            // Atribuição false para Updated - não foi realizado nenhum update até aqui
-	for i := range ps {                                                         // Este For percorrerá a lista dos elementos criados no array para buscar o elemento com o Id que já existe
-		if ps[i].ID == id { // Caso encontre esse Id ...
-			p.ID = id      // ... o Id do novo produto será o mesmo do já existente (basicamente, o Id que passamos substituirá o já existente, só que são iguais)...
-			ps[i] = p      // ... e aqui, irá atualizar (neste Id), todos os valores dos elementos que enviarmos no Put...
-			updated = true // ... alterando o seu status para "True"
+func (r *repository) Update(id int, name, sku, code string, categoryID int, count int, price money.Money, prices []money.Money) (Product, error) {
+	var p Product
+	err := r.db.Update(func(tx store.Tx) error {
+		var ps []Product
+		if err := tx.Read(&ps); err != nil {
+			return err
 		}
+
+		p = Product{Name: name, SKU: sku, GTIN: code, CategoryID: categoryID, Count: count, Price: price, Prices: prices} // Instância de "p" para Update
+		i, err := liveIndexOf(ps, id)                                                                                     // Buscamos o elemento com o Id que já existe
+		if err != nil {                                                                                                   // Caso não exista, nos será enviada uma mensagem de erro
+			return err
+		}
+		// Os códigos podem continuar os mesmos, mas não podem ser de outro produto
+		if err := checkCodes(ps, id, sku, code); err != nil {
+			return err
+		}
+		p.ID = id // o Id do novo produto será o mesmo do já existente ...
+		ps[i] = p // ... e aqui, irá atualizar (neste Id), todos os valores dos elementos que enviarmos no Put
+
+		// Gravamos a lista com o produto atualizado
+		return tx.Write(ps)
+	})
+	if err != nil {
+		return Product{}, err
 	}
-	if !updated { // Caso não tenha havido esse update, ou seja, se continuar como 'false'...
-		return Product{}, fmt.Errorf("produto %d não encontrado", id) // ... nos será enviada uma mensagem de erro
-	}
-	return p, nil // Retorno do novo produto com um erro do tipo 'nil'
+	return p, nil
 }
 
 // Criação do Método updateName
-func (repository) UpdateName(id int, name string) (Product, error) {
-	var p Product       // Instância de "p" para UpdateName
-	updated := false    // Atribuição false para Updated - não foi realizado nenhum update no Nome até aqui
-	for i := range ps { // Este For percorrerá a lista dos elementos criados no array para buscar o elemento com o Id que já existe
-		if ps[i].ID == id { // Caso encontre esse Id ...
-			ps[i].Name = name // ... o Nome que indicarmos "modificará" o que já existe
-			updated = true    // Alteração do sstatus para "true"...
-			p = ps[i]         // ... e agora, o produto existente receberá o "novo nome"
+func (r *repository) UpdateName(id int, name string) (Product, error) {
+	var p Product
+	err := r.db.Update(func(tx store.Tx) error {
+		var ps []Product
+		if err := tx.Read(&ps); err != nil {
+			return err
+		}
+
+		i, err := liveIndexOf(ps, id) // Buscamos o elemento com o Id que já existe
+		if err != nil {
+			return err
+		}
+		ps[i].Name = name // o Nome que indicarmos "modificará" o que já existe...
+		p = ps[i]         // ... e agora, o produto existente receberá o "novo nome"
+
+		return tx.Write(ps)
+	})
+	if err != nil {
+		return Product{}, err
+	}
+	return p, nil
+}
+
+// Criação do Método Trash - o produto continua no arquivo, só marcado como removido
+func (r *repository) Trash(id int, at time.Time, by string) (Product, error) {
+	var p Product
+	err := r.db.Update(func(tx store.Tx) error {
+		var ps []Product
+		if err := tx.Read(&ps); err != nil {
+			return err
+		}
+		i, err := liveIndexOf(ps, id)
+		if err != nil {
+			return err
+		}
+		ps[i].DeletedAt, ps[i].DeletedBy = &at, by
+		p = ps[i]
+		return tx.Write(ps)
+	})
+	if err != nil {
+		return Product{}, err
+	}
+	return p, nil
+}
+
+// Criação do Método Restore
+func (r *repository) Restore(id int) (Product, error) {
+	var p Product
+	err := r.db.Update(func(tx store.Tx) error {
+		var ps []Product
+		if err := tx.Read(&ps); err != nil {
+			return err
 		}
+		i, err := indexOf(ps, id)
+		if err != nil {
+			return err
+		}
+		if ps[i].DeletedAt == nil {
+			return fmt.Errorf("produto %d %w", id, ErrNotDeleted)
+		}
+		ps[i].DeletedAt, ps[i].DeletedBy = nil, ""
+		p = ps[i]
+		return tx.Write(ps)
+	})
+	if err != nil {
+		return Product{}, err
 	}
-	if !updated { // Caso não tenha havido esse update, ou seja, se continuar como 'false'...
-		return Product{}, fmt.Errorf("produto %d não encontrado", id) // ... nos será enviada uma mensagem de erro
+	return p, nil
+}
+
+/*
+Criação do Método Purge - os produtos que ficam são copiados para uma lista nova, e a lista inteira é gravada
+uma única vez (com a store de backups, cada gravação é um backup: uma por produto removido descartaria os antigos).
+O contador de IDs da store não muda: os IDs removidos não voltam a ser atribuídos (veja nextID)
+*/
+func (r *repository) Purge(before time.Time) ([]int, error) {
+	var purged []int
+	err := r.db.Update(func(tx store.Tx) error {
+		var ps []Product
+		if err := tx.Read(&ps); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		kept := make([]Product, 0, len(ps))
+		for _, p := range ps {
+			if p.DeletedAt != nil && p.DeletedAt.Before(before) {
+				purged = append(purged, p.ID)
+				continue
+			}
+			kept = append(kept, p)
+		}
+		if len(purged) == 0 {
+			return nil
+		}
+		return tx.Write(kept)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return p, nil // Retorno do produto com um novo Nome
+	return purged, nil
+}
 
+// Criação do Método Remove
+func (r *repository) Remove(id int) error {
+	return r.db.Update(func(tx store.Tx) error {
+		var ps []Product
+		if err := tx.Read(&ps); err != nil {
+			return err
+		}
+		i, err := indexOf(ps, id)
+		if err != nil {
+			return err
+		}
+		return tx.Write(append(ps[:i], ps[i+1:]...))
+	})
 }
 
-// Criação do Método Delete
-func (repository) Delete(id int) error {
-	deleted := false
-	var index int
-	for i := range ps {
-		if ps[i].ID == id {
-			index = i
-			deleted = true
+// Criação do Método Stock
+func (r *repository) Stock(id int) (int, error) {
+	p, err := r.GetByID(id)
+	if err != nil {
+		return 0, err
+	}
+	return p.Count, nil
+}
+
+// Criação do Método SetStock
+func (r *repository) SetStock(id, count int) error {
+	return r.db.Update(func(tx store.Tx) error {
+		var ps []Product
+		if err := tx.Read(&ps); err != nil {
+			return err
+		}
+		index, err := indexOf(ps, id)
+		if err != nil {
+			return err
 		}
+		ps[index].Count = count
+		markMinor(tx)
+		return tx.Write(ps)
+	})
+}
+
+// Criação do Método SetStocks
+func (r *repository) SetStocks(counts map[int]int) error {
+	return r.db.Update(func(tx store.Tx) error {
+		var ps []Product
+		if err := tx.Read(&ps); err != nil {
+			return err
+		}
+		for id, count := range counts {
+			index, err := indexOf(ps, id)
+			if err != nil {
+				return err
+			}
+			ps[index].Count = count
+		}
+		markMinor(tx)
+		return tx.Write(ps)
+	})
+}
+
+// markMinor marca a gravação só do estoque como menor (veja store.Minor): o livro de estoque a refaz,
+// e uma rajada de movimentos não descarta os backups das outras alterações do catálogo
+func markMinor(tx store.Tx) {
+	if m, ok := tx.(store.Minor); ok {
+		m.SetMinor()
+	}
+}
+
+// Stocks lê o estoque de todos os produtos numa única passada pela store
+func (r *repository) Stocks() (map[int]int, error) {
+	stocks := map[int]int{}
+	err := r.Each(Filter{Deleted: IncludeDeleted}, func(p Product) error {
+		stocks[p.ID] = p.Count
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return stocks, nil
+}
+
+// Prices lê o preço de todos os produtos numa única passada pela store
+func (r *repository) Prices() (map[int]money.Money, error) {
+	prices := map[int]money.Money{}
+	err := r.Each(Filter{Deleted: IncludeDeleted}, func(p Product) error {
+		prices[p.ID] = p.Price
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
 	}
-	if !deleted {
-		return fmt.Errorf("produto %d não encontrado", id)
+	return prices, nil
+}
+
+// CountByCategory conta os produtos da categoria numa única passada pela store
+func (r *repository) CountByCategory(categoryID int) (int, error) {
+	n := 0
+	err := r.Each(Filter{CategoryID: categoryID, Deleted: IncludeDeleted}, func(p Product) error {
+		n++
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
 	}
-	/*
-		Aqui, ps está separando a nossa 'lista de valores contidos' em Repository em duas partes
-		Na primeira, estarão os valores do início até o índice (id) que buscamos
-		Na segunda, adicionamos o valor 1 ao índice, que fará "pular" para o próximo índice, junto com os demais
+	return n, nil
+}
+
+// legacyProduct é o produto como era gravado antes das categorias: com o nome da categoria em vez do ID
+type legacyProduct struct {
+	Product
+	Category string `json:"category,omitempty"`
+	// Price fica como foi gravado: o produto antigo pode ter o preço de antes do Money (veja MigratePrices)
+	Price json.RawMessage `json:"price"`
+}
+
+// Na store de arquivo a conversão é uma única transação: ou todos os produtos passam a apontar para as categorias,
+// ou o arquivo não é tocado. Sem produtos a converter, nada é gravado
+func (r *repository) MigrateCategories(resolve func(names map[string]int) (map[string]int, error)) (int, error) {
+	migrated := 0
+	err := r.db.Update(func(tx store.Tx) error {
+		var ps []legacyProduct
+		if err := tx.Read(&ps); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		names := map[string]int{}
+		for _, p := range ps {
+			if p.CategoryID == 0 && p.Category != "" {
+				names[p.Category]++
+			}
+		}
+		if len(names) == 0 {
+			return nil
+		}
+		ids, err := resolve(names)
+		if err != nil {
+			return err
+		}
 
-		 0  1  2  3  4  5 - > Índices
-		[1, 2, 3, 4, 5, 6] -> Valores
+		for i := range ps {
+			if ps[i].CategoryID == 0 && ps[i].Category != "" {
+				ps[i].CategoryID, ps[i].Category = ids[ps[i].Category], ""
+				migrated++
+			}
+		}
+		return tx.Write(ps)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return migrated, nil
+}
 
-		Deletando o nº "3"
+// legacyPrice é o produto com o preço ainda cru, para saber se foi gravado antes do Money (só um número)
+type legacyPrice struct {
+	Product
+	Price json.RawMessage `json:"price"`
+}
 
-		[ :2] = [1, 2] -> Parte 1
-		index + 1 -> 2 + 1 = 3
-		[3: ] = [4, 5, 6] -> Parte 2
+// Na store de arquivo a conversão é uma única transação, como a das categorias. Sem preços antigos, nada é gravado
+func (r *repository) MigratePrices(currency string) (int, error) {
+	migrated := 0
+	err := r.db.Update(func(tx store.Tx) error {
+		var ps []legacyPrice
+		if err := tx.Read(&ps); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
 
-		append = ([1, 2], [4, 5, 6] ...)
+		converted := make([]Product, len(ps))
+		for i, p := range ps {
+			if money.IsLegacy(p.Price) {
+				migrated++
+			}
+			price, err := money.FromLegacy(p.Price, currency)
+			if err != nil {
+				return fmt.Errorf("preço do produto %d: %w", p.ID, err)
+			}
+			p.Product.Price = price
+			converted[i] = p.Product
+		}
+		if migrated == 0 {
+			return nil
+		}
+		return tx.Write(converted)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return migrated, nil
+}
+
+// indexOf percorre a lista buscando o produto com o Id informado
+func indexOf(ps []Product, id int) (int, error) {
+	for i := range ps {
+		if ps[i].ID == id {
+			return i, nil
+		}
+	}
+	return 0, notFoundError(id)
+}
+
+// liveIndexOf é o indexOf dos produtos do catálogo: os da lixeira não são encontrados
+func liveIndexOf(ps []Product, id int) (int, error) {
+	i, err := indexOf(ps, id)
+	if err != nil {
+		return 0, err
+	}
+	if ps[i].DeletedAt != nil {
+		return 0, notFoundError(id)
+	}
+	return i, nil
+}
+
+// notFoundError é o erro de produto inexistente, comum às duas repositories
+func notFoundError(id int) error {
+	return fmt.Errorf("produto %d %w", id, ErrNotFound)
+}
 
-		[1, 2, 4, 5, 6] -> FINAL
-	*/
-	ps = append(ps[:index], ps[index+1:]...)
-	return nil
+// codeNotFoundError é o erro da busca por um código que nenhum produto usa
+func codeNotFoundError(field, code string) error {
+	return fmt.Errorf("produto com o %s %s %w", strings.ToUpper(field), code, ErrNotFound)
 }
@@ -0,0 +1,597 @@
+package products
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/anwardh/meliProject/pkg/gtin"
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/search"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// As migrations do banco ficam no repositório, junto com o código que usa as tabelas
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// productColumns são as colunas de Product, na ordem do scanProduct; antes da conversão das categorias
+// (veja MigrateCategories) os produtos antigos ainda não têm category_id, e antes da dos preços
+// (veja MigratePrices) o preço deles ainda está só na coluna price
+const productColumns = `id, name, COALESCE(category_id, 0), count, price, price_amount, price_currency, prices, sku, gtin,
+	deleted_at, deleted_by`
+
+// gtinKey é a expressão do índice único do GTIN, a forma de 14 dígitos (veja gtin.Key); as buscas pelo GTIN
+// precisam usar a mesma expressão, e só nos produtos com GTIN, para o banco usar o índice
+const gtinKey = `substr('00000000000000' || gtin, -14)`
+
+// scanner é o que scanProduct precisa de *sql.Row e *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// errLegacyPrice é devolvido ao ler um produto com o preço antigo, sem moeda, antes do MigratePrices
+var errLegacyPrice = errors.New("o preço foi gravado sem moeda, antes do money.Money: rode a conversão dos preços (MigratePrices)")
+
+// scanProduct lê as colunas de productColumns; o preço antigo, sem moeda, só é lido pelo MigratePrices
+func scanProduct(row scanner) (Product, error) {
+	var p Product
+	var legacy float64
+	var prices string
+	var deletedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.Name, &p.CategoryID, &p.Count, &legacy, &p.Price.Amount, &p.Price.Currency, &prices,
+		&p.SKU, &p.GTIN, &deletedAt, &p.DeletedBy); err != nil {
+		return Product{}, err
+	}
+	if deletedAt.Valid {
+		p.DeletedAt = &deletedAt.Time
+	}
+	if p.Price.Currency == "" {
+		return Product{}, fmt.Errorf("preço do produto %d: %w", p.ID, errLegacyPrice)
+	}
+	if prices != "" {
+		if err := json.Unmarshal([]byte(prices), &p.Prices); err != nil {
+			return Product{}, fmt.Errorf("preços do produto %d: %w", p.ID, err)
+		}
+	}
+	return p, nil
+}
+
+// encodePrices é a coluna prices: vazia quando o produto não tem preços em outras moedas
+func encodePrices(prices []money.Money) (string, error) {
+	if len(prices) == 0 {
+		return "", nil
+	}
+	raw, err := json.Marshal(prices)
+	return string(raw), err
+}
+
+// sqlRepository é a implementação do Repository sobre um banco SQL (SQLite)
+// Diferente da repository de arquivo, cada operação altera apenas as linhas envolvidas
+type sqlRepository struct {
+	db *sql.DB
+}
+
+// Função que aplica as migrations pendentes e retorna o repositório SQL
+func NewSQLRepository(db *sql.DB) (Repository, error) {
+	dir, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Migrate(db, dir); err != nil {
+		return nil, err
+	}
+	r := &sqlRepository{db: db}
+	if err := r.fillNameFold(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// fillNameFold preenche a coluna name_fold dos produtos gravados antes dela (ou por fora da API);
+// o SQLite não sabe remover acentos, então o cálculo é feito aqui, com o mesmo search.Fold do arquivo
+func (r *sqlRepository) fillNameFold() error {
+	rows, err := r.db.Query(`SELECT id, name FROM products WHERE name_fold = '' AND name <> ''`)
+	if err != nil {
+		return err
+	}
+	names := map[int]string{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		names[id] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, name := range names {
+		if _, err := tx.Exec(`UPDATE products SET name_fold = ? WHERE id = ?`, search.Fold(name), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// O filtro vira a cláusula WHERE: só as linhas que atendem chegam à aplicação
+func (r *sqlRepository) GetAll(f Filter) ([]Product, error) {
+	ps := []Product{}
+	err := r.Each(f, func(p Product) error {
+		ps = append(ps, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (r *sqlRepository) GetByID(id int) (Product, error) {
+	p, err := scanProduct(r.db.QueryRow(`SELECT `+productColumns+` FROM products WHERE id = ? AND deleted_at IS NULL`, id))
+	if err == sql.ErrNoRows {
+		return Product{}, notFoundError(id)
+	}
+	if err != nil {
+		return Product{}, err
+	}
+	return p, nil
+}
+
+func (r *sqlRepository) GetBySKU(sku string) (Product, error) {
+	p, err := scanProduct(r.db.QueryRow(`SELECT `+productColumns+` FROM products WHERE sku = ? AND sku <> '' AND deleted_at IS NULL`, sku))
+	if err == sql.ErrNoRows {
+		return Product{}, codeNotFoundError(FieldSKU, sku)
+	}
+	return p, err
+}
+
+func (r *sqlRepository) GetByGTIN(code string) (Product, error) {
+	p, err := scanProduct(r.db.QueryRow(`SELECT `+productColumns+` FROM products WHERE gtin <> '' AND `+gtinKey+` = ? AND deleted_at IS NULL`, gtin.Key(code)))
+	if err == sql.ErrNoRows {
+		return Product{}, codeNotFoundError(FieldGTIN, code)
+	}
+	return p, err
+}
+
+// Each percorre as linhas conforme o banco as devolve, sem carregar a tabela inteira
+func (r *sqlRepository) Each(f Filter, fn func(p Product) error) error {
+	where, args := f.where()
+	rows, err := r.db.Query(`SELECT `+productColumns+` FROM products`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+/*
+List deixa a ordenação e o recorte da página com o banco. O cursor vira uma condição a mais no WHERE
+(veja PageRequest.afterWhere); buscamos um produto além do limite para saber se há próxima página
+*/
+func (r *sqlRepository) List(f Filter, pr PageRequest) (Page, error) {
+	where, args := f.where()
+
+	page := Page{Products: []Product{}}
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM products`+where, args...).Scan(&page.Total); err != nil {
+		return Page{}, err
+	}
+
+	if after, afterArgs := pr.afterWhere(); after != "" {
+		if where == "" {
+			where = " WHERE " + after
+		} else {
+			where += " AND " + after
+		}
+		args = append(args, afterArgs...)
+	}
+	query := `SELECT ` + productColumns + ` FROM products` + where + pr.orderBy()
+	if pr.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, pr.Limit+1)
+	}
+	if pr.Offset > 0 {
+		if pr.Limit <= 0 {
+			query += ` LIMIT -1`
+		}
+		query += ` OFFSET ?`
+		args = append(args, pr.Offset)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return Page{}, err
+		}
+		page.Products = append(page.Products, p)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, err
+	}
+
+	if pr.Limit > 0 && len(page.Products) > pr.Limit {
+		page.Products = page.Products[:pr.Limit]
+		page.Next = newCursor(page.Products[pr.Limit-1], pr.Sort)
+	}
+	return page, nil
+}
+
+// As tabelas guardam apenas o estado atual, sem histórico
+func (r *sqlRepository) GetAllAsOf(t time.Time, f Filter) ([]Product, error) {
+	return nil, ErrAsOfNotSupported
+}
+
+// Com o AUTOINCREMENT, o último ID atribuído fica na sqlite_sequence, mesmo depois que o produto é removido
+func (r *sqlRepository) LastID() (int, error) {
+	var id int
+	err := r.db.QueryRow(`SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'products'), 0)`).Scan(&id)
+	return id, err
+}
+
+/*
+checkCodes lê, na transação da gravação, os produtos que já usam o SKU ou o GTIN e aplica a mesma regra
+da repository de arquivo (veja checkCodes), que diz qual código está duplicado e com qual produto.
+Os índices únicos garantem a regra mesmo para quem grava no banco por fora da API
+*/
+func (r *sqlRepository) checkCodes(tx *sql.Tx, id int, sku, code string) error {
+	if sku == "" && code == "" {
+		return nil
+	}
+	rows, err := tx.Query(`SELECT `+productColumns+` FROM products
+		WHERE (sku <> '' AND sku = ?) OR (gtin <> '' AND `+gtinKey+` = ?)`, sku, gtin.Key(code))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var candidates []Product
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, p)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return checkCodes(candidates, id, sku, code)
+}
+
+// O ID é gerado pelo próprio banco (AUTOINCREMENT), então inserções simultâneas nunca repetem IDs.
+// A coluna price, dos preços antigos, fica zerada: o preço vai para price_amount e price_currency
+func (r *sqlRepository) Store(name, sku, code string, categoryID int, count int, price money.Money, prices []money.Money) (Product, error) {
+	encoded, err := encodePrices(prices)
+	if err != nil {
+		return Product{}, err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Product{}, err
+	}
+	defer tx.Rollback()
+
+	if err := r.checkCodes(tx, 0, sku, code); err != nil {
+		return Product{}, err
+	}
+	res, err := tx.Exec(`INSERT INTO products (name, name_fold, sku, gtin, category, category_id, count, price, price_amount, price_currency, prices)
+		VALUES (?, ?, ?, ?, '', ?, ?, 0, ?, ?, ?)`, name, search.Fold(name), sku, code, categoryID, count, price.Amount, price.Currency, encoded)
+	if err != nil {
+		return Product{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Product{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Product{}, err
+	}
+	return Product{ID: int(id), Name: name, SKU: sku, GTIN: code, CategoryID: categoryID, Count: count, Price: price, Prices: prices}, nil
+}
+
+func (r *sqlRepository) Update(id int, name, sku, code string, categoryID int, count int, price money.Money, prices []money.Money) (Product, error) {
+	encoded, err := encodePrices(prices)
+	if err != nil {
+		return Product{}, err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Product{}, err
+	}
+	defer tx.Rollback()
+
+	if err := r.checkCodes(tx, id, sku, code); err != nil {
+		return Product{}, err
+	}
+	res, err := tx.Exec(`UPDATE products SET name = ?, name_fold = ?, sku = ?, gtin = ?, category_id = ?, count = ?, price = 0, price_amount = ?, price_currency = ?, prices = ?
+		WHERE id = ? AND deleted_at IS NULL`, name, search.Fold(name), sku, code, categoryID, count, price.Amount, price.Currency, encoded, id)
+	if err := notFound(res, err, id); err != nil {
+		return Product{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Product{}, err
+	}
+	return Product{ID: id, Name: name, SKU: sku, GTIN: code, CategoryID: categoryID, Count: count, Price: price, Prices: prices}, nil
+}
+
+func (r *sqlRepository) UpdateName(id int, name string) (Product, error) {
+	res, err := r.db.Exec(`UPDATE products SET name = ?, name_fold = ? WHERE id = ? AND deleted_at IS NULL`, name, search.Fold(name), id)
+	if err := notFound(res, err, id); err != nil {
+		return Product{}, err
+	}
+
+	return scanProduct(r.db.QueryRow(`SELECT `+productColumns+` FROM products WHERE id = ?`, id))
+}
+
+func (r *sqlRepository) Trash(id int, at time.Time, by string) (Product, error) {
+	res, err := r.db.Exec(`UPDATE products SET deleted_at = ?, deleted_by = ? WHERE id = ? AND deleted_at IS NULL`, at.UTC(), by, id)
+	if err := notFound(res, err, id); err != nil {
+		return Product{}, err
+	}
+	return scanProduct(r.db.QueryRow(`SELECT `+productColumns+` FROM products WHERE id = ?`, id))
+}
+
+// Restore distingue o produto inexistente (ErrNotFound) do que não está na lixeira (ErrNotDeleted)
+func (r *sqlRepository) Restore(id int) (Product, error) {
+	res, err := r.db.Exec(`UPDATE products SET deleted_at = NULL, deleted_by = '' WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err := notFound(res, err, id); errors.Is(err, ErrNotFound) {
+		if _, err := r.GetByID(id); err != nil {
+			return Product{}, err
+		}
+		return Product{}, fmt.Errorf("produto %d %w", id, ErrNotDeleted)
+	} else if err != nil {
+		return Product{}, err
+	}
+	return r.GetByID(id)
+}
+
+// Os IDs e a remoção ficam na mesma transação do banco: um produto restaurado no meio não é removido
+func (r *sqlRepository) Remove(id int) error {
+	res, err := r.db.Exec(`DELETE FROM products WHERE id = ?`, id)
+	return notFound(res, err, id)
+}
+
+func (r *sqlRepository) Purge(before time.Time) ([]int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id FROM products WHERE deleted_at IS NOT NULL AND deleted_at < ? ORDER BY id`, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	var purged []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		purged = append(purged, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range purged {
+		if _, err := tx.Exec(`DELETE FROM products WHERE id = ?`, id); err != nil {
+			return nil, err
+		}
+	}
+	return purged, tx.Commit()
+}
+
+func (r *sqlRepository) Stock(id int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT count FROM products WHERE id = ? AND deleted_at IS NULL`, id).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, notFoundError(id)
+	}
+	return count, err
+}
+
+func (r *sqlRepository) SetStock(id, count int) error {
+	res, err := r.db.Exec(`UPDATE products SET count = ? WHERE id = ?`, count, id)
+	return notFound(res, err, id)
+}
+
+// Os estoques são gravados numa transação do banco: ou todos, ou nenhum
+func (r *sqlRepository) SetStocks(counts map[int]int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for id, count := range counts {
+		res, err := tx.Exec(`UPDATE products SET count = ? WHERE id = ?`, count, id)
+		if err := notFound(res, err, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *sqlRepository) Stocks() (map[int]int, error) {
+	rows, err := r.db.Query(`SELECT id, count FROM products`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stocks := map[int]int{}
+	for rows.Next() {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		stocks[id] = count
+	}
+	return stocks, rows.Err()
+}
+
+func (r *sqlRepository) Prices() (map[int]money.Money, error) {
+	prices := map[int]money.Money{}
+	err := r.Each(Filter{Deleted: IncludeDeleted}, func(p Product) error {
+		prices[p.ID] = p.Price
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+func (r *sqlRepository) CountByCategory(categoryID int) (int, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM products WHERE category_id = ?`, categoryID).Scan(&n)
+	return n, err
+}
+
+/*
+As atualizações dos produtos rodam numa transação do banco; a coluna category fica vazia nos produtos convertidos.
+Os nomes são lidos antes da transação: o resolve grava as categorias por outra conexão, e uma transação aberta
+antes dessas gravações não poderia mais gravar (o SQLite recusa, com o retrato do banco desatualizado)
+*/
+func (r *sqlRepository) MigrateCategories(resolve func(names map[string]int) (map[string]int, error)) (int, error) {
+	rows, err := r.db.Query(`SELECT category, COUNT(*) FROM products WHERE category_id IS NULL AND category <> '' GROUP BY category`)
+	if err != nil {
+		return 0, err
+	}
+	names := map[string]int{}
+	for rows.Next() {
+		var name string
+		var n int
+		if err := rows.Scan(&name, &n); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		names[name] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+
+	ids, err := resolve(names)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	migrated := 0
+	for name, id := range ids {
+		res, err := tx.Exec(`UPDATE products SET category_id = ?, category = '' WHERE category = ? AND category_id IS NULL`, id, name)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		migrated += int(n)
+	}
+	return migrated, tx.Commit()
+}
+
+// Os preços antigos são lidos em currency e gravados numa transação do banco
+func (r *sqlRepository) MigratePrices(currency string) (int, error) {
+	rows, err := r.db.Query(`SELECT id, price FROM products WHERE price_currency = ''`)
+	if err != nil {
+		return 0, err
+	}
+	converted := map[int]money.Money{}
+	for rows.Next() {
+		var id int
+		var legacy float64
+		if err := rows.Scan(&id, &legacy); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		price, err := money.FromFloat(legacy, currency)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("preço do produto %d: %w", id, err)
+		}
+		converted[id] = price
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(converted) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for id, price := range converted {
+		if _, err := tx.Exec(`UPDATE products SET price = 0, price_amount = ?, price_currency = ? WHERE id = ? AND price_currency = ''`,
+			price.Amount, price.Currency, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(converted), tx.Commit()
+}
+
+// notFound converte um UPDATE/DELETE que não atingiu nenhuma linha no mesmo erro da repository de arquivo
+func notFound(res sql.Result, err error, id int) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFoundError(id)
+	}
+	return nil
+}
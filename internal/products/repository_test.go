@@ -0,0 +1,745 @@
+package products
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anwardh/meliProject/internal/categories"
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+/*
+backend monta uma repository de um tipo de store. open devolve uma repository nova a cada chamada,
+sempre sobre os mesmos dados: é assim que conferimos que as gravações sobrevivem a uma nova repository
+(no arquivo e no SQLite, a store também é aberta de novo)
+*/
+type backend struct {
+	name  string
+	setup func(t *testing.T) (open func() Repository)
+}
+
+// backends são as stores que toda repository precisa atender do mesmo jeito
+var backends = []backend{
+	{"arquivo", fileBackend},
+	{"memoria", memoryBackend},
+	{"sqlite", sqliteBackend},
+}
+
+func fileBackend(t *testing.T) func() Repository {
+	path := filepath.Join(t.TempDir(), "products.json")
+	return func() Repository {
+		return NewRepository(&store.FileStore{FileName: path})
+	}
+}
+
+func memoryBackend(t *testing.T) func() Repository {
+	ms := store.NewMemoryStore(nil)
+	return func() Repository {
+		return NewRepository(ms)
+	}
+}
+
+// No SQLite, os produtos apontam para a tabela de categorias: criamos as categorias usadas nos testes
+func sqliteBackend(t *testing.T) func() Repository {
+	path := filepath.Join(t.TempDir(), "catalog.db")
+	first := true
+	return func() Repository {
+		sq, err := store.OpenSQLite(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sq.DB().Close() })
+
+		cr, err := categories.NewSQLRepository(sq.DB())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first {
+			first = false
+			for _, name := range []string{"Doces", "Bebidas", "Limpeza"} {
+				if _, err := cr.Store(name, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		r, err := NewSQLRepository(sq.DB())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+}
+
+// forEachBackend roda o teste em todas as stores
+func forEachBackend(t *testing.T, test func(t *testing.T, open func() Repository)) {
+	for _, b := range backends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			test(t, b.setup(t))
+		})
+	}
+}
+
+func brl(t *testing.T, amount string) money.Money {
+	t.Helper()
+	m, err := money.Parse(amount, "BRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// mustStore grava um produto sem códigos
+func mustStore(t *testing.T, r Repository, name string, categoryID, count int, price string) Product {
+	t.Helper()
+	p, err := r.Store(name, "", "", categoryID, count, brl(t, price), nil)
+	if err != nil {
+		t.Fatalf("Store(%q): %v", name, err)
+	}
+	return p
+}
+
+// ids devolve os IDs dos produtos, na ordem em que vieram
+func ids(ps []Product) []int {
+	out := make([]int, len(ps))
+	for i, p := range ps {
+		out[i] = p.ID
+	}
+	return out
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRepositoryStoreAssignsSequentialIDs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+
+		// Sem nada gravado, a listagem é vazia (no arquivo, o arquivo ainda não existe)
+		ps, err := r.GetAll(Filter{})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("GetAll sem produtos: %v", err)
+		}
+		if len(ps) != 0 {
+			t.Fatalf("GetAll sem produtos devolveu %d produtos", len(ps))
+		}
+
+		a := mustStore(t, r, "Bolo", 1, 2, "5.00")
+		b := mustStore(t, r, "Café", 2, 10, "7.50")
+		if a.ID != 1 || b.ID != 2 {
+			t.Fatalf("IDs = %d e %d, esperado 1 e 2", a.ID, b.ID)
+		}
+
+		got, err := r.GetByID(b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "Café" || got.CategoryID != 2 || got.Count != 10 || got.Price != brl(t, "7.50") {
+			t.Fatalf("GetByID = %+v", got)
+		}
+		if last, err := r.LastID(); err != nil || last != 2 {
+			t.Fatalf("LastID = %d, %v", last, err)
+		}
+		if _, err := r.GetByID(99); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetByID(99) = %v, esperado ErrNotFound", err)
+		}
+	})
+}
+
+func TestRepositoryUpdate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		p := mustStore(t, r, "Bolo", 1, 2, "5.00")
+		usd := money.Money{Amount: 150, Currency: "USD"}
+
+		updated, err := r.Update(p.ID, "Bolo de Cenoura", "BOLO-1", "", 2, 8, brl(t, "6.25"), []money.Money{usd})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetByID(p.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "Bolo de Cenoura" || got.SKU != "BOLO-1" || got.CategoryID != 2 || got.Count != 8 ||
+			got.Price != brl(t, "6.25") || len(got.Prices) != 1 || got.Prices[0] != usd {
+			t.Fatalf("depois do Update, GetByID = %+v", got)
+		}
+		if updated.ID != p.ID || updated.Name != got.Name {
+			t.Fatalf("Update devolveu %+v", updated)
+		}
+
+		if _, err := r.Update(99, "X", "", "", 1, 1, brl(t, "1.00"), nil); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Update(99) = %v, esperado ErrNotFound", err)
+		}
+	})
+}
+
+func TestRepositoryUpdateName(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		p := mustStore(t, r, "Bolo", 1, 2, "5.00")
+
+		renamed, err := r.UpdateName(p.ID, "Torta")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if renamed.Name != "Torta" || renamed.Count != 2 || renamed.Price != p.Price {
+			t.Fatalf("UpdateName devolveu %+v", renamed)
+		}
+		if got, _ := r.GetByID(p.ID); got.Name != "Torta" {
+			t.Fatalf("depois do UpdateName, GetByID = %+v", got)
+		}
+		if _, err := r.UpdateName(99, "X"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("UpdateName(99) = %v, esperado ErrNotFound", err)
+		}
+	})
+}
+
+func TestRepositoryTrashAndRestore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		a := mustStore(t, r, "Bolo", 1, 2, "5.00")
+		b := mustStore(t, r, "Café", 1, 3, "7.00")
+		at := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+
+		trashed, err := r.Trash(a.ID, at, "ana")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trashed.DeletedAt == nil || !trashed.DeletedAt.Equal(at) || trashed.DeletedBy != "ana" {
+			t.Fatalf("Trash devolveu %+v", trashed)
+		}
+
+		// Na lixeira, o produto some do catálogo, mas não do estoque nem dos preços
+		if _, err := r.GetByID(a.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetByID de um produto na lixeira = %v, esperado ErrNotFound", err)
+		}
+		if _, err := r.Stock(a.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Stock de um produto na lixeira = %v, esperado ErrNotFound", err)
+		}
+		if _, err := r.Trash(a.ID, at, "ana"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Trash de um produto na lixeira = %v, esperado ErrNotFound", err)
+		}
+		if _, err := r.UpdateName(a.ID, "X"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("UpdateName de um produto na lixeira = %v, esperado ErrNotFound", err)
+		}
+		live, err := r.GetAll(Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalIDs(ids(live), []int{b.ID}) {
+			t.Fatalf("GetAll = %v, esperado só o produto %d", ids(live), b.ID)
+		}
+		trash, err := r.GetAll(Filter{Deleted: OnlyDeleted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalIDs(ids(trash), []int{a.ID}) || trash[0].DeletedBy != "ana" {
+			t.Fatalf("lixeira = %+v", trash)
+		}
+		stocks, err := r.Stocks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stocks[a.ID] != 2 || stocks[b.ID] != 3 {
+			t.Fatalf("Stocks = %v", stocks)
+		}
+		if n, err := r.CountByCategory(1); err != nil || n != 2 {
+			t.Fatalf("CountByCategory(1) = %d, %v; os produtos da lixeira contam", n, err)
+		}
+
+		restored, err := r.Restore(a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.DeletedAt != nil || restored.DeletedBy != "" || restored.Count != 2 {
+			t.Fatalf("Restore devolveu %+v", restored)
+		}
+		if _, err := r.GetByID(a.ID); err != nil {
+			t.Fatalf("GetByID depois do Restore: %v", err)
+		}
+		if _, err := r.Restore(a.ID); !errors.Is(err, ErrNotDeleted) {
+			t.Fatalf("Restore de um produto fora da lixeira = %v, esperado ErrNotDeleted", err)
+		}
+		if _, err := r.Restore(99); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Restore(99) = %v, esperado ErrNotFound", err)
+		}
+	})
+}
+
+func TestRepositoryPurge(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		old := mustStore(t, r, "Velho", 1, 1, "1.00")
+		recent := mustStore(t, r, "Recente", 1, 1, "1.00")
+		kept := mustStore(t, r, "Ativo", 1, 1, "1.00")
+		day := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+		if _, err := r.Trash(old.ID, day, "ana"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Trash(recent.ID, day.Add(48*time.Hour), "ana"); err != nil {
+			t.Fatal(err)
+		}
+
+		purged, err := r.Purge(day.Add(24 * time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalIDs(purged, []int{old.ID}) {
+			t.Fatalf("Purge = %v, esperado só o produto %d", purged, old.ID)
+		}
+
+		all, err := r.GetAll(Filter{Deleted: IncludeDeleted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalIDs(ids(all), []int{recent.ID, kept.ID}) {
+			t.Fatalf("depois do Purge, os produtos são %v", ids(all))
+		}
+		if _, err := r.Restore(old.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Restore de um produto removido de vez = %v, esperado ErrNotFound", err)
+		}
+
+		// Sem nada vencido, nada é removido
+		if purged, err := r.Purge(day); err != nil || len(purged) != 0 {
+			t.Fatalf("Purge sem produtos vencidos = %v, %v", purged, err)
+		}
+	})
+}
+
+// O ID de um produto removido de vez não volta a ser atribuído, nem depois de reabrir a store
+func TestRepositoryPurgeDoesNotReuseIDs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		mustStore(t, r, "Bolo", 1, 1, "1.00")
+		last := mustStore(t, r, "Café", 1, 1, "1.00")
+		day := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+		if _, err := r.Trash(last.ID, day, "ana"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Purge(day.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		r = open()
+		if id, err := r.LastID(); err != nil || id != last.ID {
+			t.Fatalf("LastID depois do Purge = %d, %v; esperado %d", id, err, last.ID)
+		}
+		if p := mustStore(t, r, "Chá", 1, 1, "1.00"); p.ID != last.ID+1 {
+			t.Fatalf("o produto novo recebeu o ID %d, esperado %d (o %d foi removido pelo Purge)", p.ID, last.ID+1, last.ID)
+		}
+	})
+}
+
+func TestRepositoryCodes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		p, err := r.Store("Bolo", "BOLO-1", "0012345678905", 1, 1, brl(t, "1.00"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := r.GetBySKU("BOLO-1"); err != nil || got.ID != p.ID {
+			t.Fatalf("GetBySKU = %+v, %v", got, err)
+		}
+		// O UPC-A e o EAN-13 do mesmo item são o mesmo GTIN
+		if got, err := r.GetByGTIN("012345678905"); err != nil || got.ID != p.ID {
+			t.Fatalf("GetByGTIN pelo UPC-A = %+v, %v", got, err)
+		}
+		if _, err := r.GetBySKU("OUTRO"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetBySKU de um SKU sem produto = %v, esperado ErrNotFound", err)
+		}
+
+		_, err = r.Store("Torta", "BOLO-1", "", 1, 1, brl(t, "1.00"), nil)
+		var ce *CodeError
+		if !errors.As(err, &ce) || !errors.Is(err, ErrDuplicateCode) || ce.Field != FieldSKU || ce.ProductID != p.ID {
+			t.Fatalf("Store com SKU repetido = %v, esperado ErrDuplicateCode no sku", err)
+		}
+		_, err = r.Store("Torta", "", "012345678905", 1, 1, brl(t, "1.00"), nil)
+		if !errors.As(err, &ce) || !errors.Is(err, ErrDuplicateCode) || ce.Field != FieldGTIN {
+			t.Fatalf("Store com GTIN repetido = %v, esperado ErrDuplicateCode no gtin", err)
+		}
+
+		// O próprio produto pode manter os códigos que já tem
+		if _, err := r.Update(p.ID, "Bolo", "BOLO-1", "0012345678905", 1, 2, brl(t, "1.00"), nil); err != nil {
+			t.Fatalf("Update mantendo os códigos: %v", err)
+		}
+
+		// Na lixeira, o produto continua com os códigos
+		if _, err := r.Trash(p.ID, time.Now(), "ana"); err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.Store("Torta", "BOLO-1", "", 1, 1, brl(t, "1.00"), nil)
+		if !errors.As(err, &ce) || !ce.InTrash {
+			t.Fatalf("Store com o SKU de um produto na lixeira = %v, esperado ErrDuplicateCode com InTrash", err)
+		}
+		if _, err := r.GetBySKU("BOLO-1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetBySKU de um produto na lixeira = %v, esperado ErrNotFound", err)
+		}
+	})
+}
+
+func TestRepositoryStockAndPrices(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		p := mustStore(t, r, "Bolo", 1, 2, "5.00")
+
+		if err := r.SetStock(p.ID, 7); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := r.Stock(p.ID); err != nil || n != 7 {
+			t.Fatalf("Stock = %d, %v", n, err)
+		}
+		if err := r.SetStock(99, 1); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("SetStock(99) = %v, esperado ErrNotFound", err)
+		}
+		if _, err := r.Stock(99); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Stock(99) = %v, esperado ErrNotFound", err)
+		}
+
+		prices, err := r.Prices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(prices) != 1 || prices[p.ID] != brl(t, "5.00") {
+			t.Fatalf("Prices = %v", prices)
+		}
+	})
+}
+
+// As gravações precisam estar na store, e não só na repository que as fez
+func TestRepositoryPersistsAcrossRepositories(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		a := mustStore(t, r, "Bolo", 1, 2, "5.00")
+		b := mustStore(t, r, "Café", 1, 3, "7.00")
+		c := mustStore(t, r, "Chá", 2, 4, "3.00")
+		if _, err := r.Update(a.ID, "Bolo de Cenoura", "", "", 2, 5, brl(t, "6.00"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.UpdateName(b.ID, "Café com Leite"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Trash(c.ID, time.Now(), "ana"); err != nil {
+			t.Fatal(err)
+		}
+
+		reopened := open()
+		got, err := reopened.GetAll(Filter{Deleted: IncludeDeleted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 {
+			t.Fatalf("a nova repository enxerga %d produtos, esperado 3", len(got))
+		}
+		if got[0].Name != "Bolo de Cenoura" || got[0].CategoryID != 2 || got[0].Count != 5 || got[0].Price != brl(t, "6.00") {
+			t.Fatalf("Update não persistiu: %+v", got[0])
+		}
+		if got[1].Name != "Café com Leite" {
+			t.Fatalf("UpdateName não persistiu: %+v", got[1])
+		}
+		if got[2].DeletedAt == nil || got[2].DeletedBy != "ana" {
+			t.Fatalf("Trash não persistiu: %+v", got[2])
+		}
+
+		// A nova repository continua a sequência de IDs
+		if d := mustStore(t, reopened, "Suco", 1, 1, "4.00"); d.ID != 4 {
+			t.Fatalf("o produto gravado pela nova repository recebeu o ID %d, esperado 4", d.ID)
+		}
+	})
+}
+
+// Centenas de Store simultâneos, em várias repositories sobre o mesmo arquivo, nunca repetem um ID. Rode com -race
+func TestRepositoryConcurrentStores(t *testing.T) {
+	const (
+		repositories = 4
+		writers      = 200
+	)
+	path := filepath.Join(t.TempDir(), "products.json")
+	rs := make([]Repository, repositories)
+	for i := range rs {
+		rs[i] = NewRepository(&store.FileStore{FileName: path})
+	}
+
+	var wg sync.WaitGroup
+	created := make(chan Product, writers)
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p, err := rs[i%repositories].Store(fmt.Sprintf("Produto %d", i), "", "", 1, 1, money.Money{Amount: 100, Currency: "BRL"}, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			created <- p
+		}(i)
+	}
+	wg.Wait()
+	close(created)
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	seen := make(map[int]bool, writers)
+	for p := range created {
+		if seen[p.ID] {
+			t.Fatalf("o ID %d foi atribuído a dois produtos", p.ID)
+		}
+		seen[p.ID] = true
+	}
+	all, err := NewRepository(&store.FileStore{FileName: path}).GetAll(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != writers || len(all) != writers {
+		t.Fatalf("%d produtos gravados e %d no arquivo, esperado %d", len(seen), len(all), writers)
+	}
+}
+
+// seedCatalog grava o mesmo catálogo em qualquer store, para comparar as consultas entre elas
+func seedCatalog(t *testing.T, r Repository) {
+	t.Helper()
+	// Os produtos recebem os IDs 1 a 6, nesta ordem; o último vai para a lixeira
+	for _, p := range []struct {
+		name       string
+		categoryID int
+		count      int
+		price      string
+	}{
+		{"banana", 1, 0, "3.00"},
+		{"Abacate", 2, 5, "7.50"},
+		{"cenoura", 1, 12, "3.00"},
+		{"Bolo 100%", 3, 1, "20.00"},
+		{"bolo_de_milho", 3, 0, "15.00"},
+		{"Damasco", 2, 7, "30.00"},
+	} {
+		mustStore(t, r, p.name, p.categoryID, p.count, p.price)
+	}
+	if _, err := r.Trash(6, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), "ana"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Os filtros escolhem os mesmos produtos em todas as stores: o Match do arquivo e o WHERE do SQLite precisam concordar
+func TestRepositoryFilterParity(t *testing.T) {
+	inStock, outOfStock := true, false
+	min, max := money.Money{Amount: 300, Currency: "BRL"}, money.Money{Amount: 1500, Currency: "BRL"}
+	cases := []struct {
+		name   string
+		filter Filter
+		want   []int
+	}{
+		{"filtro vazio", Filter{}, []int{1, 2, 3, 4, 5}},
+		{"com a lixeira", Filter{Deleted: IncludeDeleted}, []int{1, 2, 3, 4, 5, 6}},
+		{"só a lixeira", Filter{Deleted: OnlyDeleted}, []int{6}},
+		{"categoria", Filter{CategoryID: 1}, []int{1, 3}},
+		{"categoria com subcategorias", Filter{CategoryID: 1, categoryIDs: []int{1, 3}}, []int{1, 3, 4, 5}},
+		{"categoria pelo nome", Filter{Category: "bolos", categoryIDs: []int{2, 3}}, []int{2, 4, 5}},
+		{"nome que nenhuma categoria tem", Filter{Category: "chás", categoryIDs: []int{}}, nil},
+		{"faixa de preço com os extremos", Filter{MinPrice: &min, MaxPrice: &max}, []int{1, 2, 3, 5}},
+		{"com estoque", Filter{InStock: &inStock}, []int{2, 3, 4}},
+		{"sem estoque", Filter{InStock: &outOfStock}, []int{1, 5}},
+		{"texto sem diferenciar maiúsculas", Filter{Query: "BOLO"}, []int{4, 5}},
+		{"% é literal", Filter{Query: "0%"}, []int{4}},
+		{"_ é literal", Filter{Query: "o_d"}, []int{5}},
+		{"critérios combinados", Filter{CategoryID: 3, categoryIDs: []int{3}, InStock: &inStock, Query: "bolo"}, []int{4}},
+		{"categoria da lixeira", Filter{CategoryID: 2, Deleted: OnlyDeleted}, []int{6}},
+	}
+
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		seedCatalog(t, r)
+		for _, c := range cases {
+			got, err := r.GetAll(c.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalIDs(ids(got), c.want) {
+				t.Errorf("%s: GetAll = %v, esperado %v", c.name, ids(got), c.want)
+			}
+			var each []int
+			if err := r.Each(c.filter, func(p Product) error { each = append(each, p.ID); return nil }); err != nil {
+				t.Fatal(err)
+			}
+			if !equalIDs(each, c.want) {
+				t.Errorf("%s: Each = %v, esperado %v", c.name, each, c.want)
+			}
+		}
+
+		for category, want := range map[int]int{1: 2, 2: 2, 3: 2, 99: 0} {
+			if n, err := r.CountByCategory(category); err != nil || n != want {
+				t.Errorf("CountByCategory(%d) = %d, %v; esperado %d", category, n, err, want)
+			}
+		}
+		if _, err := r.GetBySKU(""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetBySKU(\"\") = %v, esperado ErrNotFound: produto sem SKU não é achado pelo SKU vazio", err)
+		}
+	})
+}
+
+// A ordenação e a paginação (por offset e por cursor) devolvem as mesmas páginas em todas as stores
+func TestRepositoryListParity(t *testing.T) {
+	cases := []struct {
+		sort string
+		want []int
+	}{
+		{"", []int{1, 2, 3, 4, 5}},
+		{"-id", []int{5, 4, 3, 2, 1}},
+		{"name", []int{2, 1, 4, 5, 3}},
+		{"-name", []int{3, 5, 4, 1, 2}},
+		{"price", []int{1, 3, 2, 5, 4}},
+		{"price,-name", []int{3, 1, 2, 5, 4}},
+		{"count,-price", []int{5, 1, 4, 2, 3}},
+		{"category_id,name", []int{1, 3, 2, 4, 5}},
+	}
+
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		seedCatalog(t, r)
+		for _, c := range cases {
+			keys, err := ParseSort(c.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			all, err := r.List(Filter{}, PageRequest{Sort: keys})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalIDs(ids(all.Products), c.want) || all.Total != len(c.want) || all.Next != nil {
+				t.Errorf("sort=%q: List = %v (total %d), esperado %v", c.sort, ids(all.Products), all.Total, c.want)
+			}
+
+			offset, err := r.List(Filter{}, PageRequest{Sort: keys, Offset: 1, Limit: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalIDs(ids(offset.Products), c.want[1:3]) || offset.Total != len(c.want) {
+				t.Errorf("sort=%q: página com offset = %v, esperado %v", c.sort, ids(offset.Products), c.want[1:3])
+			}
+
+			// Percorrendo pelo cursor, de dois em dois, passamos por todos os produtos uma única vez
+			var walked []int
+			pr := PageRequest{Sort: keys, Limit: 2}
+			for pages := 0; ; pages++ {
+				if pages > len(c.want) {
+					t.Fatalf("sort=%q: o cursor não termina", c.sort)
+				}
+				page, err := r.List(Filter{}, pr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				walked = append(walked, ids(page.Products)...)
+				if page.Next == nil {
+					break
+				}
+				// O cursor passa pela API codificado
+				if pr.After, err = DecodeCursor(page.Next.Encode(), keys); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !equalIDs(walked, c.want) {
+				t.Errorf("sort=%q: pelo cursor = %v, esperado %v", c.sort, walked, c.want)
+			}
+		}
+	})
+}
+
+// Acentos e letras fora do ASCII: o LIKE e o NOCASE do SQLite não as igualam, o search.Fold sim
+func TestRepositoryUnicodeNamesParity(t *testing.T) {
+	names := []string{"Água Tônica", "ábaco", "Éclair", "CAFÉ", "café com leite", "Ñoquis", "Zebra"}
+	queries := []struct {
+		q    string
+		want []int
+	}{
+		{"água", []int{1}},
+		{"AGUA", []int{1}},
+		{"Café", []int{4, 5}},
+		{"cafe", []int{4, 5}},
+		{"ÉCLAIR", []int{3}},
+		{"ÑOQ", []int{6}},
+		{"tônica", []int{1}},
+	}
+	// Ordenado sem acentos e sem caixa: abaco, agua tonica, cafe, cafe com leite, eclair, noquis, zebra
+	wantByName := []int{2, 1, 4, 5, 3, 6, 7}
+
+	forEachBackend(t, func(t *testing.T, open func() Repository) {
+		r := open()
+		for _, name := range names {
+			mustStore(t, r, name, 1, 1, "1.00")
+		}
+
+		for _, c := range queries {
+			got, err := r.GetAll(Filter{Query: c.q})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalIDs(ids(got), c.want) {
+				t.Errorf("q=%q: %v, esperado %v", c.q, ids(got), c.want)
+			}
+		}
+
+		keys, _ := ParseSort("name")
+		var walked []int
+		pr := PageRequest{Sort: keys, Limit: 3}
+		for {
+			page, err := r.List(Filter{}, pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			walked = append(walked, ids(page.Products)...)
+			if page.Next == nil {
+				break
+			}
+			pr.After = page.Next
+		}
+		if !equalIDs(walked, wantByName) {
+			t.Errorf("sort=name: %v, esperado %v", walked, wantByName)
+		}
+
+		// A renomeação atualiza o nome usado na busca
+		if _, err := r.UpdateName(7, "Óleo"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := r.GetAll(Filter{Query: "oleo"}); err != nil || !equalIDs(ids(got), []int{7}) {
+			t.Errorf("q=oleo depois do UpdateName = %v, %v", ids(got), err)
+		}
+	})
+}
+
+// Os produtos gravados antes da coluna name_fold (ou por fora da API) são preenchidos na inicialização
+func TestSQLRepositoryFillsNameFold(t *testing.T) {
+	open := sqliteBackend(t)
+	r := open().(*sqlRepository)
+	mustStore(t, r, "Pão de Açúcar", 1, 1, "1.00")
+	if _, err := r.db.Exec(`UPDATE products SET name_fold = ''`); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := open().GetAll(Filter{Query: "pao de acucar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("a busca achou %d produtos, esperado 1: a coluna name_fold não foi preenchida", len(got))
+	}
+}
@@ -0,0 +1,145 @@
+package products
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/anwardh/meliProject/internal/categories"
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+/*
+Migrations devolve as migrations do schema do arquivo de produtos (veja store.Migrations): rodam em toda leitura
+de um arquivo antigo, inclusive a de um backup (restore e diff). São só da store dos produtos: as stores das
+categorias, do livro de estoque e do histórico de preços guardam registros de outro formato.
+categories resolve os nomes das categorias em texto dos produtos antigos (veja Service.MigrateCategories);
+sem ele, um arquivo com esses produtos não pode ser lido. currency é a moeda do catálogo, em que são lidos
+os preços antigos, gravados só como número (veja Service.MigratePrices).
+As stores sem envelope (SQLite, journal) e o conteúdo criptografado não passam por aqui: para elas continuam
+o MigrateCategories e o MigratePrices da subida
+*/
+func Migrations(c categories.Service, currency string) store.Migrations {
+	return store.Migrations{
+		// Schema 2 para 3: a categoria em texto vira o ID da categoria
+		2: func(data json.RawMessage) (json.RawMessage, error) {
+			return migrateCategories(data, c)
+		},
+		// Schema 3 para 4: o preço gravado como número passa para o formato do Money
+		3: func(data json.RawMessage) (json.RawMessage, error) {
+			return migratePrices(data, currency)
+		},
+	}
+}
+
+// errNoCategories é devolvido pela migration das categorias quando não há um Service para resolver os nomes
+var errNoCategories = errors.New("as categorias em texto dos produtos antigos só são convertidas com o Service das categorias")
+
+// migrateCategories troca a categoria em texto dos produtos antigos pelo ID (veja Service.MigrateCategories)
+func migrateCategories(data json.RawMessage, c categories.Service) (json.RawMessage, error) {
+	return migrateItems(data, func(items []map[string]json.RawMessage) (bool, error) {
+		names := map[string]int{}
+		for _, item := range items {
+			if name, ok := legacyCategory(item); ok {
+				names[name]++
+			}
+		}
+		if len(names) == 0 {
+			return false, nil
+		}
+		if c == nil {
+			return false, errNoCategories
+		}
+		ids, err := resolveCategories(c, names)
+		if err != nil {
+			return false, err
+		}
+
+		for _, item := range items {
+			if name, ok := legacyCategory(item); ok {
+				id, err := json.Marshal(ids[name])
+				if err != nil {
+					return false, err
+				}
+				item["category_id"] = id
+				delete(item, "category")
+			}
+		}
+		return true, nil
+	})
+}
+
+// legacyCategory devolve o nome da categoria do produto gravado antes das categorias (sem category_id)
+func legacyCategory(item map[string]json.RawMessage) (string, bool) {
+	var p legacyProduct
+	if item == nil || item["category"] == nil {
+		return "", false
+	}
+	if err := json.Unmarshal(item["category"], &p.Category); err != nil || p.Category == "" {
+		return "", false
+	}
+	if raw, ok := item["category_id"]; ok {
+		if err := json.Unmarshal(raw, &p.CategoryID); err != nil || p.CategoryID != 0 {
+			return "", false
+		}
+	}
+	return p.Category, true
+}
+
+// migratePrices grava no formato do Money os preços antigos dos produtos, lidos na moeda do catálogo (veja Service.MigratePrices)
+func migratePrices(data json.RawMessage, currency string) (json.RawMessage, error) {
+	return migrateItems(data, func(items []map[string]json.RawMessage) (bool, error) {
+		changed := false
+		for _, item := range items {
+			if item == nil || !money.IsLegacy(item["price"]) {
+				continue
+			}
+			price, err := money.FromLegacy(item["price"], currency)
+			if err != nil {
+				return false, fmt.Errorf("preço do produto %s: %w", item["id"], err)
+			}
+			b, err := json.Marshal(price)
+			if err != nil {
+				return false, err
+			}
+			item["price"] = b
+			changed = true
+		}
+		return changed, nil
+	})
+}
+
+/*
+migrateItems lê os dados como uma lista de registros e chama fn com eles (os que não são objetos vêm nil).
+Se fn mudar algum registro, a lista é gravada de novo, no formato da store; dados que não são uma lista
+(ex.: o conteúdo criptografado) e listas que fn não mudou voltam como estão
+*/
+func migrateItems(data json.RawMessage, fn func(items []map[string]json.RawMessage) (bool, error)) (json.RawMessage, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return data, nil
+	}
+	items := make([]map[string]json.RawMessage, len(raw))
+	for i := range raw {
+		if err := json.Unmarshal(raw[i], &items[i]); err != nil {
+			items[i] = nil
+		}
+	}
+
+	changed, err := fn(items)
+	if err != nil || !changed {
+		return data, err
+	}
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		b, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		raw[i] = b
+	}
+	return json.MarshalIndent(raw, "", "  ")
+}
@@ -0,0 +1,145 @@
+package products
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anwardh/meliProject/internal/categories"
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// legacyFile é um arquivo de produtos no schema 2, de antes das categorias
+const legacyFile = `{
+  "schema_version": 2,
+  "metadata": {},
+  "data": [
+    {"id": 1, "name": "Coca", "category": "Bebidas", "count": 1, "price": {"amount": "5.50", "currency": "BRL"}},
+    {"id": 2, "name": "Pão", "category_id": 3, "count": 2, "price": {"amount": "1.00", "currency": "BRL"}}
+  ]
+}`
+
+// resolver é o Service das categorias da migration, com o Resolve do teste
+type resolver struct {
+	categories.Service
+	resolve func(name string) (categories.Category, error)
+}
+
+func (r resolver) Resolve(name string) (categories.Category, error) {
+	return r.resolve(name)
+}
+
+// A categoria em texto vira o ID na leitura do arquivo antigo e na restauração de um backup antigo
+func TestSchemaMigratesLegacyCategories(t *testing.T) {
+	c := resolver{resolve: func(name string) (categories.Category, error) {
+		if name != "Bebidas" {
+			t.Errorf("nome a resolver = %q, esperado só Bebidas", name)
+		}
+		return categories.Category{ID: 7, Name: name}, nil
+	}}
+
+	dir := t.TempDir()
+	fs := &store.FileStore{
+		FileName:   filepath.Join(dir, "products.json"),
+		Backups:    store.BackupPolicy{Dir: filepath.Join(dir, "backups")},
+		Migrations: Migrations(c, "BRL"),
+	}
+	if err := os.WriteFile(fs.FileName, []byte(legacyFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := NewRepository(fs).GetAll(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || ps[0].CategoryID != 7 || ps[1].CategoryID != 3 {
+		t.Fatalf("produtos lidos do arquivo antigo = %+v, esperado as categorias 7 e 3", ps)
+	}
+
+	// O backup antigo volta a ser o catálogo já convertido
+	name := "products-20230601T100000.000000000Z.json"
+	if err := os.MkdirAll(fs.Backups.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(fs.Backups.Dir, name), []byte(legacyFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Restore(name); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(fs.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env struct {
+		SchemaVersion int               `json:"schema_version"`
+		Data          []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.SchemaVersion != fs.Migrations.Version() || len(env.Data) != 2 {
+		t.Fatalf("arquivo restaurado na versão %d com %d produtos", env.SchemaVersion, len(env.Data))
+	}
+	var restored map[string]json.RawMessage
+	if err := json.Unmarshal(env.Data[0], &restored); err != nil {
+		t.Fatal(err)
+	}
+	if _, legacy := restored["category"]; legacy || string(restored["category_id"]) != "7" {
+		t.Fatalf("produto restaurado = %s, esperado o category_id no lugar da categoria em texto", env.Data[0])
+	}
+}
+
+// O preço gravado como número passa para o Money na moeda do catálogo, a das migrations; o histórico de preços não é mexido
+func TestSchemaMigratesLegacyPrices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "products.json")
+	legacy := `{"schema_version": 3, "metadata": {}, "data": [{"id": 1, "name": "Coca", "category_id": 3, "count": 1, "price": 5.5}]}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewRepository(&store.FileStore{FileName: path, Migrations: Migrations(nil, "USD")}).GetByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Price.Amount != 550 || p.Price.Currency != "USD" {
+		t.Fatalf("preço lido do arquivo antigo = %+v, esperado 5.50 USD, a moeda do catálogo", p.Price)
+	}
+
+	got, err := migratePrices(json.RawMessage(`[{"id": 1, "price": 5.5}]`), "BRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []struct {
+		Price json.RawMessage `json:"price"`
+	}
+	if err := json.Unmarshal(got, &items); err != nil || len(items) != 1 || money.IsLegacy(items[0].Price) {
+		t.Fatalf("migratePrices = %s, %v; esperado o preço no formato do Money", got, err)
+	}
+}
+
+// Os registros das outras stores (categorias, livro de estoque, histórico de preços) passam pelas migrations sem mudar
+func TestSchemaMigrationKeepsOtherRecords(t *testing.T) {
+	for _, data := range []string{
+		`[{"id": 1, "product_id": 1, "old_price": null, "new_price": 5.5, "changed_by": "ana", "changed_at": "2023-06-01T10:00:00Z"}]`,
+		`[{"id": 1, "name": "Bebidas", "parent_id": null}]`,
+		`[{"id": 1, "product_id": 1, "type": "in", "quantity": 2, "balance": 2, "reason": "", "created_at": "2023-06-01T10:00:00Z"}]`,
+		`{"key_id": "k1", "nonce": "YWJj", "ciphertext": "ZGVm"}`,
+		`[1, 2, 3]`,
+	} {
+		got, err := migrateCategories(json.RawMessage(data), nil)
+		if err != nil || string(got) != data {
+			t.Errorf("migrateCategories(%s) = %s, %v; esperado os dados como estão", data, got, err)
+		}
+		if got, err := migratePrices(json.RawMessage(data), "BRL"); err != nil || string(got) != data {
+			t.Errorf("migratePrices(%s) = %s, %v; esperado os dados como estão", data, got, err)
+		}
+	}
+
+	// Sem o Service das categorias, o produto antigo não é convertido às cegas
+	if _, err := migrateCategories(json.RawMessage(`[{"id": 1, "category": "Bebidas"}]`), nil); !errors.Is(err, errNoCategories) {
+		t.Fatalf("migrateCategories sem o Service = %v, esperado errNoCategories", err)
+	}
+}
@@ -0,0 +1,143 @@
+package products
+
+import (
+	"log"
+	"math"
+	"sync"
+
+	"github.com/anwardh/meliProject/pkg/search"
+)
+
+// Limites da quantidade de resultados da busca
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// SearchResult é um produto encontrado pela busca; Score é a relevância (quanto maior, mais relevante)
+type SearchResult struct {
+	Product
+	Score float64 `json:"score"`
+}
+
+/*
+productIndex é o índice de busca dos produtos, pelo nome e pelo caminho da categoria (o nome pesa mais):
+um produto de "Bebidas > Refrigerantes" é encontrado tanto por "bebida" quanto por "refrigerante".
+Ele é montado na primeira busca e, a partir daí, acompanhado pelo Service: toda gravação que muda
+o nome ou a categoria (Store, Update, UpdateName) e todo Delete atualizam o índice, e a mudança de
+nome ou de lugar de uma categoria o remonta.
+O mutex segura a gravação e a atualização juntas; sem ele, duas alterações simultâneas do mesmo produto
+poderiam chegar ao índice na ordem inversa da que chegaram à store.
+Alterações feitas por fora do Service (ex.: o arquivo editado à mão) só aparecem na busca depois do Reindex
+*/
+type productIndex struct {
+	mu    sync.Mutex
+	index *search.Index
+	built bool
+	// paths devolve o caminho de cada categoria pelos nomes (veja categories.Service.Paths)
+	paths func() (map[int]string, error)
+}
+
+func newProductIndex(paths func() (map[int]string, error)) *productIndex {
+	return &productIndex{
+		index: search.NewIndex(search.Field{Name: "name", Weight: 2}, search.Field{Name: "category", Weight: 1}),
+		paths: paths,
+	}
+}
+
+// build monta o índice com todos os produtos da repository; chamado com o mutex travado
+func (pi *productIndex) build(r Repository) error {
+	pi.index.Reset()
+	pi.built = false
+	paths, err := pi.paths()
+	if err != nil {
+		return err
+	}
+	err = r.Each(Filter{}, func(p Product) error {
+		pi.index.Put(p.ID, p.Name, paths[p.CategoryID])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	pi.built = true
+	return nil
+}
+
+// put e remove só mexem num índice já montado: antes disso, a primeira busca lê tudo da repository.
+// Se o caminho da categoria não puder ser lido, o índice é descartado e remontado na próxima busca
+func (pi *productIndex) put(p Product) {
+	if !pi.built {
+		return
+	}
+	paths, err := pi.paths()
+	if err != nil {
+		pi.built = false
+		return
+	}
+	pi.index.Put(p.ID, p.Name, paths[p.CategoryID])
+}
+
+func (pi *productIndex) remove(id int) {
+	if pi.built {
+		pi.index.Remove(id)
+	}
+}
+
+// refresh remonta um índice já montado (ex.: depois que uma categoria mudou de nome)
+func (pi *productIndex) refresh(r Repository) {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	if pi.built {
+		if err := pi.build(r); err != nil {
+			log.Printf("evento=indice_busca_erro erro=%q", err)
+		}
+	}
+}
+
+/*
+search busca os produtos pelo índice e os lê da repository numa única passada, mantendo a ordem de relevância.
+O filtro restringe os resultados (ex.: só de uma categoria) antes de aplicar o limite
+*/
+func (pi *productIndex) search(r Repository, q string, f Filter, limit int) ([]SearchResult, error) {
+	pi.mu.Lock()
+	if !pi.built {
+		if err := pi.build(r); err != nil {
+			pi.mu.Unlock()
+			return nil, err
+		}
+	}
+	pi.mu.Unlock()
+
+	hits := pi.index.Search(q)
+	if len(hits) == 0 {
+		return []SearchResult{}, nil
+	}
+	rank := make(map[int]int, len(hits))
+	for i, h := range hits {
+		rank[h.ID] = i
+	}
+
+	found := make([]*SearchResult, len(hits))
+	err := r.Each(f, func(p Product) error {
+		if i, ok := rank[p.ID]; ok {
+			found[i] = &SearchResult{Product: p, Score: math.Round(hits[i].Score*1000) / 1000}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []SearchResult{}
+	for _, res := range found {
+		if res == nil {
+			continue
+		}
+		results = append(results, *res)
+		if len(results) == limit {
+			break
+		}
+	}
+	return results, nil
+}
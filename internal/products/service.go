@@ -1,33 +1,171 @@
 package products
 
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/anwardh/meliProject/internal/categories"
+	"github.com/anwardh/meliProject/internal/prices"
+	"github.com/anwardh/meliProject/internal/stock"
+	"github.com/anwardh/meliProject/pkg/money"
+)
+
+// Motivos dos ajustes no livro de estoque e das alterações no histórico de preços que o Service registra
+const (
+	reasonCreated = "cadastro do produto"
+	reasonUpdated = "atualização do produto"
+	reasonPurged  = "remoção definitiva do produto"
+	reasonUndone  = "gravação do produto desfeita"
+)
+
+// ErrInvalidPrice é devolvido (embrulhado, com o motivo) quando o preço ou os preços em outras moedas são recusados
+var ErrInvalidPrice = errors.New("preço inválido")
+
+// Quote é o preço de um produto numa moeda pedida
+type Quote struct {
+	Price money.Money `json:"price"`
+	// Converted diz se o preço saiu da tabela de câmbio; false é o preço do catálogo ou um preço fixado na moeda
+	Converted bool `json:"converted"`
+}
+
+// InventoryValue é o valor do estoque do catálogo numa moeda: a soma de Count × preço de todos os produtos
+type InventoryValue struct {
+	Total money.Money `json:"total"`
+	// Products é a quantidade de produtos somados; Converted, quantos deles tiveram o preço convertido pelo câmbio
+	Products  int `json:"products"`
+	Converted int `json:"converted"`
+}
+
 // Criação da Interface
 type Service interface {
-	GetAll() ([]Product, error)
-	Store(name, category string, count int, price float64) (Product, error)
+	// GetAll devolve os produtos que atendem ao filtro (Filter{} devolve todos)
+	GetAll(f Filter) ([]Product, error)
+	// Declaração do Método GetByID - um único produto
+	GetByID(id int) (Product, error)
+	// GetBySKU e GetByGTIN buscam o produto pelo código, no formato em que for informado
+	// (o SKU em maiúsculas ou minúsculas, o GTIN em qualquer formato); um código inválido devolve um CodeError com ErrInvalidCode
+	GetBySKU(sku string) (Product, error)
+	GetByGTIN(code string) (Product, error)
+	// Declaração do Método Each - percorre os produtos um por vez (para catálogos grandes)
+	Each(f Filter, fn func(p Product) error) error
+	// Declaração do Método List - uma página da listagem, ordenada
+	List(f Filter, pr PageRequest) (Page, error)
+	// Declaração do Método Search - busca pelo nome e pela categoria, do mais relevante para o menos relevante
+	Search(q string, f Filter, limit int) ([]SearchResult, error)
+	// Declaração do Método Reindex - remonta o índice da busca (ex.: depois de restaurar um backup)
+	Reindex() error
+	// Declaração do Método GetAllAsOf - os produtos como estavam num instante passado
+	GetAllAsOf(t time.Time, f Filter) ([]Product, error)
+	// Store e Update recusam categorias inexistentes (erro com categories.ErrNotFound), preços inválidos
+	// (erro com ErrInvalidPrice, veja checkPrices) e códigos inválidos ou de outro produto (um CodeError);
+	// by é quem fez a alteração, registrado no histórico de preços
+	Store(name, sku, gtin string, categoryID int, count int, price money.Money, prices []money.Money, by string) (Product, error)
 	// Declaração do Método Update
-	Update(id int, name, productType string, count int, price float64) (Product, error)
+	Update(id int, name, sku, gtin string, categoryID int, count int, price money.Money, prices []money.Money, by string) (Product, error)
+	// Declaração do Método Quote - o preço do produto na moeda pedida (veja o método)
+	Quote(p Product, currency string) (Quote, error)
+	// Declaração do Método InventoryValue - o valor do estoque na moeda pedida (veja o método)
+	InventoryValue(currency string) (InventoryValue, error)
+	// Declaração do Método Currency - a moeda do catálogo, em que fica o preço dos produtos
+	Currency() string
 
 	// Declaração do Método UpdateName
 	UpdateName(id int, name string) (Product, error)
 
-	// Declaração do Método Delete
-	Delete(id int) error
+	// Declaração do Método Delete - move o produto para a lixeira; by é quem o removeu
+	Delete(id int, by string) error
+	// Declaração do Método Trash - uma página dos produtos da lixeira
+	Trash(pr PageRequest) (Page, error)
+	// Declaração do Método Restore - tira o produto da lixeira (ErrNotDeleted se ele não estiver lá)
+	Restore(id int) (Product, error)
+	// Declaração do Método Purge - remove de vez os produtos que estão na lixeira há mais que retention
+	Purge(retention time.Duration) (int, error)
+
+	// Declaração do Método TransferStock - uma saída de um produto e uma entrada no outro, no livro de estoque
+	TransferStock(fromID, toID, quantity int) error
+
+	// Declaração do Método MigrateCategories - converte a categoria em texto dos produtos antigos (veja o método)
+	MigrateCategories() (int, error)
+	// Declaração do Método MigratePrices - grava no formato do Money os preços antigos, gravados como número
+	MigratePrices() (int, error)
 }
 
-// Declaração da Estrutura que contém um Repository
+/*
+Declaração da Estrutura que contém um Repository, o Service das categorias, a que os produtos pertencem,
+o livro de estoque, que registra toda mudança do estoque (Count) dos produtos, o histórico de preços,
+a moeda do catálogo, em que ficam os preços dos produtos (e em que são lidos os preços antigos, só números),
+e a tabela de câmbio (nil quando não há uma configurada: só os preços fixados em cada moeda ficam disponíveis)
+*/
 type service struct {
 	repository Repository
+	categories categories.Service
+	stock      stock.Service
+	prices     prices.Service
+	currency   string
+	rates      *money.Rates
+	// index é o índice da busca, atualizado a cada gravação (veja productIndex)
+	index *productIndex
+	// now é o relógio usado para marcar a remoção e calcular o prazo da lixeira
+	now func() time.Time
 }
 
-func NewService(r Repository) Service {
-	return &service{
+func NewService(r Repository, c categories.Service, st stock.Service, pr prices.Service, currency string, rates *money.Rates) Service {
+	s := &service{
 		repository: r,
+		categories: c,
+		stock:      st,
+		prices:     pr,
+		currency:   currency,
+		rates:      rates,
+		index:      newProductIndex(c.Paths),
+		now:        func() time.Time { return time.Now().UTC() },
+	}
+	c.OnChange(func() { s.index.refresh(r) })
+	return s
+}
+
+// withSubcategories completa o filtro com as subcategorias das categorias pedidas (pelo ID, pelo nome ou pelos dois,
+// quando valem as que estão nas duas listas)
+func (s *service) withSubcategories(f Filter) (Filter, error) {
+	var ids []int
+	if f.CategoryID != 0 {
+		subtree, err := s.categories.Subtree(f.CategoryID)
+		if err != nil {
+			return Filter{}, err
+		}
+		ids = subtree
+	}
+	if f.Category != "" {
+		named, err := s.categories.SubtreeNamed(f.Category)
+		if err != nil {
+			return Filter{}, err
+		}
+		if f.CategoryID != 0 {
+			both := []int{}
+			for _, id := range named {
+				if containsID(ids, id) {
+					both = append(both, id)
+				}
+			}
+			named = both
+		}
+		ids = named
 	}
+	f.categoryIDs = ids
+	return f, nil
 }
 
-/* O método GetAll que se encarregará de passar a tarefa para o Repository e retornar um array de Produtos */
-func (s *service) GetAll() ([]Product, error) {
-	ps, err := s.repository.GetAll()
+/* O método GetAll que se encarregará de passar a tarefa (e o filtro) para o Repository e retornar um array de Produtos */
+func (s *service) GetAll(f Filter) ([]Product, error) {
+	f, err := s.withSubcategories(f)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := s.repository.GetAll(f)
 	if err != nil {
 		return nil, err
 	}
@@ -35,46 +173,447 @@ func (s *service) GetAll() ([]Product, error) {
 	return ps, nil
 }
 
+// Criação do Método GetByID
+func (s *service) GetByID(id int) (Product, error) {
+	return s.repository.GetByID(id)
+}
+
+// Criação do Método GetBySKU
+func (s *service) GetBySKU(sku string) (Product, error) {
+	sku, err := NormalizeSKU(sku)
+	if err != nil {
+		return Product{}, err
+	}
+	if sku == "" {
+		return Product{}, &CodeError{Field: FieldSKU, Err: ErrInvalidCode, reason: "informe o SKU"}
+	}
+	return s.repository.GetBySKU(sku)
+}
+
+// Criação do Método GetByGTIN
+func (s *service) GetByGTIN(code string) (Product, error) {
+	code, err := NormalizeGTIN(code)
+	if err != nil {
+		return Product{}, err
+	}
+	if code == "" {
+		return Product{}, &CodeError{Field: FieldGTIN, Err: ErrInvalidCode, reason: "informe o GTIN"}
+	}
+	return s.repository.GetByGTIN(code)
+}
+
+// Criação do Método Each
+func (s *service) Each(f Filter, fn func(p Product) error) error {
+	f, err := s.withSubcategories(f)
+	if err != nil {
+		return err
+	}
+	return s.repository.Each(f, fn)
+}
+
+// Criação do Método List
+func (s *service) List(f Filter, pr PageRequest) (Page, error) {
+	f, err := s.withSubcategories(f)
+	if err != nil {
+		return Page{}, err
+	}
+	return s.repository.List(f, pr)
+}
+
+// Criação do Método Search
+func (s *service) Search(q string, f Filter, limit int) ([]SearchResult, error) {
+	f, err := s.withSubcategories(f)
+	if err != nil {
+		return nil, err
+	}
+	return s.index.search(s.repository, q, f, limit)
+}
+
+// Criação do Método Reindex
+func (s *service) Reindex() error {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+	return s.index.build(s.repository)
+}
+
+// Criação do Método GetAllAsOf
+func (s *service) GetAllAsOf(t time.Time, f Filter) ([]Product, error) {
+	f, err := s.withSubcategories(f)
+	if err != nil {
+		return nil, err
+	}
+	return s.repository.GetAllAsOf(t, f)
+}
+
 /*
-O método Store ficará encarregado de passar a tarefa de obter o último ID e
-salvar o produto no Repository, o serviço se encarregará de incrementar o ID
+O método Store ficará encarregado de passar a tarefa de salvar o produto no Repository.
+O ID é atribuído pelo próprio Repository, na mesma transação da gravação: se o serviço buscasse o LastID
+e depois salvasse, duas requisições simultâneas poderiam receber o mesmo ID.
+A gravação roda dentro do categories.Service.Use: a categoria não pode ser removida no meio dela.
+O estoque inicial é lançado no livro como um ajuste, e o preço inicial abre o histórico de preços.
+O livro e o histórico ficam em outras stores, então tudo o que eles validam é conferido antes de gravar o produto;
+se mesmo assim um deles falhar, o cadastro é desfeito (veja undoStore) e o erro volta para quem chamou
+*/
+
+func (s *service) Store(name, sku, gtin string, categoryID int, count int, price money.Money, prices []money.Money, by string) (Product, error) {
+	prices, err := checkPrices(s.currency, price, prices)
+	if err != nil {
+		return Product{}, err
+	}
+	if err := stock.CheckCount(count); err != nil {
+		return Product{}, err
+	}
+	sku, gtin, err = normalizeCodes(sku, gtin)
+	if err != nil {
+		return Product{}, err
+	}
 
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	var product Product
+	err = s.categories.Use(categoryID, func() (err error) {
+		product, err = s.repository.Store(name, sku, gtin, categoryID, count, price, prices)
+		return err
+	})
+	if err != nil {
+		return Product{}, err
+	}
+	s.index.put(product)
+
+	if err := s.stock.Set(product.ID, count, reasonCreated); err != nil {
+		return Product{}, s.undoStore(product, false, err)
+	}
+	if _, err := s.prices.Record(product.ID, nil, price, by, reasonCreated); err != nil {
+		return Product{}, s.undoStore(product, true, err)
+	}
+	return product, nil
+}
+
+/*
+undoStore desfaz o cadastro que o livro de estoque ou o histórico de preços não acompanharam: o estoque já lançado
+(stocked) volta a zero no livro, com um ajuste, e o produto é apagado. Chamado com o mutex do índice travado.
+Devolve o erro que causou o desfazer; se o desfazer também falhar, o erro dele vai junto
+(o Reconcile e o Sync da subida acertam o que sobrar)
 */
+func (s *service) undoStore(p Product, stocked bool, cause error) error {
+	if stocked {
+		if err := s.stock.Set(p.ID, 0, reasonUndone); err != nil {
+			return s.undoFailed(p.ID, cause, err)
+		}
+	}
+	if err := s.repository.Remove(p.ID); err != nil {
+		return s.undoFailed(p.ID, cause, err)
+	}
+	s.index.remove(p.ID)
+	log.Printf("evento=produto_desfeito id=%d erro=%q", p.ID, cause)
+	return cause
+}
+
+// undoFailed registra o desfazer que não deu certo e devolve os dois erros
+func (s *service) undoFailed(id int, cause, err error) error {
+	log.Printf("evento=produto_nao_desfeito id=%d erro=%q erro_desfazer=%q", id, cause, err)
+	return fmt.Errorf("%w (e a gravação não pôde ser desfeita: %v)", cause, err)
+}
 
-func (s *service) Store(name, category string, count int, price float64) (Product, error) {
-	lastID, err := s.repository.LastID()
+/*
+Criação do Método Update - a diferença de estoque é lançada no livro como um ajuste, e a mudança de preço
+vai para o histórico de preços (só a do preço do catálogo). O preço anterior é lido com o mutex travado:
+nenhuma outra gravação do Service acontece entre a leitura e o Update.
+Como no Store, o estoque é validado antes de gravar o produto; se o livro ou o histórico falharem,
+o produto volta a ser como era (veja undoUpdate)
+*/
+func (s service) Update(id int, name, sku, gtin string, categoryID int, count int, price money.Money, prices []money.Money, by string) (Product, error) {
+	prices, err := checkPrices(s.currency, price, prices)
+	if err != nil {
+		return Product{}, err
+	}
+	if err := stock.CheckCount(count); err != nil {
+		return Product{}, err
+	}
+	sku, gtin, err = normalizeCodes(sku, gtin)
 	if err != nil {
 		return Product{}, err
 	}
 
-	lastID++
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
 
-	product, err := s.repository.Store(lastID, name, category, count, price)
+	old, err := s.repository.GetByID(id)
 	if err != nil {
 		return Product{}, err
 	}
 
+	var product Product
+	err = s.categories.Use(categoryID, func() (err error) {
+		product, err = s.repository.Update(id, name, sku, gtin, categoryID, count, price, prices)
+		return err
+	})
+	if err != nil {
+		return Product{}, err
+	}
+	s.index.put(product)
+
+	if err := s.stock.Set(id, count, reasonUpdated); err != nil {
+		return Product{}, s.undoUpdate(old, false, err)
+	}
+	if old.Price != price {
+		if _, err := s.prices.Record(id, &old.Price, price, by, reasonUpdated); err != nil {
+			return Product{}, s.undoUpdate(old, true, err)
+		}
+	}
 	return product, nil
 }
 
-// Criação do Método Update
-func (s service) Update(id int, name, productType string, count int, price float64) (Product, error) {
-	product, err := s.repository.Update(id, name, productType, count, price)
+// undoUpdate grava de volta o produto como era antes do Update (e o estoque, se ele já foi lançado no livro);
+// segue as regras do undoStore
+func (s *service) undoUpdate(old Product, stocked bool, cause error) error {
+	if stocked {
+		if err := s.stock.Set(old.ID, old.Count, reasonUndone); err != nil {
+			return s.undoFailed(old.ID, cause, err)
+		}
+	}
+	restored, err := s.repository.Update(old.ID, old.Name, old.SKU, old.GTIN, old.CategoryID, old.Count, old.Price, old.Prices)
+	if err != nil {
+		return s.undoFailed(old.ID, cause, err)
+	}
+	s.index.put(restored)
+	log.Printf("evento=produto_desfeito id=%d erro=%q", old.ID, cause)
+	return cause
+}
 
-	return product, err
+/*
+checkPrices valida os preços antes da gravação: o preço do catálogo fica na moeda do catálogo (currency)
+e os demais, um por moeda, em outras moedas; nenhum pode ser negativo.
+Devolve os preços em ordem de moeda (nil quando não há nenhum), a forma em que são gravados
+*/
+func checkPrices(currency string, price money.Money, prices []money.Money) ([]money.Money, error) {
+	if price.Currency != currency {
+		return nil, fmt.Errorf("%w: o preço deve estar na moeda do catálogo (%s), não em %q", ErrInvalidPrice, currency, price.Currency)
+	}
+	if price.Amount < 0 {
+		return nil, fmt.Errorf("%w: o preço não pode ser negativo", ErrInvalidPrice)
+	}
+	if len(prices) == 0 {
+		return nil, nil
+	}
+
+	sorted := make([]money.Money, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Currency < sorted[j].Currency })
+	for i, p := range sorted {
+		switch {
+		case p.Currency == currency:
+			return nil, fmt.Errorf("%w: o preço em %s é o price, não entra em prices", ErrInvalidPrice, p.Currency)
+		case i > 0 && sorted[i-1].Currency == p.Currency:
+			return nil, fmt.Errorf("%w: há mais de um preço em %s", ErrInvalidPrice, p.Currency)
+		case p.Amount < 0:
+			return nil, fmt.Errorf("%w: o preço em %s não pode ser negativo", ErrInvalidPrice, p.Currency)
+		}
+	}
+	return sorted, nil
+}
+
+// normalizeCodes valida o SKU e o GTIN e os devolve no formato em que são gravados (veja NormalizeSKU e NormalizeGTIN)
+func normalizeCodes(sku, gtin string) (string, string, error) {
+	sku, err := NormalizeSKU(sku)
+	if err != nil {
+		return "", "", err
+	}
+	gtin, err = NormalizeGTIN(gtin)
+	if err != nil {
+		return "", "", err
+	}
+	return sku, gtin, nil
+}
+
+/*
+Quote devolve o preço do produto na moeda pedida: o preço do catálogo, se for a moeda dele; o preço fixado
+na moeda, se o produto tiver um; senão, o preço do catálogo convertido pela tabela de câmbio,
+com o arredondamento da tabela (erro com money.ErrNoRate se a tabela não tiver a moeda, ou não houver tabela)
+*/
+func (s *service) Quote(p Product, currency string) (Quote, error) {
+	if _, err := money.Exponent(currency); err != nil {
+		return Quote{}, err
+	}
+	if p.Price.Currency == currency {
+		return Quote{Price: p.Price}, nil
+	}
+	for _, price := range p.Prices {
+		if price.Currency == currency {
+			return Quote{Price: price}, nil
+		}
+	}
+	if s.rates == nil {
+		return Quote{}, fmt.Errorf("%w: o produto %d não tem preço em %s", money.ErrNoRate, p.ID, currency)
+	}
+	price, err := s.rates.Convert(p.Price, currency)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Price: price, Converted: true}, nil
+}
+
+func (s *service) Currency() string {
+	return s.currency
+}
+
+/*
+InventoryValue soma o valor do estoque na moeda pedida. Cada produto entra com o preço do Quote na moeda
+multiplicado pelo estoque; a soma é exata, em unidades mínimas: só a conversão do preço unitário arredonda
+*/
+func (s *service) InventoryValue(currency string) (InventoryValue, error) {
+	if _, err := money.Exponent(currency); err != nil {
+		return InventoryValue{}, err
+	}
+	v := InventoryValue{Total: money.Money{Currency: currency}}
+	err := s.repository.Each(Filter{}, func(p Product) error {
+		q, err := s.Quote(p, currency)
+		if err != nil {
+			return err
+		}
+		value, err := q.Price.Mul(int64(p.Count))
+		if err != nil {
+			return err
+		}
+		if v.Total, err = v.Total.Add(value); err != nil {
+			return err
+		}
+		v.Products++
+		if q.Converted {
+			v.Converted++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return InventoryValue{}, err
+	}
+	return v, nil
 }
 
 // Criação do Método UpdateName
 func (s service) UpdateName(id int, name string) (Product, error) {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
 	product, err := s.repository.UpdateName(id, name)
+	if err == nil {
+		s.index.put(product)
+	}
 
 	return product, err
 
 }
 
-// Criação do Método Delete
-func (s service) Delete(id int) error {
-	err := s.repository.Delete(id)
+/*
+Criação do Método Delete.
+O produto vai para a lixeira com o estoque que tinha: o livro só é zerado quando ele é removido de vez (veja Purge),
+então restaurar o produto não mexe no estoque
+*/
+func (s service) Delete(id int, by string) error {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	_, err := s.repository.Trash(id, s.now(), by)
+	if err == nil {
+		s.index.remove(id)
+	}
 
 	return err
 }
+
+// Criação do Método Trash
+func (s *service) Trash(pr PageRequest) (Page, error) {
+	return s.repository.List(Filter{Deleted: OnlyDeleted}, pr)
+}
+
+// Criação do Método Restore
+func (s service) Restore(id int) (Product, error) {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	product, err := s.repository.Restore(id)
+	if err == nil {
+		s.index.put(product)
+	}
+
+	return product, err
+}
+
+/*
+Purge remove de vez os produtos que foram para a lixeira antes de agora - retention e devolve quantos foram removidos.
+O livro de estoque dos removidos é zerado (veja stock.Service.Sync). Os IDs deles não são reaproveitados:
+o próximo produto recebe o ID seguinte ao último atribuído
+*/
+func (s service) Purge(retention time.Duration) (int, error) {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	purged, err := s.repository.Purge(s.now().Add(-retention))
+	if err != nil || len(purged) == 0 {
+		return 0, err
+	}
+	if _, err := s.stock.Sync(reasonPurged); err != nil {
+		return len(purged), err
+	}
+	return len(purged), nil
+}
+
+// Criação do Método TransferStock
+func (s service) TransferStock(fromID, toID, quantity int) error {
+	return s.stock.Transfer(fromID, toID, quantity)
+}
+
+/*
+MigrateCategories converte os produtos gravados antes das categorias, que guardavam o nome da categoria em texto.
+Cada nome vira (ou reaproveita) uma categoria raiz; nomes que só diferem em acentos, maiúsculas ou plural
+("Bebida", "bebida", "Bebidas") ficam na mesma categoria, que recebe a grafia usada por mais produtos.
+Devolve quantos produtos foram convertidos; rodar de novo não converte nada.
+Nos arquivos com envelope, a conversão já acontece na leitura, pela migration do schema 2 (veja migrateCategories)
+*/
+func (s *service) MigrateCategories() (int, error) {
+	n, err := s.repository.MigrateCategories(func(names map[string]int) (map[string]int, error) {
+		return resolveCategories(s.categories, names)
+	})
+	if err == nil && n > 0 {
+		s.index.refresh(s.repository)
+	}
+	return n, err
+}
+
+// resolveCategories devolve o ID da categoria de cada nome; names é quantos produtos usam cada nome
+func resolveCategories(c categories.Service, names map[string]int) (map[string]int, error) {
+	ordered := make([]string, 0, len(names))
+	for name := range names {
+		ordered = append(ordered, name)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if names[ordered[i]] != names[ordered[j]] {
+			return names[ordered[i]] > names[ordered[j]]
+		}
+		return ordered[i] < ordered[j]
+	})
+
+	ids := make(map[string]int, len(names))
+	for _, name := range ordered {
+		category, err := c.Resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		ids[name] = category.ID
+	}
+	return ids, nil
+}
+
+/*
+MigratePrices grava no formato do Money os preços dos produtos antigos, que eram um float64 sem moeda.
+Eles são lidos na moeda do catálogo (a do NewService) pela sua menor representação decimal
+e arredondados com HalfEven se tiverem mais casas do que ela (veja money.FromFloat).
+Devolve quantos produtos foram convertidos; rodar de novo não converte nada.
+Nos arquivos com envelope, a conversão já acontece na leitura, pela migration do schema 3 (veja migratePrices)
+*/
+func (s *service) MigratePrices() (int, error) {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+	return s.repository.MigratePrices(s.currency)
+}
@@ -0,0 +1,383 @@
+package products_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anwardh/meliProject/internal/categories"
+	"github.com/anwardh/meliProject/internal/prices"
+	"github.com/anwardh/meliProject/internal/products"
+	"github.com/anwardh/meliProject/internal/stock"
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+var errDisk = errors.New("disco cheio")
+
+// failingLedger é o livro de estoque que passa a falhar nas gravações (fail), como numa queda da store do livro
+type failingLedger struct {
+	stock.Service
+	fail bool
+}
+
+func (l *failingLedger) Set(productID, count int, reason string) error {
+	if l.fail {
+		return errDisk
+	}
+	return l.Service.Set(productID, count, reason)
+}
+
+// failingHistory é o histórico de preços que passa a falhar nos registros (fail)
+type failingHistory struct {
+	prices.Service
+	fail bool
+}
+
+func (h *failingHistory) Record(productID int, old *money.Money, price money.Money, by, reason string) (prices.Change, error) {
+	if h.fail {
+		return prices.Change{}, errDisk
+	}
+	return h.Service.Record(productID, old, price, by, reason)
+}
+
+// catalog é o Service dos produtos sobre stores em memória, com o livro de estoque e o histórico que podem falhar
+type catalog struct {
+	products.Service
+	repository products.Repository
+	categories categories.Service
+	ledger     *failingLedger
+	history    *failingHistory
+	movements  stock.Repository
+	categoryID int
+}
+
+func newCatalog(t *testing.T) catalog {
+	t.Helper()
+	r := products.NewRepository(store.NewMemoryStore(nil))
+	c := categories.NewService(categories.NewRepository(store.NewMemoryStore(nil)), r)
+	category, err := c.Store("Padaria", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	movements := stock.NewRepository(store.NewMemoryStore(nil))
+	l := &failingLedger{Service: stock.NewService(movements, r)}
+	h := &failingHistory{Service: prices.NewService(prices.NewRepository(store.NewMemoryStore(nil), "BRL"), r)}
+	return catalog{
+		Service:    products.NewService(r, c, l, h, "BRL", nil),
+		repository: r,
+		categories: c,
+		ledger:     l,
+		history:    h,
+		movements:  movements,
+		categoryID: category.ID,
+	}
+}
+
+func brl(t *testing.T, s string) money.Money {
+	t.Helper()
+	m, err := money.Parse(s, "BRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// Um estoque negativo é recusado antes de qualquer gravação
+func TestServiceRejectsNegativeCountBeforeWriting(t *testing.T) {
+	c := newCatalog(t)
+	p, err := c.Store("Bolo", "", "", c.categoryID, 3, brl(t, "1.00"), nil, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Store("Café", "", "", c.categoryID, -1, brl(t, "1.00"), nil, "ana"); !errors.Is(err, stock.ErrInvalidMovement) {
+		t.Fatalf("Store com estoque negativo = %v, esperado ErrInvalidMovement", err)
+	}
+	if ps, err := c.repository.GetAll(products.Filter{}); err != nil || len(ps) != 1 {
+		t.Fatalf("o produto recusado foi gravado: %+v, %v", ps, err)
+	}
+	if _, err := c.Update(p.ID, "Bolo de fubá", "", "", c.categoryID, -1, brl(t, "1.00"), nil, "ana"); !errors.Is(err, stock.ErrInvalidMovement) {
+		t.Fatalf("Update com estoque negativo = %v, esperado ErrInvalidMovement", err)
+	}
+	if got, err := c.repository.GetByID(p.ID); err != nil || got.Name != "Bolo" || got.Count != 3 {
+		t.Fatalf("o produto mudou com o Update recusado: %+v, %v", got, err)
+	}
+}
+
+// Se o livro de estoque falha, o cadastro é desfeito e o produto não fica gravado sem o lançamento
+func TestServiceStoreUndoneWhenLedgerFails(t *testing.T) {
+	c := newCatalog(t)
+	c.ledger.fail = true
+
+	if _, err := c.Store("Bolo", "", "", c.categoryID, 3, brl(t, "1.00"), nil, "ana"); !errors.Is(err, errDisk) {
+		t.Fatalf("Store com o livro falhando = %v, esperado o erro do livro", err)
+	}
+	if ps, err := c.repository.GetAll(products.Filter{Deleted: products.IncludeDeleted}); err != nil || len(ps) != 0 {
+		t.Fatalf("o cadastro não foi desfeito: %+v, %v", ps, err)
+	}
+	if rs, err := c.Search("bolo", products.Filter{}, 10); err != nil || len(rs) != 0 {
+		t.Fatalf("o produto desfeito continua na busca: %+v, %v", rs, err)
+	}
+}
+
+// Se o livro de estoque falha no Update, o produto volta a ser como era
+func TestServiceUpdateUndoneWhenLedgerFails(t *testing.T) {
+	c := newCatalog(t)
+	p, err := c.Store("Bolo", "", "", c.categoryID, 3, brl(t, "1.00"), nil, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.ledger.fail = true
+	if _, err := c.Update(p.ID, "Bolo de fubá", "", "", p.CategoryID, 5, p.Price, nil, "ana"); !errors.Is(err, errDisk) {
+		t.Fatalf("Update com o livro falhando = %v, esperado o erro do livro", err)
+	}
+	got, err := c.repository.GetByID(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Bolo" || got.Count != 3 {
+		t.Fatalf("o Update não foi desfeito: %+v", got)
+	}
+}
+
+// balance é o saldo do livro de estoque do produto (o do último lançamento)
+func (c catalog) balance(t *testing.T, id int) int {
+	t.Helper()
+	ms, err := c.movements.List(stock.Filter{ProductID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) == 0 {
+		return 0
+	}
+	return ms[len(ms)-1].Balance
+}
+
+// Se o histórico de preços falha, o cadastro é desfeito e o estoque já lançado volta a zero no livro
+func TestServiceStoreUndoneWhenHistoryFails(t *testing.T) {
+	c := newCatalog(t)
+	c.history.fail = true
+
+	if _, err := c.Store("Bolo", "", "", c.categoryID, 3, brl(t, "1.00"), nil, "ana"); !errors.Is(err, errDisk) {
+		t.Fatalf("Store com o histórico falhando = %v, esperado o erro do histórico", err)
+	}
+	if ps, err := c.repository.GetAll(products.Filter{Deleted: products.IncludeDeleted}); err != nil || len(ps) != 0 {
+		t.Fatalf("o cadastro não foi desfeito: %+v, %v", ps, err)
+	}
+	// O ID do produto desfeito é o 1, o primeiro do catálogo
+	if got := c.balance(t, 1); got != 0 {
+		t.Fatalf("saldo do produto desfeito = %d, esperado 0", got)
+	}
+}
+
+// Se o histórico de preços falha no Update, o produto e o saldo do livro voltam a ser como eram
+func TestServiceUpdateUndoneWhenHistoryFails(t *testing.T) {
+	c := newCatalog(t)
+	p, err := c.Store("Bolo", "", "", c.categoryID, 3, brl(t, "1.00"), nil, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.history.fail = true
+	if _, err := c.Update(p.ID, "Bolo", "", "", p.CategoryID, 5, brl(t, "2.00"), nil, "ana"); !errors.Is(err, errDisk) {
+		t.Fatalf("Update com o histórico falhando = %v, esperado o erro do histórico", err)
+	}
+	got, err := c.repository.GetByID(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Count != 3 || got.Price != p.Price {
+		t.Fatalf("o Update não foi desfeito: %+v", got)
+	}
+	if b := c.balance(t, p.ID); b != 3 {
+		t.Fatalf("saldo do livro = %d, esperado 3", b)
+	}
+}
+
+// ?category=<name>: o nome escolhe as categorias com ele, em qualquer nível, e as subcategorias delas
+func TestServiceFiltersByCategoryName(t *testing.T) {
+	c := newCatalog(t)
+	tree := map[string]int{"Padaria": c.categoryID}
+	for _, n := range []struct{ name, parent string }{{"Bebidas", ""}, {"Refrigerantes", "Bebidas"}, {"Mercado", ""}, {"Bebida", "Mercado"}} {
+		var parent *int
+		if n.parent != "" {
+			id := tree[n.parent]
+			parent = &id
+		}
+		category, err := c.categories.Store(n.name, parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree[n.name] = category.ID
+	}
+	stored := map[string]int{}
+	for name, category := range map[string]string{"Pão": "Padaria", "Água": "Bebidas", "Guaraná": "Refrigerantes", "Suco": "Bebida"} {
+		p, err := c.Store(name, "", "", tree[category], 1, brl(t, "1.00"), nil, "ana")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored[name] = p.ID
+	}
+
+	cases := []struct {
+		filter products.Filter
+		want   []string
+	}{
+		{products.Filter{Category: "BEBIDA"}, []string{"Água", "Guaraná", "Suco"}},
+		{products.Filter{Category: "refrigerante"}, []string{"Guaraná"}},
+		{products.Filter{Category: "Bebidas", CategoryID: tree["Mercado"]}, []string{"Suco"}},
+		{products.Filter{Category: "Chás"}, nil},
+	}
+	for _, tc := range cases {
+		ps, err := c.GetAll(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[int]bool{}
+		for _, p := range ps {
+			got[p.ID] = true
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%+v: %d produtos, esperado %v", tc.filter, len(got), tc.want)
+			continue
+		}
+		for _, name := range tc.want {
+			if !got[stored[name]] {
+				t.Errorf("%+v: sem o produto %s", tc.filter, name)
+			}
+		}
+	}
+}
+
+// O índice de busca acompanha as gravações do Service: o nome novo é encontrado, o antigo e o removido não
+func TestServiceSearchFollowsWrites(t *testing.T) {
+	c := newCatalog(t)
+	p, err := c.Store("Bolo de Cenoura", "", "", c.categoryID, 1, brl(t, "1.00"), nil, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := func(q string) []int {
+		t.Helper()
+		results, err := c.Search(q, products.Filter{}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found []int
+		for _, r := range results {
+			found = append(found, r.ID)
+		}
+		return found
+	}
+	// A primeira busca monta o índice; a categoria também é encontrada
+	if got := search("bolos"); len(got) != 1 || got[0] != p.ID {
+		t.Fatalf("Search(bolos) = %v, esperado [%d]", got, p.ID)
+	}
+	if got := search("padaria"); len(got) != 1 {
+		t.Fatalf("Search(padaria) = %v, esperado o produto pela categoria", got)
+	}
+
+	other, err := c.Store("Café", "", "", c.categoryID, 1, brl(t, "1.00"), nil, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := search("CAFE"); len(got) != 1 || got[0] != other.ID {
+		t.Fatalf("depois do Store, Search(CAFE) = %v, esperado [%d]", got, other.ID)
+	}
+
+	if _, err := c.UpdateName(p.ID, "Torta de Maçã"); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("cenoura"); len(got) != 0 {
+		t.Fatalf("depois do UpdateName, o nome antigo ainda é encontrado: %v", got)
+	}
+	if got := search("maca"); len(got) != 1 || got[0] != p.ID {
+		t.Fatalf("depois do UpdateName, Search(maca) = %v, esperado [%d]", got, p.ID)
+	}
+
+	if _, err := c.Update(p.ID, "Torta de Limão", "", "", c.categoryID, 1, brl(t, "1.00"), nil, "ana"); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("limoes"); len(got) != 1 || got[0] != p.ID {
+		t.Fatalf("depois do Update, Search(limoes) = %v, esperado [%d]", got, p.ID)
+	}
+
+	if err := c.Delete(p.ID, "ana"); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("torta"); len(got) != 0 {
+		t.Fatalf("depois do Delete, Search(torta) = %v", got)
+	}
+	if got := search("padaria"); len(got) != 1 || got[0] != other.ID {
+		t.Fatalf("depois do Delete, Search(padaria) = %v, esperado [%d]", got, other.ID)
+	}
+}
+
+// O SKU e o GTIN são únicos no catálogo, no formato em que são gravados, inclusive os dos produtos da lixeira
+func TestServiceRejectsDuplicateCodes(t *testing.T) {
+	c := newCatalog(t)
+	bolo, err := c.Store("Bolo", " bolo-1 ", "012345678905", c.categoryID, 1, brl(t, "1.00"), nil, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bolo.SKU != "BOLO-1" {
+		t.Fatalf("Store gravou o SKU %q, esperado BOLO-1", bolo.SKU)
+	}
+	torta, err := c.Store("Torta", "TORTA-1", "", c.categoryID, 1, brl(t, "1.00"), nil, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duplicates := []struct {
+		sku, gtin, field string
+	}{
+		{"Bolo-1", "", products.FieldSKU},              // o SKU em outra caixa
+		{"", "0012345678905", products.FieldGTIN},      // o EAN-13 do mesmo UPC-A
+		{"", "00012345678905", products.FieldGTIN},     // o GTIN-14 do mesmo UPC-A
+		{"NOVO", " 012345678905 ", products.FieldGTIN}, // um SKU livre não salva o GTIN repetido
+	}
+	for _, d := range duplicates {
+		_, err := c.Store("Pão", d.sku, d.gtin, c.categoryID, 1, brl(t, "1.00"), nil, "ana")
+		var ce *products.CodeError
+		if !errors.As(err, &ce) || !errors.Is(err, products.ErrDuplicateCode) || ce.Field != d.field || ce.ProductID != bolo.ID || ce.InTrash {
+			t.Errorf("Store(%q, %q) = %v, esperado ErrDuplicateCode no %s do produto %d", d.sku, d.gtin, err, d.field, bolo.ID)
+		}
+		_, err = c.Update(torta.ID, "Torta", d.sku, d.gtin, c.categoryID, 1, brl(t, "1.00"), nil, "ana")
+		if !errors.As(err, &ce) || !errors.Is(err, products.ErrDuplicateCode) || ce.Field != d.field {
+			t.Errorf("Update(%q, %q) = %v, esperado ErrDuplicateCode no %s", d.sku, d.gtin, err, d.field)
+		}
+	}
+	if p, err := c.GetByID(torta.ID); err != nil || p.SKU != "TORTA-1" || p.GTIN != "" {
+		t.Fatalf("depois das recusas, GetByID = %+v, %v; esperado a torta como estava", p, err)
+	}
+
+	// Na lixeira, o produto guarda os códigos: a recusa avisa onde ele está
+	if err := c.Delete(bolo.ID, "ana"); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range duplicates[:2] {
+		_, err := c.Store("Pão", d.sku, d.gtin, c.categoryID, 1, brl(t, "1.00"), nil, "ana")
+		var ce *products.CodeError
+		if !errors.As(err, &ce) || !errors.Is(err, products.ErrDuplicateCode) || !ce.InTrash || ce.ProductID != bolo.ID {
+			t.Errorf("Store(%q, %q) com o bolo na lixeira = %v, esperado ErrDuplicateCode com InTrash", d.sku, d.gtin, err)
+		}
+	}
+	if _, err := c.GetBySKU("bolo-1"); !errors.Is(err, products.ErrNotFound) {
+		t.Fatalf("GetBySKU de um produto na lixeira = %v, esperado ErrNotFound", err)
+	}
+	if p, err := c.Restore(bolo.ID); err != nil || p.SKU != "BOLO-1" || p.GTIN != "012345678905" {
+		t.Fatalf("Restore = %+v, %v; esperado o bolo com os códigos", p, err)
+	}
+
+	// Removido de vez, os códigos ficam livres (uma retenção negativa alcança o que acabou de ir para a lixeira)
+	if err := c.Delete(bolo.ID, "ana"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Purge(-time.Hour); err != nil || n != 1 {
+		t.Fatalf("Purge = %d, %v; esperado 1", n, err)
+	}
+	if _, err := c.Store("Bolo", "bolo-1", "0012345678905", c.categoryID, 1, brl(t, "1.00"), nil, "ana"); err != nil {
+		t.Fatalf("Store com os códigos do produto removido de vez: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+-- Livro de estoque, com os mesmos campos de stock.Movement
+-- Sem chave estrangeira para products: o histórico continua depois que o produto é removido
+CREATE TABLE stock_movements (
+    id         INTEGER   PRIMARY KEY AUTOINCREMENT,
+    product_id INTEGER   NOT NULL,
+    type       TEXT      NOT NULL CHECK (type IN ('inbound', 'outbound', 'return', 'adjustment')),
+    quantity   INTEGER   NOT NULL,
+    balance    INTEGER   NOT NULL CHECK (balance >= 0),
+    reason     TEXT      NOT NULL,
+    reference  TEXT      NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Índice para o histórico e o estoque atual de cada produto
+CREATE INDEX idx_stock_movements_product_id ON stock_movements (product_id, id);
@@ -0,0 +1,167 @@
+package stock
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// Tipos de movimentação de estoque
+const (
+	// Inbound é a entrada de mercadoria (ex.: compra do fornecedor)
+	Inbound = "inbound"
+	// Outbound é a saída de mercadoria (ex.: venda); nunca deixa o estoque negativo
+	Outbound = "outbound"
+	// Return é a devolução de mercadoria por um cliente, que volta ao estoque
+	Return = "return"
+	// Adjustment corrige o estoque para mais (quantidade positiva) ou para menos (negativa), ex.: inventário
+	Adjustment = "adjustment"
+)
+
+// Movement é um lançamento do livro de estoque. Balance é o estoque do produto depois do lançamento:
+// o último lançamento de cada produto diz o estoque atual dele
+type Movement struct {
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	Type      string    `json:"type"`
+	Quantity  int       `json:"quantity"`
+	Balance   int       `json:"balance"`
+	Reason    string    `json:"reason"`
+	Reference string    `json:"reference,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delta é quanto o lançamento muda o estoque: as saídas diminuem, os ajustes valem com o sinal
+func (m Movement) Delta() int {
+	if m.Type == Outbound {
+		return -m.Quantity
+	}
+	return m.Quantity
+}
+
+// Filter são os critérios da consulta ao histórico; campos vazios não filtram nada
+type Filter struct {
+	ProductID int
+	Type      string
+	// From e To limitam o horário do lançamento, com os extremos incluídos
+	From time.Time
+	To   time.Time
+}
+
+// Match diz se o lançamento atende a todos os critérios do filtro
+func (f Filter) Match(m Movement) bool {
+	if f.ProductID != 0 && m.ProductID != f.ProductID {
+		return false
+	}
+	if f.Type != "" && m.Type != f.Type {
+		return false
+	}
+	if !f.From.IsZero() && m.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && m.CreatedAt.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// Repository guarda o livro de estoque, que só recebe lançamentos novos (nada é alterado nem removido)
+type Repository interface {
+	// Append atribui os próximos IDs aos lançamentos, na mesma transação em que grava (ou grava todos, ou nenhum)
+	Append(ms ...Movement) ([]Movement, error)
+	// List devolve os lançamentos que atendem ao filtro, do mais antigo para o mais novo
+	List(f Filter) ([]Movement, error)
+	// Balance devolve o estoque do produto segundo o livro; found é falso se o produto ainda não tem lançamentos
+	Balance(productID int) (balance int, found bool, err error)
+	// Balances devolve o estoque de cada produto que tem lançamentos
+	Balances() (map[int]int, error)
+}
+
+// repository guarda o livro num documento da store, como as repositories de produtos e de categorias
+type repository struct {
+	db store.Store
+}
+
+// Função que retorna o repositório do livro de estoque sobre a store informada
+func NewRepository(db store.Store) Repository {
+	return &repository{
+		db: db,
+	}
+}
+
+func (r *repository) Append(ms ...Movement) ([]Movement, error) {
+	appended := make([]Movement, len(ms))
+	err := r.db.Update(func(tx store.Tx) error {
+		ledger := []Movement{}
+		if err := tx.Read(&ledger); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		id := 0
+		if len(ledger) > 0 {
+			id = ledger[len(ledger)-1].ID
+		}
+		for i, m := range ms {
+			id++
+			m.ID = id
+			appended[i] = m
+		}
+		return tx.Write(append(ledger, appended...))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return appended, nil
+}
+
+func (r *repository) List(f Filter) ([]Movement, error) {
+	ms := []Movement{}
+	err := r.each(func(m Movement) {
+		if f.Match(m) {
+			ms = append(ms, m)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+func (r *repository) Balance(productID int) (int, bool, error) {
+	balance, found := 0, false
+	err := r.each(func(m Movement) {
+		if m.ProductID == productID {
+			balance, found = m.Balance, true
+		}
+	})
+	return balance, found, err
+}
+
+func (r *repository) Balances() (map[int]int, error) {
+	balances := map[int]int{}
+	err := r.each(func(m Movement) {
+		balances[m.ProductID] = m.Balance
+	})
+	if err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// each percorre o livro em ordem, um lançamento por vez (veja store.Each); sem arquivo, o livro está vazio
+func (r *repository) each(fn func(m Movement)) error {
+	err := store.Each(r.db, func(item json.RawMessage) error {
+		var m Movement
+		if err := json.Unmarshal(item, &m); err != nil {
+			return err
+		}
+		fn(m)
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package stock
+
+import (
+	"database/sql"
+	"embed"
+	"io/fs"
+	"strings"
+
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// As migrations do livro de estoque ficam junto do pacote, como as dos produtos
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// sqlRepository é a implementação do Repository sobre um banco SQL (SQLite)
+type sqlRepository struct {
+	db *sql.DB
+}
+
+// Função que aplica as migrations pendentes e retorna o repositório SQL
+func NewSQLRepository(db *sql.DB) (Repository, error) {
+	dir, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Migrate(db, dir); err != nil {
+		return nil, err
+	}
+	return &sqlRepository{db: db}, nil
+}
+
+// Os lançamentos são inseridos numa transação do banco: ou todos são gravados, ou nenhum
+func (r *sqlRepository) Append(ms ...Movement) ([]Movement, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	appended := make([]Movement, len(ms))
+	for i, m := range ms {
+		res, err := tx.Exec(`INSERT INTO stock_movements (product_id, type, quantity, balance, reason, reference, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, m.ProductID, m.Type, m.Quantity, m.Balance, m.Reason, m.Reference, m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		m.ID = int(id)
+		appended[i] = m
+	}
+	return appended, tx.Commit()
+}
+
+// O filtro vira a cláusula WHERE, como na repository SQL de produtos.
+// Os horários são comparados em UTC, o fuso em que o Service grava os lançamentos
+func (r *sqlRepository) List(f Filter) ([]Movement, error) {
+	var conds []string
+	var args []interface{}
+	if f.ProductID != 0 {
+		conds = append(conds, "product_id = ?")
+		args = append(args, f.ProductID)
+	}
+	if f.Type != "" {
+		conds = append(conds, "type = ?")
+		args = append(args, f.Type)
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "created_at <= ?")
+		args = append(args, f.To.UTC())
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	rows, err := r.db.Query(`SELECT id, product_id, type, quantity, balance, reason, reference, created_at
+		FROM stock_movements`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ms := []Movement{}
+	for rows.Next() {
+		var m Movement
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.Type, &m.Quantity, &m.Balance, &m.Reason, &m.Reference, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, rows.Err()
+}
+
+func (r *sqlRepository) Balance(productID int) (int, bool, error) {
+	var balance int
+	err := r.db.QueryRow(`SELECT balance FROM stock_movements WHERE product_id = ? ORDER BY id DESC LIMIT 1`, productID).
+		Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return balance, true, nil
+}
+
+func (r *sqlRepository) Balances() (map[int]int, error) {
+	rows, err := r.db.Query(`SELECT product_id, balance FROM stock_movements
+		WHERE id IN (SELECT MAX(id) FROM stock_movements GROUP BY product_id)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := map[int]int{}
+	for rows.Next() {
+		var id, balance int
+		if err := rows.Scan(&id, &balance); err != nil {
+			return nil, err
+		}
+		balances[id] = balance
+	}
+	return balances, rows.Err()
+}
@@ -0,0 +1,350 @@
+package stock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInsufficientStock é devolvido (embrulhado, com o disponível e o solicitado) quando a movimentação deixaria o estoque negativo
+var ErrInsufficientStock = errors.New("estoque insuficiente")
+
+// ErrInvalidMovement é devolvido (embrulhado, com o motivo) quando a movimentação pedida não é válida
+var ErrInvalidMovement = errors.New("movimentação inválida")
+
+// Motivo do lançamento que abre o livro de um produto que já tinha estoque antes dele
+const openingReason = "saldo inicial"
+
+// Products é o que o livro precisa dos produtos: o estoque gravado em cada um, que ele mantém igual ao seu.
+// Stock, SetStock e SetStocks devolvem um erro se o produto não existe; SetStocks grava o estoque de vários
+// produtos numa única transação (ou todos, ou nenhum)
+type Products interface {
+	Stock(productID int) (int, error)
+	SetStock(productID, count int) error
+	SetStocks(counts map[int]int) error
+	Stocks() (map[int]int, error)
+}
+
+// Criação da Interface
+type Service interface {
+	// Record lança uma movimentação no livro e atualiza o estoque do produto
+	Record(productID int, kind string, quantity int, reason, reference string) (Movement, error)
+	// History devolve uma página do histórico, do lançamento mais novo para o mais antigo, e o total encontrado
+	History(f Filter, limit, offset int) ([]Movement, int, error)
+	// Set leva o estoque do produto até count com um ajuste (nada é lançado se ele já está em count)
+	Set(productID, count int, reason string) error
+	// Transfer move estoque entre dois produtos: uma saída de um e uma entrada no outro, lançadas juntas
+	Transfer(fromID, toID, quantity int) error
+	// Reconcile acerta o estoque dos produtos pelo livro (veja o método)
+	Reconcile() (int, error)
+	// Sync acerta o livro pelo estoque dos produtos (veja o método)
+	Sync(reason string) (int, error)
+}
+
+/*
+service mantém o livro e o estoque gravado nos produtos iguais. O livro manda: cada movimentação é lançada
+primeiro e só depois o produto é atualizado; se o servidor cair entre as duas gravações, o Reconcile da subida
+devolve ao produto o estoque do livro.
+O mutex segura a leitura do saldo, a validação e as gravações juntas: sem ele, duas saídas simultâneas
+poderiam ler o mesmo saldo e, juntas, deixar o estoque negativo
+*/
+type service struct {
+	mu         sync.Mutex
+	repository Repository
+	products   Products
+	// now é o relógio dos lançamentos, sempre em UTC: assim o horário gravado é comparável em qualquer store
+	now func() time.Time
+}
+
+func NewService(r Repository, p Products) Service {
+	return &service{
+		repository: r,
+		products:   p,
+		now:        func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// validate confere o tipo e a quantidade: entradas, saídas e devoluções são positivas, e os ajustes levam o sinal
+func validate(kind string, quantity int, reason string) error {
+	switch kind {
+	case Inbound, Outbound, Return:
+		if quantity <= 0 {
+			return fmt.Errorf("%w: a quantidade deve ser maior que zero", ErrInvalidMovement)
+		}
+	case Adjustment:
+		if quantity == 0 {
+			return fmt.Errorf("%w: a quantidade do ajuste não pode ser zero", ErrInvalidMovement)
+		}
+	default:
+		return fmt.Errorf("%w: o tipo deve ser %s, %s, %s ou %s", ErrInvalidMovement, Inbound, Outbound, Return, Adjustment)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("%w: o motivo é obrigatório", ErrInvalidMovement)
+	}
+	return nil
+}
+
+// CheckCount confere o estoque pedido para um produto; quem grava o produto antes do livro (ex.: o cadastro)
+// valida com ele antes de qualquer gravação, com o mesmo erro que o Set devolveria
+func CheckCount(count int) error {
+	if count < 0 {
+		return fmt.Errorf("%w: o estoque não pode ser negativo", ErrInvalidMovement)
+	}
+	return nil
+}
+
+// Criação do Método Record
+func (s *service) Record(productID int, kind string, quantity int, reason, reference string) (Movement, error) {
+	if err := validate(kind, quantity, reason); err != nil {
+		return Movement{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, err := s.balance(productID)
+	if err != nil {
+		return Movement{}, err
+	}
+	return s.append(Movement{ProductID: productID, Type: kind, Quantity: quantity, Reason: reason, Reference: reference}, balance)
+}
+
+// Criação do Método History
+func (s *service) History(f Filter, limit, offset int) ([]Movement, int, error) {
+	ms, err := s.repository.List(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(ms)
+	for i, j := 0, len(ms)-1; i < j; i, j = i+1, j-1 {
+		ms[i], ms[j] = ms[j], ms[i]
+	}
+	if offset > len(ms) {
+		offset = len(ms)
+	}
+	ms = ms[offset:]
+	if limit > 0 && limit < len(ms) {
+		ms = ms[:limit]
+	}
+	return ms, total, nil
+}
+
+// Criação do Método Set
+func (s *service) Set(productID, count int, reason string) error {
+	if err := CheckCount(count); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stock, err := s.products.Stock(productID)
+	if err != nil {
+		return err
+	}
+	// Aqui o saldo do livro não é aberto com o estoque do produto: quem chama Set já pode ter gravado
+	// o estoque novo no produto (ex.: o cadastro), e o ajuste precisa ir do saldo do livro até ele
+	balance, _, err := s.repository.Balance(productID)
+	if err != nil {
+		return err
+	}
+	if count != balance {
+		_, err := s.append(Movement{ProductID: productID, Type: Adjustment, Quantity: count - balance, Reason: reason}, balance)
+		return err
+	}
+	if stock != count {
+		return s.products.SetStock(productID, count)
+	}
+	return nil
+}
+
+/*
+Criação do Método Transfer - os dois saldos são lidos e validados antes de qualquer gravação. A saída e a entrada
+(e o saldo inicial de um produto que ainda não tinha lançamentos) vão para o livro numa única transação, e o estoque
+dos dois produtos numa única transação da store de produtos: nenhuma das duas fica com só metade da transferência.
+Se o servidor cair entre as duas, o Reconcile da subida devolve aos dois produtos o estoque do livro
+*/
+func (s *service) Transfer(fromID, toID, quantity int) error {
+	if quantity <= 0 {
+		return fmt.Errorf("%w: a quantidade a transferir deve ser maior que zero", ErrInvalidMovement)
+	}
+	if fromID == toID {
+		return fmt.Errorf("%w: a origem e o destino da transferência devem ser produtos diferentes", ErrInvalidMovement)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, fromOpening, err := s.ledger(fromID)
+	if err != nil {
+		return err
+	}
+	to, toOpening, err := s.ledger(toID)
+	if err != nil {
+		return err
+	}
+	reference := fmt.Sprintf("transferência %d→%d", fromID, toID)
+	out, err := s.movement(Movement{ProductID: fromID, Type: Outbound, Quantity: quantity, Reason: fmt.Sprintf("transferência para o produto %d", toID), Reference: reference}, from)
+	if err != nil {
+		return err
+	}
+	in, err := s.movement(Movement{ProductID: toID, Type: Inbound, Quantity: quantity, Reason: fmt.Sprintf("transferência do produto %d", fromID), Reference: reference}, to)
+	if err != nil {
+		return err
+	}
+
+	ms := append(append(fromOpening, toOpening...), out, in)
+	if _, err := s.repository.Append(ms...); err != nil {
+		return err
+	}
+	return s.products.SetStocks(map[int]int{fromID: out.Balance, toID: in.Balance})
+}
+
+/*
+Reconcile é chamado na subida, antes de atender requisições. Os produtos sem lançamentos (gravados antes do livro,
+ou por fora do Service) ganham o saldo inicial com o estoque que têm; nos demais, o livro manda, e o estoque
+que divergir dele (ex.: uma queda entre o lançamento e a gravação do produto) volta ao saldo do livro.
+Os saldos iniciais são lançados numa única gravação. Devolve quantos produtos foram acertados
+*/
+func (s *service) Reconcile() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stocks, balances, err := s.snapshot()
+	if err != nil {
+		return 0, err
+	}
+	var openings []Movement
+	n := 0
+	for id, stock := range stocks {
+		balance, found := balances[id]
+		switch {
+		case !found && stock != 0:
+			openings = append(openings, s.adjustment(id, 0, stock, openingReason))
+		case found && stock != balance:
+			if err := s.products.SetStock(id, balance); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	if len(openings) == 0 {
+		return n, nil
+	}
+	sort.Slice(openings, func(i, j int) bool { return openings[i].ProductID < openings[j].ProductID })
+	if _, err := s.repository.Append(openings...); err != nil {
+		return n, err
+	}
+	return n + len(openings), nil
+}
+
+/*
+Sync é o contrário do Reconcile: os produtos mandam, e cada estoque que divergir do livro ganha um ajuste com o motivo
+informado (um produto que deixou de existir é ajustado para zero). É o caso da restauração de um backup, em que
+o catálogo volta a um estado anterior de propósito. Os ajustes são lançados numa única gravação.
+Devolve quantos ajustes foram lançados
+*/
+func (s *service) Sync(reason string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stocks, balances, err := s.snapshot()
+	if err != nil {
+		return 0, err
+	}
+	var adjustments []Movement
+	for id, stock := range stocks {
+		if balance := balances[id]; stock != balance {
+			adjustments = append(adjustments, s.adjustment(id, balance, stock, reason))
+		}
+	}
+	for id, balance := range balances {
+		if _, ok := stocks[id]; !ok && balance != 0 {
+			adjustments = append(adjustments, s.adjustment(id, balance, 0, reason))
+		}
+	}
+	if len(adjustments) == 0 {
+		return 0, nil
+	}
+	sort.Slice(adjustments, func(i, j int) bool { return adjustments[i].ProductID < adjustments[j].ProductID })
+	if _, err := s.repository.Append(adjustments...); err != nil {
+		return 0, err
+	}
+	return len(adjustments), nil
+}
+
+// adjustment monta o ajuste que leva o saldo de from até to, sem gravar nada
+func (s *service) adjustment(productID, from, to int, reason string) Movement {
+	return Movement{ProductID: productID, Type: Adjustment, Quantity: to - from, Balance: to, Reason: reason, CreatedAt: s.now()}
+}
+
+// snapshot lê o estoque de todos os produtos e o saldo de todos os livros; chamado com o mutex travado
+func (s *service) snapshot() (map[int]int, map[int]int, error) {
+	stocks, err := s.products.Stocks()
+	if err != nil {
+		return nil, nil, err
+	}
+	balances, err := s.repository.Balances()
+	if err != nil {
+		return nil, nil, err
+	}
+	return stocks, balances, nil
+}
+
+/*
+balance devolve o saldo do produto segundo o livro; chamado com o mutex travado.
+Um produto sem lançamentos que já tem estoque ganha antes o saldo inicial, para o livro explicar todo o estoque
+*/
+func (s *service) balance(productID int) (int, error) {
+	balance, opening, err := s.ledger(productID)
+	if err != nil || len(opening) == 0 {
+		return balance, err
+	}
+	if _, err := s.repository.Append(opening...); err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+// ledger é o balance sem gravar nada: devolve também o saldo inicial que ainda precisa ser lançado (se houver),
+// para quem lança junto com ele
+func (s *service) ledger(productID int) (int, []Movement, error) {
+	stock, err := s.products.Stock(productID)
+	if err != nil {
+		return 0, nil, err
+	}
+	balance, found, err := s.repository.Balance(productID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if found || stock == 0 {
+		return balance, nil, nil
+	}
+	return stock, []Movement{s.adjustment(productID, 0, stock, openingReason)}, nil
+}
+
+// movement monta a movimentação sobre o saldo informado, sem gravar nada; recusa a que deixaria o estoque negativo
+func (s *service) movement(m Movement, balance int) (Movement, error) {
+	m.Balance = balance + m.Delta()
+	if m.Balance < 0 {
+		return Movement{}, fmt.Errorf("%w no produto %d: disponível %d, solicitado %d", ErrInsufficientStock, m.ProductID, balance, -m.Delta())
+	}
+	m.CreatedAt = s.now()
+	return m, nil
+}
+
+// append lança a movimentação sobre o saldo informado e grava o novo estoque no produto; chamado com o mutex travado
+func (s *service) append(m Movement, balance int) (Movement, error) {
+	m, err := s.movement(m, balance)
+	if err != nil {
+		return Movement{}, err
+	}
+	appended, err := s.repository.Append(m)
+	if err != nil {
+		return Movement{}, err
+	}
+	if err := s.products.SetStock(m.ProductID, m.Balance); err != nil {
+		return Movement{}, err
+	}
+	return appended[0], nil
+}
@@ -0,0 +1,122 @@
+package stock_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anwardh/meliProject/internal/products"
+	"github.com/anwardh/meliProject/internal/stock"
+	"github.com/anwardh/meliProject/pkg/money"
+	"github.com/anwardh/meliProject/pkg/store"
+)
+
+// failingStocks é a repository de produtos com a gravação dos estoques falhando, como numa queda entre as duas stores
+type failingStocks struct {
+	products.Repository
+}
+
+func (failingStocks) SetStocks(map[int]int) error {
+	return errors.New("disco cheio")
+}
+
+// newCatalog grava dois produtos, com 10 e 0 unidades, e devolve a repository e os IDs
+func newCatalog(t *testing.T) (products.Repository, int, int) {
+	t.Helper()
+	r := products.NewRepository(store.NewMemoryStore(nil))
+	price, err := money.Parse("1.00", "BRL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := r.Store("Bolo", "", "", 1, 10, price, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.Store("Café", "", "", 1, 0, price, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, a.ID, b.ID
+}
+
+func stockOf(t *testing.T, r products.Repository, id int) int {
+	t.Helper()
+	n, err := r.Stock(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func ledger(t *testing.T, l stock.Repository) []stock.Movement {
+	t.Helper()
+	ms, err := l.List(stock.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ms
+}
+
+func TestTransferRecordsBothMovementsTogether(t *testing.T) {
+	r, from, to := newCatalog(t)
+	l := stock.NewRepository(store.NewMemoryStore(nil))
+	s := stock.NewService(l, r)
+
+	if err := s.Transfer(from, to, 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := stockOf(t, r, from); got != 6 {
+		t.Fatalf("estoque da origem = %d, esperado 6", got)
+	}
+	if got := stockOf(t, r, to); got != 4 {
+		t.Fatalf("estoque do destino = %d, esperado 4", got)
+	}
+
+	// O saldo inicial da origem (que tinha estoque sem lançamentos), a saída e a entrada, nessa ordem
+	ms := ledger(t, l)
+	if len(ms) != 3 || ms[0].Type != stock.Adjustment || ms[1].Type != stock.Outbound || ms[2].Type != stock.Inbound {
+		t.Fatalf("livro = %+v, esperado o saldo inicial, a saída e a entrada", ms)
+	}
+	if ms[1].Balance != 6 || ms[2].Balance != 4 || ms[1].Reference != ms[2].Reference {
+		t.Fatalf("lançamentos da transferência = %+v", ms[1:])
+	}
+}
+
+// Uma transferência recusada não grava nada, nem o saldo inicial da origem
+func TestTransferRejectedWritesNothing(t *testing.T) {
+	r, from, to := newCatalog(t)
+	l := stock.NewRepository(store.NewMemoryStore(nil))
+	s := stock.NewService(l, r)
+
+	if err := s.Transfer(from, to, 11); !errors.Is(err, stock.ErrInsufficientStock) {
+		t.Fatalf("Transfer além do disponível = %v, esperado ErrInsufficientStock", err)
+	}
+	if err := s.Transfer(from, 99, 1); !errors.Is(err, products.ErrNotFound) {
+		t.Fatalf("Transfer para um produto inexistente = %v, esperado ErrNotFound", err)
+	}
+	if ms := ledger(t, l); len(ms) != 0 {
+		t.Fatalf("o livro recebeu %+v de transferências recusadas", ms)
+	}
+	if stockOf(t, r, from) != 10 || stockOf(t, r, to) != 0 {
+		t.Fatal("o estoque mudou com transferências recusadas")
+	}
+}
+
+// Se o estoque dos produtos não puder ser gravado, o livro já tem a transferência inteira, e o Reconcile acerta os dois
+func TestTransferReconciledAfterProductsFail(t *testing.T) {
+	r, from, to := newCatalog(t)
+	l := stock.NewRepository(store.NewMemoryStore(nil))
+
+	if err := stock.NewService(l, failingStocks{r}).Transfer(from, to, 4); err == nil {
+		t.Fatal("Transfer com a gravação dos produtos falhando não devolveu erro")
+	}
+	if ms := ledger(t, l); len(ms) != 3 {
+		t.Fatalf("livro = %+v, esperado a transferência inteira", ms)
+	}
+
+	if _, err := stock.NewService(l, r).Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if stockOf(t, r, from) != 6 || stockOf(t, r, to) != 4 {
+		t.Fatalf("depois do Reconcile, o estoque é %d e %d, esperado 6 e 4", stockOf(t, r, from), stockOf(t, r, to))
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Package gtin valida os códigos de barras GTIN (EAN-8, UPC-A, EAN-13 e GTIN-14) pelo tamanho e pelo dígito verificador.
+
+Os quatro formatos são o mesmo número com mais ou menos zeros à esquerda: o UPC-A 012345678905,
+o EAN-13 0012345678905 e o GTIN-14 00012345678905 são o mesmo item. Key devolve a forma de 14 dígitos,
+usada para comparar códigos de formatos diferentes.
+*/
+package gtin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalid é devolvido (embrulhado, com o motivo) para códigos que não são um GTIN válido
+var ErrInvalid = errors.New("GTIN inválido")
+
+// Kind é o formato do código, pela quantidade de dígitos
+type Kind string
+
+const (
+	EAN8   Kind = "EAN-8"
+	UPCA   Kind = "UPC-A"
+	EAN13  Kind = "EAN-13"
+	GTIN14 Kind = "GTIN-14"
+)
+
+// kinds são os formatos aceitos, pela quantidade de dígitos
+var kinds = map[int]Kind{8: EAN8, 12: UPCA, 13: EAN13, 14: GTIN14}
+
+/*
+Validate confere o código: só dígitos, com 8, 12, 13 ou 14 deles, e o último igual ao dígito verificador
+calculado sobre os demais. Espaços nas pontas são ignorados. Devolve o formato do código
+*/
+func Validate(code string) (Kind, error) {
+	code = strings.TrimSpace(code)
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("%w: %q deve ter só dígitos", ErrInvalid, code)
+		}
+	}
+	kind, ok := kinds[len(code)]
+	if !ok {
+		return "", fmt.Errorf("%w: %q tem %d dígitos; use 8 (EAN-8), 12 (UPC-A), 13 (EAN-13) ou 14 (GTIN-14)", ErrInvalid, code, len(code))
+	}
+	last := len(code) - 1
+	if want := CheckDigit(code[:last]); code[last] != want {
+		return "", fmt.Errorf("%w: o dígito verificador do %s %s deveria ser %c, não %c", ErrInvalid, kind, code, want, code[last])
+	}
+	return kind, nil
+}
+
+/*
+CheckDigit calcula o dígito verificador dos dígitos informados (o código sem o último dígito): da direita para
+a esquerda, os dígitos são multiplicados alternadamente por 3 e por 1, e o verificador é o que falta
+para a soma chegar ao próximo múltiplo de 10
+*/
+func CheckDigit(digits string) byte {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-1-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Key devolve o código na forma de 14 dígitos (GTIN-14), completando com zeros à esquerda; vazio continua vazio
+func Key(code string) string {
+	code = strings.TrimSpace(code)
+	if code == "" || len(code) >= 14 {
+		return code
+	}
+	return strings.Repeat("0", 14-len(code)) + code
+}
@@ -0,0 +1,78 @@
+package gtin
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []struct {
+		code string
+		kind Kind
+	}{
+		{"96385074", EAN8},
+		{"036000291452", UPCA},
+		{"012345678905", UPCA},
+		{"4006381333931", EAN13},
+		{"0012345678905", EAN13},
+		{"00012345678905", GTIN14},
+		{"10012345678902", GTIN14},
+		{" 4006381333931 ", EAN13}, // espaços nas pontas são ignorados
+	}
+	for _, c := range valid {
+		if kind, err := Validate(c.code); err != nil || kind != c.kind {
+			t.Errorf("Validate(%q) = %s, %v; esperado %s", c.code, kind, err, c.kind)
+		}
+	}
+
+	invalid := []string{
+		"96385075",       // EAN-8 com o verificador errado
+		"036000291453",   // UPC-A com o verificador errado
+		"4006381333932",  // EAN-13 com o verificador errado
+		"10012345678903", // GTIN-14 com o verificador errado
+		"4006381333",     // 10 dígitos
+		"123456789012345",
+		"40063813339-1",
+		"4006 381333931",
+		"",
+	}
+	for _, code := range invalid {
+		if kind, err := Validate(code); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Validate(%q) = %s, %v; esperado ErrInvalid", code, kind, err)
+		}
+	}
+}
+
+func TestCheckDigit(t *testing.T) {
+	cases := map[string]byte{
+		"9638507":       '4',
+		"03600029145":   '2',
+		"400638133393":  '1',
+		"1001234567890": '2',
+		"0000000":       '0', // a soma já é múltiplo de 10
+	}
+	for digits, want := range cases {
+		if got := CheckDigit(digits); got != want {
+			t.Errorf("CheckDigit(%s) = %c, esperado %c", digits, got, want)
+		}
+	}
+}
+
+// Os formatos do mesmo item têm a mesma Key
+func TestKey(t *testing.T) {
+	cases := map[string]string{
+		"012345678905":    "00012345678905",
+		"0012345678905":   "00012345678905",
+		"00012345678905":  "00012345678905",
+		"96385074":        "00000096385074",
+		" 4006381333931 ": "04006381333931",
+		"":                "",
+		"   ":             "",
+		"123456789012345": "123456789012345", // mais de 14 dígitos não é completado nem cortado
+	}
+	for code, want := range cases {
+		if got := Key(code); got != want {
+			t.Errorf("Key(%q) = %q, esperado %q", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+/*
+Package money representa valores monetários sem erro de arredondamento: o valor é guardado em unidades mínimas
+da moeda (centavos, no real) num inteiro, junto do código ISO 4217 da moeda. Somar e multiplicar é exato;
+o único arredondamento acontece na conversão entre moedas, com a regra escolhida (veja Rates).
+
+No JSON, o valor é um texto decimal, para não passar por float64 no caminho:
+
+	{"amount": "12.50", "currency": "BRL"}
+*/
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownCurrency é devolvido (embrulhado, com o código) para moedas fora da tabela de moedas conhecidas
+var ErrUnknownCurrency = errors.New("moeda desconhecida")
+
+// ErrInvalidAmount é devolvido (embrulhado, com o motivo) para valores que não são decimais válidos na moeda
+var ErrInvalidAmount = errors.New("valor inválido")
+
+// ErrCurrencyMismatch é devolvido ao somar ou comparar valores em moedas diferentes
+var ErrCurrencyMismatch = errors.New("os valores estão em moedas diferentes")
+
+// ErrOverflow é devolvido quando o resultado não cabe em unidades mínimas de 64 bits
+var ErrOverflow = errors.New("o valor é grande demais")
+
+// exponents são as moedas conhecidas, com a quantidade de casas decimais de cada uma (ISO 4217)
+var exponents = map[string]int{
+	"ARS": 2, "BRL": 2, "CLP": 0, "COP": 2, "EUR": 2, "MXN": 2, "PEN": 2, "PYG": 0, "USD": 2, "UYU": 2,
+}
+
+// Money é um valor monetário: Amount em unidades mínimas da moeda (ex.: 1250 BRL são R$ 12,50)
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// Exponent devolve a quantidade de casas decimais da moeda
+func Exponent(currency string) (int, error) {
+	exp, ok := exponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q (use %s)", ErrUnknownCurrency, currency, strings.Join(Currencies(), ", "))
+	}
+	return exp, nil
+}
+
+// Currencies devolve os códigos das moedas conhecidas, em ordem alfabética
+func Currencies() []string {
+	codes := make([]string, 0, len(exponents))
+	for code := range exponents {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+/*
+Parse lê um valor decimal (ex.: "12.50", com ponto) na moeda informada. O valor precisa ser exato na moeda:
+"12.505" em reais é recusado, em vez de arredondado sem ninguém ver
+*/
+func Parse(amount, currency string) (Money, error) {
+	exp, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	r, err := parseDecimal(amount)
+	if err != nil {
+		return Money{}, err
+	}
+	minor := new(big.Rat).Mul(r, pow10(exp))
+	if !minor.IsInt() {
+		return Money{}, fmt.Errorf("%w: %s tem mais de %d casas decimais, o máximo de %s", ErrInvalidAmount, amount, exp, currency)
+	}
+	return fromInt(minor.Num(), currency)
+}
+
+/*
+FromRat arredonda um valor exato para as unidades mínimas da moeda com o modo informado;
+é o caminho das conversões de câmbio e dos valores antigos, gravados como float
+*/
+func FromRat(r *big.Rat, currency string, mode RoundingMode) (Money, error) {
+	exp, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	minor, err := round(new(big.Rat).Mul(r, pow10(exp)), mode)
+	if err != nil {
+		return Money{}, err
+	}
+	return fromInt(minor, currency)
+}
+
+/*
+FromFloat converte um preço antigo, gravado como float64, para a moeda informada. O float é lido pela sua
+menor representação decimal (5.1 é "5.1", não 5.0999999...) e arredondado com HalfEven se tiver mais casas
+do que a moeda
+*/
+func FromFloat(f float64, currency string) (Money, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Money{}, fmt.Errorf("%w: %v", ErrInvalidAmount, f)
+	}
+	r, err := parseDecimal(fmt.Sprint(f))
+	if err != nil {
+		return Money{}, err
+	}
+	return FromRat(r, currency, HalfEven)
+}
+
+// Rat devolve o valor exato na unidade da moeda (ex.: 1250 BRL viram 12.5)
+func (m Money) Rat() (*big.Rat, error) {
+	exp, err := Exponent(m.Currency)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).Quo(new(big.Rat).SetInt64(m.Amount), pow10(exp)), nil
+}
+
+// Decimal devolve o valor como texto decimal, com todas as casas da moeda (ex.: "12.50")
+func (m Money) Decimal() string {
+	exp, ok := exponents[m.Currency]
+	if !ok {
+		return fmt.Sprint(m.Amount)
+	}
+	sign, abs := "", m.Amount
+	if abs < 0 {
+		sign = "-"
+	}
+	digits := new(big.Int).Abs(big.NewInt(abs)).String()
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// String devolve o valor com a moeda (ex.: "12.50 BRL")
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// IsZero diz se o valor é zero (em qualquer moeda) ou se o Money está vazio
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add soma dois valores da mesma moeda
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s e %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Mul multiplica o valor por uma quantidade (ex.: o preço pelo estoque)
+func (m Money) Mul(n int64) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(n))
+	return fromInt(product, m.Currency)
+}
+
+// Cmp compara dois valores da mesma moeda: -1 se m < o, 0 se iguais e +1 se m > o
+func (m Money) Cmp(o Money) (int, error) {
+	if m.Currency != o.Currency {
+		return 0, fmt.Errorf("%w: %s e %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// jsonMoney é o formato do Money no JSON
+type jsonMoney struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+// MarshalJSON grava o Money vazio (sem moeda) como null
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m.Currency == "" {
+		return []byte("null"), nil
+	}
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.Decimal(), m.Currency})
+}
+
+/*
+UnmarshalJSON aceita o formato do Money ({"amount": "12.50", "currency": "BRL"}, com o amount em texto ou número).
+O amount precisa ser exato na moeda (veja Parse); null não muda nada. O formato antigo, só o número (ex.: 12.5),
+não diz a moeda e é recusado: quem lê valores antigos sabe em que moeda eles estão (veja FromLegacy)
+*/
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if strings.TrimSpace(string(data)) == "null" {
+		return nil
+	}
+	if IsLegacy(data) {
+		return fmt.Errorf("%w: %s está sem moeda; use {\"amount\": \"12.50\", \"currency\": \"BRL\"}", ErrInvalidAmount, strings.TrimSpace(string(data)))
+	}
+
+	var j jsonMoney
+	if err := json.Unmarshal(data, &j); err != nil {
+		return fmt.Errorf("%w: use {\"amount\": \"12.50\", \"currency\": \"BRL\"}", ErrInvalidAmount)
+	}
+	amount := strings.TrimSpace(string(j.Amount))
+	if s, err := unquote(j.Amount); err == nil {
+		amount = s
+	}
+	if amount == "" || amount == "null" {
+		return fmt.Errorf("%w: o amount é obrigatório", ErrInvalidAmount)
+	}
+	v, err := Parse(amount, strings.ToUpper(strings.TrimSpace(j.Currency)))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// IsLegacy diz se o JSON é um valor no formato antigo, só o número (ex.: 12.5)
+func IsLegacy(data []byte) bool {
+	s := strings.TrimSpace(string(data))
+	return s != "" && (s[0] == '-' || (s[0] >= '0' && s[0] <= '9'))
+}
+
+/*
+FromLegacy lê um valor gravado antes do Money, só o número (ex.: 12.5), na moeda informada, arredondado com
+HalfEven se tiver mais casas do que ela. O formato do Money também é aceito, na moeda que ele mesmo traz
+*/
+func FromLegacy(data []byte, currency string) (Money, error) {
+	if !IsLegacy(data) {
+		var m Money
+		err := json.Unmarshal(data, &m)
+		return m, err
+	}
+	r, err := parseDecimal(string(data))
+	if err != nil {
+		return Money{}, err
+	}
+	return FromRat(r, currency, HalfEven)
+}
+
+func unquote(raw json.RawMessage) (string, error) {
+	var s string
+	err := json.Unmarshal(raw, &s)
+	return strings.TrimSpace(s), err
+}
+
+// parseDecimal lê um número decimal (ex.: "12.50", "-3", "1e2") sem passar por float64
+func parseDecimal(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsAny(s, "/,") {
+		return nil, fmt.Errorf("%w: %q não é um número decimal (use ponto nas casas decimais, ex.: 12.50)", ErrInvalidAmount, s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q não é um número decimal (use ponto nas casas decimais, ex.: 12.50)", ErrInvalidAmount, s)
+	}
+	return r, nil
+}
+
+func fromInt(minor *big.Int, currency string) (Money, error) {
+	if !minor.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: minor.Int64(), Currency: currency}, nil
+}
+
+func pow10(exp int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil))
+}
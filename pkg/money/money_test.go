@@ -0,0 +1,187 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		amount, currency string
+		want             Money
+		err              error
+	}{
+		{"12.50", "BRL", Money{1250, "BRL"}, nil},
+		{"12.5", "BRL", Money{1250, "BRL"}, nil},
+		{" 0.05 ", "BRL", Money{5, "BRL"}, nil},
+		{"-3", "USD", Money{-300, "USD"}, nil},
+		{"1e2", "BRL", Money{10000, "BRL"}, nil},
+		{"1500", "CLP", Money{1500, "CLP"}, nil},
+		{"92233720368547758.07", "BRL", Money{9223372036854775807, "BRL"}, nil},
+		{"12.505", "BRL", Money{}, ErrInvalidAmount}, // mais casas que a moeda: recusado, não arredondado
+		{"1.5", "CLP", Money{}, ErrInvalidAmount},
+		{"12,50", "BRL", Money{}, ErrInvalidAmount},
+		{"1/2", "BRL", Money{}, ErrInvalidAmount},
+		{"", "BRL", Money{}, ErrInvalidAmount},
+		{"abc", "BRL", Money{}, ErrInvalidAmount},
+		{"10", "XYZ", Money{}, ErrUnknownCurrency},
+		{"92233720368547758.08", "BRL", Money{}, ErrOverflow},
+	}
+	for _, c := range cases {
+		got, err := Parse(c.amount, c.currency)
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Errorf("Parse(%q, %s) = %v, %v; esperado %v", c.amount, c.currency, got, err, c.err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("Parse(%q, %s) = %v, %v; esperado %v", c.amount, c.currency, got, err, c.want)
+		}
+	}
+}
+
+func TestFromRatRounding(t *testing.T) {
+	cases := []struct {
+		value, currency string
+		halfEven        int64
+		halfUp          int64
+		down            int64
+	}{
+		{"1.125", "BRL", 112, 113, 112}, // empate: HalfEven vai para o par
+		{"1.135", "BRL", 114, 114, 113},
+		{"1.129", "BRL", 113, 113, 112},
+		{"1.121", "BRL", 112, 112, 112},
+		{"-1.125", "BRL", -112, -113, -112}, // negativos arredondam pelo valor absoluto
+		{"-1.129", "BRL", -113, -113, -112},
+		{"2.5", "CLP", 2, 3, 2},
+		{"3.5", "CLP", 4, 4, 3},
+		{"1.12", "BRL", 112, 112, 112}, // exato: nada a arredondar
+	}
+	for _, c := range cases {
+		r, ok := new(big.Rat).SetString(c.value)
+		if !ok {
+			t.Fatalf("valor %q inválido no teste", c.value)
+		}
+		for mode, want := range map[RoundingMode]int64{HalfEven: c.halfEven, HalfUp: c.halfUp, Down: c.down} {
+			got, err := FromRat(r, c.currency, mode)
+			if err != nil || got != (Money{want, c.currency}) {
+				t.Errorf("FromRat(%s %s, %s) = %v, %v; esperado %d", c.value, c.currency, mode, got, err, want)
+			}
+		}
+	}
+	if _, err := FromRat(big.NewRat(1, 3), "BRL", "para_cima"); err == nil {
+		t.Error("FromRat com uma regra desconhecida não devolveu erro")
+	}
+}
+
+// Os valores antigos, só o número, são lidos na moeda informada; o Money os recusa sem ela
+func TestFromLegacy(t *testing.T) {
+	cases := []struct {
+		raw, currency string
+		want          Money
+	}{
+		{"5.5", "BRL", Money{550, "BRL"}},
+		{"5.5", "USD", Money{550, "USD"}},
+		{"5.125", "BRL", Money{512, "BRL"}}, // arredondado com HalfEven
+		{"5.135", "BRL", Money{514, "BRL"}},
+		{"0.1", "BRL", Money{10, "BRL"}},
+		{"-2", "BRL", Money{-200, "BRL"}},
+		{"7", "CLP", Money{7, "CLP"}},
+		{"1e1", "BRL", Money{1000, "BRL"}},
+		{`{"amount": "1.00", "currency": "USD"}`, "BRL", Money{100, "USD"}}, // o formato novo traz a moeda
+		{"null", "BRL", Money{}},
+	}
+	for _, c := range cases {
+		got, err := FromLegacy([]byte(c.raw), c.currency)
+		if err != nil || got != c.want {
+			t.Errorf("FromLegacy(%s, %s) = %v, %v; esperado %v", c.raw, c.currency, got, err, c.want)
+		}
+	}
+	if _, err := FromLegacy([]byte("5.5"), "XYZ"); !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("FromLegacy numa moeda desconhecida = %v, esperado ErrUnknownCurrency", err)
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte("5.5"), &m); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Unmarshal do número sem moeda = %v, %v; esperado ErrInvalidAmount", m, err)
+	}
+	for raw, want := range map[string]bool{"5.5": true, " -1": true, "0": true, `"5.5"`: false, `{"amount": "5.50"}`: false, "null": false, "": false} {
+		if got := IsLegacy([]byte(raw)); got != want {
+			t.Errorf("IsLegacy(%q) = %v, esperado %v", raw, got, want)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	cases := []struct {
+		m    Money
+		json string
+	}{
+		{Money{1250, "BRL"}, `{"amount":"12.50","currency":"BRL"}`},
+		{Money{5, "BRL"}, `{"amount":"0.05","currency":"BRL"}`},
+		{Money{-5, "USD"}, `{"amount":"-0.05","currency":"USD"}`},
+		{Money{0, "EUR"}, `{"amount":"0.00","currency":"EUR"}`},
+		{Money{1500, "CLP"}, `{"amount":"1500","currency":"CLP"}`},
+		{Money{9223372036854775807, "BRL"}, `{"amount":"92233720368547758.07","currency":"BRL"}`},
+		{Money{}, `null`},
+	}
+	for _, c := range cases {
+		raw, err := json.Marshal(c.m)
+		if err != nil || string(raw) != c.json {
+			t.Errorf("Marshal(%v) = %s, %v; esperado %s", c.m, raw, err, c.json)
+			continue
+		}
+		var back Money
+		if err := json.Unmarshal(raw, &back); err != nil || back != c.m {
+			t.Errorf("Unmarshal(%s) = %v, %v; esperado %v", raw, back, err, c.m)
+		}
+	}
+
+	// O amount também pode vir como número, e a moeda em minúsculas; null não muda o valor
+	accepted := map[string]Money{
+		`{"amount": 12.5, "currency": "brl"}`:       {1250, "BRL"},
+		`{"amount": " 3.10 ", "currency": " usd "}`: {310, "USD"},
+	}
+	for raw, want := range accepted {
+		var m Money
+		if err := json.Unmarshal([]byte(raw), &m); err != nil || m != want {
+			t.Errorf("Unmarshal(%s) = %v, %v; esperado %v", raw, m, err, want)
+		}
+	}
+	kept := Money{100, "BRL"}
+	if err := json.Unmarshal([]byte("null"), &kept); err != nil || kept != (Money{100, "BRL"}) {
+		t.Errorf("Unmarshal(null) = %v, %v; esperado o valor como estava", kept, err)
+	}
+
+	for _, raw := range []string{`{"currency": "BRL"}`, `{"amount": null, "currency": "BRL"}`, `{"amount": "1.005", "currency": "BRL"}`, `{"amount": "1", "currency": "XYZ"}`, `"12.50"`} {
+		var m Money
+		if err := json.Unmarshal([]byte(raw), &m); err == nil {
+			t.Errorf("Unmarshal(%s) = %v, esperado erro", raw, m)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a, b := Money{1250, "BRL"}, Money{-50, "BRL"}
+	if sum, err := a.Add(b); err != nil || sum != (Money{1200, "BRL"}) {
+		t.Errorf("Add = %v, %v", sum, err)
+	}
+	if _, err := a.Add(Money{1, "USD"}); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add em moedas diferentes = %v, esperado ErrCurrencyMismatch", err)
+	}
+	if _, err := (Money{9223372036854775807, "BRL"}).Add(Money{1, "BRL"}); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Add além do int64 = %v, esperado ErrOverflow", err)
+	}
+	if p, err := a.Mul(3); err != nil || p != (Money{3750, "BRL"}) {
+		t.Errorf("Mul = %v, %v", p, err)
+	}
+	if _, err := (Money{9223372036854775807, "BRL"}).Mul(2); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Mul além do int64 = %v, esperado ErrOverflow", err)
+	}
+	if c, err := a.Cmp(b); err != nil || c != 1 {
+		t.Errorf("Cmp = %d, %v; esperado 1", c, err)
+	}
+}
@@ -0,0 +1,169 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrNoRate é devolvido (embrulhado, com as moedas) quando a tabela de câmbio não tem a taxa da conversão pedida
+var ErrNoRate = errors.New("não há taxa de câmbio configurada")
+
+// RoundingMode é a regra de arredondamento para as unidades mínimas da moeda
+type RoundingMode string
+
+const (
+	// HalfEven arredonda para o vizinho mais próximo e, no empate, para o par (1.125 → 1.12, 1.135 → 1.14).
+	// É a regra da ABNT NBR 5891 e não puxa as somas para cima nem para baixo
+	HalfEven RoundingMode = "half_even"
+	// HalfUp arredonda para o vizinho mais próximo e, no empate, para longe do zero (1.125 → 1.13)
+	HalfUp RoundingMode = "half_up"
+	// Down descarta as casas que sobram, em direção ao zero (1.129 → 1.12)
+	Down RoundingMode = "down"
+)
+
+// ParseRoundingMode valida o nome da regra; vazio é HalfEven
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	switch mode := RoundingMode(strings.TrimSpace(s)); mode {
+	case "":
+		return HalfEven, nil
+	case HalfEven, HalfUp, Down:
+		return mode, nil
+	}
+	return "", fmt.Errorf("regra de arredondamento %q desconhecida (use %s, %s ou %s)", s, HalfEven, HalfUp, Down)
+}
+
+/*
+Rates é a tabela de câmbio, configurada localmente: quanto vale uma unidade da moeda base em cada moeda.
+A conversão de A para B passa pela base (valor / taxa de A * taxa de B) com frações exatas
+e é arredondada uma única vez, no fim, pela regra da tabela
+*/
+type Rates struct {
+	Base     string
+	Rounding RoundingMode
+	rates    map[string]*big.Rat
+}
+
+/*
+ratesFile é o arquivo da tabela de câmbio. As taxas vão em texto, para não perder casas no float:
+
+	{
+	  "base": "BRL",
+	  "rounding": "half_even",
+	  "rates": { "USD": "0.1852", "ARS": "172.40" }
+	}
+*/
+type ratesFile struct {
+	Base     string            `json:"base"`
+	Rounding string            `json:"rounding"`
+	Rates    map[string]string `json:"rates"`
+}
+
+// LoadRates lê a tabela de câmbio do arquivo (veja ratesFile)
+func LoadRates(path string) (*Rates, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f ratesFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("tabela de câmbio %s inválida: %w", path, err)
+	}
+	return NewRates(f.Base, f.Rounding, f.Rates)
+}
+
+// NewRates monta a tabela de câmbio a partir das taxas em texto decimal; a taxa da base é sempre 1
+func NewRates(base, rounding string, rates map[string]string) (*Rates, error) {
+	base = strings.ToUpper(strings.TrimSpace(base))
+	if _, err := Exponent(base); err != nil {
+		return nil, fmt.Errorf("moeda base da tabela de câmbio: %w", err)
+	}
+	mode, err := ParseRoundingMode(rounding)
+	if err != nil {
+		return nil, err
+	}
+	t := &Rates{Base: base, Rounding: mode, rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+	for code, v := range rates {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if _, err := Exponent(code); err != nil {
+			return nil, fmt.Errorf("tabela de câmbio: %w", err)
+		}
+		r, err := parseDecimal(v)
+		if err != nil || r.Sign() <= 0 {
+			return nil, fmt.Errorf("tabela de câmbio: a taxa de %s deve ser um decimal positivo, não %q", code, v)
+		}
+		if code == base && r.Cmp(big.NewRat(1, 1)) != 0 {
+			return nil, fmt.Errorf("tabela de câmbio: a taxa da moeda base (%s) deve ser 1", base)
+		}
+		t.rates[code] = r
+	}
+	return t, nil
+}
+
+// Currencies devolve as moedas da tabela, em ordem alfabética
+func (t *Rates) Currencies() []string {
+	codes := make([]string, 0, len(t.rates))
+	for code := range t.rates {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Rate devolve quanto uma unidade de from vale em to
+func (t *Rates) Rate(from, to string) (*big.Rat, error) {
+	rf, okFrom := t.rates[from]
+	rt, okTo := t.rates[to]
+	if !okFrom || !okTo {
+		return nil, fmt.Errorf("%w de %s para %s (moedas na tabela: %s)", ErrNoRate, from, to, strings.Join(t.Currencies(), ", "))
+	}
+	return new(big.Rat).Quo(rt, rf), nil
+}
+
+// Convert converte o valor para a moeda to, arredondando pela regra da tabela
+func (t *Rates) Convert(m Money, to string) (Money, error) {
+	if m.Currency == to {
+		return m, nil
+	}
+	rate, err := t.Rate(m.Currency, to)
+	if err != nil {
+		return Money{}, err
+	}
+	v, err := m.Rat()
+	if err != nil {
+		return Money{}, err
+	}
+	return FromRat(v.Mul(v, rate), to, t.Rounding)
+}
+
+// round arredonda a fração para um inteiro pela regra informada
+func round(r *big.Rat, mode RoundingMode) (*big.Int, error) {
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Sign() == 0 {
+		return q, nil
+	}
+	// away é o passo para longe do zero; twice compara o resto com a metade do divisor
+	away := big.NewInt(int64(r.Sign()))
+	twice := new(big.Int).Abs(rem)
+	twice.Lsh(twice, 1)
+	half := twice.Cmp(r.Denom())
+
+	switch mode {
+	case Down:
+	case HalfUp:
+		if half >= 0 {
+			q.Add(q, away)
+		}
+	case HalfEven:
+		if half > 0 || (half == 0 && q.Bit(0) == 1) {
+			q.Add(q, away)
+		}
+	default:
+		return nil, fmt.Errorf("regra de arredondamento %q desconhecida", mode)
+	}
+	return q, nil
+}
@@ -0,0 +1,83 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func mustRates(t *testing.T, rounding string, rates map[string]string) *Rates {
+	t.Helper()
+	r, err := NewRates("BRL", rounding, rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// A conversão passa pela base com frações exatas e arredonda uma única vez, pela regra da tabela
+func TestRatesConvert(t *testing.T) {
+	rates := map[string]string{"USD": "0.125", "ARS": "170", "CLP": "160"}
+	cases := []struct {
+		rounding string
+		from     Money
+		to       string
+		want     Money
+	}{
+		{"", Money{1000, "BRL"}, "USD", Money{125, "USD"}},
+		{"", Money{125, "USD"}, "BRL", Money{1000, "BRL"}},
+		{"", Money{100, "USD"}, "ARS", Money{136000, "ARS"}}, // de USD para ARS pela base: 1 / 0.125 * 170
+		{"", Money{100, "BRL"}, "CLP", Money{160, "CLP"}},
+		{"", Money{1250, "BRL"}, "BRL", Money{1250, "BRL"}}, // a mesma moeda não converte
+		// 0.04 BRL são 0.005 USD: o empate
+		{"half_even", Money{4, "BRL"}, "USD", Money{0, "USD"}},
+		{"half_up", Money{4, "BRL"}, "USD", Money{1, "USD"}},
+		{"down", Money{4, "BRL"}, "USD", Money{0, "USD"}},
+		// 0.12 BRL são 0.015 USD
+		{"half_even", Money{12, "BRL"}, "USD", Money{2, "USD"}},
+		{"half_up", Money{12, "BRL"}, "USD", Money{2, "USD"}},
+		{"down", Money{12, "BRL"}, "USD", Money{1, "USD"}},
+		// 0.20 BRL são 0.025 USD
+		{"half_even", Money{20, "BRL"}, "USD", Money{2, "USD"}},
+		{"half_up", Money{20, "BRL"}, "USD", Money{3, "USD"}},
+		{"half_up", Money{-20, "BRL"}, "USD", Money{-3, "USD"}},
+		{"down", Money{-20, "BRL"}, "USD", Money{-2, "USD"}},
+	}
+	for _, c := range cases {
+		got, err := mustRates(t, c.rounding, rates).Convert(c.from, c.to)
+		if err != nil || got != c.want {
+			t.Errorf("Convert(%v, %s) com %q = %v, %v; esperado %v", c.from, c.to, c.rounding, got, err, c.want)
+		}
+	}
+
+	if _, err := mustRates(t, "", rates).Convert(Money{100, "BRL"}, "EUR"); !errors.Is(err, ErrNoRate) {
+		t.Errorf("Convert para uma moeda fora da tabela = %v, esperado ErrNoRate", err)
+	}
+}
+
+func TestNewRatesRejectsInvalidTables(t *testing.T) {
+	cases := map[string]struct {
+		base, rounding string
+		rates          map[string]string
+	}{
+		"base desconhecida":           {"XYZ", "", nil},
+		"regra desconhecida":          {"BRL", "para_cima", nil},
+		"moeda desconhecida":          {"BRL", "", map[string]string{"XYZ": "1"}},
+		"taxa zero":                   {"BRL", "", map[string]string{"USD": "0"}},
+		"taxa negativa":               {"BRL", "", map[string]string{"USD": "-0.2"}},
+		"taxa que não é decimal":      {"BRL", "", map[string]string{"USD": "0,2"}},
+		"taxa da base diferente de 1": {"BRL", "", map[string]string{"BRL": "2"}},
+	}
+	for name, c := range cases {
+		if _, err := NewRates(c.base, c.rounding, c.rates); err == nil {
+			t.Errorf("%s: NewRates não devolveu erro", name)
+		}
+	}
+
+	r, err := NewRates(" brl ", "", map[string]string{"usd": "0.2", "BRL": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Base != "BRL" || r.Rounding != HalfEven || len(r.Currencies()) != 2 {
+		t.Fatalf("NewRates = base %s, regra %s, moedas %v", r.Base, r.Rounding, r.Currencies())
+	}
+}
@@ -0,0 +1,119 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// stopWords são as palavras do português que aparecem em quase todo nome e não ajudam a encontrar nada
+// (já sem acentos, como saem do Fold)
+var stopWords = map[string]bool{
+	"a": true, "o": true, "as": true, "os": true, "e": true, "ou": true,
+	"de": true, "da": true, "do": true, "das": true, "dos": true,
+	"em": true, "na": true, "no": true, "nas": true, "nos": true,
+	"um": true, "uma": true, "uns": true, "umas": true,
+	"ao": true, "aos": true, "com": true, "sem": true, "para": true, "pra": true,
+	"por": true, "pelo": true, "pela": true, "pelos": true, "pelas": true, "que": true, "se": true,
+}
+
+// Fold normaliza o texto (NFKD), remove os acentos e passa para minúsculas: "Café" e "CAFE" viram "cafe".
+// Diferente da Key, mantém todas as palavras como estão, então serve para procurar um trecho do texto
+func Fold(s string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), runes.Map(unicode.ToLower), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		return strings.ToLower(s)
+	}
+	return folded
+}
+
+// Token é uma palavra do texto já analisada: Term é o radical usado no índice, Word é a palavra sem acentos
+type Token struct {
+	Term string
+	Word string
+}
+
+// Analyze quebra o texto em palavras, remove acentos e stop words e reduz cada palavra ao radical.
+// O mesmo processo vale para os textos indexados e para as buscas, então "Bolos" encontra "bolo"
+func Analyze(text string) []Token {
+	words := strings.FieldsFunc(Fold(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]Token, 0, len(words))
+	for _, w := range words {
+		if stopWords[w] {
+			continue
+		}
+		tokens = append(tokens, Token{Term: stem(w), Word: w})
+	}
+	return tokens
+}
+
+// Key devolve a forma canônica do texto: os radicais, na ordem, separados por espaço.
+// Textos com a mesma Key são o mesmo para a busca ("Bebida", "bebidas" e "BEBÍDAS" viram "bebid");
+// um texto só com stop words fica apenas sem acentos e em minúsculas
+func Key(text string) string {
+	tokens := Analyze(text)
+	if len(tokens) == 0 {
+		return strings.TrimSpace(Fold(text))
+	}
+	terms := make([]string, len(tokens))
+	for i, t := range tokens {
+		terms[i] = t.Term
+	}
+	return strings.Join(terms, " ")
+}
+
+// pluralSuffixes são as terminações de plural e o que colocar no lugar, na ordem em que são testadas
+var pluralSuffixes = []struct{ suffix, replace string }{
+	{"oes", "ao"}, // limões -> limão
+	{"aes", "ao"}, // pães -> pão
+	{"ais", "al"}, // animais -> animal
+	{"eis", "el"}, // papéis -> papel
+	{"ois", "ol"}, // lençóis -> lençol
+	{"ns", "m"},   // bombons -> bombom
+	{"res", "r"},  // flores -> flor
+	{"zes", "z"},  // luzes -> luz
+	{"ses", "s"},  // meses -> mês
+	{"s", ""},     // bolos -> bolo
+}
+
+// diminutiveSuffixes são os diminutivos, que voltam à palavra original: cafezinho -> cafe, bolinho -> bol(o)
+var diminutiveSuffixes = []string{"zinhos", "zinhas", "zinho", "zinha", "inhos", "inhas", "inho", "inha"}
+
+// minStem é o menor radical aceito: palavras curtas demais perderiam o sentido
+const minStem = 3
+
+/*
+stem é um stemmer leve do português: tira o plural, o diminutivo e a vogal final (gênero), nessa ordem.
+Não busca a raiz gramatical exata, só faz variações comuns de uma palavra caírem no mesmo radical:
+"cenoura", "cenouras" e "cenourinha" viram "cenour". Espera a palavra já sem acentos e em minúsculas
+*/
+func stem(w string) string {
+	if len(w) <= minStem {
+		return w
+	}
+	for _, p := range pluralSuffixes {
+		if strings.HasSuffix(w, p.suffix) && len(w)-len(p.suffix)+len(p.replace) >= minStem {
+			if p.suffix == "s" && strings.HasSuffix(w, "ss") {
+				break
+			}
+			w = w[:len(w)-len(p.suffix)] + p.replace
+			break
+		}
+	}
+	for _, suffix := range diminutiveSuffixes {
+		if strings.HasSuffix(w, suffix) && len(w)-len(suffix) >= minStem {
+			w = w[:len(w)-len(suffix)]
+			break
+		}
+	}
+	if n := len(w); n > minStem && strings.ContainsRune("aoe", rune(w[n-1])) {
+		w = w[:n-1]
+	}
+	return w
+}
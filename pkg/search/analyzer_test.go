@@ -0,0 +1,87 @@
+package search
+
+import "testing"
+
+func TestFold(t *testing.T) {
+	cases := map[string]string{
+		"Café":               "cafe",
+		"CAFÉ":               "cafe",
+		"Pão de Açúcar":      "pao de acucar",
+		"água-de-coco":       "agua-de-coco",
+		"ﬁlé":                "file", // ligadura (NFKD)
+		"já está sem acento": "ja esta sem acento",
+	}
+	for in, want := range cases {
+		if got := Fold(in); got != want {
+			t.Errorf("Fold(%q) = %q, esperado %q", in, got, want)
+		}
+	}
+}
+
+func TestStem(t *testing.T) {
+	cases := map[string]string{
+		"bolo":       "bol",
+		"bolos":      "bol",
+		"bolinho":    "bol",
+		"cenoura":    "cenour",
+		"cenouras":   "cenour",
+		"cenourinha": "cenour",
+		"limoes":     "lima", // limões -> limao, sem a vogal final
+		"paes":       "pao",  // o radical não fica menor que minStem
+		"animais":    "animal",
+		"papeis":     "papel",
+		"bombons":    "bombom",
+		"flores":     "flor",
+		"luzes":      "luz",
+		"cafezinho":  "caf",
+		"cafe":       "caf",
+		"gas":        "gas", // curta demais para mexer
+		"chocolate":  "chocolat",
+		"expresso":   "express", // o "ss" não é plural
+	}
+	for in, want := range cases {
+		if got := stem(in); got != want {
+			t.Errorf("stem(%q) = %q, esperado %q", in, got, want)
+		}
+	}
+}
+
+// Acentos, maiúsculas, plural e diminutivo caem na mesma Key; as stop words saem
+func TestKey(t *testing.T) {
+	same := [][]string{
+		{"Bebida", "bebidas", "BEBÍDAS"},
+		{"Bolo de Cenoura", "bolos cenouras", "BOLINHO DA CENOURINHA"},
+		{"Pão", "pães", "PAO"},
+	}
+	for _, group := range same {
+		want := Key(group[0])
+		for _, text := range group[1:] {
+			if got := Key(text); got != want {
+				t.Errorf("Key(%q) = %q, esperado %q (a Key de %q)", text, got, want, group[0])
+			}
+		}
+	}
+	if got := Key("De Da Do"); got != "de da do" {
+		t.Errorf("Key só com stop words = %q, esperado o texto sem acentos e em minúsculas", got)
+	}
+	if Key("Bolo") == Key("Bala") {
+		t.Error("Bolo e Bala têm a mesma Key")
+	}
+}
+
+func TestAnalyzeDropsStopWordsAndPunctuation(t *testing.T) {
+	tokens := Analyze("Café com Leite, 500ml (para viagem)")
+	var words []string
+	for _, tok := range tokens {
+		words = append(words, tok.Word)
+	}
+	want := []string{"cafe", "leite", "500ml", "viagem"}
+	if len(words) != len(want) {
+		t.Fatalf("Analyze = %v, esperado %v", words, want)
+	}
+	for i := range want {
+		if words[i] != want[i] {
+			t.Fatalf("Analyze = %v, esperado %v", words, want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Parâmetros do BM25: k1 limita o peso de um termo repetido; b desconta os campos longos
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// prefixWeight é o peso de um termo encontrado só pelo prefixo ("cen" em "cenoura"), em relação ao termo exato
+const prefixWeight = 0.5
+
+// Field é um campo indexado; Weight multiplica a relevância dos termos encontrados nele
+type Field struct {
+	Name   string
+	Weight float64
+}
+
+// Hit é um documento encontrado, com a sua relevância (quanto maior, mais relevante)
+type Hit struct {
+	ID    int
+	Score float64
+}
+
+// document guarda, de cada campo, quantas vezes cada termo aparece e o tamanho do campo (em termos)
+type document struct {
+	freqs   []map[string]int
+	lengths []int
+}
+
+/*
+Index é um índice invertido em memória: para cada termo (radical, veja Analyze), os documentos em que ele aparece.
+Cada documento é identificado por um ID e tem um texto por campo (na ordem dos campos do NewIndex).
+Os termos ficam também numa lista ordenada, para achar rapidamente os que começam com um prefixo.
+Pode ser usado por várias goroutines ao mesmo tempo
+*/
+type Index struct {
+	mu       sync.RWMutex
+	fields   []Field
+	docs     map[int]*document
+	postings map[string]map[int]bool
+	terms    []string
+	// totalLengths é a soma dos tamanhos de cada campo em todos os documentos (para o tamanho médio do BM25)
+	totalLengths []int
+}
+
+// NewIndex cria um índice vazio com os campos informados
+func NewIndex(fields ...Field) *Index {
+	return &Index{
+		fields:       fields,
+		docs:         map[int]*document{},
+		postings:     map[string]map[int]bool{},
+		totalLengths: make([]int, len(fields)),
+	}
+}
+
+// Len devolve a quantidade de documentos indexados
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Put indexa o documento, substituindo a versão anterior se o ID já estiver no índice
+func (ix *Index) Put(id int, values ...string) {
+	doc := &document{freqs: make([]map[string]int, len(ix.fields)), lengths: make([]int, len(ix.fields))}
+	for i := range ix.fields {
+		doc.freqs[i] = map[string]int{}
+		if i >= len(values) {
+			continue
+		}
+		for _, t := range Analyze(values[i]) {
+			doc.freqs[i][t.Term]++
+			doc.lengths[i]++
+		}
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+	ix.docs[id] = doc
+	for i, freqs := range doc.freqs {
+		ix.totalLengths[i] += doc.lengths[i]
+		for term := range freqs {
+			if ix.postings[term] == nil {
+				ix.postings[term] = map[int]bool{}
+				ix.insertTerm(term)
+			}
+			ix.postings[term][id] = true
+		}
+	}
+}
+
+// Remove tira o documento do índice; não faz nada se o ID não estiver indexado
+func (ix *Index) Remove(id int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+// Reset esvazia o índice
+func (ix *Index) Reset() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.docs = map[int]*document{}
+	ix.postings = map[string]map[int]bool{}
+	ix.terms = nil
+	ix.totalLengths = make([]int, len(ix.fields))
+}
+
+func (ix *Index) remove(id int) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	delete(ix.docs, id)
+	for i, freqs := range doc.freqs {
+		ix.totalLengths[i] -= doc.lengths[i]
+		for term := range freqs {
+			delete(ix.postings[term], id)
+			if len(ix.postings[term]) == 0 {
+				delete(ix.postings, term)
+				ix.deleteTerm(term)
+			}
+		}
+	}
+}
+
+func (ix *Index) insertTerm(term string) {
+	i := sort.SearchStrings(ix.terms, term)
+	ix.terms = append(ix.terms, "")
+	copy(ix.terms[i+1:], ix.terms[i:])
+	ix.terms[i] = term
+}
+
+func (ix *Index) deleteTerm(term string) {
+	i := sort.SearchStrings(ix.terms, term)
+	if i < len(ix.terms) && ix.terms[i] == term {
+		ix.terms = append(ix.terms[:i], ix.terms[i+1:]...)
+	}
+}
+
+// withPrefix devolve os termos do índice que começam com o prefixo
+func (ix *Index) withPrefix(prefix string) []string {
+	i := sort.SearchStrings(ix.terms, prefix)
+	j := i
+	for j < len(ix.terms) && strings.HasPrefix(ix.terms[j], prefix) {
+		j++
+	}
+	return ix.terms[i:j]
+}
+
+/*
+Search devolve os documentos que têm todas as palavras da busca, do mais relevante para o menos relevante
+(empate pelo menor ID). Cada palavra encontra o próprio radical ("bolos" encontra "bolo") e, com peso menor,
+os termos que começam com ela ("cen" encontra "cenoura"), para a busca funcionar enquanto a pessoa digita.
+A relevância é a soma do BM25 de cada termo em cada campo, multiplicado pelo peso do campo.
+Uma busca sem nenhuma palavra significativa (vazia, ou só com stop words) não encontra nada
+*/
+func (ix *Index) Search(query string) []Hit {
+	tokens := Analyze(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	n := float64(len(ix.docs))
+	scores := map[int]float64{}
+	for qi, tok := range tokens {
+		// Os termos do índice que a palavra encontra, com o peso de cada um
+		matches := map[string]float64{}
+		for _, prefix := range []string{tok.Word, tok.Term} {
+			for _, term := range ix.withPrefix(prefix) {
+				matches[term] = math.Max(matches[term], prefixWeight*float64(len(prefix))/float64(len(term)))
+			}
+		}
+		if _, ok := ix.postings[tok.Term]; ok {
+			matches[tok.Term] = 1
+		}
+
+		termScores := map[int]float64{}
+		for term, weight := range matches {
+			postings := ix.postings[term]
+			idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			for id := range postings {
+				// Busca com várias palavras: só seguem os documentos que tinham as palavras anteriores
+				if _, ok := scores[id]; qi > 0 && !ok {
+					continue
+				}
+				termScores[id] += weight * idf * ix.bm25(ix.docs[id], term)
+			}
+		}
+		if len(termScores) == 0 {
+			return nil
+		}
+		for id, s := range termScores {
+			termScores[id] = s + scores[id]
+		}
+		scores = termScores
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, s := range scores {
+		hits = append(hits, Hit{ID: id, Score: s})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+// bm25 soma a frequência do termo em cada campo do documento, descontando os campos mais longos que a média
+func (ix *Index) bm25(doc *document, term string) float64 {
+	var s float64
+	for i, f := range ix.fields {
+		tf := float64(doc.freqs[i][term])
+		if tf == 0 {
+			continue
+		}
+		avg := float64(ix.totalLengths[i]) / float64(len(ix.docs))
+		norm := 1 - bm25B + bm25B*float64(doc.lengths[i])/avg
+		s += f.Weight * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+	}
+	return s
+}
@@ -0,0 +1,104 @@
+package search
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTestIndex() *Index {
+	ix := NewIndex(Field{Name: "name", Weight: 2}, Field{Name: "category", Weight: 1})
+	ix.Put(1, "Bolo de Cenoura", "Padaria > Bolos")
+	ix.Put(2, "Café Expresso", "Bebidas > Quentes")
+	ix.Put(3, "Pão de Queijo", "Padaria")
+	ix.Put(4, "Refrigerante de Limão", "Bebidas > Refrigerantes")
+	return ix
+}
+
+func ids(hits []Hit) string {
+	var out []int
+	for _, h := range hits {
+		out = append(out, h.ID)
+	}
+	return fmt.Sprint(out)
+}
+
+// A busca ignora acentos, maiúsculas, plural e diminutivo, e procura também pelo prefixo
+func TestIndexSearchFolds(t *testing.T) {
+	ix := newTestIndex()
+	cases := map[string]string{
+		"cafe":           "[2]",
+		"CAFÉ":           "[2]",
+		"cafezinho":      "[2]",
+		"bolos":          "[1]",
+		"BOLINHO":        "[1]",
+		"cenouras":       "[1]",
+		"pães":           "[3]",
+		"limões":         "[4]",
+		"cen":            "[1]", // prefixo, enquanto a pessoa digita
+		"bebida":         "[2 4]",
+		"padaria queijo": "[3]", // todas as palavras, em qualquer campo
+		"bolo queijo":    "[]",
+		"de":             "[]", // só stop words
+		"":               "[]",
+	}
+	for q, want := range cases {
+		if got := ids(ix.Search(q)); got != want {
+			t.Errorf("Search(%q) = %s, esperado %s", q, got, want)
+		}
+	}
+}
+
+// O nome pesa mais que a categoria, e o termo exato mais que o prefixo
+func TestIndexSearchRanks(t *testing.T) {
+	ix := NewIndex(Field{Name: "name", Weight: 2}, Field{Name: "category", Weight: 1})
+	ix.Put(1, "Suco de Uva", "Refrigerantes")
+	ix.Put(2, "Refrigerante de Uva", "Bebidas")
+	if got := ids(ix.Search("refrigerante")); got != "[2 1]" {
+		t.Fatalf("Search = %s, esperado o produto com o termo no nome primeiro", got)
+	}
+
+	ix.Put(3, "Cenoura", "")
+	ix.Put(4, "Cenourinha baby", "")
+	ix.Put(5, "Centeio", "")
+	hits := ix.Search("cenoura")
+	if ids(hits) != "[3 4]" {
+		t.Fatalf("Search(cenoura) = %s, esperado [3 4]", ids(hits))
+	}
+	if hits := ix.Search("cen"); len(hits) != 3 {
+		t.Fatalf("Search(cen) = %s, esperado os três pelo prefixo", ids(hits))
+	}
+}
+
+// Put substitui a versão anterior do documento e Remove tira o documento da busca
+func TestIndexPutReplacesAndRemove(t *testing.T) {
+	ix := newTestIndex()
+
+	ix.Put(1, "Torta de Maçã", "Padaria")
+	if got := ids(ix.Search("cenoura")); got != "[]" {
+		t.Fatalf("depois do Put, a versão anterior ainda é encontrada: %s", got)
+	}
+	if got := ids(ix.Search("maca")); got != "[1]" {
+		t.Fatalf("depois do Put, Search(maca) = %s, esperado [1]", got)
+	}
+	if ix.Len() != 4 {
+		t.Fatalf("Len = %d, esperado 4", ix.Len())
+	}
+
+	ix.Remove(2)
+	ix.Remove(99) // não indexado: nada acontece
+	if got := ids(ix.Search("cafe")); got != "[]" {
+		t.Fatalf("depois do Remove, Search(cafe) = %s", got)
+	}
+	if got := ids(ix.Search("bebida")); got != "[4]" {
+		t.Fatalf("depois do Remove, Search(bebida) = %s, esperado [4]", got)
+	}
+	// O termo que só existia no documento removido também sai da busca por prefixo
+	if got := ids(ix.Search("expr")); got != "[]" {
+		t.Fatalf("depois do Remove, Search(expr) = %s", got)
+	}
+
+	ix.Reset()
+	if ix.Len() != 0 || len(ix.Search("padaria")) != 0 {
+		t.Fatalf("depois do Reset, Len = %d", ix.Len())
+	}
+}
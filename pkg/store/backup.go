@@ -0,0 +1,221 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Valores padrão da política de backups
+const (
+	DefaultBackupDir           = "backups"
+	DefaultBackupMaxCount      = 20
+	DefaultBackupMaxAge        = 30 * 24 * time.Hour
+	DefaultBackupMinorInterval = 15 * time.Minute
+)
+
+// backupTimeFormat vai no nome do backup; ordenar os nomes é o mesmo que ordenar pelo horário
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// ErrBackupNotFound é devolvido quando o backup pedido não existe
+var ErrBackupNotFound = errors.New("backup não encontrado")
+
+// BackupPolicy define onde os backups ficam e por quanto tempo.
+// Antes de cada gravação, a versão anterior do arquivo é copiada para Dir com o horário no nome.
+// Ficamos com no máximo MaxCount backups e descartamos os mais velhos que MaxAge (zero desliga cada limite).
+// As gravações menores (veja Minor) só viram backup se o mais novo tiver mais que MinorInterval
+// (zero faz cada uma delas virar backup, como as demais). Com Dir vazio, os backups ficam desligados.
+type BackupPolicy struct {
+	Dir           string
+	MaxCount      int
+	MaxAge        time.Duration
+	MinorInterval time.Duration
+}
+
+// BackupStore é implementada pelas stores que guardam backups (a FileStore e os decoradores em volta dela)
+type BackupStore interface {
+	ListBackups() ([]Backup, error)
+	ReadBackup(name string, data interface{}) error
+	Restore(name string) error
+}
+
+// Backup descreve uma cópia do arquivo guardada pela política de backups
+type Backup struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// DefaultBackupPolicy guarda os backups na pasta "backups" ao lado do arquivo
+func DefaultBackupPolicy(fileName string) BackupPolicy {
+	return BackupPolicy{
+		Dir:           filepath.Join(filepath.Dir(fileName), DefaultBackupDir),
+		MaxCount:      DefaultBackupMaxCount,
+		MaxAge:        DefaultBackupMaxAge,
+		MinorInterval: DefaultBackupMinorInterval,
+	}
+}
+
+// ListBackups devolve os backups existentes, do mais novo para o mais antigo
+func (fs *FileStore) ListBackups() ([]Backup, error) {
+	if fs.Backups.Dir == "" {
+		return []Backup{}, nil
+	}
+
+	stem, ext := fs.backupStem()
+	matches, err := filepath.Glob(filepath.Join(fs.Backups.Dir, stem+"-*"+ext))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, m := range matches {
+		name := filepath.Base(m)
+		t, ok := fs.backupTime(name)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Name: name, Time: t, Size: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+// ReadBackup preenche data com o conteúdo de um backup
+func (fs *FileStore) ReadBackup(name string, data interface{}) error {
+	b, err := fs.readBackup(name)
+	if err != nil {
+		return err
+	}
+	env, _, err := openEnvelope(fs.codec(), fs.Migrations, b)
+	if err != nil {
+		return fmt.Errorf("o backup %s não pode ser lido: %w", name, err)
+	}
+	return decode(env.Data, data)
+}
+
+// Restore troca o arquivo atual pelo backup informado.
+// A troca é atômica (mesma escrita das gravações normais) e, antes dela, a versão atual também vira
+// um backup: se a restauração foi um engano, basta restaurar esse backup novo.
+func (fs *FileStore) Restore(name string) error {
+	b, err := fs.readBackup(name)
+	if err != nil {
+		return err
+	}
+	// Backups antigos são migrados para o schema atual antes de voltarem a ser o catálogo
+	env, _, err := openEnvelope(fs.codec(), fs.Migrations, b)
+	if err != nil {
+		return fmt.Errorf("o backup %s está corrompido e não pode ser restaurado: %w", name, err)
+	}
+
+	// A restauração é um dos jeitos de sair de um arquivo corrompido, então não conferimos o arquivo atual
+	return fs.withLock(func() error {
+		return fs.replace(env.Data, maxInt(fs.lastID(), env.Metadata.LastID), false)
+	})
+}
+
+// backupCurrent copia o arquivo como está no disco para a pasta de backups e aplica a retenção.
+// Numa gravação menor (veja Minor), a cópia só acontece se o último backup passou do MinorInterval.
+// Chamado com o lock obtido, antes de cada gravação
+func (fs *FileStore) backupCurrent(minor bool) error {
+	if fs.Backups.Dir == "" {
+		return nil
+	}
+	if minor && fs.Backups.MinorInterval > 0 {
+		backups, err := fs.ListBackups()
+		if err != nil {
+			return err
+		}
+		if len(backups) > 0 && time.Since(backups[0].Time) < fs.Backups.MinorInterval {
+			return nil
+		}
+	}
+
+	current, err := os.Open(fs.FileName)
+	if os.IsNotExist(err) {
+		// Ainda não há versão anterior para guardar
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer current.Close()
+
+	if err := os.MkdirAll(fs.Backups.Dir, 0755); err != nil {
+		return err
+	}
+	stem, ext := fs.backupStem()
+	name := stem + "-" + time.Now().UTC().Format(backupTimeFormat) + ext
+	// A cópia vai direto do arquivo para o backup, sem passar inteira pela memória
+	err = writeFileAtomicFunc(filepath.Join(fs.Backups.Dir, name), 0644, func(w io.Writer) error {
+		_, err := io.Copy(w, current)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("não foi possível gravar o backup: %w", err)
+	}
+	return fs.pruneBackups()
+}
+
+// pruneBackups apaga os backups que passaram do limite de quantidade ou de idade
+func (fs *FileStore) pruneBackups() error {
+	backups, err := fs.ListBackups()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, b := range backups {
+		tooMany := fs.Backups.MaxCount > 0 && i >= fs.Backups.MaxCount
+		tooOld := fs.Backups.MaxAge > 0 && now.Sub(b.Time) > fs.Backups.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(filepath.Join(fs.Backups.Dir, b.Name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *FileStore) readBackup(name string) ([]byte, error) {
+	// O nome vem de fora (URL, linha de comando): só aceitamos nomes de backup, nunca caminhos
+	if _, ok := fs.backupTime(name); !ok || filepath.Base(name) != name || fs.Backups.Dir == "" {
+		return nil, fmt.Errorf("%w: %s", ErrBackupNotFound, name)
+	}
+	b, err := os.ReadFile(filepath.Join(fs.Backups.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrBackupNotFound, name)
+	}
+	return b, err
+}
+
+// backupStem separa o nome do arquivo da extensão, ex.: "products" e ".json"
+func (fs *FileStore) backupStem() (string, string) {
+	base := filepath.Base(fs.FileName)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext), ext
+}
+
+// backupTime extrai o horário do nome do backup, ex.: "products-20230601T100000.000000000Z.json"
+func (fs *FileStore) backupTime(name string) (time.Time, bool) {
+	stem, ext := fs.backupStem()
+	if !strings.HasPrefix(name, stem+"-") || !strings.HasSuffix(name, ext) {
+		return time.Time{}, false
+	}
+	ts := strings.TrimSuffix(strings.TrimPrefix(name, stem+"-"), ext)
+	t, err := time.Parse(backupTimeFormat, ts)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,420 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formatos de arquivo suportados pela FileStore
+const (
+	FormatJSON   = "json"
+	FormatYAML   = "yaml"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Codec converte o envelope (veja schema.go) para o formato do arquivo em disco e vice-versa.
+// Dentro da store os dados continuam em JSON; o codec só muda o que vai para o disco
+type Codec interface {
+	Name() string
+	Encode(env Envelope) ([]byte, error)
+	Decode(raw []byte) (Envelope, error)
+}
+
+// CodecFor devolve o codec do formato informado (json, yaml, csv ou ndjson)
+func CodecFor(format string) (Codec, error) {
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		return jsonCodec{}, nil
+	case FormatYAML, "yml":
+		return yamlCodec{}, nil
+	case FormatCSV:
+		return csvCodec{}, nil
+	case FormatNDJSON, "jsonl":
+		return ndjsonCodec{}, nil
+	}
+	return nil, fmt.Errorf("formato %q desconhecido (use json, yaml, csv ou ndjson)", format)
+}
+
+// CodecForFile escolhe o codec pela extensão do arquivo; extensões desconhecidas usam JSON
+func CodecForFile(fileName string) Codec {
+	c, err := CodecFor(strings.TrimPrefix(filepath.Ext(fileName), "."))
+	if err != nil {
+		return jsonCodec{}
+	}
+	return c
+}
+
+// jsonCodec é o formato original: o envelope indentado, ou a lista "pura" dos arquivos da versão 1
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return FormatJSON }
+
+func (jsonCodec) Encode(env Envelope) ([]byte, error) {
+	return json.MarshalIndent(env, "", "  ")
+}
+
+func (jsonCodec) Decode(raw []byte) (env Envelope, err error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if !json.Valid(trimmed) {
+			return env, decode(trimmed, new(interface{}))
+		}
+		return Envelope{SchemaVersion: 1, Data: trimmed}, nil
+	}
+	err = json.Unmarshal(raw, &env)
+	return env, err
+}
+
+// yamlCodec grava o mesmo envelope do JSON, em YAML, mantendo a ordem dos campos
+type yamlCodec struct{}
+
+func (yamlCodec) Name() string { return FormatYAML }
+
+func (yamlCodec) Encode(env Envelope) ([]byte, error) {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	// JSON também é YAML: lendo o JSON como um nó, a ordem dos campos é preservada.
+	// Limpamos o estilo para o nó ser gravado como YAML de blocos, e não como JSON
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	plainStyle(&node)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (yamlCodec) Decode(raw []byte) (env Envelope, err error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return env, err
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return env, fmt.Errorf("o YAML não pode ser convertido em JSON: %w", err)
+	}
+	err = json.Unmarshal(b, &env)
+	return env, err
+}
+
+func plainStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		plainStyle(c)
+	}
+}
+
+// envelopeHeader é o envelope sem os dados, usado pelos formatos de linha (CSV e NDJSON)
+type envelopeHeader struct {
+	SchemaVersion int      `json:"schema_version"`
+	Metadata      Metadata `json:"metadata"`
+}
+
+// ndjsonCodec grava uma linha com o schema_version e o metadata, seguida de um registro por linha.
+// Linhas podem ser acrescentadas no fim do arquivo; sem a linha de cabeçalho, os registros estão no schema atual
+type ndjsonCodec struct{}
+
+func (ndjsonCodec) Name() string { return FormatNDJSON }
+
+func (ndjsonCodec) Encode(env Envelope) ([]byte, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(env.Data, &items); err != nil {
+		return nil, fmt.Errorf("o formato ndjson guarda apenas listas: %w", err)
+	}
+
+	var buf bytes.Buffer
+	header, err := json.Marshal(envelopeHeader{SchemaVersion: env.SchemaVersion, Metadata: env.Metadata})
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(header)
+	buf.WriteByte('\n')
+	for _, item := range items {
+		if err := json.Compact(&buf, item); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (ndjsonCodec) Decode(raw []byte) (env Envelope, err error) {
+	env.SchemaVersion = latestSchema
+	items := []json.RawMessage{}
+
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	first := true
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return env, fmt.Errorf("linha %d não é um JSON válido", n)
+		}
+		// Só a primeira linha pode ser o cabeçalho
+		header := first && isHeader(line)
+		first = false
+		if header {
+			var h envelopeHeader
+			if err := json.Unmarshal(line, &h); err != nil {
+				return env, fmt.Errorf("linha %d: %w", n, err)
+			}
+			env.SchemaVersion, env.Metadata = h.SchemaVersion, h.Metadata
+			continue
+		}
+		items = append(items, append(json.RawMessage(nil), line...))
+	}
+	if err := sc.Err(); err != nil {
+		return env, err
+	}
+
+	env.Data, err = json.Marshal(items)
+	return env, err
+}
+
+// isHeader diz se a linha é o cabeçalho (o objeto com schema_version) e não um registro
+func isHeader(line []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return false
+	}
+	_, ok := fields["schema_version"]
+	return ok
+}
+
+/*
+csvCodec grava os dados como planilha: uma coluna por campo, na ordem em que aparecem nos registros,
+e uma linha de comentário no topo com o schema_version e o metadata:
+
+	# schema_version=2 updated_at=2023-06-01T10:00:00Z checksum=sha256:... records=1 last_id=1
+	id,name,category,count,price
+	1,Caneta,Papelaria,10,2.5
+
+Números, true/false e null vão como estão. Textos vão sem aspas, a não ser que pareçam outro tipo
+(ex.: o texto "123" é gravado como "\"123\"") ou sejam vazios: assim a leitura devolve o mesmo tipo.
+Célula vazia é campo ausente. Objetos e listas dentro de um campo são gravados como JSON.
+*/
+type csvCodec struct{}
+
+func (csvCodec) Name() string { return FormatCSV }
+
+func (csvCodec) Encode(env Envelope) ([]byte, error) {
+	var rows []json.RawMessage
+	if err := json.Unmarshal(env.Data, &rows); err != nil {
+		return nil, fmt.Errorf("o formato csv guarda apenas listas de objetos: %w", err)
+	}
+
+	var columns []string
+	seen := map[string]bool{}
+	records := make([]map[string]json.RawMessage, len(rows))
+	for i, row := range rows {
+		keys, fields, err := orderedObject(row)
+		if err != nil {
+			return nil, fmt.Errorf("o formato csv guarda apenas listas de objetos: %w", err)
+		}
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+		records[i] = fields
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# schema_version=%d updated_at=%s", env.SchemaVersion, env.Metadata.UpdatedAt.Format(time.RFC3339Nano))
+	if env.Metadata.Checksum != "" {
+		fmt.Fprintf(&buf, " checksum=%s records=%d", env.Metadata.Checksum, env.Metadata.Records)
+	}
+	if env.Metadata.LastID > 0 {
+		fmt.Fprintf(&buf, " last_id=%d", env.Metadata.LastID)
+	}
+	buf.WriteByte('\n')
+	w := csv.NewWriter(&buf)
+	if err := w.Write(columns); err != nil {
+		return nil, err
+	}
+	for _, fields := range records {
+		line := make([]string, len(columns))
+		for i, col := range columns {
+			if v, ok := fields[col]; ok {
+				line[i] = csvCell(v)
+			}
+		}
+		if err := w.Write(line); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func (csvCodec) Decode(raw []byte) (env Envelope, err error) {
+	env.SchemaVersion = latestSchema
+	if bytes.HasPrefix(raw, []byte("#")) {
+		line, rest, _ := bytes.Cut(raw, []byte("\n"))
+		if err := parseCSVHeader(string(line), &env); err != nil {
+			return env, err
+		}
+		raw = rest
+	}
+
+	r := csv.NewReader(bytes.NewReader(raw))
+	columns, err := r.Read()
+	if err == io.EOF {
+		env.Data = json.RawMessage("[]")
+		return env, nil
+	}
+	if err != nil {
+		return env, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for n := 0; ; n++ {
+		line, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return env, err
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('{')
+		first := true
+		for i, cell := range line {
+			if cell == "" {
+				continue
+			}
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			key, _ := json.Marshal(columns[i])
+			buf.Write(key)
+			buf.WriteByte(':')
+			buf.Write(csvValue(cell))
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(']')
+	env.Data = buf.Bytes()
+	return env, nil
+}
+
+// parseCSVHeader lê a linha "# schema_version=2 updated_at=... checksum=... records=... last_id=..."
+func parseCSVHeader(line string, env *Envelope) error {
+	for _, field := range strings.Fields(strings.TrimPrefix(line, "#")) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "schema_version":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("schema_version=%q inválido no cabeçalho do csv", value)
+			}
+			env.SchemaVersion = v
+		case "updated_at":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return fmt.Errorf("updated_at=%q inválido no cabeçalho do csv", value)
+			}
+			env.Metadata.UpdatedAt = t
+		case "checksum":
+			env.Metadata.Checksum = value
+		case "records":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("records=%q inválido no cabeçalho do csv", value)
+			}
+			env.Metadata.Records = n
+		case "last_id":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("last_id=%q inválido no cabeçalho do csv", value)
+			}
+			env.Metadata.LastID = n
+		}
+	}
+	return nil
+}
+
+// csvCell converte o valor JSON de um campo no texto da célula
+func csvCell(v json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		// Não é texto: número, true/false, null, objeto ou lista
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, v); err != nil {
+			return string(v)
+		}
+		return buf.String()
+	}
+	if s == "" || json.Valid([]byte(s)) {
+		// O texto seria lido como outro tipo (ou como campo ausente), então vai entre aspas
+		return string(v)
+	}
+	return s
+}
+
+// csvValue é o inverso de csvCell
+func csvValue(cell string) []byte {
+	if json.Valid([]byte(cell)) {
+		return []byte(cell)
+	}
+	b, _ := json.Marshal(cell)
+	return b
+}
+
+// orderedObject lê um objeto JSON mantendo a ordem dos campos
+func orderedObject(raw json.RawMessage) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, nil, errors.New("o registro não é um objeto")
+	}
+
+	var keys []string
+	fields := map[string]json.RawMessage{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := t.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, nil, err
+		}
+		if _, dup := fields[key]; !dup {
+			keys = append(keys, key)
+		}
+		fields[key] = v
+	}
+	return keys, fields, nil
+}
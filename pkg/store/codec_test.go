@@ -0,0 +1,205 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// codecFixtures são os dados que todo codec precisa gravar e ler de volta sem alterar
+var codecFixtures = []struct {
+	name string
+	data string
+}{
+	{"lista vazia", `[]`},
+	{"um produto", `[{"id":1,"name":"Caneta","category_id":2,"count":10,"price":{"amount":"2.50","currency":"BRL"}}]`},
+	{"campos diferentes em cada registro", `[{"id":1,"name":"Caneta"},{"id":2,"sku":"CAN-2"},{"id":3,"name":"Lápis","deleted_by":"ana"}]`},
+	{"textos que parecem outros tipos", `[{"id":1,"name":"123","sku":"true","gtin":"null","note":""}]`},
+	{"textos com separadores", `[{"id":1,"name":"Caneta, azul \"fina\"\nlinha 2","unicode":"Ação — São Paulo"}]`},
+	{"números, booleanos e null", `[{"id":1,"count":-3,"ratio":0.25,"big":9007199254740993,"active":false,"deleted_at":null}]`},
+	{"objetos e listas aninhados", `[{"id":1,"prices":[{"amount":"1.00","currency":"USD"},{"amount":"5.20","currency":"BRL"}],"tags":[]}]`},
+}
+
+var codecs = []Codec{jsonCodec{}, yamlCodec{}, csvCodec{}, ndjsonCodec{}}
+
+// Cada codec grava o envelope e o lê de volta com os mesmos dados, a mesma versão e o checksum conferido
+func TestCodecRoundTrip(t *testing.T) {
+	for _, c := range codecs {
+		for _, f := range codecFixtures {
+			c, f := c, f
+			t.Run(c.Name()+"/"+f.name, func(t *testing.T) {
+				raw, err := sealEnvelope(c, BaseSchemaVersion, []byte(f.data))
+				if err != nil {
+					t.Fatalf("Encode: %v", err)
+				}
+				env, from, err := openEnvelope(c, nil, raw)
+				if err != nil {
+					t.Fatalf("Decode: %v\n%s", err, raw)
+				}
+				if from != BaseSchemaVersion || env.SchemaVersion != BaseSchemaVersion {
+					t.Fatalf("schema_version = %d (lido %d), esperado %d", env.SchemaVersion, from, BaseSchemaVersion)
+				}
+				assertSameJSON(t, env.Data, []byte(f.data))
+
+				// Gravar de novo o que foi lido mantém o checksum e a contagem de registros
+				again, err := c.Encode(env)
+				if err != nil {
+					t.Fatal(err)
+				}
+				env2, err := c.Decode(again)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if env2.Metadata.Checksum != env.Metadata.Checksum || env2.Metadata.Records != env.Metadata.Records {
+					t.Fatalf("metadata mudou na segunda gravação: %+v, antes %+v", env2.Metadata, env.Metadata)
+				}
+			})
+		}
+	}
+}
+
+// O último ID atribuído (veja Sequence) volta igual em todos os formatos
+func TestCodecKeepsLastID(t *testing.T) {
+	for _, c := range codecs {
+		var buf bytes.Buffer
+		if _, err := writeEnvelope(&buf, c, BaseSchemaVersion, []byte(`[{"id":1}]`), 7); err != nil {
+			t.Fatal(err)
+		}
+		env, _, err := openEnvelope(c, nil, buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if env.Metadata.LastID != 7 {
+			t.Fatalf("%s: last_id = %d, esperado 7", c.Name(), env.Metadata.LastID)
+		}
+	}
+}
+
+// Os arquivos sem envelope (gravados à mão ou por versões antigas) também são lidos
+func TestCodecDecodeWithoutEnvelope(t *testing.T) {
+	cases := []struct {
+		codec   Codec
+		raw     string
+		version int
+		want    string
+	}{
+		{jsonCodec{}, `[{"id":1,"name":"Caneta"}]`, 1, `[{"id":1,"name":"Caneta"}]`},
+		{yamlCodec{}, "schema_version: 1\ndata:\n  - id: 1\n    name: Caneta\n", 1, `[{"id":1,"name":"Caneta"}]`},
+		{ndjsonCodec{}, "{\"id\":1,\"name\":\"Caneta\"}\n\n{\"id\":2,\"name\":\"Lápis\"}\n", latestSchema, `[{"id":1,"name":"Caneta"},{"id":2,"name":"Lápis"}]`},
+		{csvCodec{}, "id,name\n1,Caneta\n2,\"Lápis, preto\"\n", latestSchema, `[{"id":1,"name":"Caneta"},{"id":2,"name":"Lápis, preto"}]`},
+		{csvCodec{}, "", latestSchema, `[]`},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.codec.Name(), func(t *testing.T) {
+			env, err := c.codec.Decode([]byte(c.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.SchemaVersion != c.version {
+				t.Fatalf("schema_version = %d, esperado %d", env.SchemaVersion, c.version)
+			}
+			assertSameJSON(t, env.Data, []byte(c.want))
+		})
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	for format, want := range map[string]string{
+		"json": FormatJSON, "YAML": FormatYAML, "yml": FormatYAML, "csv": FormatCSV, "ndjson": FormatNDJSON, "jsonl": FormatNDJSON,
+	} {
+		c, err := CodecFor(format)
+		if err != nil || c.Name() != want {
+			t.Fatalf("CodecFor(%q) = %v, %v; esperado %s", format, c, err, want)
+		}
+	}
+	if _, err := CodecFor("xml"); err == nil {
+		t.Fatal("CodecFor(\"xml\") não devolveu erro")
+	}
+	if c := CodecForFile("data/products.yaml"); c.Name() != FormatYAML {
+		t.Fatalf("CodecForFile(products.yaml) = %s", c.Name())
+	}
+	if c := CodecForFile("products.txt"); c.Name() != FormatJSON {
+		t.Fatalf("CodecForFile(products.txt) = %s, esperado json", c.Name())
+	}
+}
+
+// assertSameJSON compara os dois JSON pelo conteúdo, sem olhar a formatação nem a ordem dos campos
+func assertSameJSON(t *testing.T, got, want []byte) {
+	t.Helper()
+	var g, w interface{}
+	if err := unmarshalNumbers(got, &g); err != nil {
+		t.Fatalf("%v: %s", err, got)
+	}
+	if err := unmarshalNumbers(want, &w); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Fatalf("dados = %s\nesperado %s", got, want)
+	}
+}
+
+func unmarshalNumbers(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// As migrations são da store: cada FileStore (e a massa de dados da MemoryStore) aplica só as suas
+func TestMigrationsPerStore(t *testing.T) {
+	upper := Migrations{BaseSchemaVersion: func(data json.RawMessage) (json.RawMessage, error) {
+		return bytes.ReplaceAll(data, []byte("Bolo"), []byte("BOLO")), nil
+	}}
+	if got := upper.Version(); got != BaseSchemaVersion+1 {
+		t.Fatalf("Version = %d, esperado %d", got, BaseSchemaVersion+1)
+	}
+	if got := Migrations(nil).Version(); got != BaseSchemaVersion {
+		t.Fatalf("Version sem migrations = %d, esperado %d", got, BaseSchemaVersion)
+	}
+
+	dir := t.TempDir()
+	legacy := []byte(`{"schema_version": 2, "metadata": {}, "data": [{"id": 1, "name": "Bolo"}]}`)
+	for _, name := range []string{"products.json", "categories.json", "seed.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), legacy, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	products := &FileStore{FileName: filepath.Join(dir, "products.json"), Migrations: upper}
+	if got := readItems(t, products); len(got) != 1 || got[0].Name != "BOLO" {
+		t.Fatalf("Read com a migration = %v, esperado BOLO", got)
+	}
+	writeItems(t, products, readItems(t, products)...)
+	env, _, err := decodeEnvelope(jsonCodec{}, nil, mustRead(t, products.FileName))
+	if err != nil || env.SchemaVersion != BaseSchemaVersion+1 {
+		t.Fatalf("arquivo gravado na versão %d (%v), esperado %d", env.SchemaVersion, err, BaseSchemaVersion+1)
+	}
+
+	// A outra store não roda as migrations dos produtos
+	categories := &FileStore{FileName: filepath.Join(dir, "categories.json")}
+	if got := readItems(t, categories); len(got) != 1 || got[0].Name != "Bolo" {
+		t.Fatalf("Read sem migrations = %v, esperado Bolo", got)
+	}
+
+	// A massa de dados é migrada na primeira leitura, com as migrations já configuradas
+	s, err := Open("mem://?seed=" + filepath.Join(dir, "seed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.(*MemoryStore).Migrations = upper
+	if got := readItems(t, s); len(got) != 1 || got[0].Name != "BOLO" {
+		t.Fatalf("Read da massa de dados = %v, esperado BOLO", got)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// encryptionAlgorithm identifica o formato do conteúdo criptografado
+const encryptionAlgorithm = "AES-GCM"
+
+// ErrUnknownKey é devolvido quando o conteúdo foi criptografado com uma chave que não está no Keyring
+var ErrUnknownKey = errors.New("chave de criptografia desconhecida")
+
+// Keyring guarda as chaves conhecidas, por ID. Active é a chave usada nas gravações;
+// as demais continuam servindo para ler o que foi gravado antes de uma troca de chave
+type Keyring struct {
+	Active string
+	keys   map[string][]byte
+}
+
+// ParseKeyring monta o Keyring a partir da configuração, no formato "id1:base64,id2:base64".
+// As chaves precisam ter 16, 24 ou 32 bytes (AES-128, AES-192 ou AES-256).
+// Sem active, a chave ativa é a última da lista.
+func ParseKeyring(spec, active string) (*Keyring, error) {
+	kr := &Keyring{keys: map[string][]byte{}}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("chave %q fora do formato id:base64", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("chave %s: %w", id, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("chave %s: %w", id, err)
+		}
+		kr.keys[id] = key
+		kr.Active = id
+	}
+
+	if len(kr.keys) == 0 {
+		return nil, errors.New("nenhuma chave de criptografia configurada")
+	}
+	if active != "" {
+		if _, ok := kr.keys[active]; !ok {
+			return nil, fmt.Errorf("%w: a chave ativa %s não está na lista", ErrUnknownKey, active)
+		}
+		kr.Active = active
+	}
+	return kr, nil
+}
+
+func (kr *Keyring) aead(id string) (cipher.AEAD, error) {
+	key, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealedData é o que vai para a store de baixo: o ID da chave fica junto dos dados, em claro,
+// para sabermos com qual chave abrir o conteúdo depois de uma troca de chave
+type sealedData struct {
+	Encryption string `json:"encryption"`
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedStore é um decorador que criptografa os dados antes de entregá-los a outra Store
+// (normalmente a FileStore) e os descriptografa na leitura.
+// Conteúdo antigo, gravado em claro, continua sendo lido normalmente; na próxima gravação ele é criptografado.
+// Cada leitura abre o conteúdo inteiro em memória: o AES-GCM autentica o texto cifrado como um todo,
+// então não há como entregar um registro antes de conferir todos (por isso ela não implementa Iterator)
+type EncryptedStore struct {
+	inner Store
+	keys  *Keyring
+}
+
+// NewEncryptedStore cria o decorador em volta da store informada
+func NewEncryptedStore(inner Store, keys *Keyring) *EncryptedStore {
+	return &EncryptedStore{inner: inner, keys: keys}
+}
+
+// Inner devolve a store decorada
+func (es *EncryptedStore) Inner() Store {
+	return es.inner
+}
+
+func (es *EncryptedStore) Read(data interface{}) error {
+	var raw json.RawMessage
+	if err := es.inner.Read(&raw); err != nil {
+		return err
+	}
+	return es.Open(raw, data)
+}
+
+func (es *EncryptedStore) Write(data interface{}) error {
+	return es.Update(func(tx Tx) error {
+		return tx.Write(data)
+	})
+}
+
+// Update repassa a transação para a store de baixo; o Tx entregue a fn criptografa e descriptografa no caminho
+func (es *EncryptedStore) Update(fn func(tx Tx) error) error {
+	return es.inner.Update(func(inner Tx) error {
+		return fn(&encryptedTx{inner: inner, store: es})
+	})
+}
+
+// Reencrypt regrava os dados com a chave ativa.
+// Usado na troca de chave: depois de configurar a chave nova como ativa (mantendo a antiga na lista),
+// rodamos o Reencrypt e, a partir daí, a chave antiga pode ser removida. Conteúdo em claro também é criptografado.
+func (es *EncryptedStore) Reencrypt() error {
+	return es.Update(func(tx Tx) error {
+		var data json.RawMessage
+		if err := tx.Read(&data); err != nil {
+			return err
+		}
+		return tx.Write(data)
+	})
+}
+
+// ListBackups, ReadBackup e Restore repassam os backups da store de baixo (a FileStore),
+// descriptografando o conteúdo dos backups na leitura
+func (es *EncryptedStore) ListBackups() ([]Backup, error) {
+	bs, err := es.backups()
+	if err != nil {
+		return nil, err
+	}
+	return bs.ListBackups()
+}
+
+func (es *EncryptedStore) ReadBackup(name string, data interface{}) error {
+	bs, err := es.backups()
+	if err != nil {
+		return err
+	}
+	var raw json.RawMessage
+	if err := bs.ReadBackup(name, &raw); err != nil {
+		return err
+	}
+	return es.Open(raw, data)
+}
+
+func (es *EncryptedStore) Restore(name string) error {
+	bs, err := es.backups()
+	if err != nil {
+		return err
+	}
+	return bs.Restore(name)
+}
+
+func (es *EncryptedStore) backups() (BackupStore, error) {
+	bs, ok := es.inner.(BackupStore)
+	if !ok {
+		return nil, errors.New("a store configurada não guarda backups")
+	}
+	return bs, nil
+}
+
+// Open descriptografa o conteúdo lido da store de baixo e preenche data.
+// Se o conteúdo não estiver criptografado (arquivo antigo), é lido como está
+func (es *EncryptedStore) Open(raw []byte, data interface{}) error {
+	var s sealedData
+	if err := json.Unmarshal(raw, &s); err != nil || s.Encryption == "" {
+		return decode(raw, data)
+	}
+	if s.Encryption != encryptionAlgorithm {
+		return fmt.Errorf("algoritmo de criptografia %q não suportado", s.Encryption)
+	}
+
+	aead, err := es.keys.aead(s.KeyID)
+	if err != nil {
+		return err
+	}
+	plain, err := aead.Open(nil, s.Nonce, s.Ciphertext, []byte(s.KeyID))
+	if err != nil {
+		return fmt.Errorf("não foi possível descriptografar os dados com a chave %s: %w", s.KeyID, err)
+	}
+	return decode(plain, data)
+}
+
+// seal serializa e criptografa os dados com a chave ativa
+func (es *EncryptedStore) seal(data interface{}) (sealedData, error) {
+	plain, err := encode(data)
+	if err != nil {
+		return sealedData{}, err
+	}
+
+	aead, err := es.keys.aead(es.keys.Active)
+	if err != nil {
+		return sealedData{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return sealedData{}, err
+	}
+
+	return sealedData{
+		Encryption: encryptionAlgorithm,
+		KeyID:      es.keys.Active,
+		Nonce:      nonce,
+		// O ID da chave entra como dado autenticado: trocar o key_id no arquivo invalida o conteúdo
+		Ciphertext: aead.Seal(nil, nonce, plain, []byte(es.keys.Active)),
+	}, nil
+}
+
+// encryptedTx é o Tx que o decorador entrega ao callback de Update
+type encryptedTx struct {
+	inner Tx
+	store *EncryptedStore
+}
+
+func (t *encryptedTx) Read(data interface{}) error {
+	var raw json.RawMessage
+	if err := t.inner.Read(&raw); err != nil {
+		return err
+	}
+	return t.store.Open(raw, data)
+}
+
+// LastID e SetLastID repassam o contador de IDs para o Tx da store de baixo (veja Sequence); ele fica em claro
+func (t *encryptedTx) LastID() int {
+	if seq, ok := t.inner.(Sequence); ok {
+		return seq.LastID()
+	}
+	return 0
+}
+
+func (t *encryptedTx) SetLastID(id int) {
+	if seq, ok := t.inner.(Sequence); ok {
+		seq.SetLastID(id)
+	}
+}
+
+// SetMinor repassa a marca da gravação para o Tx da store de baixo (veja Minor)
+func (t *encryptedTx) SetMinor() {
+	if m, ok := t.inner.(Minor); ok {
+		m.SetMinor()
+	}
+}
+
+func (t *encryptedTx) Write(data interface{}) error {
+	s, err := t.store.seal(data)
+	if err != nil {
+		return err
+	}
+	return t.inner.Write(s)
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keySpec monta a configuração de chaves no formato do ParseKeyring; a chave na posição i é o byte i+1 repetido
+func keySpec(ids ...string) string {
+	var entries []string
+	for i, id := range ids {
+		entries = append(entries, id+":"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(i + 1)}, 32)))
+	}
+	return strings.Join(entries, ",")
+}
+
+func keyring(t *testing.T, spec, active string) *Keyring {
+	t.Helper()
+	kr, err := ParseKeyring(spec, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+// rawSealed lê o conteúdo criptografado como está na store de baixo
+func rawSealed(t *testing.T, s Store) sealedData {
+	t.Helper()
+	var sd sealedData
+	if err := s.Read(&sd); err != nil {
+		t.Fatal(err)
+	}
+	return sd
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "products.json")
+	es := NewEncryptedStore(&FileStore{FileName: path}, keyring(t, keySpec("k1"), ""))
+	want := []journalItem{{1, "Bolo de chocolate"}, {2, "Café"}}
+	writeItems(t, es, want...)
+
+	if got := readItems(t, es); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Read = %v, esperado %v", got, want)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "chocolate") {
+		t.Fatalf("o arquivo tem os dados em claro:\n%s", raw)
+	}
+	if sd := rawSealed(t, es.Inner()); sd.Encryption != encryptionAlgorithm || sd.KeyID != "k1" {
+		t.Fatalf("conteúdo gravado = %s/%s, esperado %s/k1", sd.Encryption, sd.KeyID, encryptionAlgorithm)
+	}
+}
+
+// O arquivo antigo, em claro, continua sendo lido e é criptografado na próxima gravação
+func TestEncryptedStoreReadsLegacyPlaintext(t *testing.T) {
+	inner := &FileStore{FileName: filepath.Join(t.TempDir(), "products.json")}
+	writeItems(t, inner, journalItem{1, "Bolo"})
+
+	es := NewEncryptedStore(inner, keyring(t, keySpec("k1"), ""))
+	if got := readItems(t, es); len(got) != 1 || got[0].Name != "Bolo" {
+		t.Fatalf("Read do arquivo em claro = %v", got)
+	}
+	if err := es.Reencrypt(); err != nil {
+		t.Fatal(err)
+	}
+	if sd := rawSealed(t, inner); sd.KeyID != "k1" {
+		t.Fatalf("depois do Reencrypt, key_id = %q, esperado k1", sd.KeyID)
+	}
+	if got := readItems(t, es); len(got) != 1 || got[0].Name != "Bolo" {
+		t.Fatalf("Read depois do Reencrypt = %v", got)
+	}
+}
+
+// Troca de chave: a chave nova vira a ativa, a antiga continua lendo até o Reencrypt, depois pode sair da lista
+func TestEncryptedStoreKeyRotation(t *testing.T) {
+	inner := NewMemoryStore(nil)
+	writeItems(t, NewEncryptedStore(inner, keyring(t, keySpec("k1"), "")), journalItem{1, "Bolo"})
+
+	both := NewEncryptedStore(inner, keyring(t, keySpec("k1", "k2"), "k2"))
+	if got := readItems(t, both); len(got) != 1 {
+		t.Fatalf("Read com a chave antiga na lista = %v", got)
+	}
+	if err := both.Reencrypt(); err != nil {
+		t.Fatal(err)
+	}
+	if sd := rawSealed(t, inner); sd.KeyID != "k2" {
+		t.Fatalf("depois do Reencrypt, key_id = %q, esperado k2", sd.KeyID)
+	}
+
+	// Só com a chave nova: a antiga já pode sair da lista
+	kr := keyring(t, keySpec("k1", "k2"), "k2")
+	delete(kr.keys, "k1")
+	if got := readItems(t, NewEncryptedStore(inner, kr)); len(got) != 1 || got[0].Name != "Bolo" {
+		t.Fatalf("Read só com a chave nova = %v", got)
+	}
+}
+
+func TestEncryptedStoreRejectsWrongKey(t *testing.T) {
+	inner := NewMemoryStore(nil)
+	writeItems(t, NewEncryptedStore(inner, keyring(t, keySpec("k1"), "")), journalItem{1, "Bolo"})
+
+	var items []journalItem
+	// A chave do conteúdo não está na lista
+	other := keyring(t, keySpec("k1", "k2"), "k2")
+	delete(other.keys, "k1")
+	if err := NewEncryptedStore(inner, other).Read(&items); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Read sem a chave do conteúdo = %v, esperado ErrUnknownKey", err)
+	}
+	// O mesmo ID com outros bytes não abre o conteúdo
+	wrong := keyring(t, "k1:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32)), "")
+	if err := NewEncryptedStore(inner, wrong).Read(&items); err == nil || !strings.Contains(err.Error(), "descriptografar") {
+		t.Fatalf("Read com a chave errada = %v, esperado erro ao descriptografar", err)
+	}
+}
+
+// O conteúdo e o key_id são autenticados: qualquer alteração é recusada, em vez de virar dados trocados
+func TestEncryptedStoreRejectsTampering(t *testing.T) {
+	kr := keyring(t, keySpec("k1", "k2"), "k1")
+	tamper := map[string]func(sd *sealedData){
+		"ciphertext": func(sd *sealedData) { sd.Ciphertext[0] ^= 0xff },
+		"nonce":      func(sd *sealedData) { sd.Nonce[0] ^= 0xff },
+		// A k2 existe: só o dado autenticado impede que o conteúdo seja aberto com o key_id trocado
+		"key_id": func(sd *sealedData) { sd.KeyID = "k2" },
+	}
+	for name, change := range tamper {
+		change := change
+		t.Run(name, func(t *testing.T) {
+			inner := NewMemoryStore(nil)
+			es := NewEncryptedStore(inner, kr)
+			writeItems(t, es, journalItem{1, "Bolo"})
+
+			sd := rawSealed(t, inner)
+			change(&sd)
+			if err := inner.Write(sd); err != nil {
+				t.Fatal(err)
+			}
+			var items []journalItem
+			if err := es.Read(&items); err == nil {
+				t.Fatalf("Read do conteúdo com o %s alterado = %v, esperado erro", name, items)
+			}
+		})
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	kr := keyring(t, keySpec("k1", "k2"), "")
+	if kr.Active != "k2" {
+		t.Fatalf("sem active, a chave ativa = %q, esperado a última (k2)", kr.Active)
+	}
+
+	invalid := map[string]struct{ spec, active string }{
+		"vazia":               {"", ""},
+		"sem id":              {":" + base64.StdEncoding.EncodeToString(make([]byte, 32)), ""},
+		"base64 inválido":     {"k1:%%%", ""},
+		"tamanho inválido":    {"k1:" + base64.StdEncoding.EncodeToString(make([]byte, 10)), ""},
+		"ativa fora da lista": {keySpec("k1"), "k9"},
+	}
+	for name, c := range invalid {
+		if _, err := ParseKeyring(c.spec, c.active); err == nil {
+			t.Errorf("%s: ParseKeyring(%q, %q) aceitou a configuração", name, c.spec, c.active)
+		}
+	}
+}
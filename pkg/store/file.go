@@ -1,61 +1,451 @@
 package store
 
 import (
+	"bufio"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 )
 
 // Declaramos a inteface da nossa Store, nela definimos os métodos que a store deve ter
 type Store interface {
 	Read(data interface{}) error
 	Write(data interface{}) error
+	// Update executa fn numa transação: fn lê e grava através do Tx, com acesso exclusivo à store.
+	// As gravações só chegam à store se fn devolver nil; se devolver um erro, tudo é descartado
+	Update(fn func(tx Tx) error) error
 }
 
 // Estamos declarando um ipo personalizado, que se chama "Type" e o tipo desse tipo é string
 // type Type string
 
 // Criamos uma constante definindo no que iremos gravar, no caso falamos que vamos gravar num file ("arquivo")
+// Hoje a store é escolhida pelo DSN (veja registry.go); esses são os nomes da configuração antiga (STORE_TYPE)
 const (
 	// Caso quisermos criar um alias para string, podemos usar o tipo que criamos anterioremnte
 	// FileType Type = "file"
 	FileType string = "arquivo"
+	// MemoryType guarda os dados apenas em memória (testes e instâncias de demonstração)
+	MemoryType string = "memoria"
 )
 
+// FileScheme é o esquema do DSN da store de arquivo, ex.: "file://products.json?backup_max_count=10"
+const FileScheme = "file"
+
 // Definimos nossa struct FileStore
 type FileStore struct {
 	FileName string
+
+	// mu serializa as goroutines deste processo; entre processos usamos o flock no arquivo de lock
+	mu sync.Mutex
+
+	// Backups define a cópia da versão anterior antes de cada gravação (veja backup.go)
+	Backups BackupPolicy
+
+	// Codec é o formato do arquivo em disco (veja codec.go); sem codec, o arquivo é JSON
+	Codec Codec
+
+	// Migrations são as migrations dos dados deste arquivo (veja schema.go); sem elas, o arquivo fica na BaseSchemaVersion.
+	// Precisam estar configuradas antes da primeira leitura
+	Migrations Migrations
+
+	// WatchInterval é o intervalo entre as verificações de edições externas (veja watch.go); zero desliga o Watch
+	WatchInterval time.Duration
+
+	// cache é a última versão válida lida ou gravada (veja watch.go)
+	cacheMu sync.Mutex
+	cache   *fileCache
 }
 
 // A nossa Store é como se fosse um trabalhador que precisa saber o nome do arquivo que ele vai trabalhar
 // Ao passarmos para ele o nome do arquivo, poderemos gravar e ler esse arquivo
 
-// Aqui definimos no que o trabalhador vai gravar, no caso num "fiarquivole", e qual o nome desse "arquivo"
-func Factory(store string, fileName string) Store {
-	switch store {
-	case FileType:
-		return &FileStore{fileName}
+// Aqui definimos no que o trabalhador vai gravar, no caso num "arquivo", e qual o nome desse "arquivo".
+// As opções do DSN ajustam a política de backups (backup_dir, "none" desliga; backup_max_count; backup_max_age;
+// backup_minor_interval),
+// o intervalo do Watch (watch_interval) e o formato do arquivo (format; sem ela, vale a extensão do arquivo)
+func init() {
+	Register(FileScheme, func(path string, opts *Options) (Store, error) {
+		if path == "" {
+			return nil, errors.New("informe o caminho do arquivo, ex.: file://products.json")
+		}
+		fs := &FileStore{FileName: path, Backups: DefaultBackupPolicy(path)}
+		fs.Backups.Dir = opts.String("backup_dir", fs.Backups.Dir)
+		if fs.Backups.Dir == "none" {
+			fs.Backups.Dir = ""
+		}
+		fs.Backups.MaxCount = opts.Int("backup_max_count", fs.Backups.MaxCount)
+		fs.Backups.MaxAge = opts.Duration("backup_max_age", fs.Backups.MaxAge)
+		fs.Backups.MinorInterval = opts.Duration("backup_minor_interval", fs.Backups.MinorInterval)
+		fs.WatchInterval = opts.Duration("watch_interval", DefaultWatchInterval)
+		fs.Codec = CodecForFile(path)
+		format := opts.String("format", "")
+		if err := opts.Err(); err != nil {
+			return nil, err
+		}
+		if format != "" {
+			c, err := CodecFor(format)
+			if err != nil {
+				return nil, err
+			}
+			fs.Codec = c
+		}
+		return fs, nil
+	})
+}
+
+// codec devolve o formato do arquivo; o valor zero da FileStore continua gravando JSON
+func (fs *FileStore) codec() Codec {
+	if fs.Codec == nil {
+		return jsonCodec{}
 	}
+	return fs.Codec
+}
+
+// withLock garante que apenas um ciclo de leitura-alteração-escrita acontece por vez.
+// Dentro do processo, usamos um mutex (o Gin atende cada requisição numa goroutine diferente).
+// Entre processos (vários servidores ou uma ferramenta administrativa), usamos um flock num arquivo
+// "<arquivo>.lock" ao lado do arquivo de dados. Não travamos o próprio arquivo de dados porque
+// a escrita atômica troca o arquivo por outro (rename), e o lock ficaria no arquivo antigo.
+func (fs *FileStore) withLock(fn func() error) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return withFileLock(fs.FileName+".lock", fn)
+}
+
+// withFileLock executa fn segurando o flock do arquivo de lock informado
+func withFileLock(lockName string, fn func() error) error {
+	lock, err := os.OpenFile(lockName, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	return fn()
+}
+
+/*
+Update abre uma transação sobre o arquivo:
+  - com o lock obtido, lemos o arquivo uma única vez (esse é o "retrato" que a transação enxerga)
+    e, se alguém o editou por fora, partimos da última versão válida;
+  - fn lê e grava pelo Tx, mas as gravações ficam em memória;
+  - se fn der certo, gravamos o resultado de forma atômica (commit);
+  - se fn devolver um erro, nada é gravado e o arquivo continua como estava (rollback).
+*/
+func (fs *FileStore) Update(fn func(tx Tx) error) error {
+	return fs.withLock(func() error {
+		data, lastID, err := fs.snapshot()
+		t := newTx(data, err)
+		t.lastID = lastID
+
+		if err := fn(t); err != nil {
+			return err
+		}
+		if !t.written {
+			return nil
+		}
+		return fs.commit(t.pending, t.lastID, t.minor)
+	})
+}
+
+// commit grava os dados no arquivo, dentro do envelope com a versão do schema (veja schema.go).
+// Não sobrescrevemos um arquivo que não conseguimos ler: os registros que ainda estão nele seriam perdidos
+// (ex.: um Store em cima de um arquivo corrompido gravaria só o produto novo).
+// O arquivo volta a aceitar gravações depois do repair (veja repair.go) ou da restauração de um backup.
+// Chamado com o lock obtido
+func (fs *FileStore) commit(data []byte, lastID int, minor bool) error {
+	if err := fs.checkReadable(); err != nil {
+		return fmt.Errorf("gravação recusada: %w", err)
+	}
+	return fs.replace(data, lastID, minor)
+}
+
+// checkReadable confere se o arquivo no disco pode ser lido (ou ainda não existe).
+// Na maioria das vezes o arquivo é o mesmo que acabamos de ler ou gravar, e o open não precisa conferi-lo de novo
+func (fs *FileStore) checkReadable() error {
+	src, err := fs.open(false)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	src.Close()
+	return src.stale
+}
+
+// replace grava os dados sem conferir o arquivo atual; usado pelo commit e pelas rotinas que
+// substituem um arquivo corrompido (restauração de backup e repair). minor é a marca da gravação (veja Minor).
+// Chamado com o lock obtido
+func (fs *FileStore) replace(data []byte, lastID int, minor bool) error {
+	// Guardamos a versão anterior antes de sobrescrevê-la
+	if err := fs.backupCurrent(minor); err != nil {
+		return err
+	}
+	// Não escrevemos direto no arquivo: se o processo cair no meio da escrita, o catálogo ficaria truncado.
+	// O envelope vai direto para o temporário, calculando o checksum do arquivo no caminho
+	h := sha256.New()
+	err := writeFileAtomicFunc(fs.FileName, 0644, func(w io.Writer) error {
+		_, err := writeEnvelope(io.MultiWriter(w, h), fs.codec(), fs.Migrations.Version(), data, lastID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	fs.remember(sum, lastID)
 	return nil
 }
 
+// lastID devolve o último ID atribuído no arquivo atual (ou na última versão válida); zero se não houver.
+// As rotinas que trocam o arquivo inteiro (restauração e repair) mantêm o maior entre ele e o do conteúdo novo,
+// para que uma versão antiga não traga de volta IDs que já foram usados
+func (fs *FileStore) lastID() int {
+	src, err := fs.open(false)
+	if err != nil {
+		return 0
+	}
+	src.Close()
+	return src.lastID
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// UpgradeSchema deve ser chamado na inicialização.
+// Se o arquivo foi gravado numa versão antiga do schema (inclusive a lista "pura", sem envelope),
+// ele é migrado e regravado na versão atual (a versão antiga fica nos backups).
+// Se o arquivo vier de uma versão mais nova, desconhecida, devolvemos ErrSchemaTooNew:
+// a aplicação não deve subir e arriscar sobrescrever campos que não conhece.
+func (fs *FileStore) UpgradeSchema() error {
+	return fs.withLock(func() error {
+		raw, err := os.ReadFile(fs.FileName)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		env, from, err := openEnvelope(fs.codec(), fs.Migrations, raw)
+		if err != nil {
+			return err
+		}
+		if from == env.SchemaVersion {
+			return nil
+		}
+		return fs.commit(env.Data, env.Metadata.LastID, false)
+	})
+}
+
 // Ensinamos ao trabalhador como gravar num arquivo
+// A gravação simples também passa pelo lock, para não atropelar uma transação em andamento
 func (fs *FileStore) Write(data interface{}) error {
-	// A função MarshalIndent faz a mesma coisa que a Marshal, porém ela "indenta" o jso também
-	fileData, err := json.MarshalIndent(data, "", "  ")
+	return fs.Update(func(tx Tx) error {
+		return tx.Write(data)
+	})
+}
+
+// writeFileAtomic grava os dados num arquivo temporário no mesmo diretório do destino,
+// força a gravação no disco (fsync) e só então renomeia o temporário por cima do destino.
+// O rename é atômico: quem ler o arquivo verá a versão antiga inteira ou a nova inteira, nunca um pedaço.
+// Por fim, sincronizamos o diretório para que o próprio rename sobreviva a uma queda de energia.
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	return writeFileAtomicFunc(fileName, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomicFunc é o writeFileAtomic para quem grava o conteúdo aos poucos: write escreve no temporário
+func writeFileAtomicFunc(fileName string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	dir, base := filepath.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, tempPattern(base))
+	if err != nil {
+		return err
+	}
+	// Se qualquer passo falhar, o temporário é descartado e o arquivo original continua intacto
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	bw := bufio.NewWriter(tmp)
+	if err = write(bw); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), fileName); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir garante que as entradas do diretório (o rename) foram gravadas no disco
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	// Usamos a função WriteFile para escrever no arquivo com o nome que a pessoa definiu
-	return os.WriteFile(fs.FileName, fileData, 0644)
+	defer d.Close()
+	return d.Sync()
 }
 
-// Ensinamos ao trabalhador como ler um arquivo
-// products []Product
-func (fs *FileStore) Read(data interface{}) error {
-	// Usamos a função ReadFile para ler o arquivo com o nome que a pessoa definiu
-	file, err := os.ReadFile(fs.FileName)
+// tempPattern é o padrão dos arquivos temporários, ex.: ".products.json.tmp-123456"
+func tempPattern(base string) string {
+	return "." + base + ".tmp-*"
+}
+
+/*
+Recover deve ser chamado na inicialização, antes de qualquer leitura.
+Ele procura os temporários que sobraram de uma escrita interrompida e decide o que fazer com cada um:
+  - se o temporário mais recente está completo (pode ser lido no formato do arquivo) e é mais novo que o arquivo
+    (ou o arquivo sumiu/está corrompido), concluímos a escrita renomeando o temporário;
+  - todos os outros temporários são descartados.
+
+Tudo acontece com o lock da store: sem ele, poderíamos apagar o temporário de uma escrita que outro
+processo (ou outra goroutine) ainda está fazendo.
+*/
+func (fs *FileStore) Recover() error {
+	return fs.withLock(fs.recoverTemps)
+}
+
+// recoverTemps é o Recover, já com o lock obtido
+func (fs *FileStore) recoverTemps() error {
+	dir, base := filepath.Split(fs.FileName)
+	if dir == "" {
+		dir = "."
+	}
+
+	// As cópias da última versão válida interrompidas no meio (veja saveLastGood) só são descartadas
+	if stale, err := filepath.Glob(filepath.Join(dir, "."+base+".last-good-*")); err == nil {
+		for _, name := range stale {
+			os.Remove(name)
+		}
+	}
+
+	tmps, err := filepath.Glob(filepath.Join(dir, tempPattern(base)))
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(file, data)
+	if len(tmps) == 0 {
+		return nil
+	}
+
+	// Ordenamos do temporário mais novo para o mais antigo
+	modTime := func(name string) int64 {
+		info, err := os.Stat(name)
+		if err != nil {
+			return 0
+		}
+		return info.ModTime().UnixNano()
+	}
+	sort.Slice(tmps, func(i, j int) bool { return modTime(tmps[i]) > modTime(tmps[j]) })
+
+	finished := false
+	for _, tmp := range tmps {
+		if !finished && fs.shouldFinish(tmp) {
+			if err := os.Rename(tmp, fs.FileName); err != nil {
+				return err
+			}
+			if err := syncDir(dir); err != nil {
+				return err
+			}
+			finished = true
+			continue
+		}
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// shouldFinish diz se o temporário contém uma escrita completa que ainda não chegou ao arquivo
+func (fs *FileStore) shouldFinish(tmp string) bool {
+	tmpData, err := os.ReadFile(tmp)
+	if err != nil || !fs.valid(tmpData) {
+		// Temporário incompleto: a escrita foi interrompida antes do fsync
+		return false
+	}
+
+	current, err := os.ReadFile(fs.FileName)
+	if err != nil || !fs.valid(current) {
+		// O arquivo sumiu ou está corrompido, o temporário é o melhor que temos
+		return true
+	}
+
+	tmpInfo, err := os.Stat(tmp)
+	if err != nil {
+		return false
+	}
+	fileInfo, err := os.Stat(fs.FileName)
+	if err != nil {
+		return true
+	}
+	// Só concluímos se o temporário for mais novo que a última escrita que deu certo
+	return !tmpInfo.ModTime().Before(fileInfo.ModTime())
+}
+
+// valid diz se o conteúdo é um arquivo completo no formato da store
+func (fs *FileStore) valid(raw []byte) bool {
+	_, err := fs.codec().Decode(raw)
+	return err == nil
+}
+
+// Ensinamos ao trabalhador como ler um arquivo
+// products []Product
+func (fs *FileStore) Read(data interface{}) error {
+	// Lemos o arquivo com o nome que a pessoa definiu (ou a última versão válida, se ele foi corrompido por fora)
+	// Aqui o decoder já está nos dados, fora do envelope
+	return fs.readData(func(dec *json.Decoder, _ int) error {
+		return dec.Decode(data)
+	})
+}
+
+// snapshot devolve os dados do arquivo para a transação, que precisa deles inteiros para o Tx.Read,
+// e o último ID atribuído
+func (fs *FileStore) snapshot() ([]byte, int, error) {
+	var data json.RawMessage
+	var lastID int
+	err := fs.readData(func(dec *json.Decoder, id int) error {
+		lastID = id
+		return dec.Decode(&data)
+	})
+	return data, lastID, err
 }
@@ -0,0 +1,296 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Cada goroutine usa uma das FileStore abertas sobre o mesmo arquivo: dentro de uma FileStore quem serializa
+// é o mutex; entre elas, só o flock (como entre processos diferentes). Rode com -race
+func TestFileStoreConcurrentUpdates(t *testing.T) {
+	const (
+		stores  = 4
+		writers = 300
+	)
+	path := filepath.Join(t.TempDir(), "ids.json")
+	fss := make([]*FileStore, stores)
+	for i := range fss {
+		fss[i] = &FileStore{FileName: path}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers+stores)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(fs *FileStore) {
+			defer wg.Done()
+			errs <- fs.Update(func(tx Tx) error {
+				ids := []int{}
+				if err := tx.Read(&ids); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+				next := 1
+				if len(ids) > 0 {
+					next = ids[len(ids)-1] + 1
+				}
+				return tx.Write(append(ids, next))
+			})
+		}(fss[i%stores])
+	}
+	// Um Recover no meio das gravações não pode apagar o temporário de uma delas
+	for _, fs := range fss {
+		wg.Add(1)
+		go func(fs *FileStore) {
+			defer wg.Done()
+			errs <- fs.Recover()
+		}(fs)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []int
+	if err := (&FileStore{FileName: path}).Read(&ids); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != writers {
+		t.Fatalf("o arquivo tem %d IDs, esperado %d: alguma gravação se perdeu", len(ids), writers)
+	}
+	seen := make(map[int]bool, len(ids))
+	for i, id := range ids {
+		if seen[id] {
+			t.Fatalf("o ID %d foi atribuído duas vezes", id)
+		}
+		seen[id] = true
+		if id != i+1 {
+			t.Fatalf("ids[%d] = %d, esperado %d", i, id, i+1)
+		}
+	}
+}
+
+// sealed devolve os itens dentro do envelope, como a FileStore os grava
+func sealed(t *testing.T, items ...int) []byte {
+	t.Helper()
+	data, err := encode(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := sealEnvelope(jsonCodec{}, BaseSchemaVersion, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// Simula as escritas interrompidas em cada ponto do writeFileAtomic e confere o que o Recover faz com os temporários
+func TestFileStoreRecover(t *testing.T) {
+	base := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	type temp struct {
+		raw []byte
+		age time.Duration // em relação ao arquivo: positivo é mais novo
+	}
+	cases := []struct {
+		name  string
+		file  []byte // nil: o arquivo não existe
+		temps []temp
+		want  []int
+	}{
+		{
+			name:  "temporário completo e mais novo: a escrita é concluída",
+			file:  sealed(t, 1),
+			temps: []temp{{sealed(t, 1, 2), time.Minute}},
+			want:  []int{1, 2},
+		},
+		{
+			name:  "temporário truncado: a escrita é descartada",
+			file:  sealed(t, 1),
+			temps: []temp{{truncate(sealed(t, 1, 2)), time.Minute}},
+			want:  []int{1},
+		},
+		{
+			name:  "temporário vazio (queda antes da primeira escrita): é descartado",
+			file:  sealed(t, 1),
+			temps: []temp{{[]byte{}, time.Minute}},
+			want:  []int{1},
+		},
+		{
+			name:  "temporário completo, mas mais velho que o arquivo: é descartado",
+			file:  sealed(t, 1, 2, 3),
+			temps: []temp{{sealed(t, 1, 2), -time.Minute}},
+			want:  []int{1, 2, 3},
+		},
+		{
+			name:  "arquivo corrompido: vale o temporário completo, mesmo mais velho",
+			file:  truncate(sealed(t, 1, 2, 3)),
+			temps: []temp{{sealed(t, 1, 2), -time.Minute}},
+			want:  []int{1, 2},
+		},
+		{
+			name:  "arquivo sumiu: vale o temporário completo",
+			temps: []temp{{sealed(t, 1), time.Minute}},
+			want:  []int{1},
+		},
+		{
+			name: "vários temporários: vale o mais novo que está completo",
+			file: sealed(t, 1),
+			temps: []temp{
+				{sealed(t, 1, 2), time.Minute},
+				{sealed(t, 1, 2, 3), 2 * time.Minute},
+				{truncate(sealed(t, 1, 2, 3, 4)), 3 * time.Minute},
+			},
+			want: []int{1, 2, 3},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "products.json")
+			if c.file != nil {
+				if err := os.WriteFile(path, c.file, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, base, base); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, tmp := range c.temps {
+				name := filepath.Join(dir, fmt.Sprintf(".products.json.tmp-%d", i))
+				if err := os.WriteFile(name, tmp.raw, 0600); err != nil {
+					t.Fatal(err)
+				}
+				at := base.Add(tmp.age)
+				if err := os.Chtimes(name, at, at); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fs := &FileStore{FileName: path}
+			if err := fs.Recover(); err != nil {
+				t.Fatal(err)
+			}
+
+			left, err := filepath.Glob(filepath.Join(dir, tempPattern("products.json")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) != 0 {
+				t.Fatalf("sobraram temporários: %v", left)
+			}
+			var got []int
+			if err := fs.Read(&got); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Fatalf("depois do Recover, o arquivo tem %v, esperado %v", got, c.want)
+			}
+		})
+	}
+}
+
+// truncate simula a queda no meio da escrita: só a primeira metade chegou ao disco
+func truncate(raw []byte) []byte {
+	return raw[:len(raw)/2]
+}
+
+// Restaurar um backup antigo não faz o último ID atribuído voltar atrás
+func TestFileStoreRestoreKeepsLastID(t *testing.T) {
+	dir := t.TempDir()
+	fs := &FileStore{FileName: filepath.Join(dir, "ids.json"), Backups: BackupPolicy{Dir: filepath.Join(dir, "backups"), MaxCount: 10}}
+	for _, ids := range [][]int{{1}, {1, 2}} {
+		err := fs.Update(func(tx Tx) error {
+			tx.(Sequence).SetLastID(ids[len(ids)-1])
+			return tx.Write(ids)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := fs.ListBackups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("ListBackups = %v, %v; esperado um backup", backups, err)
+	}
+	if err := fs.Restore(backups[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	reopened := &FileStore{FileName: fs.FileName}
+	err = reopened.Update(func(tx Tx) error {
+		var ids []int
+		if err := tx.Read(&ids); err != nil || fmt.Sprint(ids) != "[1]" {
+			t.Fatalf("depois do Restore, o arquivo tem %v, %v", ids, err)
+		}
+		if got := tx.(Sequence).LastID(); got != 2 {
+			t.Fatalf("LastID depois do Restore = %d, esperado 2", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// As gravações menores (veja Minor) só viram backup depois do MinorInterval; as demais sempre viram
+func TestFileStoreMinorWritesBatchBackups(t *testing.T) {
+	dir := t.TempDir()
+	fs := &FileStore{FileName: filepath.Join(dir, "ids.json"), Backups: BackupPolicy{Dir: filepath.Join(dir, "backups"), MaxCount: 3, MinorInterval: time.Hour}}
+	encrypted := NewEncryptedStore(fs, keyring(t, keySpec("k1"), ""))
+	write := func(s Store, minor bool, ids ...int) {
+		t.Helper()
+		if err := s.Update(func(tx Tx) error {
+			if minor {
+				tx.(Minor).SetMinor()
+			}
+			return tx.Write(ids)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func() int {
+		t.Helper()
+		backups, err := fs.ListBackups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(backups)
+	}
+
+	write(fs, false, 1)
+	write(fs, false, 1, 2) // o primeiro backup
+	first, err := fs.ListBackups()
+	if err != nil || len(first) != 1 {
+		t.Fatalf("ListBackups = %v, %v; esperado um backup", first, err)
+	}
+
+	// Uma rajada de gravações menores não gera backups nem descarta o que já existe, mesmo pelo decorador
+	for i := 0; i < 10; i++ {
+		write(fs, true, 1, 2, i)
+		write(encrypted, true, 1, 2, i)
+	}
+	if backups, err := fs.ListBackups(); err != nil || len(backups) != 1 || backups[0].Name != first[0].Name {
+		t.Fatalf("depois das gravações menores, ListBackups = %v, %v; esperado só %s", backups, err, first[0].Name)
+	}
+
+	write(fs, false, 1, 2, 3)
+	if n := count(); n != 2 {
+		t.Fatalf("depois de uma gravação normal, %d backups; esperado 2", n)
+	}
+
+	// Passado o intervalo, a gravação menor também vira backup
+	fs.Backups.MinorInterval = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	write(fs, true, 1, 2, 3, 4)
+	if n := count(); n != 3 {
+		t.Fatalf("depois do intervalo, %d backups; esperado 3", n)
+	}
+}
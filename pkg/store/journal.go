@@ -0,0 +1,717 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JournalScheme guarda cada alteração como um registro num log, em vez de regravar o arquivo inteiro,
+// ex.: "journal://data/?compact_every=100"
+const JournalScheme = "journal"
+
+// Operações registradas no journal
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpRename = "rename"
+	OpDelete = "delete"
+)
+
+// DefaultCompactEvery é a quantidade de registros no log que dispara uma compactação
+const DefaultCompactEvery = 1000
+
+const (
+	journalLogName      = "journal.log"
+	journalSnapshotName = "snapshot.json"
+	journalLockName     = "journal.lock"
+
+	// journalArchiveFormat é o nome de um log arquivado na compactação, com o seq do último registro dele
+	journalArchiveFormat  = "journal-%020d.log"
+	journalArchivePattern = "journal-*.log"
+)
+
+// ErrHistoryCompacted é devolvido quando pedimos um estado anterior ao histórico guardado
+// (os logs arquivados pelas compactações foram apagados)
+var ErrHistoryCompacted = errors.New("o histórico desse instante não está mais disponível")
+
+// TimeTraveler é implementada pelas stores que conseguem devolver os dados como eram num instante passado
+type TimeTraveler interface {
+	ReadAt(t time.Time, data interface{}) error
+}
+
+/*
+JournalStore guarda os dados num diretório com dois arquivos:
+  - journal.log: um registro JSON por linha para cada criação, alteração, renomeação ou remoção;
+  - snapshot.json: o estado completo no momento da última compactação.
+
+Na inicialização, o estado é reconstruído lendo o snapshot e reaplicando o log.
+A cada CompactEvery registros, o estado é gravado num novo snapshot e o log recomeça vazio.
+O log antigo não é apagado: ele é arquivado como journal-<seq>.log (seq é o último registro dele),
+e é com os logs arquivados que o ReadAt reconstrói os instantes anteriores ao snapshot.
+Os arquivados não são lidos na inicialização; apagá-los libera espaço, mas o ReadAt deixa de alcançar os instantes deles.
+
+Os dados precisam ser uma lista de objetos com o campo "id" (como a lista de produtos):
+é comparando a lista antiga com a nova, pelo id, que o Write descobre quais registros gravar.
+Por isso o estado inteiro fica em memória (o Each percorre esse estado, veja stream.go), e o snapshot
+é lido e gravado inteiro a cada compactação.
+O último ID atribuído (veja Sequence) vem dos próprios registros de criação do log, e o snapshot o guarda
+para que os IDs removidos antes da compactação continuem contando.
+*/
+type JournalStore struct {
+	Dir          string
+	CompactEvery int
+
+	mu     sync.Mutex
+	state  *journalState
+	seq    int64 // último registro aplicado
+	since  int   // registros no log desde o último snapshot
+	exists bool  // false enquanto nada foi gravado (equivale ao arquivo que ainda não existe)
+
+	logInfo os.FileInfo // usado para perceber que outro processo compactou o log
+	offset  int64       // até onde o log já foi lido
+
+	now func() time.Time
+}
+
+// journalRecord é uma linha do journal.log
+type journalRecord struct {
+	Seq  int64           `json:"seq"`
+	Time time.Time       `json:"time"`
+	Op   string          `json:"op"`
+	ID   json.RawMessage `json:"id"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// journalSnapshot é o conteúdo do snapshot.json
+type journalSnapshot struct {
+	Seq    int64             `json:"seq"`
+	Time   time.Time         `json:"time"`
+	LastID int               `json:"last_id,omitempty"`
+	Data   []json.RawMessage `json:"data"`
+}
+
+// A opção compact_every do DSN troca a quantidade de registros que dispara a compactação
+func init() {
+	Register(JournalScheme, func(path string, opts *Options) (Store, error) {
+		if path == "" {
+			return nil, errors.New("informe o diretório do journal, ex.: journal://data/")
+		}
+		js := NewJournalStore(path)
+		js.CompactEvery = opts.Int("compact_every", js.CompactEvery)
+		if err := opts.Err(); err != nil {
+			return nil, err
+		}
+		return js, nil
+	})
+}
+
+// NewJournalStore cria a store de journal no diretório informado
+func NewJournalStore(dir string) *JournalStore {
+	return &JournalStore{Dir: dir, CompactEvery: DefaultCompactEvery, now: time.Now}
+}
+
+func (js *JournalStore) Read(data interface{}) error {
+	var doc []byte
+	err := js.withLock(func() error {
+		var err error
+		doc, err = js.document()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return decode(doc, data)
+}
+
+func (js *JournalStore) Write(data interface{}) error {
+	return js.Update(func(tx Tx) error {
+		return tx.Write(data)
+	})
+}
+
+// Update aplica fn sobre o estado atual; no commit, gravamos no log apenas o que mudou
+func (js *JournalStore) Update(fn func(tx Tx) error) error {
+	return js.withLock(func() error {
+		t := newTx(js.document())
+		t.lastID = js.state.lastID
+		if err := fn(t); err != nil {
+			return err
+		}
+		if !t.written {
+			return nil
+		}
+
+		records, err := js.diff(t.pending)
+		if err != nil {
+			return err
+		}
+		if err := js.append(records); err != nil {
+			return err
+		}
+		js.state.seen(t.lastID)
+		if js.CompactEvery > 0 && js.since >= js.CompactEvery {
+			return js.compact()
+		}
+		return nil
+	})
+}
+
+// Compact grava o estado atual num novo snapshot e recomeça o log
+func (js *JournalStore) Compact() error {
+	return js.withLock(js.compact)
+}
+
+// ReadAt preenche data com o estado como era no instante t, reaplicando o log até esse instante.
+// A leitura é feita direto dos arquivos e não altera o estado atual.
+// Se t for anterior ao snapshot, partimos do estado vazio e reaplicamos os logs arquivados pelas compactações;
+// se algum deles foi apagado, o histórico tem um buraco e devolvemos ErrHistoryCompacted.
+func (js *JournalStore) ReadAt(t time.Time, data interface{}) error {
+	var doc []byte
+	err := js.withLock(func() error {
+		snap, err := js.readSnapshot()
+		if err != nil {
+			return err
+		}
+
+		state := newJournalState(nil)
+		var seq int64
+		logs := []string{js.logPath()}
+		fromScratch := snap == nil || t.Before(snap.Time)
+		if fromScratch {
+			archived, err := js.archivedLogs()
+			if err != nil {
+				return err
+			}
+			logs = append(archived, logs...)
+		} else {
+			if state, err = stateFromItems(snap.Data); err != nil {
+				return err
+			}
+			seq = snap.Seq
+		}
+
+		for _, name := range logs {
+			_, err := scanLogFile(name, 0, func(rec journalRecord) error {
+				if rec.Seq <= seq || rec.Time.After(t) {
+					return nil
+				}
+				// Reaplicando do estado vazio, os registros precisam vir todos, em sequência
+				if fromScratch && rec.Seq != seq+1 {
+					return ErrHistoryCompacted
+				}
+				seq = rec.Seq
+				return state.apply(rec)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		doc = state.document()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return decode(doc, data)
+}
+
+// withLock segura o mutex do processo e o flock do diretório e garante que o estado em memória está atualizado
+func (js *JournalStore) withLock(fn func() error) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if err := os.MkdirAll(js.Dir, 0755); err != nil {
+		return err
+	}
+	return withFileLock(filepath.Join(js.Dir, journalLockName), func() error {
+		if err := js.refresh(); err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
+// document devolve o estado atual no mesmo formato que o FileStore gravaria
+func (js *JournalStore) document() ([]byte, error) {
+	if !js.exists {
+		return nil, fmt.Errorf("journal em %s vazio: %w", js.Dir, os.ErrNotExist)
+	}
+	return js.state.document(), nil
+}
+
+// refresh lê os registros que outros processos gravaram desde a última leitura.
+// Se o log foi trocado (compactação feita por outro processo), recarregamos tudo
+func (js *JournalStore) refresh() error {
+	info, err := os.Stat(js.logPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if js.state == nil || info == nil || js.logInfo == nil || !os.SameFile(info, js.logInfo) || info.Size() < js.offset {
+		return js.load()
+	}
+	if info.Size() == js.offset {
+		return nil
+	}
+
+	offset, err := js.scanLog(js.offset, js.applyRecord)
+	if err != nil {
+		return err
+	}
+	return js.truncateTail(offset, info)
+}
+
+// load reconstrói o estado a partir do snapshot e do log
+func (js *JournalStore) load() error {
+	js.state = newJournalState(nil)
+	js.seq, js.since, js.offset, js.logInfo, js.exists = 0, 0, 0, nil, false
+
+	snap, err := js.readSnapshot()
+	if err != nil {
+		return err
+	}
+	if snap != nil {
+		if js.state, err = stateFromItems(snap.Data); err != nil {
+			return err
+		}
+		js.state.seen(snap.LastID)
+		js.seq = snap.Seq
+		js.exists = true
+	}
+
+	info, err := os.Stat(js.logPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	js.exists = true
+
+	offset, err := js.scanLog(0, js.applyRecord)
+	if err != nil {
+		return err
+	}
+	return js.truncateTail(offset, info)
+}
+
+// truncateTail descarta o registro incompleto que sobrou no final do log (queda no meio da gravação).
+// Só é chamada com o flock obtido, então nenhum outro processo está escrevendo esse registro
+func (js *JournalStore) truncateTail(offset int64, info os.FileInfo) error {
+	if offset < info.Size() {
+		if err := os.Truncate(js.logPath(), offset); err != nil {
+			return err
+		}
+		var err error
+		if info, err = os.Stat(js.logPath()); err != nil {
+			return err
+		}
+	}
+	js.offset = offset
+	js.logInfo = info
+	return nil
+}
+
+// applyRecord aplica um registro do log no estado em memória.
+// Registros que já estão no snapshot (seq menor ou igual) são ignorados: isso acontece quando
+// o processo cai entre gravar o snapshot e recomeçar o log
+func (js *JournalStore) applyRecord(rec journalRecord) error {
+	if rec.Seq <= js.seq {
+		return nil
+	}
+	if err := js.state.apply(rec); err != nil {
+		return err
+	}
+	js.seq = rec.Seq
+	js.since++
+	return nil
+}
+
+// scanLog lê o log a partir de offset e chama fn para cada registro completo.
+// Devolve a posição logo depois do último registro válido.
+// Um registro incompleto ou ilegível no final do log é tratado como uma gravação interrompida e ignorado
+// (quem chamou decide se trunca o arquivo). Um registro ilegível seguido de registros válidos é corrupção.
+func (js *JournalStore) scanLog(offset int64, fn func(rec journalRecord) error) (int64, error) {
+	return scanLogFile(js.logPath(), offset, fn)
+}
+
+// scanLogFile é o scanLog de um log qualquer (o atual ou um arquivado)
+func scanLogFile(name string, offset int64, fn func(rec journalRecord) error) (int64, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return offset, nil
+	}
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	reader := bufio.NewReader(f)
+	pos := offset
+	var badLine int64 = -1
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			complete := line[len(line)-1] == '\n'
+
+			var rec journalRecord
+			if !complete || json.Unmarshal(line, &rec) != nil {
+				if badLine < 0 {
+					badLine = pos
+				}
+			} else {
+				if badLine >= 0 {
+					return offset, fmt.Errorf("journal corrompido: registro ilegível na posição %d", badLine)
+				}
+				if err := fn(rec); err != nil {
+					return offset, err
+				}
+			}
+			pos += int64(len(line))
+			if badLine < 0 {
+				offset = pos
+			}
+		}
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+	}
+}
+
+// diff compara o estado atual com o novo documento e gera os registros para o log
+func (js *JournalStore) diff(newDoc []byte) ([]journalRecord, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(newDoc, &items); err != nil {
+		return nil, fmt.Errorf("o journal só guarda listas de objetos com id: %w", err)
+	}
+	next, err := stateFromItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	now := js.now()
+	seq := js.seq
+	var records []journalRecord
+	add := func(op string, id string, data json.RawMessage) {
+		seq++
+		records = append(records, journalRecord{Seq: seq, Time: now, Op: op, ID: json.RawMessage(id), Data: data})
+	}
+
+	// Primeiro as remoções, depois criações e alterações na ordem da nova lista:
+	// assim, reaplicar o log reproduz a mesma ordem dos itens
+	for _, id := range js.state.ids {
+		if _, ok := next.index[id]; !ok {
+			add(OpDelete, id, nil)
+		}
+	}
+	for i, id := range next.ids {
+		item := next.items[i]
+		old, ok := js.state.get(id)
+		switch {
+		case !ok:
+			add(OpCreate, id, item)
+		case bytes.Equal(old, item):
+			// nada mudou neste item
+		case onlyNameChanged(old, item):
+			add(OpRename, id, item)
+		default:
+			add(OpUpdate, id, item)
+		}
+	}
+
+	// Mesmo sem alterações, o Write precisa deixar o journal "existindo", como o arquivo faria
+	if len(records) == 0 && !js.exists {
+		return nil, js.touchLog()
+	}
+	return records, nil
+}
+
+// append grava os registros no final do log e os aplica no estado em memória
+func (js *JournalStore) append(records []journalRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	_, statErr := os.Stat(js.logPath())
+	f, err := os.OpenFile(js.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if os.IsNotExist(statErr) {
+		if err := syncDir(js.Dir); err != nil {
+			return err
+		}
+	}
+
+	for _, rec := range records {
+		if err := js.applyRecord(rec); err != nil {
+			return err
+		}
+	}
+	info, err := os.Stat(js.logPath())
+	if err != nil {
+		return err
+	}
+	js.offset = info.Size()
+	js.logInfo = info
+	js.exists = true
+	return nil
+}
+
+// touchLog cria o log vazio
+func (js *JournalStore) touchLog() error {
+	if err := writeFileAtomic(js.logPath(), nil, 0644); err != nil {
+		return err
+	}
+	info, err := os.Stat(js.logPath())
+	if err != nil {
+		return err
+	}
+	js.offset, js.logInfo, js.exists = 0, info, true
+	return nil
+}
+
+// compact grava o snapshot, arquiva o log e começa um vazio.
+// O snapshot é gravado primeiro: se o processo cair antes de o log ser trocado,
+// os registros que já estão no snapshot são ignorados pelo seq na próxima carga
+// (e serão arquivados na próxima compactação, junto com os seguintes)
+func (js *JournalStore) compact() error {
+	snap := journalSnapshot{Seq: js.seq, Time: js.now(), LastID: js.state.lastID, Data: js.state.items}
+	if snap.Data == nil {
+		snap.Data = []json.RawMessage{}
+	}
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(js.snapshotPath(), b, 0644); err != nil {
+		return err
+	}
+	if err := js.archiveLog(); err != nil {
+		return err
+	}
+	if err := js.touchLog(); err != nil {
+		return err
+	}
+	js.since = 0
+	return nil
+}
+
+// archiveLog guarda o log atual como journal-<seq>.log, para o ReadAt; um log vazio não tem o que guardar
+func (js *JournalStore) archiveLog() error {
+	info, err := os.Stat(js.logPath())
+	if os.IsNotExist(err) || err == nil && info.Size() == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	archived := filepath.Join(js.Dir, fmt.Sprintf(journalArchiveFormat, js.seq))
+	if err := os.Rename(js.logPath(), archived); err != nil {
+		return err
+	}
+	return syncDir(js.Dir)
+}
+
+// archivedLogs lista os logs arquivados, do mais antigo para o mais novo (o nome tem o seq com zeros à esquerda)
+func (js *JournalStore) archivedLogs() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(js.Dir, journalArchivePattern))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (js *JournalStore) readSnapshot() (*journalSnapshot, error) {
+	b, err := os.ReadFile(js.snapshotPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap journalSnapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, fmt.Errorf("snapshot do journal corrompido: %w", err)
+	}
+	return &snap, nil
+}
+
+func (js *JournalStore) logPath() string {
+	return filepath.Join(js.Dir, journalLogName)
+}
+
+func (js *JournalStore) snapshotPath() string {
+	return filepath.Join(js.Dir, journalSnapshotName)
+}
+
+// journalState é a lista de itens, na ordem em que aparecem, com um índice por id
+type journalState struct {
+	ids   []string
+	items []json.RawMessage
+	index map[string]int
+
+	// lastID é o maior id numérico que já passou pelo estado, mesmo que o item tenha sido removido
+	lastID int
+}
+
+func newJournalState(items []json.RawMessage) *journalState {
+	return &journalState{items: items, index: map[string]int{}}
+}
+
+func stateFromItems(items []json.RawMessage) (*journalState, error) {
+	s := newJournalState(nil)
+	for _, item := range items {
+		id, err := itemID(item)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := s.index[id]; dup {
+			return nil, fmt.Errorf("id %s repetido na lista", id)
+		}
+		s.put(id, compactJSON(item))
+	}
+	return s, nil
+}
+
+func (s *journalState) get(id string) (json.RawMessage, bool) {
+	i, ok := s.index[id]
+	if !ok {
+		return nil, false
+	}
+	return s.items[i], true
+}
+
+func (s *journalState) put(id string, item json.RawMessage) {
+	if n, err := strconv.Atoi(id); err == nil {
+		s.seen(n)
+	}
+	if i, ok := s.index[id]; ok {
+		s.items[i] = item
+		return
+	}
+	s.index[id] = len(s.items)
+	s.ids = append(s.ids, id)
+	s.items = append(s.items, item)
+}
+
+// seen registra um ID já atribuído; o lastID nunca diminui
+func (s *journalState) seen(id int) {
+	if id > s.lastID {
+		s.lastID = id
+	}
+}
+
+func (s *journalState) remove(id string) {
+	i, ok := s.index[id]
+	if !ok {
+		return
+	}
+	s.ids = append(s.ids[:i], s.ids[i+1:]...)
+	s.items = append(s.items[:i], s.items[i+1:]...)
+	delete(s.index, id)
+	for j := i; j < len(s.ids); j++ {
+		s.index[s.ids[j]] = j
+	}
+}
+
+func (s *journalState) apply(rec journalRecord) error {
+	id := string(compactJSON(rec.ID))
+	switch rec.Op {
+	case OpCreate, OpUpdate, OpRename:
+		s.put(id, compactJSON(rec.Data))
+	case OpDelete:
+		s.remove(id)
+	default:
+		return fmt.Errorf("operação %q desconhecida no journal (seq %d)", rec.Op, rec.Seq)
+	}
+	return nil
+}
+
+// document monta a lista, no mesmo formato indentado do FileStore
+func (s *journalState) document() []byte {
+	items := s.items
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+	b, _ := encode(items)
+	return b
+}
+
+// itemID extrai o campo "id" de um item da lista
+func itemID(item json.RawMessage) (string, error) {
+	var v struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(item, &v); err != nil || len(v.ID) == 0 {
+		return "", fmt.Errorf("o journal só guarda listas de objetos com id")
+	}
+	return string(compactJSON(v.ID)), nil
+}
+
+// onlyNameChanged diz se a única diferença entre os dois objetos é o campo "name"
+func onlyNameChanged(old, item json.RawMessage) bool {
+	var a, b map[string]json.RawMessage
+	if json.Unmarshal(old, &a) != nil || json.Unmarshal(item, &b) != nil {
+		return false
+	}
+	if len(a) != len(b) || bytes.Equal(a["name"], b["name"]) {
+		return false
+	}
+	for k, v := range a {
+		if k == "name" {
+			continue
+		}
+		if !bytes.Equal(v, b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func compactJSON(b []byte) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return b
+	}
+	return buf.Bytes()
+}
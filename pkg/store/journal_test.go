@@ -0,0 +1,217 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type journalItem struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func readItems(t *testing.T, s Store) []journalItem {
+	t.Helper()
+	var items []journalItem
+	if err := s.Read(&items); err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func writeItems(t *testing.T, s Store, items ...journalItem) {
+	t.Helper()
+	if items == nil {
+		items = []journalItem{}
+	}
+	if err := s.Write(items); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalReplaysLogOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJournalStore(dir)
+
+	if err := js.Read(&[]journalItem{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Read de um journal vazio = %v, esperado os.ErrNotExist", err)
+	}
+
+	writeItems(t, js, journalItem{1, "Bolo"})
+	writeItems(t, js, journalItem{1, "Bolo"}, journalItem{2, "Café"})
+	writeItems(t, js, journalItem{1, "Torta"}, journalItem{2, "Café"}, journalItem{3, "Chá"})
+	writeItems(t, js, journalItem{1, "Torta"}, journalItem{3, "Chá"})
+
+	// Um novo JournalStore (outro processo, ou o mesmo depois de reiniciar) reconstrói o estado pelo log
+	got := readItems(t, NewJournalStore(dir))
+	want := []journalItem{{1, "Torta"}, {3, "Chá"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("depois de reabrir, o journal tem %v, esperado %v", got, want)
+	}
+
+	// Só o que mudou vai para o log: criação, criação, renomeação + criação e remoção
+	var records int
+	if _, err := js.scanLog(0, func(journalRecord) error { records++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if records != 5 {
+		t.Fatalf("o log tem %d registros, esperado 5", records)
+	}
+}
+
+func TestJournalCompactionKeepsHistory(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	js := NewJournalStore(dir)
+	js.CompactEvery = 2
+	now := start
+	js.now = func() time.Time { return now }
+
+	// Cada Write grava um registro, um minuto depois do anterior: os registros 2 e 4 disparam compactações
+	versions := [][]journalItem{
+		{{1, "v1"}},
+		{{1, "v2"}},
+		{{1, "v3"}},
+		{{1, "v4"}},
+		{{1, "v5"}},
+	}
+	for _, items := range versions {
+		now = now.Add(time.Minute)
+		writeItems(t, js, items...)
+	}
+
+	archived, err := js.archivedLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != 2 {
+		t.Fatalf("%d logs arquivados, esperado 2", len(archived))
+	}
+	if snap, err := js.readSnapshot(); err != nil || snap == nil || snap.Seq != 4 {
+		t.Fatalf("snapshot = %+v, %v; esperado o seq 4", snap, err)
+	}
+
+	reopened := NewJournalStore(dir)
+	if got := readItems(t, reopened); fmt.Sprint(got) != fmt.Sprint(versions[4]) {
+		t.Fatalf("depois de reabrir, o journal tem %v, esperado %v", got, versions[4])
+	}
+
+	// Os instantes anteriores ao snapshot continuam alcançáveis pelos logs arquivados
+	for i, want := range versions {
+		var got []journalItem
+		at := start.Add(time.Duration(i+1) * time.Minute)
+		if err := reopened.ReadAt(at, &got); err != nil {
+			t.Fatalf("ReadAt(%s): %v", at, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("ReadAt(%s) = %v, esperado %v", at, got, want)
+		}
+	}
+	var before []journalItem
+	if err := reopened.ReadAt(start, &before); err != nil || len(before) != 0 {
+		t.Fatalf("ReadAt antes do primeiro registro = %v, %v; esperado a lista vazia", before, err)
+	}
+
+	// Sem o primeiro log arquivado, os instantes dele não podem mais ser reconstruídos
+	if err := os.Remove(archived[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.ReadAt(start.Add(3*time.Minute), &before); !errors.Is(err, ErrHistoryCompacted) {
+		t.Fatalf("ReadAt sem o log arquivado = %v, esperado ErrHistoryCompacted", err)
+	}
+	// Depois do snapshot, o log arquivado não faz falta
+	var latest []journalItem
+	if err := reopened.ReadAt(start.Add(5*time.Minute), &latest); err != nil || fmt.Sprint(latest) != fmt.Sprint(versions[4]) {
+		t.Fatalf("ReadAt depois do snapshot = %v, %v", latest, err)
+	}
+}
+
+func TestJournalTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	writeItems(t, NewJournalStore(dir), journalItem{1, "Bolo"})
+	writeItems(t, NewJournalStore(dir), journalItem{1, "Bolo"}, journalItem{2, "Café"})
+
+	logPath := filepath.Join(dir, journalLogName)
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queda no meio da gravação: só um pedaço do registro chegou ao disco
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"seq":3,"time":"2023-06-01T10:00:00Z","op":"create","id":3,"da`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	js := NewJournalStore(dir)
+	want := []journalItem{{1, "Bolo"}, {2, "Café"}}
+	if got := readItems(t, js); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("com o registro incompleto, o journal tem %v, esperado %v", got, want)
+	}
+	after, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != info.Size() {
+		t.Fatalf("o log tem %d bytes, esperado %d: o registro incompleto não foi descartado", after.Size(), info.Size())
+	}
+
+	// As próximas gravações continuam depois do último registro válido
+	writeItems(t, js, journalItem{1, "Bolo"}, journalItem{2, "Café"}, journalItem{3, "Chá"})
+	if got := readItems(t, NewJournalStore(dir)); len(got) != 3 || got[2].Name != "Chá" {
+		t.Fatalf("depois de gravar de novo, o journal tem %v", got)
+	}
+}
+
+func TestJournalCorruptedMiddle(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJournalStore(dir)
+	writeItems(t, js, journalItem{1, "Bolo"})
+
+	// Um registro ilegível seguido de registros válidos não é uma gravação interrompida: é corrupção
+	logPath := filepath.Join(dir, journalLogName)
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("lixo\n" + `{"seq":2,"time":"2023-06-01T10:00:00Z","op":"create","id":2,"data":{"id":2,"name":"Café"}}` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := NewJournalStore(dir).Read(&[]journalItem{}); err == nil {
+		t.Fatal("Read de um journal corrompido no meio não devolveu erro")
+	}
+}
+
+// O último ID atribuído continua valendo depois que o item é removido, da compactação e da reabertura
+func TestJournalKeepsLastID(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJournalStore(dir)
+	js.CompactEvery = 3
+
+	writeItems(t, js, journalItem{1, "Bolo"}, journalItem{2, "Café"})
+	writeItems(t, js, journalItem{1, "Bolo"})
+	for _, s := range []Store{js, NewJournalStore(dir)} {
+		err := s.Update(func(tx Tx) error {
+			if got := tx.(Sequence).LastID(); got != 2 {
+				t.Fatalf("LastID = %d, esperado 2", got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if snap, err := js.readSnapshot(); err != nil || snap == nil || snap.LastID != 2 {
+		t.Fatalf("snapshot = %+v, %v; esperado o last_id 2", snap, err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+// Nas plataformas sem flock contamos apenas com o mutex do processo
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
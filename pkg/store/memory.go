@@ -0,0 +1,121 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// MemoryStore guarda os dados em memória, já serializados em JSON.
+// Assim como no arquivo, cada Read devolve uma cópia nova e cada Write passa pelo JSON:
+// quem usa a store em memória (testes, instâncias de demonstração) enxerga o mesmo comportamento do arquivo.
+// Os dados somem quando o processo termina.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data []byte
+
+	// lastID é o último ID atribuído (veja Sequence)
+	lastID int
+
+	// Migrations são as migrations da massa de dados, como as da FileStore (veja FileStore.Migrations).
+	// A massa de dados é lida na criação da store e só passa pelas migrations no primeiro acesso
+	Migrations Migrations
+
+	// seed é o conteúdo do arquivo da massa de dados, até o primeiro acesso
+	seed     []byte
+	seedName string
+}
+
+// NewMemoryStore cria a store em memória; seed é opcional e deve conter os dados iniciais em JSON (a lista, sem envelope).
+// Para começar com o conteúdo de um arquivo da FileStore, use o DSN "mem://?seed=products.json"
+func NewMemoryStore(seed []byte) *MemoryStore {
+	ms := &MemoryStore{}
+	if len(seed) > 0 {
+		ms.data = append([]byte(nil), seed...)
+	}
+	return ms
+}
+
+// MemoryScheme é o esquema do DSN da store em memória, ex.: "mem://?seed=products.json"
+const MemoryScheme = "mem"
+
+// A opção seed é opcional e aponta o arquivo usado como massa de dados inicial ("mem://products.json" também vale)
+func init() {
+	Register(MemoryScheme, func(path string, opts *Options) (Store, error) {
+		seed := opts.String("seed", path)
+		if err := opts.Err(); err != nil {
+			return nil, err
+		}
+		return newMemoryStoreFromFile(seed)
+	})
+}
+
+/*
+newMemoryStoreFromFile cria a store em memória usando um arquivo como massa de dados inicial.
+O arquivo é aberto como na FileStore: no formato da extensão e, no primeiro acesso, migrado para o schema atual
+*/
+func newMemoryStoreFromFile(fileName string) (*MemoryStore, error) {
+	if fileName == "" {
+		return NewMemoryStore(nil), nil
+	}
+	raw, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	// Um arquivo que não pode ser lido é recusado já na criação; as migrations ficam para o primeiro acesso
+	env, _, err := decodeEnvelope(CodecForFile(fileName), nil, raw)
+	if err != nil {
+		return nil, fmt.Errorf("a massa de dados %s não pode ser lida: %w", fileName, err)
+	}
+	return &MemoryStore{lastID: env.Metadata.LastID, seed: raw, seedName: fileName}, nil
+}
+
+func (ms *MemoryStore) Read(data interface{}) error {
+	ms.mu.Lock()
+	snapshot, err := ms.snapshot()
+	ms.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return decode(snapshot, data)
+}
+
+func (ms *MemoryStore) Write(data interface{}) error {
+	return ms.Update(func(tx Tx) error {
+		return tx.Write(data)
+	})
+}
+
+// Update segue as mesmas regras do arquivo: o mutex garante exclusividade
+// e o conteúdo só é trocado se fn devolver nil
+func (ms *MemoryStore) Update(fn func(tx Tx) error) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	t := newTx(ms.snapshot())
+	t.lastID = ms.lastID
+	if err := fn(t); err != nil {
+		return err
+	}
+	if t.written {
+		ms.data, ms.lastID, ms.seed = t.pending, t.lastID, nil
+	}
+	return nil
+}
+
+// snapshot devolve o conteúdo atual; sem nada gravado, se comporta como um arquivo que ainda não existe.
+// No primeiro acesso, a massa de dados passa pelas migrations
+func (ms *MemoryStore) snapshot() ([]byte, error) {
+	if ms.seed != nil {
+		env, _, err := openEnvelope(CodecForFile(ms.seedName), ms.Migrations, ms.seed)
+		if err != nil {
+			return nil, fmt.Errorf("a massa de dados %s não pode ser lida: %w", ms.seedName, err)
+		}
+		ms.data, ms.seed = env.Data, nil
+	}
+	if ms.data == nil {
+		return nil, fmt.Errorf("store em memória vazia: %w", os.ErrNotExist)
+	}
+	return ms.data, nil
+}
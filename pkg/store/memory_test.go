@@ -0,0 +1,74 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A massa de dados da store em memória é aberta como o arquivo da FileStore: envelope, formatos e schema antigo
+func TestMemoryStoreSeedFromFile(t *testing.T) {
+	want := []journalItem{{1, "Bolo"}, {2, "Café"}}
+	for _, c := range codecs {
+		c := c
+		t.Run(c.Name(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "products."+c.Name())
+			fs := &FileStore{FileName: path, Codec: c}
+			writeItems(t, fs, want...)
+
+			ms, err := Open("mem://?seed=" + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readItems(t, ms); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("Read = %v, esperado %v", got, want)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	// A lista sem envelope (versão 1) também serve de massa de dados
+	legacy := filepath.Join(dir, "legacy.json")
+	if err := os.WriteFile(legacy, []byte(`[{"id": 1, "name": "Bolo"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := Open("mem://" + legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readItems(t, ms); len(got) != 1 || got[0].Name != "Bolo" {
+		t.Fatalf("Read da lista sem envelope = %v", got)
+	}
+
+	// O último ID atribuído vem junto com os dados
+	ids := &FileStore{FileName: filepath.Join(dir, "ids.json")}
+	if err := ids.Update(func(tx Tx) error {
+		tx.(Sequence).SetLastID(9)
+		return tx.Write([]journalItem{{1, "Bolo"}})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	seeded, err := newMemoryStoreFromFile(ids.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := seeded.Update(func(tx Tx) error {
+		if got := tx.(Sequence).LastID(); got != 9 {
+			t.Errorf("LastID = %d, esperado 9", got)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Uma massa de dados que não pode ser lida é recusada
+	truncated := filepath.Join(dir, "truncated.json")
+	if err := os.WriteFile(truncated, truncate(sealed(t, 1, 2, 3)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open("mem://?seed=" + truncated); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("Open com a massa de dados truncada = %v, esperado ErrCorrupted", err)
+	}
+}
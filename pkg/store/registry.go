@@ -0,0 +1,146 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opener abre uma store a partir do caminho e das opções de um DSN
+type Opener func(path string, opts *Options) (Store, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Opener{}
+)
+
+// Register registra uma store pelo esquema do DSN (ex.: "file" para "file://products.json").
+// Cada store se registra no init do seu próprio arquivo
+func Register(scheme string, open Opener) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, dup := registry[scheme]; dup {
+		panic(fmt.Sprintf("store: esquema %q registrado duas vezes", scheme))
+	}
+	registry[scheme] = open
+}
+
+// Schemes devolve os esquemas registrados, em ordem alfabética
+func Schemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	schemes := make([]string, 0, len(registry))
+	for s := range registry {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+/*
+Open abre a store descrita pelo DSN, no formato "esquema://caminho?opcao=valor&...". Exemplos:
+
+	file://products.json?backup_max_count=10
+	mem://?seed=products.json
+	sqlite://catalog.db?busy_timeout=5000
+	journal://data/?compact_every=100
+
+Esquemas desconhecidos e opções que a store não reconhece são erros (nunca devolvemos uma store nil).
+*/
+func Open(dsn string) (Store, error) {
+	scheme, rest, ok := strings.Cut(dsn, "://")
+	if !ok || scheme == "" {
+		return nil, fmt.Errorf("DSN %q inválido: use o formato esquema://caminho?opções (esquemas: %s)",
+			dsn, strings.Join(Schemes(), ", "))
+	}
+	path, query, _ := strings.Cut(rest, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("DSN %q: opções inválidas: %w", dsn, err)
+	}
+
+	registryMu.RLock()
+	open, ok := registry[scheme]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("store %q desconhecida no DSN %q (esquemas registrados: %s)",
+			scheme, dsn, strings.Join(Schemes(), ", "))
+	}
+
+	s, err := open(path, &Options{scheme: scheme, values: values, used: map[string]bool{}})
+	if err != nil {
+		return nil, fmt.Errorf("DSN %q: %w", dsn, err)
+	}
+	return s, nil
+}
+
+// Options são as opções de um DSN. Os métodos convertem cada opção para o tipo esperado
+// e acumulam os erros, que Err devolve junto com as opções que nenhuma leitura usou
+type Options struct {
+	scheme string
+	values url.Values
+	used   map[string]bool
+	errs   []string
+}
+
+// String devolve a opção como texto, ou def quando ela não foi informada
+func (o *Options) String(key, def string) string {
+	o.used[key] = true
+	if !o.values.Has(key) {
+		return def
+	}
+	return o.values.Get(key)
+}
+
+// Int devolve a opção como inteiro
+func (o *Options) Int(key string, def int) int {
+	v := o.String(key, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		o.errs = append(o.errs, fmt.Sprintf("%s=%q não é um número inteiro", key, v))
+		return def
+	}
+	return n
+}
+
+// Duration devolve a opção como duração (ex.: "30s", "720h")
+func (o *Options) Duration(key string, def time.Duration) time.Duration {
+	v := o.String(key, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		o.errs = append(o.errs, fmt.Sprintf("%s=%q não é uma duração (ex.: 30s, 720h)", key, v))
+		return def
+	}
+	return d
+}
+
+// Err devolve os erros de conversão e as opções desconhecidas; as stores chamam depois de ler todas as opções
+func (o *Options) Err() error {
+	errs := append([]string(nil), o.errs...)
+	var unknown []string
+	for key := range o.values {
+		if !o.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, fmt.Sprintf("opção %q desconhecida para a store %s", key, o.scheme))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(errs, "; "))
+}
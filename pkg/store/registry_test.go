@@ -0,0 +1,107 @@
+package store
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenParsesOptions(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open("file://" + filepath.Join(dir, "products.yaml") + "?backup_dir=none&backup_max_count=3&backup_max_age=48h&backup_minor_interval=1m&watch_interval=0s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, ok := s.(*FileStore)
+	if !ok {
+		t.Fatalf("Open devolveu %T, esperado *FileStore", s)
+	}
+	if fs.Backups.Dir != "" || fs.Backups.MaxCount != 3 || fs.Backups.MaxAge != 48*time.Hour || fs.Backups.MinorInterval != time.Minute || fs.WatchInterval != 0 {
+		t.Fatalf("opções lidas = %+v, watch_interval = %v", fs.Backups, fs.WatchInterval)
+	}
+	if fs.codec().Name() != FormatYAML {
+		t.Fatalf("formato = %s, esperado o da extensão (yaml)", fs.codec().Name())
+	}
+
+	s, err = Open("file://" + filepath.Join(dir, "products.yaml") + "?format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := s.(*FileStore).codec().Name(); name != FormatCSV {
+		t.Fatalf("com format=csv, formato = %s", name)
+	}
+
+	if s, err := Open("mem://"); err != nil || s == nil {
+		t.Fatalf("Open(mem://) = %v, %v", s, err)
+	}
+}
+
+// Cada erro de DSN devolve uma mensagem que aponta o problema, e nunca uma store nil sem erro
+func TestOpenRejectsInvalidDSN(t *testing.T) {
+	dir := t.TempDir()
+	file := "file://" + filepath.Join(dir, "products.json")
+	cases := []struct {
+		name, dsn, want string
+	}{
+		{"sem esquema", "products.json", "inválido"},
+		{"esquema vazio", "://products.json", "inválido"},
+		{"esquema desconhecido", "redis://localhost", `store "redis" desconhecida`},
+		{"query malformada", file + "?backup_max_count=%zz", "opções inválidas"},
+		{"arquivo sem caminho", "file://", "caminho do arquivo"},
+		{"opção desconhecida no arquivo", file + "?backup_max=3", `opção "backup_max" desconhecida para a store file`},
+		{"opção desconhecida na memória", "mem://?seed_file=x.json", `opção "seed_file" desconhecida para a store mem`},
+		{"opção desconhecida no journal", "journal://" + dir + "?compact=10", `opção "compact" desconhecida para a store journal`},
+		{"opção desconhecida no sqlite", "sqlite://" + filepath.Join(dir, "c.db") + "?timeout=1", `opção "timeout" desconhecida para a store sqlite`},
+		{"inteiro inválido", file + "?backup_max_count=dez", `backup_max_count="dez" não é um número inteiro`},
+		{"duração inválida", file + "?backup_max_age=30dias", `backup_max_age="30dias" não é uma duração`},
+		{"formato desconhecido", file + "?format=xml", `formato "xml" desconhecido`},
+		{"inteiro inválido no journal", "journal://" + dir + "?compact_every=muitos", "compact_every"},
+		{"modo inválido no sqlite", "sqlite://" + filepath.Join(dir, "c.db") + "?journal_mode=fast", "journal_mode"},
+		{"massa de dados inexistente", "mem://?seed=" + filepath.Join(dir, "nada.json"), "nada.json"},
+	}
+	for _, c := range cases {
+		s, err := Open(c.dsn)
+		if err == nil {
+			t.Errorf("%s: Open(%q) = %T, esperado erro", c.name, c.dsn, s)
+			continue
+		}
+		if s != nil {
+			t.Errorf("%s: Open(%q) devolveu uma store junto com o erro", c.name, c.dsn)
+		}
+		if !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: Open(%q) = %q, esperado um erro com %q", c.name, c.dsn, err, c.want)
+		}
+	}
+}
+
+// Todos os erros de opções são devolvidos juntos, e as opções desconhecidas em ordem alfabética
+func TestOptionsErrCollectsAll(t *testing.T) {
+	_, err := Open("file://products.json?zeta=1&alpha=2&backup_max_count=x")
+	if err == nil {
+		t.Fatal("Open aceitou as opções inválidas")
+	}
+	msg := err.Error()
+	count, alpha, zeta := strings.Index(msg, "backup_max_count"), strings.Index(msg, `"alpha"`), strings.Index(msg, `"zeta"`)
+	if count < 0 || alpha < 0 || zeta < 0 || !(alpha < zeta) {
+		t.Fatalf("erro = %q, esperado o backup_max_count e as opções alpha e zeta, nessa ordem", msg)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registrar o mesmo esquema duas vezes não entrou em pânico")
+		}
+	}()
+	Register(FileScheme, func(string, *Options) (Store, error) { return nil, nil })
+}
+
+func TestSchemesListsBuiltins(t *testing.T) {
+	got := strings.Join(Schemes(), ",")
+	for _, scheme := range []string{FileScheme, MemoryScheme, JournalScheme, SQLiteScheme} {
+		if !strings.Contains(got, scheme) {
+			t.Errorf("Schemes() = %s, sem o esquema %s", got, scheme)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultQuarantineDir é a pasta, ao lado do arquivo, onde o repair guarda o arquivo danificado e o relatório
+const DefaultQuarantineDir = "quarantine"
+
+// Report é o resultado do Verify e do Repair
+type Report struct {
+	File      string    `json:"file"`
+	CheckedAt time.Time `json:"checked_at"`
+	OK        bool      `json:"ok"`
+	// Problem é o motivo pelo qual o arquivo não pode ser lido
+	Problem string `json:"problem,omitempty"`
+	// Edited diz que o arquivo, válido, foi editado fora da store: os dados não conferem com o checksum gravado.
+	// Ele é lido normalmente, e a próxima gravação grava o checksum novo
+	Edited bool `json:"edited,omitempty"`
+	// Salvaged é a quantidade de registros lidos (num arquivo danificado, os que o repair recupera)
+	Salvaged int `json:"salvaged"`
+	// Lost são os registros que não puderam ser lidos e ficam apenas na quarentena
+	Lost []LostRecord `json:"lost"`
+	// Quarantine é a cópia do arquivo danificado guardada pelo repair
+	Quarantine string `json:"quarantine,omitempty"`
+}
+
+// LostRecord é um registro que não pôde ser recuperado.
+// Position é o número do registro na lista (a partir de 1) ou, nos formatos de linha, o número da linha
+type LostRecord struct {
+	Position int    `json:"position"`
+	Reason   string `json:"reason"`
+	Raw      string `json:"raw"`
+}
+
+// ErrNotRepairable é devolvido pelo Repair quando a lista de dados não foi encontrada no arquivo danificado
+// (ex.: o conteúdo criptografado, que não é uma lista): gravar uma lista vazia no lugar apagaria tudo
+var ErrNotRepairable = errors.New("a lista de dados não foi encontrada; o arquivo não foi alterado (restaure um backup)")
+
+// salvager é implementada pelos codecs que conseguem ler, registro a registro, um arquivo danificado.
+// Devolve a versão do schema (zero quando não foi possível descobrir), os registros lidos (nil quando
+// a lista de dados não foi encontrada) e os perdidos
+type salvager interface {
+	salvage(raw []byte) (version int, records []json.RawMessage, lost []LostRecord)
+}
+
+// Verify confere se o arquivo pode ser lido e se os dados conferem com o checksum (veja Report.Edited).
+// Num arquivo danificado, o relatório diz o que o Repair conseguiria recuperar; nada é alterado
+func (fs *FileStore) Verify() (Report, error) {
+	raw, err := os.ReadFile(fs.FileName)
+	if err != nil {
+		return Report{}, err
+	}
+	report, _, _ := fs.inspect(raw)
+	return report, nil
+}
+
+/*
+Repair recupera um arquivo danificado:
+  - o arquivo como está é copiado para a quarentena (DefaultQuarantineDir, ao lado do arquivo), junto com o relatório;
+  - todos os registros que ainda podem ser lidos são migrados para o schema atual e gravados no lugar do arquivo.
+
+Os registros que não puderam ser lidos aparecem em Report.Lost e continuam na cópia da quarentena.
+Com o arquivo em ordem (inclusive editado fora da store), nada é feito. Se a lista de dados não for encontrada,
+devolvemos ErrNotRepairable sem mexer no arquivo.
+*/
+func (fs *FileStore) Repair() (Report, error) {
+	var report Report
+	err := fs.withLock(func() error {
+		raw, err := os.ReadFile(fs.FileName)
+		if err != nil {
+			return err
+		}
+		var env Envelope
+		var found bool
+		report, env, found = fs.inspect(raw)
+		if report.OK {
+			return nil
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", ErrNotRepairable, report.Problem)
+		}
+		if err := migrate(&env, fs.Migrations); err != nil {
+			return err
+		}
+
+		report.Quarantine, err = fs.quarantine(raw, report)
+		if err != nil {
+			return fmt.Errorf("não foi possível guardar o arquivo na quarentena: %w", err)
+		}
+		if err := fs.replace(env.Data, maxInt(fs.lastID(), env.Metadata.LastID), false); err != nil {
+			return err
+		}
+		log.Printf("evento=catalogo_reparado arquivo=%s recuperados=%d perdidos=%d quarentena=%s",
+			fs.FileName, report.Salvaged, len(report.Lost), report.Quarantine)
+		return nil
+	})
+	return report, err
+}
+
+// inspect lê o arquivo e, se ele estiver danificado, recupera o que for possível.
+// Devolve o relatório, o envelope com os registros recuperados (ainda na versão original do schema)
+// e se a lista de dados foi encontrada
+func (fs *FileStore) inspect(raw []byte) (Report, Envelope, bool) {
+	report := Report{File: fs.FileName, CheckedAt: time.Now().UTC(), Lost: []LostRecord{}}
+
+	env, edited, err := decodeEnvelope(fs.codec(), fs.Migrations, raw)
+	if err == nil {
+		// As migrations também precisam conseguir ler os dados; o envelope devolvido continua na versão original
+		migrated := env
+		err = migrate(&migrated, fs.Migrations)
+	}
+	if err == nil {
+		var items []json.RawMessage
+		if json.Unmarshal(env.Data, &items) == nil {
+			report.Salvaged = len(items)
+		}
+		report.OK, report.Edited = true, edited
+		return report, env, true
+	}
+	report.Problem = err.Error()
+
+	var version int
+	var records []json.RawMessage
+	var lost []LostRecord
+	if s, ok := fs.codec().(salvager); ok {
+		version, records, lost = s.salvage(raw)
+	}
+	if version == 0 {
+		version = fs.Migrations.Version()
+	}
+	data, _ := json.Marshal(records)
+
+	report.Salvaged = len(records)
+	report.Lost = append(report.Lost, lost...)
+	return report, Envelope{SchemaVersion: version, Data: data}, records != nil
+}
+
+// quarantine guarda o arquivo danificado e o relatório, ex.: "quarantine/products-20230601T100000.000000000Z.json"
+func (fs *FileStore) quarantine(raw []byte, report Report) (string, error) {
+	dir := filepath.Join(filepath.Dir(fs.FileName), DefaultQuarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	stem, ext := fs.backupStem()
+	name := filepath.Join(dir, stem+"-"+time.Now().UTC().Format(backupTimeFormat))
+	if err := writeFileAtomic(name+ext, raw, 0644); err != nil {
+		return "", err
+	}
+
+	report.Quarantine = name + ext
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(name+".report.json", b, 0644); err != nil {
+		return "", err
+	}
+	return name + ext, nil
+}
+
+// salvageRecord confere um registro recuperado: só aceitamos objetos JSON completos
+func salvageRecord(position int, raw []byte, records *[]json.RawMessage, lost *[]LostRecord) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		reason := "JSON inválido: " + err.Error()
+		if json.Valid(raw) {
+			reason = "o registro não é um objeto"
+		}
+		*lost = append(*lost, LostRecord{Position: position, Reason: reason, Raw: string(raw)})
+		return
+	}
+	*records = append(*records, append(json.RawMessage(nil), raw...))
+}
+
+var schemaVersionPattern = regexp.MustCompile(`"schema_version"\s*:\s*(\d+)`)
+
+// salvage procura a lista de dados (a lista "pura" ou o campo "data" do envelope) e lê um registro por vez
+func (jsonCodec) salvage(raw []byte) (int, []json.RawMessage, []LostRecord) {
+	var lost []LostRecord
+
+	version, start := 0, -1
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		version, start = 1, bytes.IndexByte(raw, '[')
+	} else {
+		if m := schemaVersionPattern.FindSubmatch(raw); m != nil {
+			fmt.Sscan(string(m[1]), &version)
+		}
+		if i := bytes.Index(raw, []byte(`"data"`)); i >= 0 {
+			rest := bytes.TrimLeft(raw[i+len(`"data"`):], " \t\r\n:")
+			if len(rest) > 0 && rest[0] == '[' {
+				start = len(raw) - len(rest)
+			}
+		}
+	}
+	if start < 0 {
+		lost = append(lost, LostRecord{Position: 0, Reason: "a lista de dados não foi encontrada no arquivo", Raw: string(raw)})
+		return version, nil, lost
+	}
+
+	records := []json.RawMessage{}
+	for i, value := range scanList(raw[start+1:]) {
+		salvageRecord(i+1, value, &records, &lost)
+	}
+	return version, records, lost
+}
+
+// scanList separa os valores de uma lista JSON (b começa logo depois do '['), sem exigir que a lista seja válida.
+// Um valor termina quando fecha o objeto em que começou, ou numa vírgula; a lista termina no ']' ou no fim do arquivo.
+// Textos não podem ter quebras de linha no JSON, então uma quebra de linha encerra um texto sem aspas de fechamento
+func scanList(b []byte) [][]byte {
+	var values [][]byte
+	i := 0
+	for i < len(b) {
+		c := b[i]
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ',' {
+			i++
+			continue
+		}
+		if c == ']' {
+			break
+		}
+
+		start, depth, inStr, esc := i, 0, false, false
+	value:
+		for ; i < len(b); i++ {
+			c := b[i]
+			if inStr {
+				switch {
+				case esc:
+					esc = false
+				case c == '\\':
+					esc = true
+				case c == '"' || c == '\n':
+					inStr = false
+				}
+				continue
+			}
+			switch c {
+			case '"':
+				inStr = true
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth == 0 {
+					break value
+				}
+				depth--
+				if depth == 0 {
+					i++
+					break value
+				}
+			case ',':
+				if depth == 0 {
+					break value
+				}
+			}
+		}
+		if i == start {
+			// Um '}' solto: vira um registro inválido e seguimos em frente
+			i++
+		}
+		values = append(values, bytes.TrimSpace(b[start:i]))
+	}
+	return values
+}
+
+// salvage lê o YAML como um todo: se ele não abre, não há como separar os registros
+func (yamlCodec) salvage(raw []byte) (int, []json.RawMessage, []LostRecord) {
+	var lost []LostRecord
+
+	var doc struct {
+		SchemaVersion int           `yaml:"schema_version"`
+		Data          []interface{} `yaml:"data"`
+	}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		lost = append(lost, LostRecord{Position: 0, Reason: "YAML inválido: " + err.Error(), Raw: string(raw)})
+		return 0, nil, lost
+	}
+	records := []json.RawMessage{}
+	for i, item := range doc.Data {
+		b, err := json.Marshal(item)
+		if err != nil {
+			lost = append(lost, LostRecord{Position: i + 1, Reason: err.Error(), Raw: fmt.Sprint(item)})
+			continue
+		}
+		salvageRecord(i+1, b, &records, &lost)
+	}
+	return doc.SchemaVersion, records, lost
+}
+
+// salvage lê linha a linha; a primeira linha pode ser o cabeçalho com o schema_version
+func (ndjsonCodec) salvage(raw []byte) (int, []json.RawMessage, []LostRecord) {
+	records, lost := []json.RawMessage{}, []LostRecord{}
+	version := 0
+
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if n == 1 && isHeader(line) {
+			var h envelopeHeader
+			if json.Unmarshal(line, &h) == nil {
+				version = h.SchemaVersion
+			}
+			continue
+		}
+		salvageRecord(n, line, &records, &lost)
+	}
+	if err := sc.Err(); err != nil {
+		lost = append(lost, LostRecord{Reason: "leitura interrompida: " + err.Error()})
+	}
+	return version, records, lost
+}
+
+// salvage lê linha a linha da planilha; linhas com colunas a mais ou a menos são perdidas
+func (csvCodec) salvage(raw []byte) (int, []json.RawMessage, []LostRecord) {
+	records, lost := []json.RawMessage{}, []LostRecord{}
+
+	var env Envelope
+	offset := 0
+	if bytes.HasPrefix(raw, []byte("#")) {
+		line, rest, _ := bytes.Cut(raw, []byte("\n"))
+		parseCSVHeader(string(line), &env)
+		raw, offset = rest, 1
+	}
+
+	r := csv.NewReader(bytes.NewReader(raw))
+	r.FieldsPerRecord = -1
+	columns, err := r.Read()
+	if err != nil {
+		lost = append(lost, LostRecord{Position: 1 + offset, Reason: "cabeçalho da planilha ilegível", Raw: string(raw)})
+		return env.SchemaVersion, records, lost
+	}
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			lost = append(lost, LostRecord{Position: perr.StartLine + offset, Reason: perr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			lost = append(lost, LostRecord{Reason: "leitura interrompida: " + err.Error()})
+			break
+		}
+		line, _ := r.FieldPos(0)
+		line += offset
+		if len(row) != len(columns) {
+			lost = append(lost, LostRecord{
+				Position: line,
+				Reason:   fmt.Sprintf("a linha tem %d colunas, o cabeçalho tem %d", len(row), len(columns)),
+				Raw:      csvLine(row),
+			})
+			continue
+		}
+
+		obj := map[string]json.RawMessage{}
+		for i, cell := range row {
+			if cell != "" {
+				obj[columns[i]] = csvValue(cell)
+			}
+		}
+		b, err := json.Marshal(obj)
+		if err != nil {
+			lost = append(lost, LostRecord{Position: line, Reason: err.Error(), Raw: csvLine(row)})
+			continue
+		}
+		salvageRecord(line, b, &records, &lost)
+	}
+	return env.SchemaVersion, records, lost
+}
+
+// csvLine monta de volta a linha da planilha, para o relatório
+func csvLine(row []string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(row)
+	w.Flush()
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
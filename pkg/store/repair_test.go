@@ -0,0 +1,127 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// damaged grava três itens e quebra o segundo (o texto perde as aspas de fechamento), como num arquivo editado pela metade
+func damaged(t *testing.T, fs *FileStore) []byte {
+	t.Helper()
+	if err := fs.Update(func(tx Tx) error {
+		tx.(Sequence).SetLastID(5)
+		return tx.Write([]journalItem{{1, "Bolo"}, {2, "Café"}, {3, "Chá"}})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(fs.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = bytes.Replace(raw, []byte(`"Café"`), []byte(`"Café`), 1)
+	if err := os.WriteFile(fs.FileName, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestVerify(t *testing.T) {
+	fs := &FileStore{FileName: filepath.Join(t.TempDir(), "products.json")}
+	writeItems(t, fs, journalItem{1, "Bolo"}, journalItem{2, "Café"})
+	report, err := fs.Verify()
+	if err != nil || !report.OK || report.Edited || report.Salvaged != 2 {
+		t.Fatalf("Verify do arquivo gravado pela store = %+v, %v", report, err)
+	}
+
+	// Editado à mão: o arquivo está em ordem, mas o relatório diz que o checksum não confere
+	raw, err := os.ReadFile(fs.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fs.FileName, bytes.Replace(raw, []byte("Bolo"), []byte("Torta"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := fs.Verify(); err != nil || !report.OK || !report.Edited {
+		t.Fatalf("Verify do arquivo editado à mão = %+v, %v; esperado OK e editado", report, err)
+	}
+
+	// Danificado: o relatório diz o que o Repair recuperaria, sem mexer no arquivo
+	raw = damaged(t, fs)
+	report, err = fs.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK || report.Problem == "" || report.Salvaged != 2 || len(report.Lost) != 1 || report.Lost[0].Position != 2 {
+		t.Fatalf("Verify do arquivo danificado = %+v", report)
+	}
+	if after, _ := os.ReadFile(fs.FileName); !bytes.Equal(after, raw) {
+		t.Fatal("o Verify alterou o arquivo")
+	}
+}
+
+// O Repair guarda o arquivo danificado na quarentena e grava no lugar os registros que ainda podem ser lidos
+func TestRepairSalvagesRecords(t *testing.T) {
+	dir := t.TempDir()
+	fs := &FileStore{FileName: filepath.Join(dir, "products.json")}
+	raw := damaged(t, fs)
+
+	report, err := fs.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK || report.Salvaged != 2 || len(report.Lost) != 1 || report.Quarantine == "" {
+		t.Fatalf("Repair = %+v", report)
+	}
+	if kept, err := os.ReadFile(report.Quarantine); err != nil || !bytes.Equal(kept, raw) {
+		t.Fatalf("a quarentena não tem o arquivo danificado como estava: %v", err)
+	}
+	if _, err := os.Stat(report.Quarantine[:len(report.Quarantine)-len(".json")] + ".report.json"); err != nil {
+		t.Fatalf("o relatório não foi gravado na quarentena: %v", err)
+	}
+
+	want := []journalItem{{1, "Bolo"}, {3, "Chá"}}
+	if got := readItems(t, fs); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("depois do Repair, Read = %v, esperado %v", got, want)
+	}
+	if err := fs.Update(func(tx Tx) error {
+		if got := tx.(Sequence).LastID(); got != 5 {
+			t.Errorf("depois do Repair, LastID = %d, esperado 5", got)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Com o arquivo em ordem (inclusive editado à mão), o Repair não faz nada
+	if report, err := fs.Repair(); err != nil || !report.OK || report.Quarantine != "" {
+		t.Fatalf("Repair do arquivo em ordem = %+v, %v", report, err)
+	}
+}
+
+// Sem a lista de dados (ex.: o conteúdo criptografado), o Repair recusa em vez de gravar uma lista vazia
+func TestRepairRefusesWithoutList(t *testing.T) {
+	dir := t.TempDir()
+	fs := &FileStore{FileName: filepath.Join(dir, "products.json")}
+	writeItems(t, NewEncryptedStore(fs, keyring(t, keySpec("k1"), "")), journalItem{1, "Bolo"})
+
+	raw, err := os.ReadFile(fs.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fs.FileName, truncate(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Repair(); !errors.Is(err, ErrNotRepairable) {
+		t.Fatalf("Repair do conteúdo criptografado danificado = %v, esperado ErrNotRepairable", err)
+	}
+	if after, _ := os.ReadFile(fs.FileName); !bytes.Equal(after, truncate(raw)) {
+		t.Fatal("o Repair alterou o arquivo que não pôde reparar")
+	}
+	if _, err := os.Stat(filepath.Join(dir, DefaultQuarantineDir)); !os.IsNotExist(err) {
+		t.Fatalf("o Repair criou a quarentena sem reparar o arquivo: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// ErrCorrupted é devolvido quando o arquivo não pode ser lido: formato inválido, truncado ou que as migrations não convertem
+var ErrCorrupted = errors.New("o arquivo da store está corrompido (veja o comando repair)")
+
+// errEdited indica que os dados, válidos, não conferem com o checksum gravado: o arquivo foi editado fora da store.
+// Não é um arquivo corrompido: os dados são lidos como estão e a próxima gravação grava o checksum novo
+var errEdited = errors.New("os dados não conferem com o checksum gravado (arquivo editado fora da store)")
+
+// ErrSchemaTooNew é devolvido quando o arquivo foi gravado por uma versão mais nova da aplicação
+var ErrSchemaTooNew = errors.New("o arquivo usa uma versão de schema mais nova do que esta aplicação conhece")
+
+/*
+Envelope é o formato do arquivo em disco:
+
+	{
+	  "schema_version": 2,
+	  "metadata": { "updated_at": "..." },
+	  "data": [ ... ]
+	}
+
+O schema_version diz como "data" deve ser interpretado. Arquivos antigos são atualizados
+pelas migrations da store (veja Migrations) quando são lidos.
+*/
+type Envelope struct {
+	SchemaVersion int             `json:"schema_version"`
+	Metadata      Metadata        `json:"metadata"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Metadata são as informações sobre a gravação que acompanham os dados
+type Metadata struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	Checksum  string    `json:"checksum,omitempty"`
+	Records   int       `json:"records,omitempty"`
+	// LastID é o último ID atribuído (veja Sequence), que continua valendo depois que os registros saem da lista
+	LastID int `json:"last_id,omitempty"`
+}
+
+// Migration converte os dados de uma versão de schema para a seguinte
+type Migration func(data json.RawMessage) (json.RawMessage, error)
+
+// BaseSchemaVersion é a versão do envelope, antes de qualquer migration dos dados: a versão 1 era a lista "pura",
+// sem envelope, e passa para a 2 sem mudar os dados
+const BaseSchemaVersion = 2
+
+// latestSchema é a versão dos arquivos nos formatos de linha sem cabeçalho: os registros estão na versão atual da store
+const latestSchema = -1
+
+/*
+Migrations são as migrations dos dados de uma store: Migrations[from] leva os dados da versão from para from+1,
+a partir de BaseSchemaVersion. Cada store tem as suas (veja FileStore.Migrations), porque cada arquivo guarda
+um tipo de registro: as migrations dos produtos não rodam sobre o arquivo das categorias.
+A versão atual do schema da store é a seguinte à última migration; ao mudar o formato dos dados, basta
+acrescentar a migration. Uma store sem migrations fica na BaseSchemaVersion
+*/
+type Migrations map[int]Migration
+
+// Version é a versão com que os arquivos da store são gravados
+func (m Migrations) Version() int {
+	current := BaseSchemaVersion
+	for from := range m {
+		if from+1 > current {
+			current = from + 1
+		}
+	}
+	return current
+}
+
+// openEnvelope lê o arquivo no formato do codec e devolve o envelope já migrado para a versão atual da store,
+// junto com a versão original. No JSON, a lista "pura" (sem envelope) é tratada como a versão 1.
+// Um arquivo editado fora da store (veja errEdited) é lido normalmente
+func openEnvelope(c Codec, m Migrations, raw []byte) (env Envelope, from int, err error) {
+	env, _, err = decodeEnvelope(c, m, raw)
+	if err != nil {
+		return env, 0, err
+	}
+	from = env.SchemaVersion
+	if err := migrate(&env, m); err != nil {
+		return env, from, err
+	}
+	return env, from, nil
+}
+
+// decodeEnvelope lê o arquivo no formato do codec, sem aplicar as migrations, e diz se os dados
+// foram editados fora da store (não conferem com o checksum gravado)
+func decodeEnvelope(c Codec, m Migrations, raw []byte) (env Envelope, edited bool, err error) {
+	env, err = c.Decode(raw)
+	if err != nil {
+		return env, false, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	if env.SchemaVersion == latestSchema {
+		env.SchemaVersion = m.Version()
+	}
+
+	if env.SchemaVersion < 1 || len(env.Data) == 0 {
+		return env, false, fmt.Errorf("%w: arquivo sem schema_version ou sem data", ErrCorrupted)
+	}
+	err = verifyChecksum(env)
+	if err == errEdited {
+		return env, true, nil
+	}
+	return env, false, err
+}
+
+// migrate aplica, em sequência, as migrations da versão do envelope até a versão atual da store
+func migrate(env *Envelope, m Migrations) error {
+	current := m.Version()
+	if env.SchemaVersion > current {
+		return fmt.Errorf("%w: arquivo na versão %d, aplicação na versão %d", ErrSchemaTooNew, env.SchemaVersion, current)
+	}
+	if env.SchemaVersion == 1 {
+		// A lista "pura" vira o envelope; os dados em si não mudam
+		env.SchemaVersion = BaseSchemaVersion
+	}
+
+	for env.SchemaVersion < current {
+		fn, ok := m[env.SchemaVersion]
+		if !ok {
+			return fmt.Errorf("não há migration registrada para o schema %d", env.SchemaVersion)
+		}
+		data, err := fn(env.Data)
+		if err != nil {
+			return fmt.Errorf("%w: migration do schema %d para %d: %w", ErrCorrupted, env.SchemaVersion, env.SchemaVersion+1, err)
+		}
+		env.Data = data
+		env.SchemaVersion++
+	}
+	return nil
+}
+
+// sealEnvelope monta o arquivo em disco a partir dos dados, na versão informada e no formato do codec
+func sealEnvelope(c Codec, version int, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := writeEnvelope(&buf, c, version, data, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeEnvelope grava o envelope em w, na versão informada e no formato do codec, com o último ID atribuído,
+// e devolve o metadata gravado. Os codecs que sabem gravar aos poucos (veja streamEncoder) escrevem um registro por vez, sem montar o arquivo em memória
+func writeEnvelope(w io.Writer, c Codec, version int, data []byte, lastID int) (Metadata, error) {
+	sum, records, err := checksum(data)
+	if err != nil {
+		return Metadata{}, err
+	}
+	env := Envelope{
+		SchemaVersion: version,
+		Metadata:      Metadata{UpdatedAt: time.Now().UTC(), Checksum: sum, Records: records, LastID: lastID},
+		Data:          data,
+	}
+	if se, ok := c.(streamEncoder); ok {
+		return env.Metadata, se.encodeTo(w, env)
+	}
+	raw, err := c.Encode(env)
+	if err != nil {
+		return Metadata{}, err
+	}
+	_, err = w.Write(raw)
+	return env.Metadata, err
+}
+
+// verifyChecksum confere os dados com o checksum e a quantidade de registros do metadata; arquivos sem checksum (antigos) são aceitos.
+// Devolve errEdited quando os dados são válidos mas não conferem, e ErrCorrupted quando nem dá para calcular o checksum
+func verifyChecksum(env Envelope) error {
+	if env.Metadata.Checksum == "" {
+		return nil
+	}
+	sum, records, err := checksum(env.Data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	if sum != env.Metadata.Checksum || records != env.Metadata.Records {
+		return errEdited
+	}
+	return nil
+}
+
+// checksum calcula o checksum dos dados numa forma canônica (JSON compacto, campos em ordem alfabética),
+// para que o resultado não dependa do formato do arquivo (JSON, YAML, CSV...).
+// Quando os dados são uma lista, devolvemos também a quantidade de registros. A lista é convertida um registro por vez (veja listHasher)
+func checksum(data []byte) (string, int, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		h := newListHasher()
+		if err := eachItem(bytes.NewReader(trimmed), h.add); err != nil {
+			return "", 0, err
+		}
+		sum, records := h.sum()
+		return sum, records, nil
+	}
+
+	// Os dados que não são uma lista (ex.: o conteúdo criptografado) são um único valor
+	canonical, err := canonicalJSON(data)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(canonical)
+	return "sha256:" + hex.EncodeToString(sum[:]), 0, nil
+}
+
+// canonicalJSON é o valor em JSON compacto, com os campos dos objetos em ordem alfabética e os números como estão
+func canonicalJSON(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+/*
+listHasher calcula o checksum de uma lista recebendo um registro por vez: só um registro fica em memória.
+O resultado é o mesmo da lista inteira na forma canônica, "[" + registros separados por vírgula + "]",
+então os arquivos gravados antes dele continuam conferindo
+*/
+type listHasher struct {
+	h     hash.Hash
+	count int
+}
+
+func newListHasher() *listHasher {
+	h := sha256.New()
+	h.Write([]byte("["))
+	return &listHasher{h: h}
+}
+
+func (lh *listHasher) add(item json.RawMessage) error {
+	canonical, err := canonicalJSON(item)
+	if err != nil {
+		return err
+	}
+	if lh.count > 0 {
+		lh.h.Write([]byte(","))
+	}
+	lh.h.Write(canonical)
+	lh.count++
+	return nil
+}
+
+// sum fecha a lista e devolve o checksum e a quantidade de registros
+func (lh *listHasher) sum() (string, int) {
+	lh.h.Write([]byte("]"))
+	return "sha256:" + hex.EncodeToString(lh.h.Sum(nil)), lh.count
+}
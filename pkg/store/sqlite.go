@@ -0,0 +1,146 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	// Driver SQLite escrito em Go puro (sem cgo)
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteScheme guarda os dados num banco SQLite embarcado, ex.: "sqlite://catalog.db?busy_timeout=5000"
+const SQLiteScheme = "sqlite"
+
+// Valores padrão das opções do DSN da store SQLite
+const (
+	DefaultSQLiteBusyTimeout = 5000 // em milissegundos
+	DefaultSQLiteJournalMode = "WAL"
+)
+
+// ErrNotDocumentStore é devolvido quando alguém tenta usar a store SQLite como uma store de documento (Read/Write).
+// Os dados ficam em tabelas, então quem usa essa store precisa de um repositório SQL
+var ErrNotDocumentStore = errors.New("a store sqlite guarda os dados em tabelas; use um repositório SQL")
+
+// SQLiteStore é a store que guarda os dados num banco SQLite
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// As opções do DSN são busy_timeout (em milissegundos) e journal_mode (ex.: WAL, DELETE)
+func init() {
+	Register(SQLiteScheme, func(path string, opts *Options) (Store, error) {
+		if path == "" {
+			return nil, errors.New("informe o caminho do banco, ex.: sqlite://catalog.db")
+		}
+		busyTimeout := opts.Int("busy_timeout", DefaultSQLiteBusyTimeout)
+		journalMode := strings.ToUpper(opts.String("journal_mode", DefaultSQLiteJournalMode))
+		if err := opts.Err(); err != nil {
+			return nil, err
+		}
+		// O modo vai direto para o pragma, então só aceitamos os modos que o SQLite conhece
+		switch journalMode {
+		case "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+		default:
+			return nil, fmt.Errorf("journal_mode=%q inválido (use DELETE, TRUNCATE, PERSIST, MEMORY, WAL ou OFF)", journalMode)
+		}
+		return openSQLite(path, busyTimeout, journalMode)
+	})
+}
+
+// OpenSQLite abre (ou cria) o banco no caminho informado.
+// O busy_timeout faz uma escrita esperar a outra em vez de falhar com "database is locked",
+// e o WAL permite leituras enquanto alguém escreve
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	return openSQLite(path, DefaultSQLiteBusyTimeout, DefaultSQLiteJournalMode)
+}
+
+func openSQLite(path string, busyTimeout int, journalMode string) (*SQLiteStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(%s)&_pragma=foreign_keys(1)",
+		path, busyTimeout, journalMode)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// DB devolve a conexão com o banco, para os repositórios SQL
+func (s *SQLiteStore) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLiteStore) Read(data interface{}) error {
+	return ErrNotDocumentStore
+}
+
+func (s *SQLiteStore) Write(data interface{}) error {
+	return ErrNotDocumentStore
+}
+
+func (s *SQLiteStore) Update(fn func(tx Tx) error) error {
+	return ErrNotDocumentStore
+}
+
+// Migrate aplica, em ordem de nome, os arquivos .sql de migrations que ainda não foram aplicados.
+// As versões aplicadas ficam registradas na tabela schema_migrations, então cada arquivo roda uma única vez.
+// Cada migration roda na sua própria transação.
+func Migrate(db *sql.DB, migrations fs.FS) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(name, ".sql")
+
+		var applied int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, version).Scan(&applied); err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+
+		script, err := fs.ReadFile(migrations, name)
+		if err != nil {
+			return err
+		}
+		if err := applyMigration(db, version, string(script)); err != nil {
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, version, script string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
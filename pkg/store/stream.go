@@ -0,0 +1,327 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Iterator é implementada pelas stores que conseguem entregar os registros de uma lista um por vez,
+// sem montar a lista inteira em memória. Se fn devolver um erro, a iteração para e o erro é devolvido
+type Iterator interface {
+	Each(fn func(item json.RawMessage) error) error
+}
+
+// Each percorre os registros da store um por vez.
+// Nas stores que não implementam Iterator (ex.: a criptografada, que precisa abrir o conteúdo inteiro),
+// a lista é lida de uma vez e percorrida em seguida
+func Each(s Store, fn func(item json.RawMessage) error) error {
+	if it, ok := s.(Iterator); ok {
+		return it.Each(fn)
+	}
+
+	var items []json.RawMessage
+	if err := s.Read(&items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachItem lê a lista JSON token a token, decodificando um registro por vez
+func eachItem(r io.Reader, fn func(item json.RawMessage) error) error {
+	return eachDecoded(json.NewDecoder(r), fn)
+}
+
+// eachDecoded é o eachItem sobre um decoder já posicionado no início da lista
+func eachDecoded(dec *json.Decoder, fn func(item json.RawMessage) error) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('[') {
+		return errors.New("os dados não são uma lista")
+	}
+	return eachOpened(dec, fn)
+}
+
+// eachOpened é o eachDecoded depois do "[" que abre a lista
+func eachOpened(dec *json.Decoder, fn func(item json.RawMessage) error) error {
+	for dec.More() {
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+/*
+Each percorre o arquivo um produto por vez, decodificando direto do disco: nem o arquivo nem a lista
+ficam em memória, então o consumo por requisição não cresce com o catálogo.
+Se o arquivo foi editado por fora e ficou inválido, percorremos a cópia da última versão válida (veja watch.go).
+Só o formato JSON é lido aos poucos; os outros (e os arquivos em schemas antigos, que passam pelas migrations)
+são lidos inteiros a cada leitura, e os dados são percorridos em seguida
+*/
+func (fs *FileStore) Each(fn func(item json.RawMessage) error) error {
+	return fs.readData(func(dec *json.Decoder, _ int) error {
+		return eachDecoded(dec, fn)
+	})
+}
+
+// readData abre a última versão válida do arquivo e entrega a fn um decoder posicionado nos dados do envelope,
+// com o último ID atribuído (veja Sequence)
+func (fs *FileStore) readData(fn func(dec *json.Decoder, lastID int) error) error {
+	src, err := fs.open(false)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if _, ok := fs.codec().(jsonCodec); ok && src.schema == fs.Migrations.Version() {
+		dec := json.NewDecoder(bufio.NewReader(src))
+		if err := seekData(dec); err != nil {
+			return fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+		return fn(dec, src.lastID)
+	}
+
+	raw, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	env, _, err := openEnvelope(fs.codec(), fs.Migrations, raw)
+	if err != nil {
+		return err
+	}
+	return fn(json.NewDecoder(bytes.NewReader(env.Data)), src.lastID)
+}
+
+// seekData avança o decoder do envelope JSON até o valor de "data", pulando os outros campos
+func seekData(dec *json.Decoder) error {
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return errors.New("o arquivo não é um envelope")
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if name, _ := key.(string); strings.EqualFold(name, "data") {
+			return nil
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	return errors.New("o envelope não tem data")
+}
+
+// errWholeFile indica um envelope JSON que não dá para conferir aos poucos: "data" antes de "metadata"
+// (ex.: editado à mão), sem saber antes o checksum, ou dados que não são uma lista
+// (ex.: o conteúdo criptografado). Nesses casos o arquivo é conferido inteiro, pelo openEnvelope
+var errWholeFile = errors.New("o envelope precisa ser lido inteiro")
+
+/*
+verifyJSON confere o envelope JSON lendo um registro por vez: a versão do schema, o checksum (veja listHasher)
+e se não sobrou nada depois do envelope (current é a versão atual do schema da store). Devolve o cabeçalho do envelope, sem os dados, e se o arquivo
+foi editado fora da store (veja errEdited).
+Os erros são os mesmos do openEnvelope (ErrCorrupted, ErrSchemaTooNew), mas as migrations não são aplicadas
+*/
+func verifyJSON(r io.Reader, current int) (envelopeHeader, bool, error) {
+	var h envelopeHeader
+	var edited bool
+	corrupted := func(err error) (envelopeHeader, bool, error) {
+		return h, false, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+
+	dec := json.NewDecoder(r)
+	t, err := dec.Token()
+	if err != nil {
+		return corrupted(err)
+	}
+	switch t {
+	case json.Delim('['):
+		// A lista "pura" é a versão 1, sem checksum
+		h.SchemaVersion = 1
+		if err := eachOpened(dec, func(json.RawMessage) error { return nil }); err != nil {
+			return corrupted(err)
+		}
+	case json.Delim('{'):
+		var hasMetadata, hasData bool
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return corrupted(err)
+			}
+			// Como no json.Unmarshal, os nomes dos campos não diferenciam maiúsculas de minúsculas
+			switch name, _ := key.(string); strings.ToLower(name) {
+			case "schema_version":
+				err = dec.Decode(&h.SchemaVersion)
+			case "metadata":
+				err, hasMetadata = dec.Decode(&h.Metadata), true
+			case "data":
+				if !hasMetadata {
+					return h, false, errWholeFile
+				}
+				hasData = true
+				switch err = verifyData(dec, h.Metadata); err {
+				case errWholeFile:
+					return h, false, err
+				case errEdited:
+					edited, err = true, nil
+				}
+			default:
+				var skip json.RawMessage
+				err = dec.Decode(&skip)
+			}
+			if err != nil {
+				return corrupted(err)
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return corrupted(err)
+		}
+		if h.SchemaVersion < 1 || !hasData {
+			return corrupted(errors.New("arquivo sem schema_version ou sem data"))
+		}
+	default:
+		return corrupted(errors.New("o arquivo não é um envelope"))
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return corrupted(errors.New("conteúdo depois do fim do envelope"))
+	}
+	if h.SchemaVersion > current {
+		return h, false, fmt.Errorf("%w: arquivo na versão %d, aplicação na versão %d", ErrSchemaTooNew, h.SchemaVersion, current)
+	}
+	return h, edited, nil
+}
+
+// verifyData confere o checksum e a quantidade de registros da lista com o decoder posicionado nela
+// (errEdited quando não conferem); sem checksum (arquivos antigos), os registros só precisam ser JSON válido
+func verifyData(dec *json.Decoder, meta Metadata) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('[') {
+		return errWholeFile
+	}
+
+	if meta.Checksum == "" {
+		return eachOpened(dec, func(json.RawMessage) error { return nil })
+	}
+	h := newListHasher()
+	if err := eachOpened(dec, h.add); err != nil {
+		return err
+	}
+	if sum, records := h.sum(); sum != meta.Checksum || records != meta.Records {
+		return errEdited
+	}
+	return nil
+}
+
+// streamEncoder é implementada pelos codecs que gravam o envelope aos poucos, um registro por vez
+type streamEncoder interface {
+	encodeTo(w io.Writer, env Envelope) error
+}
+
+// encodeTo grava o mesmo arquivo que o Encode (o envelope indentado), mas indentando um registro por vez
+func (jsonCodec) encodeTo(w io.Writer, env Envelope) error {
+	meta, err := json.MarshalIndent(env.Metadata, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "{\n  \"schema_version\": %d,\n  \"metadata\": %s,\n  \"data\": ", env.SchemaVersion, meta)
+
+	if trimmed := bytes.TrimSpace(env.Data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var buf bytes.Buffer
+		n := 0
+		err = eachItem(bytes.NewReader(trimmed), func(item json.RawMessage) error {
+			buf.Reset()
+			if err := json.Indent(&buf, item, "    ", "  "); err != nil {
+				return err
+			}
+			if n == 0 {
+				bw.WriteString("[\n    ")
+			} else {
+				bw.WriteString(",\n    ")
+			}
+			n++
+			_, err := buf.WriteTo(bw)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			bw.WriteString("[]")
+		} else {
+			bw.WriteString("\n  ]")
+		}
+	} else {
+		// Os dados que não são uma lista (ex.: o conteúdo criptografado) são um único valor
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, env.Data, "  ", "  "); err != nil {
+			return err
+		}
+		buf.WriteTo(bw)
+	}
+
+	bw.WriteString("\n}")
+	return bw.Flush()
+}
+
+// Each percorre o conteúdo atual; gravações durante a iteração não afetam o que está sendo percorrido
+func (ms *MemoryStore) Each(fn func(item json.RawMessage) error) error {
+	ms.mu.Lock()
+	snapshot, err := ms.snapshot()
+	ms.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return eachItem(bytes.NewReader(snapshot), fn)
+}
+
+/*
+Each percorre os itens do estado do journal, sem montar o documento da lista.
+O journal mantém o estado inteiro em memória (é comparando com ele que o Write descobre o que gravar no log),
+então a iteração não economiza a leitura do disco, só a cópia do documento
+*/
+func (js *JournalStore) Each(fn func(item json.RawMessage) error) error {
+	var items []json.RawMessage
+	err := js.withLock(func() error {
+		if !js.exists {
+			return fmt.Errorf("journal em %s vazio: %w", js.Dir, os.ErrNotExist)
+		}
+		// Os itens não são alterados depois de gravados (o put troca o item inteiro), basta copiar a lista
+		items = append(items, js.state.items...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
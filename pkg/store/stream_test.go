@@ -0,0 +1,209 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// O envelope gravado aos poucos é idêntico ao do Encode, byte a byte
+func TestJSONEncodeToMatchesEncode(t *testing.T) {
+	for _, f := range append(codecFixtures, struct{ name, data string }{"objeto", `{"key_id":"k1","nonce":"YWJj"}`}) {
+		f := f
+		t.Run(f.name, func(t *testing.T) {
+			var data bytes.Buffer
+			if err := json.Indent(&data, []byte(f.data), "", "  "); err != nil {
+				t.Fatal(err)
+			}
+			env := Envelope{SchemaVersion: BaseSchemaVersion, Metadata: Metadata{Checksum: "sha256:x", Records: 1}, Data: data.Bytes()}
+
+			want, err := jsonCodec{}.Encode(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := (jsonCodec{}).encodeTo(&got, env); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != string(want) {
+				t.Fatalf("encodeTo gravou\n%s\nesperado\n%s", got.String(), want)
+			}
+		})
+	}
+}
+
+// A conferência do arquivo um registro por vez aceita e recusa os mesmos arquivos que o openEnvelope
+func TestVerifyJSONAgreesWithOpenEnvelope(t *testing.T) {
+	valid := string(sealed(t, 1, 2, 3))
+	cases := []struct {
+		name     string
+		raw      string
+		whole    bool // o arquivo não dá para conferir aos poucos
+		tooNew   bool
+		corrupts bool
+		edited   bool // válido, mas não confere com o checksum: editado fora da store
+	}{
+		{name: "envelope gravado pela store", raw: valid},
+		{name: "lista sem envelope (versão 1)", raw: `[1, 2, 3]`},
+		{name: "envelope sem checksum", raw: `{"schema_version": 2, "metadata": {}, "data": [1]}`},
+		{name: "registros a menos que o metadata", raw: strings.Replace(valid, `"records": 3`, `"records": 2`, 1), edited: true},
+		{name: "registro alterado", raw: strings.Replace(valid, "    3\n", "    4\n", 1), edited: true},
+		{name: "registro acrescentado", raw: strings.Replace(valid, "    3\n", "    3,\n    4\n", 1), edited: true},
+		{name: "metadata sem records", raw: strings.Replace(valid, ",\n    \"records\": 3", "", 1), edited: true},
+		{name: "arquivo truncado", raw: string(truncate([]byte(valid))), corrupts: true},
+		{name: "conteúdo depois do envelope", raw: valid + "\n{}", corrupts: true},
+		{name: "registros a mais que o metadata", raw: strings.Replace(valid, `"records": 3`, `"records": 4`, 1), edited: true},
+		{name: "sem data", raw: `{"schema_version": 2, "metadata": {}}`, corrupts: true},
+		{name: "schema mais novo", raw: `{"schema_version": 99, "metadata": {}, "data": []}`, tooNew: true},
+		{name: "data antes do metadata", raw: `{"schema_version": 2, "data": [1], "metadata": {}}`, whole: true},
+		{name: "data que não é uma lista", raw: `{"schema_version": 2, "metadata": {}, "data": {"a": 1}}`, whole: true},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			_, _, want := openEnvelope(jsonCodec{}, nil, []byte(c.raw))
+			_, wantEdited, _ := decodeEnvelope(jsonCodec{}, nil, []byte(c.raw))
+			h, edited, err := verifyJSON(strings.NewReader(c.raw), BaseSchemaVersion)
+			if edited != c.edited || wantEdited != c.edited {
+				t.Fatalf("verifyJSON editado = %v, decodeEnvelope editado = %v; esperado %v", edited, wantEdited, c.edited)
+			}
+			switch {
+			case c.whole:
+				if err != errWholeFile {
+					t.Fatalf("verifyJSON = %v, esperado errWholeFile", err)
+				}
+				return
+			case c.tooNew:
+				if !errors.Is(err, ErrSchemaTooNew) || !errors.Is(want, ErrSchemaTooNew) {
+					t.Fatalf("verifyJSON = %v, openEnvelope = %v; esperado ErrSchemaTooNew", err, want)
+				}
+			case c.corrupts:
+				if !errors.Is(err, ErrCorrupted) || !errors.Is(want, ErrCorrupted) {
+					t.Fatalf("verifyJSON = %v, openEnvelope = %v; esperado ErrCorrupted", err, want)
+				}
+			default:
+				if err != nil || want != nil {
+					t.Fatalf("verifyJSON = %v, openEnvelope = %v; esperado nil", err, want)
+				}
+				if h.SchemaVersion < 1 {
+					t.Fatalf("schema_version = %d", h.SchemaVersion)
+				}
+			}
+		})
+	}
+}
+
+// Uma edição externa inválida não derruba as leituras: elas passam a vir da cópia da última versão válida,
+// e as gravações são recusadas até o arquivo ser corrigido
+func TestFileStoreServesLastGoodVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "products.json")
+	fs := &FileStore{FileName: path}
+	writeItems(t, fs, journalItem{1, "Bolo"}, journalItem{2, "Café"})
+
+	if err := os.WriteFile(path, []byte(`{"schema_version": 2, "data": [{"id": 1`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []journalItem{{1, "Bolo"}, {2, "Café"}}
+	if got := readItems(t, fs); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("com o arquivo inválido, Read = %v, esperado %v", got, want)
+	}
+	var names []string
+	err := fs.Each(func(item json.RawMessage) error {
+		var it journalItem
+		if err := json.Unmarshal(item, &it); err != nil {
+			return err
+		}
+		names = append(names, it.Name)
+		return nil
+	})
+	if err != nil || strings.Join(names, ",") != "Bolo,Café" {
+		t.Fatalf("com o arquivo inválido, Each = %v, %v", names, err)
+	}
+	if err := fs.Write([]journalItem{{3, "Chá"}}); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("Write com o arquivo inválido = %v, esperado ErrCorrupted", err)
+	}
+
+	// Corrigido o arquivo, a versão nova passa a valer
+	if err := os.WriteFile(path, sealed(t, 7), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	if err := fs.Read(&ids); err != nil || fmt.Sprint(ids) != "[7]" {
+		t.Fatalf("depois de corrigir o arquivo, Read = %v, %v", ids, err)
+	}
+}
+
+// Each e Read leem os formatos e schemas que não são percorridos aos poucos (lidos inteiros) da mesma forma
+func TestFileStoreEachAcrossFormats(t *testing.T) {
+	for _, c := range codecs {
+		c := c
+		t.Run(c.Name(), func(t *testing.T) {
+			fs := &FileStore{FileName: filepath.Join(t.TempDir(), "products."+c.Name()), Codec: c}
+			writeItems(t, fs, journalItem{1, "Bolo"}, journalItem{2, "Café"})
+
+			var got []journalItem
+			err := fs.Each(func(item json.RawMessage) error {
+				var it journalItem
+				err := json.Unmarshal(item, &it)
+				got = append(got, it)
+				return err
+			})
+			if err != nil || fmt.Sprint(got) != fmt.Sprint(readItems(t, fs)) || len(got) != 2 {
+				t.Fatalf("Each = %v, %v; Read = %v", got, err, readItems(t, fs))
+			}
+		})
+	}
+
+	// A lista sem envelope (versão 1) também é percorrida
+	path := filepath.Join(t.TempDir(), "products.json")
+	if err := os.WriteFile(path, []byte(`[{"id": 1, "name": "Bolo"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := (&FileStore{FileName: path}).Each(func(json.RawMessage) error { n++; return nil }); err != nil || n != 1 {
+		t.Fatalf("Each na versão 1 = %d registros, %v", n, err)
+	}
+}
+
+// Uma edição à mão válida não é um arquivo corrompido: passa a valer na leitura, e a gravação seguinte grava o checksum novo
+func TestFileStoreAcceptsHandEdits(t *testing.T) {
+	for _, c := range []Codec{jsonCodec{}, yamlCodec{}} {
+		c := c
+		t.Run(c.Name(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "products."+c.Name())
+			fs := &FileStore{FileName: path, Codec: c}
+			writeItems(t, fs, journalItem{1, "Bolo"}, journalItem{2, "Café"})
+
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, bytes.Replace(raw, []byte("Bolo"), []byte("Torta"), 1), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := readItems(t, fs); len(got) != 2 || got[0].Name != "Torta" {
+				t.Fatalf("Read depois da edição à mão = %v, esperado a Torta", got)
+			}
+
+			if err := fs.Write(append(readItems(t, fs), journalItem{3, "Chá"})); err != nil {
+				t.Fatalf("Write depois da edição à mão = %v", err)
+			}
+			raw, err = os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, edited, err := decodeEnvelope(c, nil, raw); err != nil || edited {
+				t.Fatalf("depois do Write, o arquivo editado = %v, %v; esperado o checksum novo", edited, err)
+			}
+			if got := readItems(t, fs); len(got) != 3 || got[0].Name != "Torta" {
+				t.Fatalf("Read depois do Write = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import "encoding/json"
+
+// Tx é o que o callback de Store.Update recebe para ler e gravar dentro da transação
+type Tx interface {
+	// Read preenche data com o conteúdo da transação (o retrato inicial ou o que já foi gravado nela)
+	Read(data interface{}) error
+	// Write substitui o conteúdo da transação; só chega à store no commit
+	Write(data interface{}) error
+}
+
+// Sequence é implementada pelo Tx das stores que guardam, junto dos dados, o último ID atribuído.
+// O contador nunca diminui: os IDs dos registros removidos de vez (ex.: pelo Purge da lixeira) não voltam a ser usados.
+// SetLastID com um valor menor que o atual é ignorado; o valor novo é gravado no commit, junto com os dados
+type Sequence interface {
+	LastID() int
+	SetLastID(id int)
+}
+
+/*
+Minor é implementada pelo Tx das stores que guardam backups. SetMinor marca a gravação como uma alteração pequena
+e frequente, que outro registro consegue refazer (ex.: o estoque dos produtos, que o livro de estoque refaz):
+ela só vira backup se o mais novo tiver mais que BackupPolicy.MinorInterval. Sem isso, uma rajada de
+movimentos de estoque gravaria um backup por movimento e a retenção descartaria os backups das outras alterações
+*/
+type Minor interface {
+	SetMinor()
+}
+
+// tx guarda o retrato lido no início da transação e a gravação pendente
+type tx struct {
+	snapshot    []byte
+	snapshotErr error
+
+	pending []byte
+	written bool
+
+	lastID int
+	minor  bool
+}
+
+func newTx(snapshot []byte, err error) *tx {
+	return &tx{snapshot: snapshot, snapshotErr: err}
+}
+
+func (t *tx) Read(data interface{}) error {
+	// Depois de uma gravação, a própria transação enxerga o que gravou
+	if t.written {
+		return decode(t.pending, data)
+	}
+	if t.snapshotErr != nil {
+		return t.snapshotErr
+	}
+	return decode(t.snapshot, data)
+}
+
+func (t *tx) Write(data interface{}) error {
+	b, err := encode(data)
+	if err != nil {
+		return err
+	}
+	t.pending = b
+	t.written = true
+	return nil
+}
+
+func (t *tx) LastID() int {
+	return t.lastID
+}
+
+func (t *tx) SetLastID(id int) {
+	if id > t.lastID {
+		t.lastID = id
+	}
+}
+
+func (t *tx) SetMinor() {
+	t.minor = true
+}
+
+// encode é o formato em que os dados são gravados
+func encode(data interface{}) ([]byte, error) {
+	// A função MarshalIndent faz a mesma coisa que a Marshal, porém ela "indenta" o jso também
+	return json.MarshalIndent(data, "", "  ")
+}
+
+// decode é o inverso de encode
+func decode(b []byte, data interface{}) error {
+	return json.Unmarshal(b, data)
+}
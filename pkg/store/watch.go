@@ -0,0 +1,252 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultWatchInterval é o intervalo padrão entre as verificações do Watch
+const DefaultWatchInterval = 2 * time.Second
+
+// fileCache é o que a FileStore sabe da última versão válida do arquivo.
+// Os dados não ficam em memória: se o arquivo for editado por fora e ficar inválido,
+// servimos a cópia da última versão válida que fica ao lado dele (veja lastGoodName)
+type fileCache struct {
+	sum     [sha256.Size]byte // checksum do arquivo como está no disco
+	modTime time.Time
+	size    int64
+	schema  int // versão do schema em que o arquivo foi gravado
+	lastID  int // último ID atribuído, do metadata (veja Sequence)
+
+	// fallback diz se a cópia da última versão válida foi gravada; sem ela, um arquivo inválido é um erro
+	fallback bool
+
+	// badSum é o checksum da última versão inválida que já foi reportada, para não repetir o log a cada leitura
+	badSum [sha256.Size]byte
+}
+
+// fileSource é o arquivo aberto para leitura: o próprio arquivo ou, se ele ficou inválido, a cópia da última versão válida
+type fileSource struct {
+	*os.File
+	schema int // versão do schema do conteúdo (as anteriores à atual passam pelas migrations)
+	lastID int
+
+	// stale é o erro do arquivo no disco quando estamos lendo a cópia da última versão válida
+	stale error
+}
+
+// lastGoodName é a cópia da última versão válida, ex.: ".products.json.last-good"
+func (fs *FileStore) lastGoodName() string {
+	dir, base := filepath.Split(fs.FileName)
+	return filepath.Join(dir, "."+base+".last-good")
+}
+
+/*
+open abre o arquivo para leitura, conferindo-o só quando ele muda.
+Alguém pode editar o arquivo à mão (ou outro processo pode gravá-lo) enquanto o servidor roda.
+Percebemos isso pela data de modificação e pelo tamanho; com force, conferimos também o checksum
+(uma edição rápida pode não mudar nem a data nem o tamanho).
+Se o novo conteúdo não puder ser lido (JSON inválido, schema desconhecido), continuamos servindo
+a última versão válida e registramos o erro. Um conteúdo válido que não confere com o checksum é uma edição
+à mão: passa a valer como qualquer outra versão, e a próxima gravação grava o checksum novo.
+Quem chama lê do arquivo aberto e precisa fechá-lo; uma gravação no meio da leitura troca o arquivo
+por outro (rename), sem mexer no que já está aberto
+*/
+func (fs *FileStore) open(force bool) (*fileSource, error) {
+	fs.cacheMu.Lock()
+	defer fs.cacheMu.Unlock()
+
+	c := fs.cache
+	f, err := os.Open(fs.FileName)
+	if err != nil {
+		if c != nil && !os.IsNotExist(err) {
+			return fs.openLastGood(err)
+		}
+		return nil, err
+	}
+	src, err := fs.check(f, force)
+	if err != nil {
+		f.Close()
+		if c == nil || !c.fallback {
+			return nil, err
+		}
+		return fs.openLastGood(err)
+	}
+	return src, nil
+}
+
+// check confere o arquivo aberto contra o cache e, se ele mudou, confere o conteúdo novo. Chamado com o cacheMu obtido
+func (fs *FileStore) check(f *os.File, force bool) (*fileSource, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	c := fs.cache
+	if c != nil && !force && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return &fileSource{File: f, schema: c.schema, lastID: c.lastID}, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if c != nil && sum == c.sum {
+		// Só a data mudou (ex.: "touch"), o conteúdo é o mesmo
+		c.modTime, c.size = info.ModTime(), info.Size()
+		return &fileSource{File: f, schema: c.schema, lastID: c.lastID}, nil
+	}
+
+	header, edited, err := fs.verify(f)
+	if err != nil {
+		if c != nil && sum != c.badSum {
+			c.badSum = sum
+			log.Printf("evento=catalogo_invalido arquivo=%s sha256=%s erro=%q servindo=%s",
+				fs.FileName, hex.EncodeToString(sum[:]), err, hex.EncodeToString(c.sum[:]))
+		}
+		return nil, err
+	}
+	if edited {
+		log.Printf("evento=catalogo_editado arquivo=%s sha256=%s bytes=%d detalhe=%q",
+			fs.FileName, hex.EncodeToString(sum[:]), info.Size(), "os dados não conferem com o checksum gravado; a próxima gravação grava o checksum novo")
+	}
+	if c != nil {
+		log.Printf("evento=catalogo_recarregado arquivo=%s sha256_anterior=%s sha256=%s bytes=%d",
+			fs.FileName, hex.EncodeToString(c.sum[:]), hex.EncodeToString(sum[:]), info.Size())
+	}
+	fs.cache = &fileCache{sum: sum, modTime: info.ModTime(), size: info.Size(), schema: header.SchemaVersion, lastID: header.Metadata.LastID}
+	fs.cache.fallback = fs.saveLastGood(f)
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &fileSource{File: f, schema: header.SchemaVersion, lastID: header.Metadata.LastID}, nil
+}
+
+// verify confere o conteúdo do arquivo e devolve o cabeçalho do envelope, com a versão do schema em que ele foi gravado,
+// e se ele foi editado fora da store (veja errEdited).
+// O JSON no schema atual é conferido um registro por vez (veja verifyJSON); os outros formatos são lidos inteiros
+func (fs *FileStore) verify(f *os.File) (envelopeHeader, bool, error) {
+	if _, ok := fs.codec().(jsonCodec); ok {
+		h, edited, err := verifyJSON(bufio.NewReader(f), fs.Migrations.Version())
+		// Os arquivos em versões antigas do schema são lidos inteiros, para passar pelas migrations
+		if err != errWholeFile && (err != nil || h.SchemaVersion == fs.Migrations.Version()) {
+			return h, edited, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return h, false, err
+		}
+	}
+
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return envelopeHeader{}, false, err
+	}
+	env, edited, err := decodeEnvelope(fs.codec(), fs.Migrations, raw)
+	if err != nil {
+		return envelopeHeader{}, false, err
+	}
+	h := envelopeHeader{SchemaVersion: env.SchemaVersion, Metadata: env.Metadata}
+	return h, edited, migrate(&env, fs.Migrations)
+}
+
+// saveLastGood copia a versão válida que acabou de ser lida ou gravada para o lado do arquivo.
+// A cópia não passa pelo fsync: numa queda de energia, o próprio arquivo (esse sim sincronizado) é a referência
+func (fs *FileStore) saveLastGood(f *os.File) bool {
+	err := func() error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		dir, base := filepath.Split(fs.FileName)
+		if dir == "" {
+			dir = "."
+		}
+		tmp, err := os.CreateTemp(dir, "."+base+".last-good-*")
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tmp, f)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), fs.lastGoodName())
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+		return err
+	}()
+	if err != nil {
+		log.Printf("evento=catalogo_copia_falhou arquivo=%s erro=%q", fs.FileName, err)
+		return false
+	}
+	return true
+}
+
+// openLastGood abre a cópia da última versão válida, guardando o erro do arquivo no disco. Chamado com o cacheMu obtido
+func (fs *FileStore) openLastGood(cause error) (*fileSource, error) {
+	f, err := os.Open(fs.lastGoodName())
+	if err != nil {
+		return nil, cause
+	}
+	return &fileSource{File: f, schema: fs.cache.schema, lastID: fs.cache.lastID, stale: cause}, nil
+}
+
+// remember guarda no cache o que a própria FileStore acabou de gravar (sum é o checksum do arquivo, na versão atual)
+func (fs *FileStore) remember(sum [sha256.Size]byte, lastID int) {
+	fs.cacheMu.Lock()
+	defer fs.cacheMu.Unlock()
+
+	f, err := os.Open(fs.FileName)
+	if err != nil {
+		fs.cache = nil
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		fs.cache = nil
+		return
+	}
+	fs.cache = &fileCache{sum: sum, modTime: info.ModTime(), size: info.Size(), schema: fs.Migrations.Version(), lastID: lastID}
+	fs.cache.fallback = fs.saveLastGood(f)
+}
+
+// Watch confere o arquivo a cada intervalo, até o contexto ser cancelado,
+// para que edições externas sejam percebidas (e registradas) mesmo sem requisições chegando
+func (fs *FileStore) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			src, err := fs.open(true)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					log.Printf("evento=catalogo_erro_leitura arquivo=%s erro=%q", fs.FileName, err)
+				}
+				continue
+			}
+			src.Close()
+		}
+	}
+}
@@ -10,12 +10,48 @@ type Response struct { // O omitempty fará com que, dependendo do que houver (e
 	Code  string      `json:"code"`
 	Data  interface{} `json:"data,omitempty"`
 	Error string      `json:"error,omitempty"`
+	// Errors traz o erro de cada parâmetro inválido, pelo nome do parâmetro
+	Errors map[string]string `json:"errors,omitempty"`
+	// Pagination acompanha as listagens paginadas
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Pagination descreve a página devolvida e como chegar às vizinhas
+type Pagination struct {
+	// Total é a quantidade de itens encontrados, somando todas as páginas
+	Total  int `json:"total"`
+	Limit  int `json:"limit,omitempty"`
+	Offset int `json:"offset,omitempty"`
+	// NextCursor é omitido na última página
+	NextCursor string `json:"next_cursor,omitempty"`
+	Links      Links  `json:"links"`
+}
+
+// Links são as URLs da própria página e das vizinhas (omitidas quando não existem)
+type Links struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
 }
 
 func NewResponse(code int, data interface{}, err string) Response {
 
 	if code < http.StatusMultipleChoices { // Status 300
-		return Response{strconv.FormatInt(int64(code), 10), data, ""} // Omitindo o Error
+		return Response{Code: strconv.FormatInt(int64(code), 10), Data: data} // Omitindo o Error
 	}
-	return Response{strconv.FormatInt(int64(code), 10), nil, err} // Omitindo o Data
+	return Response{Code: strconv.FormatInt(int64(code), 10), Error: err} // Omitindo o Data
+}
+
+// NewValidationResponse monta a resposta 400 com o erro de cada parâmetro inválido
+func NewValidationResponse(errs map[string]string) Response {
+	r := NewResponse(http.StatusBadRequest, nil, "parâmetros inválidos")
+	r.Errors = errs
+	return r
+}
+
+// NewPagedResponse monta a resposta 200 de uma listagem paginada
+func NewPagedResponse(data interface{}, p Pagination) Response {
+	r := NewResponse(http.StatusOK, data, "")
+	r.Pagination = &p
+	return r
 }